@@ -1,35 +1,493 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	projectoptimizer "parqlite/project-optimizer"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exit codes
+const (
+	exitFailure = 1 // reading or writing data failed
+	exitUsage   = 2 // bad flags or arguments, invalid queries, unknown tables or columns
 )
 
-func handleErr(err error) {
+type command struct {
+	name, args, summary string
+	run                 func(args []string) error
+}
+
+var commands = []command{
+	{"query", "[flags] <sql> [file ...]", "run a SQL query, files are tables named after them", runQuery},
+	{"head", "[flags] <file>", "print the first rows of a file", runHead},
+	{"schema", "[flags] <file>", "print the schema of a file", runSchema},
+	{"stats", "[flags] <file>", "summarize every column of a file", runStats},
+	{"convert", "[flags] <input> <output>", "convert between parquet, csv, tsv, json and ndjson", runConvert},
+	{"inspect", "[flags] <file.parquet>", "print the footer of a parquet file", runInspect},
+	{"shell", "[flags] [file ...]", "start the interactive SQL shell, the default", runShell},
+}
+
+func main() {
+	name, run, args := "parqlite", runShell, os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			usage(os.Stdout)
+			return
+		}
+		known := false
+		for _, cmd := range commands {
+			if cmd.name == args[0] {
+				name, run, args, known = cmd.name, cmd.run, args[1:], true
+			}
+		}
+		if !known && !isShellArg(args[0]) {
+			fmt.Fprintf(os.Stderr, "parqlite: unknown command %q\n\n", args[0])
+			usage(os.Stderr)
+			os.Exit(exitUsage)
+		}
+	}
+	if err := run(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// the shell is the default command, its arguments are flags, files,
+// globs and urls. anything else is a mistyped command
+func isShellArg(arg string) bool {
+	if strings.HasPrefix(arg, "-") || strings.Contains(arg, "://") || strings.ContainsAny(arg, "*?[") {
+		return true
+	}
+	_, err := os.Stat(arg)
+	return err == nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: parqlite <command> [flags] [arguments]\n\ncommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nexit status is %d when reading or writing data fails and %d for bad arguments, queries or columns\n", exitFailure, exitUsage)
+}
+
+// a mistake in the arguments of a command
+type usageError struct{ err error }
+
+func (u usageError) Error() string { return u.err.Error() }
+func (u usageError) Unwrap() error { return u.err }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+func exitCode(err error) int {
+	var usage usageError
+	if errors.As(err, &usage) || errors.Is(err, projectoptimizer.ErrInvalidQuery) || errors.Is(err, projectoptimizer.ErrColumnNotFound) ||
+		errors.Is(err, projectoptimizer.ErrUnsupportedFormat) {
+		return exitUsage
+	}
+	return exitFailure
+}
+
+// parses flags before, between and after the positional arguments, which
+// are returned. the flag package alone stops at the first positional one
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError{err}
+		}
+		consumed := len(args) - fs.NArg()
+		if consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, fs.Args()...), nil
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: parqlite %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// the flags of the result formats
+type resultFlags struct {
+	format, pager               *string
+	maxRows, maxWidth, pageRows *int
+}
+
+func newResultFlags(fs *flag.FlagSet, name string) *resultFlags {
+	formats := strings.Join(projectoptimizer.ResultFormats(), ", ")
+	return &resultFlags{
+		format:   fs.String(name, projectoptimizer.FormatTable, "result format: "+formats),
+		maxRows:  fs.Int("max-rows", 20, "rows the table format previews"),
+		maxWidth: fs.Int("max-width", 50, "characters the columns of tables are cut to"),
+		pageRows: fs.Int("page-rows", 0, "rows the full format repeats its header after, 0 never does"),
+		pager:    fs.String("pager", projectoptimizer.DefaultPager(), "command the full format is paged with on a terminal, empty pages nothing"),
+	}
+}
+
+func (r *resultFlags) check() error {
+	for _, f := range projectoptimizer.ResultFormats() {
+		if f == strings.ToLower(*r.format) {
+			return nil
+		}
+	}
+	return usagef("unknown format %q, expected one of %s", *r.format, strings.Join(projectoptimizer.ResultFormats(), ", "))
+}
+
+func (r *resultFlags) options() projectoptimizer.ResultOptions {
+	return projectoptimizer.ResultOptions{MaxRows: *r.maxRows, MaxWidth: *r.maxWidth, PageRows: *r.pageRows}
+}
+
+// the pager of the full format, empty when stdout is not a terminal
+func (r *resultFlags) pagerCommand() string {
+	if !projectoptimizer.IsTerminal(os.Stdout) {
+		return ""
+	}
+	return *r.pager
+}
+
+// the flags bounding and reporting the memory of queries
+type memoryFlags struct {
+	limit  *int64
+	report *bool
+}
+
+func newMemoryFlags(fs *flag.FlagSet) *memoryFlags {
+	return &memoryFlags{
+		limit:  fs.Int64("memory-limit", 0, "bytes a query may hold, sorts spill to disk past it and other operators fail, 0 is unlimited"),
+		report: fs.Bool("memory", false, "report the peak memory of queries and their operators"),
+	}
+}
+
+// writes the rows of a query to stdout, the full format through the pager.
+// the memory of the query is reported to stderr when mf asks for it
+func writeResult(in *projectoptimizer.Input, rf *resultFlags, mf *memoryFlags) error {
+	defer in.Close()
+	if *mf.report {
+		defer func() { fmt.Fprintf(os.Stderr, "memory: %s\n", in.Memory()) }()
+	}
+	var screen io.Writer = os.Stdout
+	if strings.ToLower(*rf.format) == projectoptimizer.FormatFull && rf.pagerCommand() != "" {
+		pager, err := projectoptimizer.StartPager(rf.pagerCommand(), os.Stdout)
+		if err != nil {
+			return err
+		}
+		defer pager.Close()
+		screen = pager
+	}
+	out := bufio.NewWriter(screen)
+	_, err := projectoptimizer.WriteResult(out, in, *rf.format, rf.options())
+	if err == nil {
+		err = out.Flush()
+	}
+	if errors.Is(err, projectoptimizer.ErrPagerClosed) {
+		return nil
+	}
+	return err
+}
+
+// parqlite query [flags] <sql> [file ...]
+func runQuery(args []string) error {
+	fs := newFlagSet("query", "[flags] <sql> [file ...]")
+	result := newResultFlags(fs, "format")
+	tables := tableFlag{}
+	fs.Var(tables, "table", "table as name=path, may be repeated")
+	mmap := fs.Bool("mmap", false, "memory map local files instead of reading them")
+	memory := newMemoryFlags(fs)
+	args, err := parseArgs(fs, args)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return usagef("expected a query")
+	}
+	if err := result.check(); err != nil {
+		return err
 	}
+	for _, path := range args[1:] {
+		tables[projectoptimizer.TableName(path)] = path
+	}
+	in, err := projectoptimizer.Query(args[0], tables, projectoptimizer.PlanOptions{Mmap: *mmap, MemoryLimit: *memory.limit})
+	if err != nil {
+		return err
+	}
+	return writeResult(in, result, memory)
 }
-func main() {
-	f, err := os.Open("data/history.parquet")
-	handleErr(err)
-	projectoptimizer.ReadRecordBatch(f, []string{"lat", "lon"})
-	/*
-	   	projectNodeLeaf := projectoptimizer.NewProjectExecLeaf(f, []string{"lat", "lon", "country", "capital"}, []projectoptimizer.FilterPredicate{
-	   		func(v reflect.Value) bool {
-	   			lat := v.FieldByName("Lat").Float()
-	   			return lat == -12.06
-	   		},
-	   	})
-
-	   tmpCopy := projectNodeLeaf.Schema().Clone()
-	   tmpCopy.KeepFields("lat", "country")
-	   proj := projectoptimizer.NewProjectExec(tmpCopy, projectNodeLeaf, nil)
-	   sumExec, err := projectoptimizer.NewSumExec(proj, "lat")
-	   handleErr(err)
-	   fmt.Printf("schema: %v", sumExec.Schema().ShowSchema())
-	*/
+
+// parqlite head [flags] <file>
+func runHead(args []string) error {
+	fs := newFlagSet("head", "[flags] <file>")
+	rows := fs.Int64("n", 10, "rows to print, all of them when negative")
+	columns := fs.String("columns", "", "comma separated columns to print")
+	where := fs.String("where", "", "filter expression, e.g. \"country = 'Angola'\"")
+	from := fs.String("from", "", "input format (parquet, csv, tsv, json, ndjson), guessed from the extension by default")
+	result := newResultFlags(fs, "format")
+	mmap := fs.Bool("mmap", false, "memory map a local input instead of reading it")
+	memory := newMemoryFlags(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fs.Usage()
+		return usagef("expected a file")
+	}
+	if err := result.check(); err != nil {
+		return err
+	}
+	if *rows == 0 {
+		return nil
+	}
+	opts := projectoptimizer.HeadOptions{
+		Rows:   *rows,
+		Where:  *where,
+		Format: *from,
+		Plan:   projectoptimizer.PlanOptions{Mmap: *mmap, MemoryLimit: *memory.limit},
+	}
+	if *columns != "" {
+		opts.Columns = strings.Split(*columns, ",")
+	}
+	in, err := projectoptimizer.Head(args[0], opts)
+	if err != nil {
+		return err
+	}
+	return writeResult(in, result, memory)
+}
+
+// parqlite schema [flags] <file>
+func runSchema(args []string) error {
+	fs := newFlagSet("schema", "[flags] <file>")
+	asJSON := fs.Bool("json", false, "print the schema as json")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fs.Usage()
+		return usagef("expected a file")
+	}
+	desc, err := projectoptimizer.ReadSchema(args[0], projectoptimizer.PlanOptions{})
+	if err != nil {
+		return err
+	}
+	if !*asJSON {
+		fmt.Print(desc)
+		return nil
+	}
+	b, err := desc.JSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// parqlite stats [flags] <file>
+func runStats(args []string) error {
+	fs := newFlagSet("stats", "[flags] <file>")
+	asJSON := fs.Bool("json", false, "print the stats as json")
+	mmap := fs.Bool("mmap", false, "memory map a local input instead of reading it")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fs.Usage()
+		return usagef("expected a file")
+	}
+	stats, err := projectoptimizer.Stats(args[0], projectoptimizer.PlanOptions{Mmap: *mmap})
+	if err != nil {
+		return err
+	}
+	if !*asJSON {
+		fmt.Print(stats)
+		return nil
+	}
+	b, err := stats.JSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// parqlite [shell] [flags] [file ...]
+func runShell(args []string) error {
+	fs := newFlagSet("shell", "[flags] [file ...]")
+	result := newResultFlags(fs, "mode")
+	timer := fs.Bool("timer", false, "report how long queries run")
+	history := fs.String("history", projectoptimizer.DefaultHistoryPath(), "file the shell history is kept in, empty keeps none")
+	mmap := fs.Bool("mmap", false, "memory map local files instead of reading them")
+	memory := newMemoryFlags(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := result.check(); err != nil {
+		return err
+	}
+
+	session := projectoptimizer.NewSession(projectoptimizer.PlanOptions{Mmap: *mmap, MemoryLimit: *memory.limit})
+	for _, path := range args {
+		if err := session.Register(projectoptimizer.TableName(path), path); err != nil {
+			return err
+		}
+	}
+	shell := projectoptimizer.NewShell(session, os.Stdout, os.Stderr)
+	shell.Mode, shell.Timer, shell.Memory = strings.ToLower(*result.format), *timer, *memory.report
+	shell.Result, shell.Pager = result.options(), result.pagerCommand()
+	lr, err := projectoptimizer.NewLineReader(os.Stdin, os.Stdout, *history)
+	if err != nil {
+		return err
+	}
+	defer lr.Close()
+	return shell.Run(lr)
+}
+
+// parqlite convert [flags] <input> <output>
+func runConvert(args []string) error {
+	fs := newFlagSet("convert", "[flags] <input> <output>")
+	from := fs.String("from", "", "input format (parquet, csv, tsv, json, ndjson), guessed from the extension by default")
+	to := fs.String("to", "", "output format, guessed from the extension by default")
+	columns := fs.String("columns", "", "comma separated columns to keep")
+	where := fs.String("where", "", "filter expression, e.g. \"country = 'Angola'\"")
+	compression := fs.String("compression", "snappy", "parquet compression: none, snappy, gzip, zstd, lz4, brotli")
+	delimiter := fs.String("delimiter", "", "csv delimiter for input and output")
+	bloomFilters := fs.String("bloom-filter", "", "comma separated parquet columns to write bloom filters for")
+	batchSize := fs.Uint("batch-size", 4096, "rows read per batch")
+	mmap := fs.Bool("mmap", false, "memory map a local input instead of reading it")
+	types := typeFlag{}
+	fs.Var(types, "type", "column type override as column=type, may be repeated")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		fs.Usage()
+		return usagef("expected an input and an output path")
+	}
+
+	opts := projectoptimizer.ConvertOptions{
+		Input:     projectoptimizer.InputOptions{Format: *from, Types: types, Mmap: *mmap},
+		Output:    projectoptimizer.OutputOptions{Format: *to, Compression: *compression},
+		Where:     *where,
+		BatchSize: *batchSize,
+	}
+	if *columns != "" {
+		opts.Input.Columns = strings.Split(*columns, ",")
+	}
+	if *bloomFilters != "" {
+		opts.Output.BloomFilters = strings.Split(*bloomFilters, ",")
+	}
+	if *delimiter != "" {
+		d := []rune(*delimiter)[0]
+		opts.Input.CSV.Delimiter = d
+		opts.Output.CSV.Delimiter = d
+	}
+	rows, err := projectoptimizer.Convert(args[0], args[1], opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d rows to %s\n", rows, args[1])
+	return nil
+}
+
+// parqlite inspect [flags] <file.parquet>
+func runInspect(args []string) error {
+	fs := newFlagSet("inspect", "[flags] <file.parquet>")
+	asJSON := fs.Bool("json", false, "print the report as json")
+	pages := fs.Bool("pages", false, "read and list the header of every page")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		fs.Usage()
+		return usagef("expected a parquet file")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := projectoptimizer.InspectFile(f, projectoptimizer.InspectOptions{Pages: *pages})
+	if err != nil {
+		return err
+	}
+	if !*asJSON {
+		fmt.Print(info)
+		return nil
+	}
+	b, err := info.JSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// repeated -table name=path flags
+type tableFlag map[string]string
+
+func (t tableFlag) String() string {
+	return ""
+}
+
+func (t tableFlag) Set(v string) error {
+	name, path, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected name=path, got %q", v)
+	}
+	t[strings.TrimSpace(name)] = path
+	return nil
+}
+
+// repeated -type column=type flags
+type typeFlag map[string]parquet.Type
+
+func (t typeFlag) String() string {
+	return ""
+}
+
+func (t typeFlag) Set(v string) error {
+	name, typ, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected column=type, got %q", v)
+	}
+	pt, err := projectoptimizer.ParseTypeName(typ)
+	if err != nil {
+		return err
+	}
+	t[strings.TrimSpace(name)] = pt
+	return nil
 }
 
 func DisplayRecords(displayer projectoptimizer.Display) {
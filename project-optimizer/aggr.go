@@ -2,6 +2,8 @@ package projectoptimizer
 
 import (
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/parquet-go/parquet-go"
 )
@@ -11,7 +13,9 @@ type AggregationExec interface {
 	Aggr() (any, error)
 }
 
-// implement sum,avg,count only
+// implement sum,avg,count only, AggregateExec adds min and max and computes
+// several aggregates in one pass over its input
+// NULLs follow sql: they are skipped, and sum/avg over no values is NULL
 
 type SumExec struct {
 	childInput Operator
@@ -23,17 +27,17 @@ type SumExec struct {
 func NewSumExec(input Operator, columnName string) (*SumExec, error) {
 	field, err := input.Schema().ColumnInfo(columnName)
 	if err != nil {
-		return nil, fmt.Errorf("column %q not found: %w", columnName, err)
+		return nil, err
 	}
 
-	if !isNumericType(field.PqType) {
+	if field.Node != nil || !isNumericType(field.PqType) {
 		return nil, fmt.Errorf("column %q has unsupported type %v for sum operation (must be numeric)", columnName, field.PqType)
 	}
 
 	// Build output schema with single result column
 	schema := &parquetSchema{
 		Fields: []structField{
-			{Name: fmt.Sprintf("sum_%s", columnName), PqType: parquet.DoubleType},
+			{Name: fmt.Sprintf("sum_%s", columnName), PqType: parquet.DoubleType, Optional: true},
 		},
 	}
 
@@ -48,20 +52,66 @@ func (s *SumExec) Schema() *parquetSchema {
 	return s.schema
 }
 
-// need to change the interfaces, shouldnt return a record batch should return a single value
-func (s *SumExec) Next(numRecords int) (RecordBatch, error) {
-	return RecordBatch{}, nil
+// drains the child and returns the sum as a float64, nil when every value is NULL
+func (s *SumExec) Aggr() (any, error) {
+	seen := false
+	s.result = 0
+	err := scanColumn(s.childInput, s.columnName, -1, func(v any) {
+		if f, ok := toFloat(v); ok {
+			s.result += f
+			seen = true
+		}
+	})
+	if err != nil || !seen {
+		return nil, err
+	}
+	return s.result, nil
 }
 
+// dates, timestamps and times are stored as integers but are not numbers,
+// int96 only holds timestamps
 func isNumericType(t parquet.Type) bool {
-	switch t {
-	case parquet.Int32Type, parquet.Int64Type, parquet.FloatType, parquet.DoubleType:
+	if isDateType(t) || isGroupType(t) {
+		return false
+	}
+	if lt := t.LogicalType(); lt != nil && (lt.Timestamp != nil || lt.Time != nil) {
+		return false
+	}
+	switch t.Kind() {
+	case parquet.Int32, parquet.Int64, parquet.Float, parquet.Double:
 		return true
 	default:
 		return false
 	}
 }
 
+// calls fn with every non NULL value of a column, the column is looked up
+// by name and falls back to idx
+func scanColumn(input Operator, columnName string, idx int, fn func(v any)) error {
+	if i, ok := schemaIndex(input.Schema())[strings.ToLower(columnName)]; ok {
+		idx = i
+	}
+	if idx < 0 || idx >= len(input.Schema().Fields) {
+		return fmt.Errorf("%w: %s", ErrColumnNotFound, columnName)
+	}
+	for {
+		batch, err := input.Next(defaultBatchSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if idx < len(batch.Columns) {
+			for _, v := range batch.Columns[idx] {
+				if v != nil {
+					fn(v)
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
 type AvgExec struct {
 	childInput Operator
 	columnName string
@@ -79,6 +129,29 @@ func NewAvgExec(input Operator, columnName, columnType string, columnIdx int) *A
 	}
 }
 
+func (a *AvgExec) Schema() *parquetSchema {
+	return &parquetSchema{Fields: []structField{
+		{Name: fmt.Sprintf("avg_%s", a.columnName), PqType: parquet.DoubleType, Optional: true},
+	}}
+}
+
+// average of the non NULL values, nil when there are none
+func (a *AvgExec) Aggr() (any, error) {
+	var sum float64
+	var n int
+	err := scanColumn(a.childInput, a.columnName, a.columnIdx, func(v any) {
+		if f, ok := toFloat(v); ok {
+			sum += f
+			n++
+		}
+	})
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	a.result = sum / float64(n)
+	return a.result, nil
+}
+
 type CountExec struct {
 	childInput Operator
 	columnName string
@@ -95,3 +168,210 @@ func NewCountExec(input Operator, columnName, columnType string, columnIdx int)
 		columnIdx:  columnIdx,
 	}
 }
+
+func (c *CountExec) Schema() *parquetSchema {
+	name := "count"
+	if c.columnName != "*" {
+		name = fmt.Sprintf("count_%s", c.columnName)
+	}
+	return &parquetSchema{Fields: []structField{{Name: name, PqType: parquet.Int64Type}}}
+}
+
+// number of non NULL values of the column as an int64, every row for "*"
+func (c *CountExec) Aggr() (any, error) {
+	c.tot = 0
+	if c.columnName == "*" {
+		for {
+			batch, err := c.childInput.Next(defaultBatchSize)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			c.tot += uint64(batch.NumRows())
+			if err == io.EOF {
+				return int64(c.tot), nil
+			}
+		}
+	}
+	err := scanColumn(c.childInput, c.columnName, c.columnIdx, func(any) { c.tot++ })
+	if err != nil {
+		return nil, err
+	}
+	return int64(c.tot), nil
+}
+
+// Aggregate is an aggregate function over a column, Column is "*" for
+// COUNT(*)
+type Aggregate struct {
+	Func   string // count, sum, avg, min or max
+	Column string
+}
+
+// Name is the output column, sum_x like SumExec and count for COUNT(*)
+func (a Aggregate) Name() string {
+	if a.Column == "*" {
+		return a.Func
+	}
+	return a.Func + "_" + a.Column
+}
+
+func (a Aggregate) String() string {
+	if a.Column == "*" {
+		return strings.ToUpper(a.Func) + "(*)"
+	}
+	return strings.ToUpper(a.Func) + "(" + quoteIdent(a.Column) + ")"
+}
+
+// AggregateExec returns a single row with every aggregate of its input
+type AggregateExec struct {
+	childInput Operator
+	aggrs      []Aggregate
+	idx        []int // input column of every aggregate, -1 for COUNT(*)
+	schema     *parquetSchema
+	done       bool
+}
+
+func NewAggregateExec(input Operator, aggrs []Aggregate) (*AggregateExec, error) {
+	if len(aggrs) == 0 {
+		return nil, fmt.Errorf("no aggregates")
+	}
+	colIdx := schemaIndex(input.Schema())
+	a := &AggregateExec{childInput: input, aggrs: aggrs, idx: make([]int, len(aggrs)), schema: &parquetSchema{}}
+	for i, aggr := range aggrs {
+		a.idx[i] = -1
+		if aggr.Column == "*" {
+			if aggr.Func != "count" {
+				return nil, fmt.Errorf("%s(*) is not supported", aggr.Func)
+			}
+			a.schema.Fields = append(a.schema.Fields, structField{Name: aggr.Name(), PqType: parquet.Int64Type})
+			continue
+		}
+		idx, ok := colIdx[strings.ToLower(aggr.Column)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrColumnNotFound, aggr.Column)
+		}
+		a.idx[i] = idx
+		field := input.Schema().Fields[idx]
+		out := structField{Name: aggr.Name(), PqType: parquet.DoubleType, Optional: true}
+		switch aggr.Func {
+		case "count":
+			out = structField{Name: aggr.Name(), PqType: parquet.Int64Type}
+		case "sum", "avg":
+			if field.Node != nil || !isNumericType(field.PqType) {
+				return nil, fmt.Errorf("column %q has unsupported type %v for %s operation (must be numeric)", aggr.Column, field.PqType, aggr.Func)
+			}
+		case "min", "max":
+			if field.Node != nil {
+				return nil, fmt.Errorf("column %q is nested, %s needs a primitive column", aggr.Column, aggr.Func)
+			}
+			out.PqType = field.PqType
+		default:
+			return nil, fmt.Errorf("unknown aggregate %s", aggr.Func)
+		}
+		a.schema.Fields = append(a.schema.Fields, out)
+	}
+	return a, nil
+}
+
+func (a *AggregateExec) Schema() *parquetSchema {
+	return a.schema
+}
+
+// drains the input on the first call and returns the only row with io.EOF
+func (a *AggregateExec) Next(uint) (RecordBatch, error) {
+	out := RecordBatch{Schema: *a.schema, Columns: make([][]any, len(a.aggrs))}
+	if a.done {
+		return out, io.EOF
+	}
+	counts := make([]int64, len(a.aggrs))
+	sums := make([]float64, len(a.aggrs))
+	values := make([]any, len(a.aggrs))
+	for {
+		batch, err := a.childInput.Next(defaultBatchSize)
+		if err != nil && err != io.EOF {
+			return RecordBatch{}, err
+		}
+		for i, aggr := range a.aggrs {
+			if a.idx[i] < 0 {
+				counts[i] += int64(batch.NumRows())
+				continue
+			}
+			if dc := batch.Dict(a.idx[i]); dc != nil && aggr.Func != "sum" && aggr.Func != "avg" {
+				n, v := aggregateCodes(aggr.Func, dc, values[i])
+				counts[i] += n
+				values[i] = v
+				continue
+			}
+			for _, v := range batch.Columns[a.idx[i]] {
+				if v == nil {
+					continue
+				}
+				counts[i]++
+				switch aggr.Func {
+				case "sum", "avg":
+					f, _ := toFloat(v)
+					sums[i] += f
+				case "min", "max":
+					if replacesExtreme(aggr.Func, v, values[i]) {
+						values[i] = v
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	for i, aggr := range a.aggrs {
+		var v any
+		switch {
+		case aggr.Func == "count":
+			v = counts[i]
+		case counts[i] == 0:
+			// NULL
+		case aggr.Func == "sum":
+			v = sums[i]
+		case aggr.Func == "avg":
+			v = sums[i] / float64(counts[i])
+		default:
+			v = values[i]
+		}
+		out.Columns[i] = []any{v}
+	}
+	a.done = true
+	return out, io.EOF
+}
+
+// whether v is a new min or max over cur, nil before the first value
+func replacesExtreme(fn string, v, cur any) bool {
+	if cur == nil {
+		return true
+	}
+	cmp := sortCompare(v, cur)
+	return cmp < 0 && fn == "min" || cmp > 0 && fn == "max"
+}
+
+// count, min or max of a dictionary encoded column: rows are counted by
+// their codes and only the entries they use are compared, once each.
+// returns the rows counted and the new min or max over cur
+func aggregateCodes(fn string, dc *DictColumn, cur any) (int64, any) {
+	var seen []bool
+	if fn != "count" {
+		seen = make([]bool, len(dc.Dict.Values))
+	}
+	var n int64
+	for _, code := range dc.Codes {
+		if code < 0 {
+			continue
+		}
+		n++
+		if seen != nil {
+			seen[code] = true
+		}
+	}
+	for code, used := range seen {
+		if used && replacesExtreme(fn, dc.Dict.Values[code], cur) {
+			cur = dc.Dict.Values[code]
+		}
+	}
+	return n, cur
+}
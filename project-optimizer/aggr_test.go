@@ -0,0 +1,87 @@
+package projectoptimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func nullableInput(t *testing.T) Operator {
+	t.Helper()
+	src := `{"name": "a", "score": 10}
+{"name": "b", "score": null}
+{"name": "c"}
+{"name": "d", "score": 20}
+`
+	op, err := NewJSONExecLeaf(strings.NewReader(src), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func TestAggregatesSkipNulls(t *testing.T) {
+	sum, err := NewSumExec(nullableInput(t), "score")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := sum.Aggr(); err != nil || got != float64(30) {
+		t.Fatalf("expected sum 30, got %v (%v)", got, err)
+	}
+
+	avg := NewAvgExec(nullableInput(t), "score", "int64", -1)
+	if got, err := avg.Aggr(); err != nil || got != float64(15) {
+		t.Fatalf("expected avg 15 ignoring NULLs, got %v (%v)", got, err)
+	}
+
+	count := NewCountExec(nullableInput(t), "score", "int64", -1)
+	if got, err := count.Aggr(); err != nil || got != int64(2) {
+		t.Fatalf("expected count 2, got %v (%v)", got, err)
+	}
+	rows := NewCountExec(nullableInput(t), "*", "", -1)
+	if got, err := rows.Aggr(); err != nil || got != int64(4) {
+		t.Fatalf("expected count(*) 4, got %v (%v)", got, err)
+	}
+}
+
+func TestAggregatesOverOnlyNulls(t *testing.T) {
+	where, err := ParseExpr("score IS NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := NewFilterExec(nullableInput(t), where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := NewSumExec(filtered, "score")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := sum.Aggr(); err != nil || got != nil {
+		t.Fatalf("expected NULL sum, got %v (%v)", got, err)
+	}
+}
+
+func TestTimesAreNotNumeric(t *testing.T) {
+	for _, name := range []string{"timestamp", "int96", "time", "date"} {
+		typ, err := ParseTypeName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isNumericType(typ) {
+			t.Errorf("expected %s not to be numeric", name)
+		}
+		schema := &parquetSchema{Fields: []structField{{Name: "at", PqType: typ, Optional: true}}}
+		in, err := NewJSONExecLeaf(strings.NewReader(`{"at": null}`), JSONOptions{Schema: schema})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewAggregateExec(in, []Aggregate{{Func: "sum", Column: "at"}}); err == nil {
+			t.Errorf("expected sum of a %s column to be rejected", name)
+		}
+	}
+	if !isNumericType(parquet.Int(16).Type()) || !isNumericType(parquet.DoubleType) {
+		t.Fatal("expected integers and doubles to be numeric")
+	}
+}
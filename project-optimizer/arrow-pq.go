@@ -3,12 +3,11 @@ package projectoptimizer
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
-	"os"
 
 	"github.com/apache/arrow/go/v15/arrow"
 	"github.com/apache/arrow/go/v15/arrow/array"
-	"github.com/apache/arrow/go/v15/arrow/memory"
 	"github.com/apache/arrow/go/v15/parquet"
 	"github.com/apache/arrow/go/v15/parquet/file"
 	"github.com/apache/arrow/go/v15/parquet/pqarrow"
@@ -17,7 +16,7 @@ import (
 
 // ArrowTest demonstrates reading parquet with Apache Arrow
 // This is the recommended approach for the execution engine
-func ArrowTest(f *os.File) {
+func ArrowTest(f io.ReaderAt) {
 	// Method 1: Using pqarrow (HIGH-LEVEL - RECOMMENDED)
 	readWithPqArrow(f)
 
@@ -26,11 +25,12 @@ func ArrowTest(f *os.File) {
 }
 
 // readWithPqArrow uses the high-level pqarrow API (easiest and most common)
-func readWithPqArrow(f *os.File) {
-	allocator := memory.NewGoAllocator()
+func readWithPqArrow(f io.ReaderAt) {
+	pool := NewMemoryPool(0)
+	allocator := pool.Allocator("arrow")
 
 	// First create low-level file reader
-	fileReader, err := file.NewParquetReader(f)
+	fileReader, err := file.NewParquetReader(arrowSource(f))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -57,6 +57,7 @@ func readWithPqArrow(f *os.File) {
 	fmt.Printf("Schema: %s\n", table.Schema())
 	fmt.Printf("NumRows: %d\n", table.NumRows())
 	fmt.Printf("NumCols: %d\n", table.NumCols())
+	fmt.Printf("Memory: %s\n", pool.Usage())
 
 	// Iterate through columns
 	for i := 0; i < int(table.NumCols()); i++ {
@@ -114,8 +115,8 @@ func printFirstValues(arr arrow.Array, n int) {
 }
 
 // readWithLowLevel demonstrates low-level API (for advanced use cases)
-func readWithLowLevel(f *os.File) {
-	reader, err := file.NewParquetReader(f)
+func readWithLowLevel(f io.ReaderAt) {
+	reader, err := file.NewParquetReader(arrowSource(f))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -202,6 +203,15 @@ func readColumnValues(col file.ColumnChunkReader, schemaCol *schema.Column) {
 	}
 }
 
+// arrow readers seek, sources are read through a section reader
+func arrowSource(f io.ReaderAt) parquet.ReaderAtSeeker {
+	src, err := AsSource(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return io.NewSectionReader(src, 0, src.Size())
+}
+
 func minInt(a, b int) int {
 	if a < b {
 		return a
@@ -209,11 +219,11 @@ func minInt(a, b int) int {
 	return b
 }
 
-func ReadRecordBatch(f *os.File, columns []string) {
-	allocator := memory.NewGoAllocator()
+func ReadRecordBatch(f io.ReaderAt, columns []string) {
+	allocator := NewMemoryPool(0).Allocator("arrow")
 
 	// First create low-level file reader
-	fileReader, err := file.NewParquetReader(f)
+	fileReader, err := file.NewParquetReader(arrowSource(f))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -242,19 +252,7 @@ func ReadRecordBatch(f *os.File, columns []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("rdr schema : %v", rdr.Schema())
-	return
 	defer rdr.Release()
-	idx := 0
-	for rdr.Next() && idx < 3 {
-		rec := rdr.Record()
-		cols := rec.NumCols()
-		for i := 0; i < int(cols); i++ {
-			col := rec.Column(i)
-			fmt.Printf("col (%d) : %v\n", i, col)
-		}
-
-		idx++
-	}
-
+	// only the schema of the record reader is shown
+	fmt.Printf("rdr schema : %v", rdr.Schema())
 }
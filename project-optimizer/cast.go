@@ -0,0 +1,201 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParseTypeName maps a user facing type name (int64, double, string...) to a parquet type
+func ParseTypeName(name string) (parquet.Type, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "bool", "boolean":
+		return parquet.BooleanType, nil
+	case "int32", "int":
+		return parquet.Int32Type, nil
+	case "int64", "bigint", "long":
+		return parquet.Int64Type, nil
+	case "float", "float32":
+		return parquet.FloatType, nil
+	case "double", "float64":
+		return parquet.DoubleType, nil
+	case "string", "utf8", "text":
+		return parquet.String().Type(), nil
+	case "date":
+		return parquet.Date().Type(), nil
+	case "timestamp", "timestamp_us":
+		return parquet.Timestamp(parquet.Microsecond).Type(), nil
+	case "timestamp_ms":
+		return parquet.Timestamp(parquet.Millisecond).Type(), nil
+	case "timestamp_ns":
+		return parquet.Timestamp(parquet.Nanosecond).Type(), nil
+	case "time":
+		return parquet.Time(parquet.Microsecond).Type(), nil
+	case "uuid":
+		return parquet.UUID().Type(), nil
+	case "enum":
+		return parquet.Enum().Type(), nil
+	case "json":
+		return parquet.JSON().Type(), nil
+	case "int96":
+		return parquet.Int96Type, nil
+	}
+	// decimal(precision, scale)
+	var precision, scale int
+	if _, err := fmt.Sscanf(strings.ReplaceAll(strings.ToLower(name), " ", ""), "decimal(%d,%d)", &precision, &scale); err == nil {
+		if precision < 1 || precision > maxDecimalPrecision || scale < 0 || scale > precision {
+			return nil, fmt.Errorf("invalid decimal precision %d and scale %d", precision, scale)
+		}
+		base := parquet.Int64Type
+		switch {
+		case precision <= 9:
+			base = parquet.Int32Type
+		case precision > 18:
+			base = parquet.FixedLenByteArrayType(decimalLength(precision))
+		}
+		return parquet.Decimal(scale, precision, base).Type(), nil
+	}
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+// the largest precision of a 16 byte decimal, as arrow and spark read them
+const maxDecimalPrecision = 38
+
+// fewest bytes of two's complement holding every value of precision digits
+func decimalLength(precision int) int {
+	return int(math.Ceil((float64(precision)*math.Log2(10) + 1) / 8))
+}
+
+// converts a RecordBatch value to the go type used for a parquet type
+func castValue(v any, to parquet.Type) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if out, ok, err := castLogical(v, to); ok {
+		return out, err
+	}
+	if isDateType(to) {
+		switch x := v.(type) {
+		case time.Time:
+			return x, nil
+		case string:
+			return parseDate(x)
+		}
+		return nil, fmt.Errorf("cannot cast %T to date", v)
+	}
+	if to.Kind() == parquet.ByteArray || to.Kind() == parquet.FixedLenByteArray {
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case float32:
+			return strconv.FormatFloat(float64(x), 'f', -1, 32), nil
+		case float64:
+			return strconv.FormatFloat(x, 'f', -1, 64), nil
+		}
+		return formatValue(v), nil
+	}
+	if s, ok := v.(string); ok {
+		return parseCell(strings.TrimSpace(s), to)
+	}
+	if b, ok := v.(bool); ok {
+		if to.Kind() == parquet.Boolean {
+			return b, nil
+		}
+		return nil, fmt.Errorf("cannot cast bool to %v", to)
+	}
+	f, ok := toFloat(v)
+	if !ok {
+		return nil, fmt.Errorf("cannot cast %T to %v", v, to)
+	}
+	switch to.Kind() {
+	case parquet.Boolean:
+		return f != 0, nil
+	case parquet.Int32:
+		i, err := castInt(v, f, 32)
+		return int32(i), err
+	case parquet.Int64:
+		return castInt(v, f, 64)
+	case parquet.Float:
+		return float32(f), nil
+	case parquet.Double:
+		return f, nil
+	}
+	return nil, fmt.Errorf("cannot cast %T to %v", v, to)
+}
+
+// integers are cast when they fit in bits, floats when they also have no
+// fractional part
+func castInt(v any, f float64, bits int) (int64, error) {
+	if i, ok := v.(int64); ok {
+		if bits < 64 && (i < -1<<(bits-1) || i >= 1<<(bits-1)) {
+			return 0, fmt.Errorf("cannot cast %d to int%d: out of range", i, bits)
+		}
+		return i, nil
+	}
+	if f != math.Trunc(f) {
+		return 0, fmt.Errorf("cannot cast %v to int%d: not an integer", v, bits)
+	}
+	// the float of the largest integer rounds up to the bound
+	if limit := math.Ldexp(1, bits-1); f < -limit || f >= limit {
+		return 0, fmt.Errorf("cannot cast %v to int%d: out of range", v, bits)
+	}
+	return int64(f), nil
+}
+
+// CastExec changes the type of selected columns of its child
+type CastExec struct {
+	childInput Operator
+	schema     *parquetSchema
+	casts      map[int]parquet.Type
+}
+
+func NewCastExec(input Operator, types map[string]parquet.Type) (*CastExec, error) {
+	schema := input.Schema().Clone()
+	casts := make(map[int]parquet.Type)
+	for name, t := range types {
+		found := false
+		for i := range schema.Fields {
+			if strings.EqualFold(schema.Fields[i].Name, name) {
+				if schema.Fields[i].Node != nil {
+					return nil, fmt.Errorf("cast column %q is nested, only flat columns can be cast", name)
+				}
+				schema.Fields[i].PqType = t
+				casts[i] = t
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("cast column %q not found in input schema", name)
+		}
+	}
+	return &CastExec{childInput: input, schema: schema, casts: casts}, nil
+}
+
+func (c *CastExec) Next(n uint) (RecordBatch, error) {
+	batch, err := c.childInput.Next(n)
+	out := RecordBatch{Schema: *c.schema, Columns: make([][]any, len(batch.Columns))}
+	copy(out.Columns, batch.Columns)
+	for i, t := range c.casts {
+		if i >= len(batch.Columns) {
+			continue
+		}
+		col := make([]any, len(batch.Columns[i]))
+		for row, v := range batch.Columns[i] {
+			cv, cerr := castValue(v, t)
+			if cerr != nil {
+				return out, fmt.Errorf("casting column %q: %w", c.schema.Fields[i].Name, cerr)
+			}
+			col[row] = cv
+		}
+		out.Columns[i] = col
+	}
+	return out, err
+}
+
+func (c *CastExec) Schema() *parquetSchema {
+	return c.schema
+}
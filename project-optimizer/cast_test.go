@@ -0,0 +1,46 @@
+package projectoptimizer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestCastIntegers(t *testing.T) {
+	for _, tc := range []struct {
+		v    any
+		to   parquet.Type
+		want any
+	}{
+		{int64(-1 << 31), parquet.Int32Type, int32(-1 << 31)},
+		{float64(42), parquet.Int32Type, int32(42)},
+		{float32(-7), parquet.Int64Type, int64(-7)},
+		{int64(math.MaxInt64), parquet.Int64Type, int64(math.MaxInt64)},
+	} {
+		got, err := castValue(tc.v, tc.to)
+		if err != nil || got != tc.want {
+			t.Errorf("%T %v to %v: expected %v, got %v (%v)", tc.v, tc.v, tc.to, tc.want, got, err)
+		}
+	}
+	for _, tc := range []struct {
+		v  any
+		to parquet.Type
+	}{
+		{int64(1 << 31), parquet.Int32Type},    // wraps around
+		{int64(-1<<31 - 1), parquet.Int32Type}, // wraps around
+		{float64(3e9), parquet.Int32Type},      // out of range
+		{float64(1.5), parquet.Int32Type},      // fractional
+		{float64(-0.25), parquet.Int64Type},    // fractional
+		{float64(1e19), parquet.Int64Type},     // out of range
+		{uint64(math.MaxUint64), parquet.Int64Type},
+		{math.Inf(1), parquet.Int64Type},
+		{math.Inf(-1), parquet.Int32Type},
+		{math.NaN(), parquet.Int64Type},
+		{float32(math.NaN()), parquet.Int32Type},
+	} {
+		if got, err := castValue(tc.v, tc.to); err == nil {
+			t.Errorf("%T %v to %v: expected an error, got %v", tc.v, tc.v, tc.to, got)
+		}
+	}
+}
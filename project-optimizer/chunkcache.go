@@ -0,0 +1,328 @@
+package projectoptimizer
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/parquet-go/parquet-go"
+)
+
+// decoded column chunks of local files kept as arrow arrays. a leaf given a
+// ChunkCache reads whole column chunks through it instead of decoding rows,
+// every leaf sharing the cache reuses the chunks any of them decoded, a chunk
+// being decoded is waited for rather than decoded twice. chunks are keyed by
+// file, row group and column; a file is known by its path, size and
+// modification time so a rewritten file is never served from old chunks
+
+// ChunkCache keeps decoded column chunks up to a number of bytes, least
+// recently used first out. it is safe for concurrent use
+type ChunkCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[chunkKey]*chunkEntry
+	lru      list.List   // of loaded *chunkEntry, most recently used first
+	pool     *MemoryPool // counts the arrow buffers of the chunks, not bounded by it
+	mem      memory.Allocator
+
+	hits, misses, evictions atomic.Int64
+}
+
+// ChunkCacheStats counts the lookups of a ChunkCache
+type ChunkCacheStats struct {
+	Hits, Misses, Evictions int64
+	Entries                 int
+	Bytes                   int64 // of the arrays held
+	Allocated               int64 // arrow buffers not released yet, held by the cache or by leaves
+}
+
+type chunkKey struct {
+	path     string
+	size     int64
+	modTime  int64 // unix nanoseconds
+	rowGroup int
+	column   int // leaf column in the file
+}
+
+type chunkEntry struct {
+	key   chunkKey
+	ready chan struct{} // closed once arr or err is set
+	arr   arrow.Array
+	err   error
+	size  int64
+	elem  *list.Element // nil while loading and once evicted
+}
+
+// NewChunkCache keeps up to maxBytes of decoded chunks
+func NewChunkCache(maxBytes int64) *ChunkCache {
+	pool := NewMemoryPool(0)
+	return &ChunkCache{maxBytes: maxBytes, entries: make(map[chunkKey]*chunkEntry), pool: pool, mem: pool.Allocator("chunk cache")}
+}
+
+// SetMaxBytes changes the limit and evicts what no longer fits
+func (c *ChunkCache) SetMaxBytes(maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// Clear drops every chunk, leaves keep the ones they are reading
+func (c *ChunkCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back().Value.(*chunkEntry))
+	}
+}
+
+func (c *ChunkCache) Stats() ChunkCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ChunkCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   c.lru.Len(),
+		Bytes:     c.bytes,
+		Allocated: c.pool.Usage().Used,
+	}
+}
+
+// the chunk at key, decoded by load on a miss. the caller owns a reference
+// to the array and releases it
+func (c *ChunkCache) get(key chunkKey, load func() (arrow.Array, error)) (arrow.Array, error) {
+	for {
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		if !ok {
+			entry = &chunkEntry{key: key, ready: make(chan struct{})}
+			c.entries[key] = entry
+			c.mu.Unlock()
+			c.misses.Add(1)
+			return c.load(entry, load)
+		}
+		if entry.elem != nil {
+			c.lru.MoveToFront(entry.elem)
+			entry.arr.Retain()
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return entry.arr, nil
+		}
+		c.mu.Unlock()
+		<-entry.ready
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		// decoded by another leaf, it may have been evicted already
+	}
+}
+
+func (c *ChunkCache) load(entry *chunkEntry, load func() (arrow.Array, error)) (arrow.Array, error) {
+	arr, err := load()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(entry.ready)
+	if err != nil {
+		entry.err = err
+		delete(c.entries, entry.key)
+		return nil, err
+	}
+	entry.arr, entry.size = arr, arraySize(arr.Data())
+	if entry.size > c.maxBytes {
+		delete(c.entries, entry.key) // never fits, the caller keeps it
+		return arr, nil
+	}
+	arr.Retain() // the reference of the cache
+	entry.elem = c.lru.PushFront(entry)
+	c.bytes += entry.size
+	c.evict()
+	return arr, nil
+}
+
+// drops the least recently used chunks above the limit, must be called with mu held
+func (c *ChunkCache) evict() {
+	for c.lru.Len() > 0 && c.bytes > c.maxBytes {
+		c.remove(c.lru.Back().Value.(*chunkEntry))
+		c.evictions.Add(1)
+	}
+}
+
+func (c *ChunkCache) remove(entry *chunkEntry) {
+	c.lru.Remove(entry.elem)
+	entry.elem = nil
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+	entry.arr.Release()
+}
+
+// bytes of the buffers of an array and its children
+func arraySize(data arrow.ArrayData) int64 {
+	var n int64
+	for _, b := range data.Buffers() {
+		if b != nil {
+			n += int64(b.Len())
+		}
+	}
+	for _, child := range data.Children() {
+		n += arraySize(child)
+	}
+	return n
+}
+
+// a leaf reading the column chunks of a local file through a ChunkCache,
+// one row group at a time
+type cachedChunks struct {
+	cache   *ChunkCache
+	file    *parquet.File
+	key     chunkKey // of the file, row group and column are set per chunk
+	fields  []structField
+	columns []int // leaf column of every field in the file
+	types   []arrow.DataType
+	ends    []int64 // first row after every row group
+
+	group  int // row group of arrays, -1 before the first one
+	start  int64
+	arrays []arrow.Array
+}
+
+// reads the leaf through cache when src is a local file and every field is a
+// flat column arrow can hold, nil otherwise
+func newCachedChunks(cache *ChunkCache, src io.ReaderAt, schema *parquetSchema) *cachedChunks {
+	if s, ok := src.(sizedReaderAt); ok {
+		src = s.ReaderAt
+	}
+	f, ok := src.(*os.File)
+	if !ok {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	pf, err := parquetFileOf(f)
+	if err != nil {
+		return nil
+	}
+	path, err := filepath.Abs(f.Name())
+	if err != nil {
+		return nil
+	}
+	c := &cachedChunks{
+		cache:   cache,
+		file:    pf,
+		key:     chunkKey{path: path, size: info.Size(), modTime: info.ModTime().UnixNano()},
+		fields:  schema.Fields,
+		columns: make([]int, len(schema.Fields)),
+		types:   make([]arrow.DataType, len(schema.Fields)),
+		group:   -1,
+	}
+	for i, field := range schema.Fields {
+		ref, ok := findNode(pf.Schema(), field.Name)
+		if !ok || field.Node != nil || !ref.node.Leaf() || len(ref.path) != 1 {
+			return nil
+		}
+		if c.types[i] = arrowType(field.PqType); c.types[i] == nil {
+			return nil
+		}
+		c.columns[i] = ref.column
+	}
+	var end int64
+	for _, rg := range pf.RowGroups() {
+		end += rg.NumRows()
+		c.ends = append(c.ends, end)
+	}
+	return c
+}
+
+// decodes the values of a row into values, io.EOF after the last row
+func (c *cachedChunks) read(row int64, values []any) error {
+	if err := c.seek(row); err != nil {
+		return err
+	}
+	i := int(row - c.start)
+	for f, arr := range c.arrays {
+		values[f] = arrowColumnValue(c.fields[f], arr, i)
+	}
+	return nil
+}
+
+// makes arrays hold the chunks of the row group of row
+func (c *cachedChunks) seek(row int64) error {
+	if c.group >= 0 && row >= c.start && row < c.ends[c.group] {
+		return nil
+	}
+	c.release()
+	g := 0
+	for g < len(c.ends) && row >= c.ends[g] {
+		g++
+	}
+	if g == len(c.ends) {
+		return io.EOF
+	}
+	rg := c.file.RowGroups()[g]
+	for f, column := range c.columns {
+		key := c.key
+		key.rowGroup, key.column = g, column
+		arr, err := c.cache.get(key, func() (arrow.Array, error) {
+			arrays, err := decodeChunkArrays(nil, rg.ColumnChunks()[column], c.types[f], c.cache.mem)
+			if err != nil {
+				return nil, fmt.Errorf("decoding column %s of row group %d: %w", c.fields[f].Name, g, err)
+			}
+			return arrays[0], nil
+		})
+		if err != nil {
+			c.release()
+			return err
+		}
+		c.arrays = append(c.arrays, arr)
+	}
+	c.group, c.start = g, c.ends[g]-rg.NumRows()
+	return nil
+}
+
+// drops the chunks of the current row group
+func (c *cachedChunks) release() {
+	for _, arr := range c.arrays {
+		arr.Release()
+	}
+	c.arrays, c.group = c.arrays[:0], -1
+}
+
+// the value the leaf decodes from a parquet value, read from an arrow array
+func arrowColumnValue(field structField, arr arrow.Array, i int) any {
+	if arr.IsNull(i) {
+		return nil
+	}
+	var v parquet.Value
+	switch a := arr.(type) {
+	case *array.Boolean:
+		v = parquet.BooleanValue(a.Value(i))
+	case *array.Int32:
+		v = parquet.Int32Value(a.Value(i))
+	case *array.Date32:
+		v = parquet.Int32Value(int32(a.Value(i)))
+	case *array.Int64:
+		v = parquet.Int64Value(a.Value(i))
+	case *array.Timestamp:
+		v = parquet.Int64Value(int64(a.Value(i)))
+	case *array.Float32:
+		v = parquet.FloatValue(a.Value(i))
+	case *array.Float64:
+		v = parquet.DoubleValue(a.Value(i))
+	case *array.String:
+		return strings.Clone(a.Value(i))
+	case *array.Binary:
+		return string(a.Value(i))
+	}
+	return columnValue(field, v)
+}
@@ -0,0 +1,174 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func readWithChunkCache(t *testing.T, path string, columns []string, cache *ChunkCache, where Expr) RecordBatch {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	leaf := NewProjectExecLeaf(f, columns, nil)
+	if cache != nil && !leaf.UseChunkCache(cache) {
+		t.Fatalf("expected %v to be read through the cache", columns)
+	}
+	if where != nil {
+		if _, err := leaf.PruneRowGroups(where); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return drain(t, leaf)
+}
+
+func TestChunkCacheServesLeaves(t *testing.T) {
+	path := writeMmapFile(t, parquet.Compression(&parquet.Snappy))
+	columns := []string{"name", "bonus", "score"}
+	want := readWithChunkCache(t, path, columns, nil, nil)
+
+	cache := NewChunkCache(1 << 20)
+	got := readWithChunkCache(t, path, columns, cache, nil)
+	if !reflect.DeepEqual(got.Columns, want.Columns) {
+		t.Fatalf("rows read through the cache differ")
+	}
+	// 3 row groups of 3 columns
+	if stats := cache.Stats(); stats.Misses != 9 || stats.Hits != 0 || stats.Entries != 9 || stats.Allocated < stats.Bytes {
+		t.Fatalf("expected every chunk to be decoded once, got %+v", stats)
+	}
+	got = readWithChunkCache(t, path, columns, cache, nil)
+	if stats := cache.Stats(); stats.Misses != 9 || stats.Hits != 9 || !reflect.DeepEqual(got.Columns, want.Columns) {
+		t.Fatalf("expected the second read to be served from the cache, got %+v", stats)
+	}
+
+	// pruned row groups are not decoded
+	where, err := ParseExpr("score >= 200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache = NewChunkCache(1 << 20)
+	if got := readWithChunkCache(t, path, columns, cache, where); got.NumRows() != 200 {
+		t.Fatalf("expected the last row group, got %d rows", got.NumRows())
+	}
+	if stats := cache.Stats(); stats.Misses != 3 {
+		t.Fatalf("expected the chunks of one row group, got %+v", stats)
+	}
+
+	f, err := os.Open("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if leaf := NewProjectExecLeaf(f, []string{"country", "lat"}, nil); !leaf.UseChunkCache(cache) {
+		t.Fatalf("expected history to be read through the cache")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := NewProjectExecLeaf(BytesSource(data), columns, nil); leaf.UseChunkCache(cache) {
+		t.Fatalf("expected sources that are not local files to be read row by row")
+	}
+}
+
+func TestChunkCacheSharedAcrossConcurrentLeaves(t *testing.T) {
+	path := writeMmapFile(t)
+	columns := []string{"id", "name"}
+	cache := NewChunkCache(1 << 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := readWithChunkCache(t, path, columns, cache, nil); got.NumRows() != 1000 {
+				t.Errorf("expected 1000 rows, got %d", got.NumRows())
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := cache.Stats(); stats.Misses != 6 || stats.Hits != 7*6 {
+		t.Fatalf("expected each chunk to be decoded once, got %+v", stats)
+	}
+}
+
+func TestChunkCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	path := writeMmapFile(t)
+	cache := NewChunkCache(1 << 20)
+	readWithChunkCache(t, path, []string{"id"}, cache, nil)
+	max := cache.Stats().Bytes - 1
+
+	// short of a byte, the chunk of the first row group is evicted
+	cache.SetMaxBytes(max)
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Evictions != 1 || stats.Bytes > max {
+		t.Fatalf("expected one chunk to be evicted, got %+v", stats)
+	}
+	want := readWithChunkCache(t, path, []string{"id"}, nil, nil)
+	got := readWithChunkCache(t, path, []string{"id"}, cache, nil)
+	if !reflect.DeepEqual(got.Columns, want.Columns) {
+		t.Fatalf("rows differ after evictions")
+	}
+	if stats := cache.Stats(); stats.Bytes > max || stats.Misses != 3+3 {
+		t.Fatalf("expected every chunk to be decoded again in a cache too small for them, got %+v", stats)
+	}
+
+	cache.Clear()
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Fatalf("expected an empty cache, got %+v", stats)
+	}
+}
+
+func TestLeafReportsCorruptPages(t *testing.T) {
+	data, err := os.ReadFile(writeMmapFile(t, parquet.Compression(&parquet.Snappy)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// garble the pages of every column chunk, the footer and page index stay readable
+	pf, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rg := range pf.Metadata().RowGroups {
+		for _, chunk := range rg.Columns {
+			start := chunk.MetaData.DataPageOffset
+			if off := chunk.MetaData.DictionaryPageOffset; off > 0 && off < start {
+				start = off
+			}
+			for i := start; i < start+chunk.MetaData.TotalCompressedSize; i++ {
+				data[i] = 0xff
+			}
+		}
+	}
+	path := filepath.Join(t.TempDir(), "corrupt.parquet")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, cache := range []*ChunkCache{nil, NewChunkCache(1 << 20)} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf := NewProjectExecLeaf(f, []string{"name", "score"}, nil)
+		if cache != nil && !leaf.UseChunkCache(cache) {
+			t.Fatalf("expected the file to be read through the cache")
+		}
+		// the error ends the scan instead of a short batch without one
+		var calls int
+		for err == nil && calls < 100 {
+			_, err = leaf.Next(64)
+			calls++
+		}
+		f.Close()
+		if err == nil || err == io.EOF {
+			t.Fatalf("cached=%v: expected a read error, got %v after %d batches", cache != nil, err, calls)
+		}
+	}
+}
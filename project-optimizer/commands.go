@@ -0,0 +1,175 @@
+package projectoptimizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// the commands of the parqlite cli as library calls: Query, Head, ReadSchema
+// and Stats. errors in what was asked for wrap ErrInvalidQuery or
+// ErrColumnNotFound, other errors come from reading the data
+
+// Query runs a query over tables, a map of table names to paths
+func Query(query string, tables map[string]string, opts PlanOptions) (*Input, error) {
+	s := NewSession(opts)
+	for name, source := range tables {
+		s.tables[name] = source
+	}
+	return s.Query(query)
+}
+
+type HeadOptions struct {
+	Rows    int64    // 10 when 0, every row when negative
+	Columns []string // every column when empty
+	Where   string   // filter expression, see ParseExpr
+	Format  string   // of the file, guessed from the extension when empty
+	Plan    PlanOptions
+}
+
+// Head reads the first rows of a file, dataset or url
+func Head(source string, opts HeadOptions) (*Input, error) {
+	if opts.Rows == 0 {
+		opts.Rows = 10
+	}
+	var p Plan = &ScanPlan{Path: source, Format: opts.Format}
+	if opts.Where != "" {
+		where, err := ParseExpr(opts.Where)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		p = &FilterPlan{Input: p, Where: where}
+	}
+	if len(opts.Columns) > 0 {
+		p = &ProjectPlan{Input: p, Columns: opts.Columns}
+	}
+	if opts.Rows > 0 {
+		p = &LimitPlan{Input: p, N: opts.Rows}
+	}
+	return BuildPlan(p, opts.Plan)
+}
+
+// ReadSchema describes the columns of a file, dataset or url. local parquet
+// files report the encodings and codecs of their columns
+func ReadSchema(source string, opts PlanOptions) (*SchemaDescription, error) {
+	format, err := scanFormat(source, "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	if info, err := os.Stat(source); err == nil && !info.IsDir() && format == FormatParquet {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return DescribeSchema(f)
+	}
+	in, err := BuildPlan(&ScanPlan{Path: source, Format: format}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return in.Schema().Describe(), nil
+}
+
+// TableStats summarizes every column of a table
+type TableStats struct {
+	Path    string          `json:"path"`
+	Rows    int64           `json:"rows"`
+	Columns []ColumnSummary `json:"columns"`
+}
+
+// ColumnSummary is computed from the values of a column, Min and Max are
+// NULL for nested columns and Mean for non numeric ones
+type ColumnSummary struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Nulls int64  `json:"nulls"`
+	Min   any    `json:"min"`
+	Max   any    `json:"max"`
+	Mean  any    `json:"mean"`
+}
+
+// Stats reads a whole file, dataset or url once to summarize its columns
+func Stats(source string, opts PlanOptions) (*TableStats, error) {
+	in, err := BuildPlan(&ScanPlan{Path: source}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	fields := in.Schema().Fields
+	aggrs := []Aggregate{{Func: "count", Column: "*"}}
+	for _, field := range fields {
+		aggrs = append(aggrs, Aggregate{Func: "count", Column: field.Name})
+		if field.Node == nil {
+			aggrs = append(aggrs, Aggregate{Func: "min", Column: field.Name}, Aggregate{Func: "max", Column: field.Name})
+		}
+		if field.Node == nil && isNumericType(field.PqType) {
+			aggrs = append(aggrs, Aggregate{Func: "avg", Column: field.Name})
+		}
+	}
+	op, err := NewAggregateExec(in.Operator, aggrs)
+	if err != nil {
+		return nil, err
+	}
+	row, err := op.Next(1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	values := make([]any, len(row.Columns))
+	for i, col := range row.Columns {
+		values[i] = col[0]
+	}
+	stats := &TableStats{Path: source, Rows: values[0].(int64)}
+	next := 1
+	for _, field := range fields {
+		summary := ColumnSummary{Name: field.Name, Type: fieldTypeName(field)}
+		summary.Nulls = stats.Rows - values[next].(int64)
+		next++
+		if field.Node == nil {
+			summary.Min, summary.Max = values[next], values[next+1]
+			next += 2
+		}
+		if field.Node == nil && isNumericType(field.PqType) {
+			summary.Mean = values[next]
+			next++
+		}
+		stats.Columns = append(stats.Columns, summary)
+	}
+	return stats, nil
+}
+
+// JSON renders the stats for tools
+func (s *TableStats) JSON() ([]byte, error) {
+	out := *s
+	out.Columns = make([]ColumnSummary, len(s.Columns))
+	for i, col := range s.Columns {
+		col.Min, col.Max, col.Mean = jsonValue(col.Min), jsonValue(col.Max), jsonValue(col.Mean)
+		out.Columns[i] = col
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// String renders the stats for people, one line per column
+func (s *TableStats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "file: %s\nrows: %d\n\n", s.Path, s.Rows)
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "column\ttype\tnulls\tmin\tmax\tmean")
+	for _, col := range s.Columns {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", col.Name, col.Type, col.Nulls,
+			truncate(statText(col.Min), 24), truncate(statText(col.Max), 24), statText(col.Mean))
+	}
+	tw.Flush()
+	return sb.String()
+}
+
+func statText(v any) string {
+	if v == nil {
+		return "-"
+	}
+	return textValue(v)
+}
@@ -0,0 +1,142 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestHead(t *testing.T) {
+	in, err := Head("../data/history.parquet", HeadOptions{
+		Rows:    3,
+		Columns: []string{"lat", "country"},
+		Where:   "country = 'Angola'",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	rows, err := WriteResult(&out, in, FormatCSV, ResultOptions{})
+	in.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "lat,country\n" + strings.Repeat("-8.838333333,Angola\n", 3); rows != 3 || out.String() != want {
+		t.Fatalf("expected\n%s\ngot %d rows\n%s", want, rows, out.String())
+	}
+
+	in, err = Head("../data/history.parquet", HeadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(t, in); got.NumRows() != 10 || len(got.Columns) != len(in.Schema().Fields) {
+		t.Fatalf("expected 10 rows of every column, got %d", got.NumRows())
+	}
+	in.Close()
+
+	// mistakes of the caller are told apart from failures to read
+	if _, err := Head("../data/history.parquet", HeadOptions{Columns: []string{"nope"}}); !errors.Is(err, ErrColumnNotFound) {
+		t.Fatalf("expected ErrColumnNotFound, got %v", err)
+	}
+	if _, err := Head("../data/history.parquet", HeadOptions{Where: "lat >"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery, got %v", err)
+	}
+	if _, err := Head("../data/history.parquet", HeadOptions{Where: "nope > 1"}); !errors.Is(err, ErrColumnNotFound) {
+		t.Fatalf("expected ErrColumnNotFound, got %v", err)
+	}
+	_, err = Head("../data/missing.parquet", HeadOptions{})
+	if err == nil || errors.Is(err, ErrInvalidQuery) || errors.Is(err, ErrColumnNotFound) {
+		t.Fatalf("expected a read error, got %v", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	tables := map[string]string{"h": "../data/history.parquet"}
+	in, err := Query("SELECT count(*) FROM h WHERE country = 'Angola'", tables, PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if got := drain(t, in); got.Columns[0][0] != int64(307) {
+		t.Fatalf("expected 307 rows, got %v", got.Columns[0][0])
+	}
+	if _, err := Query("SELECT * FROM nope", tables, PlanOptions{}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery, got %v", err)
+	}
+	if _, err := Query("SELECT sum(country) FROM h", tables, PlanOptions{}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery, got %v", err)
+	}
+	if _, err := Query("SELECT * FROM h ORDER BY nope", tables, PlanOptions{}); !errors.Is(err, ErrColumnNotFound) {
+		t.Fatalf("expected ErrColumnNotFound, got %v", err)
+	}
+	if _, err := Query("SELECT count(*) FROM h ORDER BY nope", tables, PlanOptions{}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestReadSchema(t *testing.T) {
+	desc, err := ReadSchema("../data/history.parquet", PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.NumRows != 62321 || desc.Fields[1].Name != "country" || desc.Fields[1].Compression == "" {
+		t.Fatalf("unexpected schema\n%s", desc)
+	}
+
+	// other formats have no encodings
+	dir := t.TempDir()
+	if _, err := Convert("../data/history.parquet", dir+"/history.csv", ConvertOptions{Input: InputOptions{Columns: []string{"country", "lat"}}}); err != nil {
+		t.Fatal(err)
+	}
+	desc, err = ReadSchema(dir+"/history.csv", PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(desc.Fields) != 2 || desc.Fields[1].Type != "double" {
+		t.Fatalf("unexpected csv schema\n%s", desc)
+	}
+	if _, err := ReadSchema("history.unknown", PlanOptions{}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats, err := Stats(writeDailyDataset(t), PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rows != 6 {
+		t.Fatalf("expected 6 rows, got %d", stats.Rows)
+	}
+	byName := make(map[string]ColumnSummary)
+	for _, col := range stats.Columns {
+		byName[col.Name] = col
+	}
+	total := byName["total"]
+	if total.Min != float64(10) || total.Max != float64(60) || total.Mean != float64(30) || total.Nulls != 0 {
+		t.Fatalf("unexpected stats of total %+v", total)
+	}
+	region := byName["region"]
+	if region.Nulls != 4 || region.Min != "eu" || region.Max != "us" || region.Mean != nil {
+		t.Fatalf("unexpected stats of region %+v", region)
+	}
+	if !strings.Contains(stats.String(), "region") {
+		t.Fatalf("expected every column in\n%s", stats)
+	}
+	// long values are cut by character
+	long := &TableStats{Columns: []ColumnSummary{{Name: "s", Type: "string", Min: strings.Repeat("a", 20) + "ééééé", Max: "b"}}}
+	if text := long.String(); !utf8.ValidString(text) || !strings.Contains(text, strings.Repeat("a", 20)+"é...") {
+		t.Fatalf("expected the min cut to 24 characters, got\n%s", text)
+	}
+	b, err := stats.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded TableStats
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded.Rows != 6 || len(decoded.Columns) != len(stats.Columns) {
+		t.Fatalf("unexpected json %s (%v)", b, err)
+	}
+}
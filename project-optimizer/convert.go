@@ -0,0 +1,285 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// convert between every supported source and sink format
+
+const (
+	FormatParquet = "parquet"
+	FormatCSV     = "csv"
+	FormatTSV     = "tsv"
+	FormatJSON    = "json"
+	FormatNDJSON  = "ndjson"
+)
+
+// ErrUnsupportedFormat is returned for formats and codecs that are not known,
+// given explicitly or guessed from the extension of a path
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+// FormatFromPath guesses the file format from the extension
+func FormatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".parquet", ".pq":
+		return FormatParquet, nil
+	case ".csv":
+		return FormatCSV, nil
+	case ".tsv", ".tab":
+		return FormatTSV, nil
+	case ".json":
+		return FormatJSON, nil
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON, nil
+	}
+	return "", fmt.Errorf("%w: cannot guess the format of %s, set it explicitly", ErrUnsupportedFormat, path)
+}
+
+type InputOptions struct {
+	Format     string                  // guessed from the extension when empty
+	Columns    []string                // projection, empty reads every column
+	Types      map[string]parquet.Type // type overrides
+	CSV        CSVOptions              // delimiter, null markers... for csv inputs
+	Where      Expr                    // parquet row groups where this cannot be true are skipped, rows are not filtered
+	HTTP       HTTPOptions             // for http:// and https:// paths and s3:// objects
+	Mmap       bool                    // memory map local files, unmapped by Close. batches still hold copies
+	ChunkCache *ChunkCache             // decoded column chunks of parquet files shared with other inputs
+}
+
+// Input is an opened source file, Close releases it
+type Input struct {
+	Operator
+	f   io.Closer
+	mem *MemoryPool // of a query built from a plan, nil for other inputs
+}
+
+// Close releases the files of the input and the memory its operators reserved
+func (in *Input) Close() error {
+	in.mem.freeAll()
+	return in.f.Close()
+}
+
+// Memory reports the memory the operators of a query used, nothing for
+// inputs that are not built from a plan
+func (in *Input) Memory() MemoryUsage {
+	return in.mem.Usage()
+}
+
+// OpenInput opens a local file, an s3:// object or a remote file when path
+// is an http url
+func OpenInput(path string, opts InputOptions) (*Input, error) {
+	format := opts.Format
+	if format == "" {
+		name := path
+		if u, err := url.Parse(path); err == nil && isHTTPURL(path) {
+			name = u.Path
+		}
+		var err error
+		if format, err = FormatFromPath(name); err != nil {
+			return nil, err
+		}
+	}
+	var src Source
+	var closer io.Closer
+	if isHTTPURL(path) {
+		hs, err := OpenHTTPSource(path, opts.HTTP)
+		if err != nil {
+			return nil, err
+		}
+		src, closer = hs, hs
+	} else {
+		var err error
+		if src, closer, err = openPath(path, opts.Mmap, opts.HTTP); err != nil {
+			return nil, err
+		}
+	}
+	op, err := openOperator(src, format, opts)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return &Input{Operator: op, f: closer}, nil
+}
+
+func openOperator(f Source, format string, opts InputOptions) (Operator, error) {
+	switch format {
+	case FormatParquet:
+		schema, err := ReadParquetSchema(f)
+		if err != nil {
+			return nil, err
+		}
+		columns := opts.Columns
+		if len(columns) == 0 {
+			columns = schema.toColumns()
+		}
+		for _, name := range columns {
+			if _, err := schema.ColumnInfo(name); err != nil {
+				return nil, err
+			}
+		}
+		leaf, err := OpenProjectExecLeaf(f, columns, nil)
+		if err != nil {
+			return nil, err
+		}
+		leaf.UseChunkCache(opts.ChunkCache)
+		if opts.Where != nil {
+			if _, err := leaf.PruneRowGroups(opts.Where); err != nil {
+				return nil, err
+			}
+		}
+		var op Operator = leaf
+		if len(opts.Types) > 0 {
+			return NewCastExec(op, opts.Types)
+		}
+		return op, nil
+	case FormatCSV, FormatTSV:
+		csvOpts := opts.CSV
+		csvOpts.Columns = opts.Columns
+		csvOpts.Types = opts.Types
+		if format == FormatTSV && csvOpts.Delimiter == 0 {
+			csvOpts.Delimiter = '\t'
+		}
+		return NewCSVExecLeaf(io.NewSectionReader(f, 0, f.Size()), csvOpts)
+	case FormatJSON, FormatNDJSON:
+		return NewJSONExecLeaf(io.NewSectionReader(f, 0, f.Size()), JSONOptions{Columns: opts.Columns, Types: opts.Types})
+	}
+	return nil, fmt.Errorf("%w: input format %q", ErrUnsupportedFormat, format)
+}
+
+// ReadParquetSchema parses the schema from the footer of a parquet file
+func ReadParquetSchema(f io.ReaderAt) (*parquetSchema, error) {
+	if entry := cachedFooter(f); entry != nil {
+		return entry.schema.Clone(), nil
+	}
+	pf, err := openParquetFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return parseSchema(pf.Schema())
+}
+
+type OutputOptions struct {
+	Format       string   // guessed from the extension when empty
+	Compression  string   // parquet codec, defaults to snappy
+	BloomFilters []string // parquet columns to write bloom filters for
+	CSV          CSVWriteOptions
+}
+
+// NewBatchWriter creates the sink for a format
+func NewBatchWriter(out io.Writer, schema *parquetSchema, opts OutputOptions) (BatchWriter, error) {
+	switch opts.Format {
+	case FormatParquet:
+		codec, err := ParquetCodec(opts.Compression)
+		if err != nil {
+			return nil, err
+		}
+		options := []parquet.WriterOption{parquet.Compression(codec)}
+		if len(opts.BloomFilters) > 0 {
+			bloom, err := BloomFilterColumns(schema, opts.BloomFilters...)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, bloom)
+		}
+		return NewParquetBatchWriter(out, schema, options...)
+	case FormatCSV:
+		return NewCSVBatchWriter(out, schema, opts.CSV), nil
+	case FormatTSV:
+		csvOpts := opts.CSV
+		if csvOpts.Delimiter == 0 {
+			csvOpts.Delimiter = '\t'
+		}
+		return NewCSVBatchWriter(out, schema, csvOpts), nil
+	case FormatJSON:
+		return NewJSONBatchWriter(out, schema, false), nil
+	case FormatNDJSON:
+		return NewJSONBatchWriter(out, schema, true), nil
+	}
+	return nil, fmt.Errorf("%w: output format %q", ErrUnsupportedFormat, opts.Format)
+}
+
+type ConvertOptions struct {
+	Input     InputOptions
+	Output    OutputOptions
+	Where     string // filter expression, e.g. "country = 'Angola'"
+	BatchSize uint
+}
+
+// Convert streams src into dst, returns the number of rows written.
+// dst is removed again if the conversion fails
+func Convert(src, dst string, opts ConvertOptions) (rows int64, err error) {
+	if opts.Output.Format == "" {
+		if opts.Output.Format, err = FormatFromPath(dst); err != nil {
+			return 0, err
+		}
+	}
+	var where Expr
+	if opts.Where != "" {
+		if where, err = ParseExpr(opts.Where); err != nil {
+			return 0, fmt.Errorf("%w: parsing filter: %v", ErrInvalidQuery, err)
+		}
+	}
+
+	// the filter may need columns that are not part of the output
+	selected := opts.Input.Columns
+	inOpts := opts.Input
+	inOpts.Where = where
+	if len(selected) > 0 && where != nil {
+		inOpts.Columns = appendMissing(selected, exprColumns(where))
+	}
+	in, err := OpenInput(src, inOpts)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	var op Operator = in
+	if where != nil {
+		if op, err = NewFilterExec(op, where); err != nil {
+			return 0, err
+		}
+	}
+	if len(selected) > 0 && len(inOpts.Columns) != len(selected) {
+		schema := op.Schema().Clone()
+		schema.KeepFields(selected...)
+		op = NewProjectExec(schema, op, nil)
+	}
+
+	out, err := CreateOutput(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			discardOutput(out, dst)
+		} else {
+			err = out.Close()
+		}
+	}()
+	w, err := NewBatchWriter(out, op.Schema(), opts.Output)
+	if err != nil {
+		return 0, err
+	}
+	if rows, err = CopyBatches(w, op, opts.BatchSize); err != nil {
+		return rows, err
+	}
+	return rows, w.Close()
+}
+
+// names of want followed by every name of extra it does not already contain
+func appendMissing(want, extra []string) []string {
+	out := append([]string(nil), want...)
+	for _, name := range extra {
+		if indexFold(out, name) < 0 {
+			out = append(out, name)
+		}
+	}
+	return out
+}
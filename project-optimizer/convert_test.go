@@ -0,0 +1,141 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestConvertCSVToParquetAndBack(t *testing.T) {
+	dir := t.TempDir()
+	pq := filepath.Join(dir, "sales.parquet")
+	rows, err := Convert("../data/sales.csv", pq, ConvertOptions{
+		Input: InputOptions{Columns: []string{"Transaction ID", "Total Amount"}},
+		Where: "Gender = 'Female' AND Age < 20",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows == 0 {
+		t.Fatal("expected some rows to pass the filter")
+	}
+
+	out := filepath.Join(dir, "sales.csv")
+	back, err := Convert(pq, out, ConvertOptions{Output: OutputOptions{CSV: CSVWriteOptions{Delimiter: ';'}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back != rows {
+		t.Fatalf("expected %d rows back, got %d", rows, back)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if lines[0] != "Transaction ID;Total Amount" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if int64(len(lines)-1) != rows {
+		t.Errorf("expected %d data lines, got %d", rows, len(lines)-1)
+	}
+}
+
+func TestConvertRemovesOutputOnError(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "out.csv")
+	_, err := Convert("../data/countries-table.json", dst, ConvertOptions{
+		Input: InputOptions{Types: map[string]parquet.Type{"country": parquet.Int64Type}},
+	})
+	if err == nil {
+		t.Fatal("expected casting country names to int64 to fail")
+	}
+	if _, statErr := os.Stat(dst); !os.IsNotExist(statErr) {
+		t.Errorf("expected %s to be removed after the failure", dst)
+	}
+}
+
+func TestConvertUnsupportedFormats(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		dst  string
+		opts ConvertOptions
+	}{
+		{"out.xml", ConvertOptions{}},
+		{"out.csv", ConvertOptions{Output: OutputOptions{Format: "bogus"}}},
+		{"out.csv", ConvertOptions{Input: InputOptions{Format: "bogus"}}},
+		{"out.parquet", ConvertOptions{Output: OutputOptions{Compression: "bogus"}}},
+	} {
+		dst := filepath.Join(dir, tc.dst)
+		if _, err := Convert("../data/history.parquet", dst, tc.opts); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%s %+v: expected ErrUnsupportedFormat, got %v", tc.dst, tc.opts, err)
+		}
+		if _, err := os.Stat(dst); !os.IsNotExist(err) {
+			t.Errorf("expected no %s to be left behind", tc.dst)
+		}
+	}
+}
+
+func TestConvertWritesBloomFilters(t *testing.T) {
+	pq := filepath.Join(t.TempDir(), "sales.parquet")
+	_, err := Convert("../data/sales.csv", pq, ConvertOptions{
+		Output: OutputOptions{BloomFilters: []string{"product category"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(pq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := InspectFile(f, InspectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, col := range info.RowGroups[0].Columns {
+		if hasBloom := col.BloomFilter != nil; hasBloom != (col.Path == "Product Category") {
+			t.Errorf("column %s: unexpected bloom filter %v", col.Path, col.BloomFilter)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "beauty.csv")
+	rows, err := Convert(pq, out, ConvertOptions{Where: "`Product Category` = 'Beauty'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows == 0 {
+		t.Fatalf("expected the row group holding Beauty not to be pruned")
+	}
+}
+
+func TestConvertOddColumnNames(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "prices.csv")
+	data := "id,\"Price, USD\",\"say \"\"hi\"\"\"\n1,9.5,a\n2,,b\n"
+	if err := os.WriteFile(src, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pq := filepath.Join(dir, "prices.parquet")
+	if rows, err := Convert(src, pq, ConvertOptions{}); err != nil || rows != 2 {
+		t.Fatalf("expected 2 rows, got %d (%v)", rows, err)
+	}
+	f, err := os.Open(pq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	columns := []string{"id", "Price, USD", `say "hi"`}
+	leaf := NewProjectExecLeaf(f, columns, nil)
+	if got := leaf.Schema().toColumns(); !reflect.DeepEqual(got, columns) {
+		t.Fatalf("expected the columns %v, got %v", columns, got)
+	}
+	batch := drain(t, leaf)
+	if price := batch.Columns[1]; price[0] != 9.5 || price[1] != nil {
+		t.Fatalf("unexpected prices %v", price)
+	}
+}
@@ -0,0 +1,318 @@
+package projectoptimizer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// read csv files as a leaf operator, columns are typed either from an explicit
+// schema or by sampling the first rows of the file
+
+const defaultSampleSize = 1000
+
+var csvDateLayouts = []string{time.DateOnly, "2006/01/02"}
+
+type CSVOptions struct {
+	Delimiter  rune                    // defaults to ','
+	NoHeader   bool                    // first row is data, columns are named column1..columnN
+	NullValues []string                // cells read as NULL, defaults to "" and "NULL"
+	SampleSize int                     // rows used for type inference, defaults to 1000
+	Schema     *parquetSchema          // explicit schema, skips inference
+	Columns    []string                // projection, empty keeps every column
+	Types      map[string]parquet.Type // per column overrides of the inferred type
+	LazyQuotes bool                    // allow quotes inside unquoted fields
+	Where      Expr                    // rows kept, nil keeps every row
+}
+
+type CSVExec struct {
+	r       *csv.Reader
+	schema  *parquetSchema
+	colIdx  []int // index in the csv record of every output column
+	nulls   map[string]bool
+	sample  [][]string
+	line    int
+	where   Expr
+	whereAt map[string]int // lower cased column name -> position in a row
+	Type    reflect.Type
+	doneErr error
+}
+
+func NewCSVExecLeaf(source io.Reader, opts CSVOptions) (*CSVExec, error) {
+	r := csv.NewReader(source)
+	if opts.Delimiter != 0 {
+		r.Comma = opts.Delimiter
+	}
+	r.LazyQuotes = opts.LazyQuotes
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = false
+
+	nullValues := opts.NullValues
+	if nullValues == nil {
+		nullValues = []string{"", "NULL"}
+	}
+	c := &CSVExec{r: r, nulls: make(map[string]bool), where: opts.Where}
+	for _, v := range nullValues {
+		c.nulls[v] = true
+	}
+
+	var header []string
+	if !opts.NoHeader {
+		rec, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading csv header: %w", err)
+		}
+		header = rec
+		c.line++
+	}
+
+	sampleSize := opts.SampleSize
+	if sampleSize <= 0 {
+		sampleSize = defaultSampleSize
+	}
+	for len(c.sample) < sampleSize {
+		rec, err := r.Read()
+		if err == io.EOF {
+			c.doneErr = io.EOF
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading csv sample: %w", err)
+		}
+		c.sample = append(c.sample, rec)
+	}
+	if header == nil {
+		width := 0
+		if len(c.sample) > 0 {
+			width = len(c.sample[0])
+		}
+		if opts.Schema != nil {
+			width = max(width, len(opts.Schema.Fields))
+		}
+		for i := 0; i < width; i++ {
+			header = append(header, fmt.Sprintf("column%d", i+1))
+		}
+	}
+
+	full, fileIdx, err := c.resolveSchema(header, !opts.NoHeader, opts.Schema)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTypeOverrides(full, opts.Types); err != nil {
+		return nil, err
+	}
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = full.toColumns()
+	}
+	c.schema = &parquetSchema{}
+	for _, name := range columns {
+		found := false
+		for i, field := range full.Fields {
+			if strings.EqualFold(name, field.Name) {
+				c.schema.Fields = append(c.schema.Fields, field)
+				c.colIdx = append(c.colIdx, fileIdx[i])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s in csv header", ErrColumnNotFound, name)
+		}
+	}
+	c.whereAt = schemaIndex(c.schema)
+	if err := checkWhere(c.where, c.whereAt); err != nil {
+		return nil, err
+	}
+	_, c.Type = genStructWithFields(c.schema.Fields...)
+	return c, nil
+}
+
+// builds the schema of every csv column and the record index of each field
+func (c *CSVExec) resolveSchema(header []string, hasHeader bool, explicit *parquetSchema) (*parquetSchema, []int, error) {
+	if explicit != nil {
+		var idx []int
+		for pos, field := range explicit.Fields {
+			i := pos
+			if hasHeader {
+				i = indexFold(header, field.Name)
+				if i < 0 {
+					return nil, nil, fmt.Errorf("column %s of the explicit schema not found in csv header", field.Name)
+				}
+			}
+			idx = append(idx, i)
+		}
+		return explicit.Clone(), idx, nil
+	}
+	schema := &parquetSchema{}
+	idx := make([]int, len(header))
+	for i, name := range header {
+		schema.Fields = append(schema.Fields, structField{Name: name, PqType: c.inferColumn(i), Optional: true})
+		idx[i] = i
+	}
+	return schema, idx, nil
+}
+
+// narrowest type every sampled non null cell parses as
+func (c *CSVExec) inferColumn(col int) parquet.Type {
+	var cells []string
+	for _, rec := range c.sample {
+		if col >= len(rec) || c.nulls[rec[col]] {
+			continue
+		}
+		cells = append(cells, rec[col])
+	}
+	return inferCellType(cells)
+}
+
+// narrowest type every cell parses as, string when there are no cells
+func inferCellType(cells []string) parquet.Type {
+	isBool, isInt, isDouble, isDate := true, true, true, true
+	for _, cell := range cells {
+		if isBool {
+			_, err := strconv.ParseBool(cell)
+			isBool = err == nil
+		}
+		if isInt {
+			_, err := strconv.ParseInt(cell, 10, 64)
+			isInt = err == nil
+		}
+		if isDouble {
+			_, err := strconv.ParseFloat(cell, 64)
+			isDouble = err == nil
+		}
+		if isDate {
+			_, err := parseDate(cell)
+			isDate = err == nil
+		}
+	}
+	switch {
+	case len(cells) == 0:
+		return parquet.String().Type()
+	case isBool && !isInt:
+		return parquet.BooleanType
+	case isInt:
+		return parquet.Int64Type
+	case isDouble:
+		return parquet.DoubleType
+	case isDate:
+		return parquet.Date().Type()
+	default:
+		return parquet.String().Type()
+	}
+}
+
+func (c *CSVExec) Next(n uint) (RecordBatch, error) {
+	batch := RecordBatch{
+		Schema:  *c.schema,
+		Columns: make([][]any, len(c.schema.Fields)),
+	}
+	var curSize uint
+	for curSize < n {
+		rec, err := c.nextRecord()
+		if err != nil {
+			return batch, err
+		}
+		row := make([]any, len(c.schema.Fields))
+		for i, field := range c.schema.Fields {
+			idx := c.colIdx[i]
+			if idx >= len(rec) || c.nulls[rec[idx]] {
+				continue
+			}
+			v, err := parseCell(rec[idx], field.PqType)
+			if err != nil {
+				return batch, fmt.Errorf("csv line %d column %q: %w", c.line, field.Name, err)
+			}
+			row[i] = v
+		}
+		if !matchesWhere(c.where, c.whereAt, row) {
+			continue
+		}
+		for i, v := range row {
+			batch.Columns[i] = append(batch.Columns[i], v)
+		}
+		curSize++
+	}
+	return batch, nil
+}
+
+func (c *CSVExec) nextRecord() ([]string, error) {
+	if len(c.sample) > 0 {
+		rec := c.sample[0]
+		c.sample = c.sample[1:]
+		c.line++
+		return rec, nil
+	}
+	if c.doneErr != nil {
+		return nil, c.doneErr
+	}
+	rec, err := c.r.Read()
+	if err != nil {
+		c.doneErr = err
+		return nil, err
+	}
+	c.line++
+	return rec, nil
+}
+
+func (c *CSVExec) Schema() *parquetSchema {
+	return c.schema
+}
+
+func parseCell(cell string, t parquet.Type) (any, error) {
+	if isDateType(t) {
+		return parseDate(cell)
+	}
+	switch t.Kind() {
+	case parquet.Boolean:
+		return strconv.ParseBool(cell)
+	case parquet.Int32:
+		v, err := strconv.ParseInt(cell, 10, 32)
+		return int32(v), err
+	case parquet.Int64:
+		return strconv.ParseInt(cell, 10, 64)
+	case parquet.Float:
+		v, err := strconv.ParseFloat(cell, 32)
+		return float32(v), err
+	case parquet.Double:
+		return strconv.ParseFloat(cell, 64)
+	default:
+		return cell, nil
+	}
+}
+
+func parseDate(cell string) (time.Time, error) {
+	for _, layout := range csvDateLayouts {
+		if t, err := time.Parse(layout, cell); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("not a date: " + cell)
+}
+
+func applyTypeOverrides(schema *parquetSchema, types map[string]parquet.Type) error {
+	for name, t := range types {
+		i := indexFold(schema.toColumns(), name)
+		if i < 0 {
+			return fmt.Errorf("type override column %q not found", name)
+		}
+		schema.Fields[i].PqType = t
+	}
+	return nil
+}
+
+func indexFold(names []string, name string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,138 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestCSVInferSchema(t *testing.T) {
+	f, err := os.Open("../data/sales.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	leaf, err := NewCSVExecLeaf(f, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]parquet.Kind{
+		"Transaction ID":   parquet.Int64,
+		"Date":             parquet.Int32,
+		"Customer ID":      parquet.ByteArray,
+		"Age":              parquet.Int64,
+		"Product Category": parquet.ByteArray,
+		"Total Amount":     parquet.Int64,
+	}
+	for name, kind := range want {
+		field, err := leaf.Schema().ColumnInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if field.PqType.Kind() != kind {
+			t.Errorf("column %q: expected %v, got %v", name, kind, field.PqType.Kind())
+		}
+	}
+	if field, _ := leaf.Schema().ColumnInfo("Date"); !isDateType(field.PqType) {
+		t.Errorf("expected Date column to be inferred as a date")
+	}
+
+	total := 0
+	for {
+		batch, err := leaf.Next(256)
+		total += batch.NumRows()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total != 1000 {
+		t.Errorf("expected 1000 rows, got %d", total)
+	}
+}
+
+func TestCSVProjectionAndFilter(t *testing.T) {
+	f, err := os.Open("../data/sales.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	leaf, err := NewCSVExecLeaf(f, CSVOptions{
+		Columns: []string{"product category", "Total Amount", "Date"},
+		Where:   mustParseExpr(t, "\"product category\" = 'Beauty'"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := leaf.Next(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Columns) != 3 || batch.NumRows() != 10 {
+		t.Fatalf("expected 10 rows of 3 columns, got %d x %d", batch.NumRows(), len(batch.Columns))
+	}
+	for i := 0; i < batch.NumRows(); i++ {
+		if batch.Columns[0][i] != "Beauty" {
+			t.Errorf("row %d: filter let %v through", i, batch.Columns[0][i])
+		}
+		if _, ok := batch.Columns[2][i].(time.Time); !ok {
+			t.Errorf("row %d: expected date as time.Time, got %T", i, batch.Columns[2][i])
+		}
+	}
+}
+
+func TestCSVExplicitSchemaNoHeader(t *testing.T) {
+	data := "1;'a;b';NA\n2;c;3.5\n"
+	schema := &parquetSchema{Fields: []structField{
+		{Name: "id", PqType: parquet.Int32Type},
+		{Name: "name", PqType: parquet.String().Type()},
+		{Name: "score", PqType: parquet.DoubleType},
+	}}
+	leaf, err := NewCSVExecLeaf(strings.NewReader(strings.ReplaceAll(data, "'", `"`)), CSVOptions{
+		Delimiter:  ';',
+		NoHeader:   true,
+		NullValues: []string{"NA"},
+		Schema:     schema,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := leaf.Next(10)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	want := [][]any{{int32(1), int32(2)}, {"a;b", "c"}, {nil, 3.5}}
+	if !reflect.DeepEqual(batch.Columns, want) {
+		t.Errorf("expected %v, got %v", want, batch.Columns)
+	}
+}
+
+func TestCSVFilterDropsNulls(t *testing.T) {
+	leaf, err := NewCSVExecLeaf(strings.NewReader("name,lat\na,1\nb,\nc,5\n"), CSVOptions{
+		Where: mustParseExpr(t, "lat != 5"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := leaf.Next(10)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	want := [][]any{{"a"}, {int64(1)}}
+	if !reflect.DeepEqual(batch.Columns, want) {
+		t.Errorf("expected %v, got %v", want, batch.Columns)
+	}
+	if _, err := NewCSVExecLeaf(strings.NewReader("name\na\n"), CSVOptions{Where: mustParseExpr(t, "lat > 0")}); !errors.Is(err, ErrColumnNotFound) {
+		t.Errorf("expected ErrColumnNotFound for a filter on a missing column, got %v", err)
+	}
+}
@@ -0,0 +1,513 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// scan a directory or glob of parquet files as a single table
+
+type DatasetOptions struct {
+	Columns        []string // projection, empty reads every column
+	Where          Expr     // rows where this is not true are dropped, also used to prune files and row groups
+	Parallelism    int      // number of files scanned at once, <= 1 scans them in order
+	FilenameColumn string   // name of a virtual column holding the path of each row's file
+	NoPartitioning bool     // do not turn key=value directories into partition columns
+	SchemaMerge    SchemaMergeMode
+	Mmap           bool        // memory map local files while they are scanned, batches still hold copies
+	ChunkCache     *ChunkCache // decoded column chunks shared with other scans, nil decodes rows
+	HTTP           HTTPOptions // for the objects of s3:// datasets, their requests are signed
+}
+
+type datasetFile struct {
+	path    string
+	schema  *parquetSchema // nil until the footer is read
+	stats   map[string]columnStats
+	numRows int64
+	virtual map[string]any // partition and filename values by lower cased column name
+}
+
+type datasetResult struct {
+	batch RecordBatch
+	err   error
+}
+
+// DatasetExec is a leaf reading every parquet file matched by a pattern.
+// Close must be called when the operator is not drained to the end
+type DatasetExec struct {
+	opts       DatasetOptions
+	schema     *parquetSchema // output schema
+	scanSchema *parquetSchema // output columns followed by the columns only needed by Where
+	colIdx     map[string]int
+	files      []datasetFile
+	pruned     []string
+	prunedRGs  atomic.Int64
+
+	once    sync.Once
+	results chan datasetResult
+	stop    chan struct{}
+	workers sync.WaitGroup
+	pending RecordBatch
+	done    bool
+}
+
+// NewDatasetExec opens a dataset. pattern is either a directory, which is
+// walked for *.parquet files, or a glob such as data/2024-*.parquet.
+// key=value directories below it become typed partition columns appended
+// after the columns stored in the files
+func NewDatasetExec(pattern string, opts DatasetOptions) (*DatasetExec, error) {
+	paths, root, err := datasetPaths(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var partitions []structField
+	var values [][]any
+	if !opts.NoPartitioning {
+		if partitions, values, err = inferPartitions(root, paths); err != nil {
+			return nil, err
+		}
+	}
+	d := &DatasetExec{opts: opts}
+	var candidates []datasetFile
+	for i, path := range paths {
+		file := datasetFile{path: path, virtual: make(map[string]any)}
+		for k, field := range partitions {
+			file.virtual[strings.ToLower(field.Name)] = values[i][k]
+		}
+		if opts.FilenameColumn != "" {
+			file.virtual[strings.ToLower(opts.FilenameColumn)] = path
+		}
+		// skip whole directories before their footers are read
+		if opts.Where != nil && canSkip(opts.Where, d.fileLookup(file)) {
+			d.pruned = append(d.pruned, path)
+			continue
+		}
+		candidates = append(candidates, file)
+	}
+	var files []datasetFile
+	for _, file := range candidates {
+		if file, err = readDatasetFile(file, opts.HTTP); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	schemaFiles := files
+	if len(files) == 0 {
+		// the schema still comes from a file, even if none is scanned
+		first, err := readDatasetFile(datasetFile{path: paths[0]}, opts.HTTP)
+		if err != nil {
+			return nil, err
+		}
+		schemaFiles = []datasetFile{first}
+	}
+
+	unified, err := unifySchemas(schemaFiles, opts.SchemaMerge)
+	if err != nil {
+		return nil, err
+	}
+	virtual := partitions
+	if opts.FilenameColumn != "" {
+		virtual = append(virtual, structField{Name: opts.FilenameColumn, PqType: parquet.String().Type()})
+	}
+	for _, field := range virtual {
+		if _, err := unified.ColumnInfo(field.Name); err == nil {
+			return nil, fmt.Errorf("column %q of the dataset collides with a partition or filename column", field.Name)
+		}
+		unified.Fields = append(unified.Fields, field)
+	}
+
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = unified.toColumns()
+	}
+	scanColumns := columns
+	if opts.Where != nil {
+		scanColumns = appendMissing(columns, exprColumns(opts.Where))
+	}
+	for _, name := range scanColumns {
+		if _, err := unified.ColumnInfo(name); err != nil {
+			return nil, err
+		}
+	}
+	d.schema = unified.Clone()
+	d.schema.KeepFields(columns...)
+	d.scanSchema = unified.Clone()
+	d.scanSchema.KeepFields(scanColumns...)
+	d.colIdx = schemaIndex(d.scanSchema)
+
+	for _, file := range files {
+		if opts.Where != nil && canSkip(opts.Where, d.fileLookup(file)) {
+			d.pruned = append(d.pruned, file.path)
+			continue
+		}
+		d.files = append(d.files, file)
+	}
+	return d, nil
+}
+
+// every parquet file matched by pattern sorted by path, and the directory
+// partition directories are relative to
+func datasetPaths(pattern string) ([]string, string, error) {
+	if isS3URL(pattern) {
+		return s3DatasetPaths(pattern)
+	}
+	var paths []string
+	root := globRoot(pattern)
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		root = pattern
+		err := filepath.WalkDir(pattern, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(path), ".parquet") {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, "", fmt.Errorf("no parquet files match %s", pattern)
+	}
+	sort.Strings(paths)
+	return paths, root, nil
+}
+
+// reads the footer of a file: its schema and statistics. s3 objects are
+// read with httpOpts
+func readDatasetFile(file datasetFile, httpOpts HTTPOptions) (datasetFile, error) {
+	if !isS3URL(file.path) {
+		info, err := os.Stat(file.path)
+		if err != nil {
+			return file, err
+		}
+		entry, err := Footers.get(file.path, info)
+		if err != nil {
+			return file, err
+		}
+		if entry != nil {
+			file.schema = entry.schema.Clone()
+			file.stats, _ = entry.statistics()
+			file.numRows = entry.file.NumRows()
+			return file, nil
+		}
+	}
+	f, closer, err := openPath(file.path, false, httpOpts)
+	if err != nil {
+		return file, err
+	}
+	defer closer.Close()
+	pf, err := parquet.OpenFile(f, f.Size())
+	if err != nil {
+		return file, fmt.Errorf("reading footer of %s: %w", file.path, err)
+	}
+	schema, err := parseSchema(pf.Schema())
+	if err != nil {
+		return file, fmt.Errorf("reading schema of %s: %w", file.path, err)
+	}
+	md := pf.Metadata()
+	file.schema = schema
+	file.stats = fileStats(schema, md)
+	file.numRows = md.NumRows
+	return file, nil
+}
+
+// merged schema of the files, see MergeSchemas
+func unifySchemas(files []datasetFile, mode SchemaMergeMode) (*parquetSchema, error) {
+	schemas := make([]*parquetSchema, len(files))
+	names := make([]string, len(files))
+	for i, file := range files {
+		schemas[i], names[i] = file.schema, file.path
+	}
+	return mergeSchemas(mode, schemas, names)
+}
+
+// statistics of a file as seen by canSkip
+func (d *DatasetExec) fileLookup(file datasetFile) statsLookup {
+	virtual := virtualLookup(file)
+	return func(column string) (columnStats, bool) {
+		if cs, ok := virtual(column); ok {
+			return cs, true
+		}
+		if file.schema == nil {
+			return columnStats{}, false
+		}
+		if _, err := file.schema.ColumnInfo(column); err != nil {
+			return columnStats{missing: true}, true
+		}
+		cs, ok := file.stats[strings.ToLower(column)]
+		return cs, ok
+	}
+}
+
+// partition and filename columns hold a single value per file
+func virtualLookup(file datasetFile) statsLookup {
+	return func(column string) (columnStats, bool) {
+		v, ok := file.virtual[strings.ToLower(column)]
+		if !ok {
+			return columnStats{}, false
+		}
+		if v == nil {
+			return columnStats{missing: true}, true
+		}
+		return columnStats{min: v, max: v, hasMinMax: true, numValues: 1}, true
+	}
+}
+
+// Files returns the files that are scanned, Pruned the ones skipped by their statistics
+func (d *DatasetExec) Files() []string {
+	paths := make([]string, len(d.files))
+	for i, file := range d.files {
+		paths[i] = file.path
+	}
+	return paths
+}
+
+func (d *DatasetExec) Pruned() []string {
+	return d.pruned
+}
+
+// PrunedRowGroups is the number of row groups of scanned files skipped so far
+// by their statistics and bloom filters
+func (d *DatasetExec) PrunedRowGroups() int64 {
+	return d.prunedRGs.Load()
+}
+
+func (d *DatasetExec) Schema() *parquetSchema {
+	return d.schema
+}
+
+func (d *DatasetExec) Next(n uint) (RecordBatch, error) {
+	d.once.Do(func() { d.start(n) })
+	out := RecordBatch{Schema: *d.schema, Columns: make([][]any, len(d.schema.Fields))}
+	for uint(out.NumRows()) < n {
+		if d.pending.NumRows() == 0 {
+			if d.done {
+				return out, io.EOF
+			}
+			res, ok := <-d.results
+			if !ok {
+				d.done = true
+				continue
+			}
+			if res.err != nil {
+				return out, res.err
+			}
+			d.pending = res.batch
+			continue
+		}
+		take := min(int(n)-out.NumRows(), d.pending.NumRows())
+		for c := range out.Columns {
+			out.Columns[c] = append(out.Columns[c], d.pending.Columns[c][:take]...)
+			d.pending.Columns[c] = d.pending.Columns[c][take:]
+		}
+	}
+	return out, nil
+}
+
+// scans the files in background workers, one worker keeps the file order
+func (d *DatasetExec) start(batchSize uint) {
+	workers := d.opts.Parallelism
+	if workers < 1 {
+		workers = 1
+	}
+	d.stop = make(chan struct{})
+	d.results = make(chan datasetResult, workers)
+	jobs := make(chan datasetFile)
+	go func() {
+		defer close(jobs)
+		for _, file := range d.files {
+			select {
+			case jobs <- file:
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		d.workers.Add(1)
+		go func() {
+			defer d.workers.Done()
+			for file := range jobs {
+				if err := d.scanFile(file, batchSize); err != nil {
+					d.send(datasetResult{err: fmt.Errorf("scanning %s: %w", file.path, err)})
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		d.workers.Wait()
+		close(d.results)
+	}()
+}
+
+func (d *DatasetExec) send(res datasetResult) bool {
+	select {
+	case d.results <- res:
+		return true
+	case <-d.stop:
+		return false
+	}
+}
+
+// reads one file, fills the columns it lacks with NULL and applies Where
+func (d *DatasetExec) scanFile(file datasetFile, batchSize uint) error {
+	f, closer, err := openPath(file.path, d.opts.Mmap, d.opts.HTTP)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	var present []string
+	source := make([]int, len(d.scanSchema.Fields)) // scan column -> file batch column, -1 when absent
+	for i, field := range d.scanSchema.Fields {
+		source[i] = -1
+		if _, virtual := file.virtual[strings.ToLower(field.Name)]; virtual {
+			continue
+		}
+		if _, err := file.schema.ColumnInfo(field.Name); err == nil {
+			source[i] = len(present)
+			present = append(present, field.Name)
+		}
+	}
+	var next func() (RecordBatch, int, error)
+	if len(present) == 0 {
+		// only virtual or missing columns, the footer knows how many rows there are
+		left := file.numRows
+		next = func() (RecordBatch, int, error) {
+			rows := min(int(batchSize), int(left))
+			left -= int64(rows)
+			if left == 0 {
+				return RecordBatch{}, rows, io.EOF
+			}
+			return RecordBatch{}, rows, nil
+		}
+	} else {
+		leaf, err := OpenProjectExecLeaf(f, present, nil)
+		if err != nil {
+			return err
+		}
+		leaf.UseChunkCache(d.opts.ChunkCache)
+		if d.opts.Where != nil {
+			skip, err := prunedRowGroups(f, file.schema, d.opts.Where, virtualLookup(file))
+			if err != nil {
+				return err
+			}
+			leaf.leaf.skip = skip
+			d.prunedRGs.Add(int64(len(skip)))
+		}
+		next = func() (RecordBatch, int, error) {
+			batch, err := leaf.Next(batchSize)
+			return batch, batch.NumRows(), err
+		}
+	}
+	for {
+		batch, rows, err := next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		scanned := RecordBatch{Schema: *d.scanSchema, Columns: make([][]any, len(source))}
+		for i, src := range source {
+			switch {
+			case src >= 0:
+				col, err := d.widen(file, i, batch.Columns[src])
+				if err != nil {
+					return err
+				}
+				scanned.Columns[i] = col
+				// string columns are never widened, their dictionaries stay valid
+				if dc := batch.Dict(src); dc != nil && d.scanSchema.Fields[i].PqType.Kind() == parquet.ByteArray {
+					if scanned.Dicts == nil {
+						scanned.Dicts = make([]*DictColumn, len(source))
+					}
+					scanned.Dicts[i] = dc
+				}
+			default:
+				// missing columns are NULL, virtual ones repeat the value of the file
+				scanned.Columns[i] = repeatValue(file.virtual[strings.ToLower(d.scanSchema.Fields[i].Name)], rows)
+			}
+		}
+		if d.opts.Where != nil {
+			filtered := RecordBatch{Schema: *d.scanSchema, Columns: make([][]any, len(source))}
+			appendMatching(&filtered, scanned, d.opts.Where, d.colIdx)
+			scanned = filtered
+		}
+		if scanned.NumRows() > 0 {
+			out := RecordBatch{Schema: *d.schema, Columns: scanned.Columns[:len(d.schema.Fields)]}
+			if !d.send(datasetResult{batch: out}) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// converts a column of a file to the wider type of the merged schema
+func (d *DatasetExec) widen(file datasetFile, i int, col []any) ([]any, error) {
+	want := d.scanSchema.Fields[i]
+	have, _ := file.schema.ColumnInfo(want.Name)
+	if want.Node != nil || sameType(have.PqType, want.PqType) {
+		return col, nil
+	}
+	out := make([]any, len(col))
+	for row, v := range col {
+		cv, err := castValue(v, want.PqType)
+		if err != nil {
+			return nil, fmt.Errorf("widening column %q: %w", want.Name, err)
+		}
+		out[row] = cv
+	}
+	return out, nil
+}
+
+func repeatValue(v any, n int) []any {
+	col := make([]any, n)
+	for i := range col {
+		col[i] = v
+	}
+	return col
+}
+
+// Close stops the background scan and waits for the workers to close
+// their files
+func (d *DatasetExec) Close() error {
+	d.once.Do(func() {
+		d.stop = make(chan struct{})
+		d.done = true
+	})
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+	if d.results != nil {
+		for range d.results {
+		}
+	}
+	d.workers.Wait()
+	return nil
+}
@@ -0,0 +1,438 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+type dailyRow struct {
+	Day   int64   `parquet:"day"`
+	Store string  `parquet:"store"`
+	Total float64 `parquet:"total"`
+}
+
+type dailyRowWithRegion struct {
+	Day    int64   `parquet:"day"`
+	Store  string  `parquet:"store"`
+	Total  float64 `parquet:"total"`
+	Region string  `parquet:"region"`
+}
+
+// three daily files, the last one has an extra column
+func writeDailyDataset(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for day := int64(1); day <= 2; day++ {
+		rows := []dailyRow{
+			{Day: day, Store: "north", Total: float64(day) * 10},
+			{Day: day, Store: "south", Total: float64(day) * 20},
+		}
+		path := filepath.Join(dir, "day-"+string(rune('0'+day))+".parquet")
+		if err := parquet.WriteFile(path, rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows := []dailyRowWithRegion{
+		{Day: 3, Store: "north", Total: 30, Region: "eu"},
+		{Day: 3, Store: "west", Total: 60, Region: "us"},
+	}
+	if err := parquet.WriteFile(filepath.Join(dir, "day-3.parquet"), rows); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func drain(t *testing.T, op Operator) RecordBatch {
+	t.Helper()
+	out := RecordBatch{Schema: *op.Schema(), Columns: make([][]any, len(op.Schema().Fields))}
+	for {
+		batch, err := op.Next(3)
+		for c := range batch.Columns {
+			out.Columns[c] = append(out.Columns[c], batch.Columns[c]...)
+		}
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDatasetUnifiesSchemas(t *testing.T) {
+	dir := writeDailyDataset(t)
+	ds, err := NewDatasetExec(dir, DatasetOptions{FilenameColumn: "_file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	got := ds.Schema().toColumns()
+	want := []string{"day", "store", "total", "region", "_file"}
+	if len(got) != len(want) {
+		t.Fatalf("expected columns %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected columns %v, got %v", want, got)
+		}
+	}
+
+	out := drain(t, ds)
+	if out.NumRows() != 6 {
+		t.Fatalf("expected 6 rows, got %d", out.NumRows())
+	}
+	// files are scanned in order, the region only exists in the last one
+	for row := 0; row < 4; row++ {
+		if out.Columns[3][row] != nil {
+			t.Fatalf("row %d: expected NULL region, got %v", row, out.Columns[3][row])
+		}
+	}
+	if out.Columns[3][5] != "us" {
+		t.Fatalf("expected region us, got %v", out.Columns[3][5])
+	}
+	if out.Columns[4][5] != filepath.Join(dir, "day-3.parquet") {
+		t.Fatalf("unexpected filename %v", out.Columns[4][5])
+	}
+}
+
+func TestDatasetPrunesFiles(t *testing.T) {
+	dir := writeDailyDataset(t)
+	where, err := ParseExpr("day >= 2 AND store = 'north'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewDatasetExec(filepath.Join(dir, "day-*.parquet"), DatasetOptions{
+		Columns:     []string{"total"},
+		Where:       where,
+		Parallelism: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	if pruned := ds.Pruned(); len(pruned) != 1 || filepath.Base(pruned[0]) != "day-1.parquet" {
+		t.Fatalf("expected day-1.parquet to be pruned, got %v", pruned)
+	}
+	out := drain(t, ds)
+	if len(out.Columns) != 1 {
+		t.Fatalf("expected only the projected column, got %d", len(out.Columns))
+	}
+	var totals []float64
+	for _, v := range out.Columns[0] {
+		totals = append(totals, v.(float64))
+	}
+	sort.Float64s(totals)
+	if len(totals) != 2 || totals[0] != 20 || totals[1] != 30 {
+		t.Fatalf("expected totals [20 30], got %v", totals)
+	}
+}
+
+func TestDatasetCloseWaitsForWorkers(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 8; i++ {
+		rows := make([]dailyRow, 1000)
+		for j := range rows {
+			rows[j] = dailyRow{Day: int64(i), Store: "north", Total: float64(j)}
+		}
+		if err := parquet.WriteFile(filepath.Join(dir, fmt.Sprintf("day-%d.parquet", i)), rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ds, err := NewDatasetExec(dir, DatasetOptions{Parallelism: 4, Mmap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Next(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the results are closed once every worker has returned
+	select {
+	case _, ok := <-ds.results:
+		if ok {
+			t.Fatal("expected no results after Close")
+		}
+	default:
+		t.Fatal("expected the workers to be done when Close returns")
+	}
+}
+
+func TestDatasetPrunesOnMissingColumn(t *testing.T) {
+	dir := writeDailyDataset(t)
+	where, err := ParseExpr("region = 'us'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewDatasetExec(dir, DatasetOptions{Where: where})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	if files := ds.Files(); len(files) != 1 {
+		t.Fatalf("expected only the file with a region column, got %v", files)
+	}
+	if out := drain(t, ds); out.NumRows() != 1 {
+		t.Fatalf("expected 1 row, got %d", out.NumRows())
+	}
+}
+
+func TestDatasetReportsUnreadableFiles(t *testing.T) {
+	dir := writeDailyDataset(t)
+	ds, err := NewDatasetExec(dir, DatasetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	// the file is replaced after its footer was read for the schema
+	if err := os.WriteFile(filepath.Join(dir, "day-2.parquet"), []byte("not parquet"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err := ds.Next(3)
+		if err == io.EOF {
+			t.Fatal("expected the unreadable file to fail the scan")
+		}
+		if err != nil {
+			break
+		}
+	}
+	if _, err := OpenProjectExecLeaf(strings.NewReader("not parquet"), []string{"day"}, nil); err == nil {
+		t.Fatal("expected an error opening a file that is not parquet")
+	}
+}
+
+func TestPartitionedRoundTrip(t *testing.T) {
+	src, err := NewDatasetExec(writeDailyDataset(t), DatasetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	root := t.TempDir()
+	rows, err := WritePartitioned(src, root, []string{"day", "store"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 6 {
+		t.Fatalf("expected 6 rows written, got %d", rows)
+	}
+
+	where, err := ParseExpr("day = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewDatasetExec(root, DatasetOptions{Columns: []string{"store", "total", "day"}, Where: where})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	if len(ds.Files()) != 2 || len(ds.Pruned()) != 4 {
+		t.Fatalf("expected 2 files scanned and 4 pruned, got %v and %v", ds.Files(), ds.Pruned())
+	}
+	day, err := ds.Schema().ColumnInfo("day")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if day.PqType.Kind() != parquet.Int64 {
+		t.Fatalf("expected day partition to be int64, got %v", day.PqType)
+	}
+	out := drain(t, ds)
+	if out.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", out.NumRows())
+	}
+	for row := 0; row < 2; row++ {
+		if out.Columns[2][row] != int64(2) {
+			t.Fatalf("row %d: expected day 2, got %v", row, out.Columns[2][row])
+		}
+	}
+}
+
+func TestFloatPartitionsKeepEveryDigit(t *testing.T) {
+	src, err := NewJSONExecLeaf(strings.NewReader(`{"x": 1.231, "n": 1}
+{"x": 1.234, "n": 2}
+{"x": 0.001, "n": 3}
+`), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if _, err := WritePartitioned(src, root, []string{"x"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewDatasetExec(root, DatasetOptions{Columns: []string{"n", "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	if len(ds.Files()) != 3 {
+		t.Fatalf("expected a partition per value, got %v", ds.Files())
+	}
+	out := drain(t, ds)
+	got := make(map[any]any)
+	for row := 0; row < out.NumRows(); row++ {
+		got[out.Columns[0][row]] = out.Columns[1][row]
+	}
+	if got[int64(1)] != 1.231 || got[int64(2)] != 1.234 || got[int64(3)] != 0.001 {
+		t.Fatalf("expected the values of x back, got %v", got)
+	}
+}
+
+func TestPartitionedWriterLimitsOpenFiles(t *testing.T) {
+	var src strings.Builder
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&src, "{\"k\": \"%c\", \"n\": %d}\n", 'a'+i%3, i)
+	}
+	in, err := NewJSONExecLeaf(strings.NewReader(src.String()), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	w, err := NewPartitionedWriter(root, in.Schema(), []string{"k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMaxOpenFiles(2); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		batch, err := in.Next(2)
+		if batch.NumRows() > 0 {
+			if err := w.WriteBatch(batch); err != nil {
+				t.Fatal(err)
+			}
+			if open := w.open.Len(); open > 2 {
+				t.Fatalf("expected at most 2 open files, got %d", open)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// closed partitions go on in new part files
+	parts, err := filepath.Glob(filepath.Join(root, "k=a", "*.parquet"))
+	if err != nil || len(parts) < 2 {
+		t.Fatalf("expected several part files of k=a, got %v (%v)", parts, err)
+	}
+	ds, err := NewDatasetExec(root, DatasetOptions{Columns: []string{"n", "k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	out := drain(t, ds)
+	seen := make(map[int64]bool)
+	for row := 0; row < out.NumRows(); row++ {
+		n := out.Columns[0][row].(int64)
+		if k := out.Columns[1][row]; k != string(rune('a'+n%3)) {
+			t.Fatalf("row %d in partition %v", n, k)
+		}
+		seen[n] = true
+	}
+	if len(seen) != 12 {
+		t.Fatalf("expected every row back, got %v", seen)
+	}
+}
+
+func TestPartitionValuesAreEscaped(t *testing.T) {
+	values, err := parsePartitionPath("root", filepath.Join("root", partitionDir("city", "a/b=c"), "part-00000.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0].key != "city" || values[0].raw != "a/b=c" {
+		t.Fatalf("unexpected partition values %+v", values)
+	}
+}
+
+// three row groups that all span a..z, only bloom filters can tell them apart
+func writeBloomFile(t *testing.T, path string, bloom bool) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	schema := &parquetSchema{Fields: []structField{
+		{Name: "store", PqType: parquet.String().Type()},
+		{Name: "n", PqType: parquet.Int32Type},
+	}}
+	options := []parquet.WriterOption{parquet.MaxRowsPerRowGroup(4)}
+	if bloom {
+		option, err := BloomFilterColumns(schema, "Store", "n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		options = append(options, option)
+	}
+	w, err := NewParquetBatchWriter(f, schema, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := RecordBatch{Schema: *schema, Columns: [][]any{
+		{"a", "z", "m", "c", "a", "z", "b", "d", "a", "z", "q", "r"},
+		{int32(1), int32(2), int32(3), int32(4), int32(1), int32(2), int32(5), int32(6), int32(1), int32(2), int32(7), int32(8)},
+	}}
+	if err := w.WriteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatasetPrunesRowGroupsWithBloomFilters(t *testing.T) {
+	dir := t.TempDir()
+	writeBloomFile(t, filepath.Join(dir, "bloom.parquet"), true)
+	writeBloomFile(t, filepath.Join(dir, "plain.parquet"), false)
+
+	for _, tc := range []struct {
+		file, where string
+		pruned      int64
+		want        []any
+	}{
+		{"bloom.parquet", "store = 'm'", 2, []any{int32(3)}},
+		{"bloom.parquet", "store IN ('b', 'r', 'x')", 1, []any{int32(5), int32(8)}},
+		{"bloom.parquet", "n = 7", 2, []any{int32(7)}},
+		{"bloom.parquet", "store = 'x' OR n = 4", 2, []any{int32(4)}},
+		{"bloom.parquet", "store = 'a'", 0, []any{int32(1), int32(1), int32(1)}},
+		{"plain.parquet", "store = 'm'", 0, []any{int32(3)}},
+	} {
+		where, err := ParseExpr(tc.where)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := NewDatasetExec(filepath.Join(dir, tc.file), DatasetOptions{Columns: []string{"n"}, Where: where})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := drain(t, ds)
+		if !reflect.DeepEqual(out.Columns[0], tc.want) {
+			t.Errorf("%s on %s: expected %v, got %v", tc.where, tc.file, tc.want, out.Columns[0])
+		}
+		if got := ds.PrunedRowGroups(); got != tc.pruned {
+			t.Errorf("%s on %s: expected %d pruned row groups, got %d", tc.where, tc.file, tc.pruned, got)
+		}
+	}
+}
+
+func TestBloomFilterColumnsRejectsUnknownColumns(t *testing.T) {
+	schema := &parquetSchema{Fields: []structField{{Name: "store", PqType: parquet.String().Type()}}}
+	if _, err := BloomFilterColumns(schema, "region"); err == nil {
+		t.Fatalf("expected an error for a column that is not in the schema")
+	}
+}
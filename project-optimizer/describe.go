@@ -0,0 +1,214 @@
+package projectoptimizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// schema inspection: the tree of a parquet schema as parquet tools print it
+//
+//	message schema {
+//	  optional binary country (STRING);
+//	  optional group address {
+//	    required binary city (STRING);
+//	  }
+//	}
+//
+// with the field ids of the file and the encodings and compression of every
+// leaf column taken from the footer.
+
+// SchemaNode is a field of a parquet schema, groups list their fields in Children
+type SchemaNode struct {
+	Name        string        `json:"name"`
+	Path        string        `json:"path"`                   // dotted path from the root
+	Repetition  string        `json:"repetition"`             // required, optional or repeated
+	Type        string        `json:"type,omitempty"`         // physical type of leaves, e.g. binary or fixed_len_byte_array(16)
+	LogicalType string        `json:"logical_type,omitempty"` // e.g. STRING, DATE, DECIMAL(10,2), LIST
+	FieldID     int           `json:"field_id,omitempty"`     // 0 when the file has none
+	Encodings   []string      `json:"encodings,omitempty"`    // leaves of files only
+	Compression string        `json:"compression,omitempty"`  // leaves of files only
+	Children    []*SchemaNode `json:"children,omitempty"`
+}
+
+// SchemaDescription is the schema of a parquet file or of an operator
+type SchemaDescription struct {
+	Name    string        `json:"name"`
+	NumRows int64         `json:"num_rows,omitempty"`
+	Fields  []*SchemaNode `json:"fields"`
+}
+
+// DescribeSchema reads the schema of a parquet file and the encodings and
+// compression codecs its column chunks use
+func DescribeSchema(f io.ReaderAt) (*SchemaDescription, error) {
+	pf, err := openParquetFile(f)
+	if err != nil {
+		return nil, err
+	}
+	schema := pf.Schema()
+	desc := &SchemaDescription{Name: schema.Name(), NumRows: pf.NumRows()}
+	for _, field := range schema.Fields() {
+		desc.Fields = append(desc.Fields, describeNode(field.Name(), "", field))
+	}
+
+	// a column may be written with other encodings or codecs in every row group
+	encodings := make(map[string]map[string]bool)
+	codecs := make(map[string]map[string]bool)
+	for _, rg := range pf.Metadata().RowGroups {
+		for _, chunk := range rg.Columns {
+			path := strings.Join(chunk.MetaData.PathInSchema, ".")
+			if encodings[path] == nil {
+				encodings[path], codecs[path] = make(map[string]bool), make(map[string]bool)
+			}
+			for _, enc := range chunk.MetaData.Encoding {
+				encodings[path][enc.String()] = true
+			}
+			codecs[path][chunk.MetaData.Codec.String()] = true
+		}
+	}
+	desc.walk(func(node *SchemaNode) {
+		if len(node.Children) > 0 {
+			return
+		}
+		node.Encodings = sortedKeys(encodings[node.Path])
+		node.Compression = strings.Join(sortedKeys(codecs[node.Path]), ", ")
+	})
+	return desc, nil
+}
+
+// Describe renders the schema of a RecordBatch or operator, there are no
+// encodings or field ids outside of files
+func (p *parquetSchema) Describe() *SchemaDescription {
+	desc := &SchemaDescription{Name: "schema"}
+	for _, field := range p.Fields {
+		node := field.Node
+		if node == nil {
+			node = parquet.Leaf(field.PqType)
+			if field.Optional {
+				node = parquet.Optional(node)
+			} else {
+				node = parquet.Required(node)
+			}
+		}
+		desc.Fields = append(desc.Fields, describeNode(field.Name, "", node))
+	}
+	return desc
+}
+
+func describeNode(name, parent string, node parquet.Node) *SchemaNode {
+	out := &SchemaNode{Name: name, Path: name, Repetition: "required", FieldID: node.ID()}
+	if parent != "" {
+		out.Path = parent + "." + name
+	}
+	switch {
+	case node.Repeated():
+		out.Repetition = "repeated"
+	case node.Optional():
+		out.Repetition = "optional"
+	}
+	if lt := node.Type().LogicalType(); lt != nil {
+		out.LogicalType = lt.String()
+	}
+	if !node.Leaf() {
+		for _, field := range node.Fields() {
+			out.Children = append(out.Children, describeNode(field.Name(), out.Path, field))
+		}
+		return out
+	}
+	out.Type = physicalTypeName(node.Type())
+	return out
+}
+
+// physical type as written in parquet schema definitions
+func physicalTypeName(t parquet.Type) string {
+	switch t.Kind() {
+	case parquet.Boolean:
+		return "boolean"
+	case parquet.Int32:
+		return "int32"
+	case parquet.Int64:
+		return "int64"
+	case parquet.Int96:
+		return "int96"
+	case parquet.Float:
+		return "float"
+	case parquet.Double:
+		return "double"
+	case parquet.ByteArray:
+		return "binary"
+	case parquet.FixedLenByteArray:
+		return fmt.Sprintf("fixed_len_byte_array(%d)", t.Length())
+	}
+	return strings.ToLower(t.Kind().String())
+}
+
+// visits every node depth first
+func (d *SchemaDescription) walk(fn func(*SchemaNode)) {
+	var visit func([]*SchemaNode)
+	visit = func(nodes []*SchemaNode) {
+		for _, node := range nodes {
+			fn(node)
+			visit(node.Children)
+		}
+	}
+	visit(d.Fields)
+}
+
+// String renders the schema as a parquet message definition
+func (d *SchemaDescription) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "message %s {\n", d.Name)
+	for _, node := range d.Fields {
+		node.write(&sb, 1)
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func (n *SchemaNode) write(sb *strings.Builder, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(n.Repetition)
+	if n.Type != "" {
+		sb.WriteString(" " + n.Type)
+	} else {
+		sb.WriteString(" group")
+	}
+	sb.WriteString(" " + n.Name)
+	if n.LogicalType != "" {
+		sb.WriteString(" (" + n.LogicalType + ")")
+	}
+	if n.FieldID != 0 {
+		fmt.Fprintf(sb, " = %d", n.FieldID)
+	}
+	if n.Type == "" {
+		sb.WriteString(" {\n")
+		for _, child := range n.Children {
+			child.write(sb, depth+1)
+		}
+		sb.WriteString(strings.Repeat("  ", depth) + "}\n")
+		return
+	}
+	sb.WriteString(";")
+	if n.Compression != "" || len(n.Encodings) > 0 {
+		fmt.Fprintf(sb, " // %s [%s]", n.Compression, strings.Join(n.Encodings, " "))
+	}
+	sb.WriteString("\n")
+}
+
+// JSON renders the schema for tools
+func (d *SchemaDescription) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package projectoptimizer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestShowSchemaRendersTypes(t *testing.T) {
+	f, err := os.Open("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	schema, err := ReadParquetSchema(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema.KeepFields("country", "lat")
+	want := "message schema {\n  optional binary country (STRING);\n  optional double lat;\n}\n"
+	if got := schema.ShowSchema(); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestDescribeSchema(t *testing.T) {
+	type row struct {
+		ID    int64    `parquet:"id,id(1)"`
+		Name  string   `parquet:"name,id(2),optional,zstd"`
+		Codes []string `parquet:"codes,list"`
+	}
+	path := filepath.Join(t.TempDir(), "ids.parquet")
+	if err := parquet.WriteFile(path, []row{{ID: 1, Name: "a", Codes: []string{"x"}}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	desc, err := DescribeSchema(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := desc.String()
+	for _, line := range []string{
+		"required int64 id (INT(64,true)) = 1;",
+		"optional binary name (STRING) = 2; // ZSTD",
+		"required group codes (LIST) {",
+		"repeated group list {",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("expected %q in\n%s", line, text)
+		}
+	}
+
+	b, err := desc.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SchemaDescription
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	element := decoded.Fields[2].Children[0].Children[0]
+	if element.Path != "codes.list.element" || element.Type != "binary" || len(element.Encodings) == 0 {
+		t.Fatalf("unexpected leaf in json output: %+v", element)
+	}
+	if decoded.NumRows != 1 || decoded.Fields[1].FieldID != 2 || decoded.Fields[1].Compression != "ZSTD" {
+		t.Fatalf("unexpected json output %s", b)
+	}
+}
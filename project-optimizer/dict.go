@@ -0,0 +1,237 @@
+package projectoptimizer
+
+import (
+	"io"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+/*
+dictionary encoded string columns.
+
+low cardinality strings like country names are written with a dictionary page
+per column chunk and data pages of indices into it. a leaf keeps those
+dictionaries: the entries of every dictionary page are added to a Dictionary
+of the column and rows refer to them by code. the column of the RecordBatch
+still holds the values, but every row shares the boxed value of its entry
+instead of a string of its own, operators that do not know about
+dictionaries keep working.
+
+filters evaluate the conjuncts of a where clause that read a single
+dictionary column once per entry and test the code of every row.
+AggregateExec counts the codes of a dictionary column and compares each
+entry they use once for min and max. there is no grouping or join
+operator yet to hash the codes.
+*/
+
+// Dictionary holds the distinct values of a column, codes index Values.
+// it only grows, codes stay valid for the whole scan
+type Dictionary struct {
+	Values []any // strings, boxed once
+	index  map[string]int32
+}
+
+func newDictionary() *Dictionary {
+	return &Dictionary{index: make(map[string]int32)}
+}
+
+// code of b, b is added when it is not known yet
+func (d *Dictionary) code(b []byte) int32 {
+	if c, ok := d.index[string(b)]; ok {
+		return c
+	}
+	c := int32(len(d.Values))
+	s := string(b)
+	d.index[s] = c
+	d.Values = append(d.Values, s)
+	return c
+}
+
+// DictColumn is the dictionary encoding of a column of a RecordBatch
+type DictColumn struct {
+	Dict  *Dictionary
+	Codes []int32 // code of every row, -1 for NULL
+}
+
+// Dict returns the dictionary encoding of column c, nil when the column is
+// not dictionary encoded or an operator changed it without keeping its codes
+func (r RecordBatch) Dict(c int) *DictColumn {
+	if c >= len(r.Dicts) || r.Dicts[c] == nil || len(r.Dicts[c].Codes) != len(r.Columns[c]) {
+		return nil
+	}
+	return r.Dicts[c]
+}
+
+// adds the dictionary encodings of the columns of batch to out, out keeps a
+// dictionary as long as every batch appended to it uses the same one
+func (r *RecordBatch) adoptDicts(batch RecordBatch) {
+	for c := range r.Columns {
+		dc := batch.Dict(c)
+		switch {
+		case c < len(r.Dicts) && r.Dicts[c] != nil:
+			if dc == nil || dc.Dict != r.Dicts[c].Dict {
+				r.Dicts[c] = nil
+			}
+		case dc != nil && len(r.Columns[c]) == 0:
+			if r.Dicts == nil {
+				r.Dicts = make([]*DictColumn, len(r.Columns))
+			}
+			r.Dicts[c] = &DictColumn{Dict: dc.Dict}
+		}
+	}
+}
+
+// whether a column of a file is read into a Dictionary: a flat string column
+// with a dictionary page in every row group
+func isDictColumn(field structField, md *format.FileMetaData, column int) bool {
+	if field.Node != nil || field.PqType.Kind() != parquet.ByteArray {
+		return false
+	}
+	if lt := field.PqType.LogicalType(); lt != nil && lt.Decimal != nil {
+		return false
+	}
+	if len(md.RowGroups) == 0 {
+		return false
+	}
+	for _, rg := range md.RowGroups {
+		dict := false
+		for _, enc := range rg.Columns[column].MetaData.Encoding {
+			if enc == format.RLEDictionary || enc == format.PlainDictionary {
+				dict = true
+			}
+		}
+		if !dict {
+			return false
+		}
+	}
+	return true
+}
+
+// dictionaries of the columns of a leaf, with the column chunks their
+// dictionary pages are read from
+type leafDicts struct {
+	dicts  []*Dictionary // per schema field, nil for other columns
+	chunks []int         // leaf column of every field in the file
+	groups []parquet.RowGroup
+	ends   []int64 // first row after every row group
+	next   int     // row group whose dictionary pages are read next
+}
+
+// finds the dictionary encoded string columns of schema in f, nil when there are none
+func newLeafDicts(f io.ReaderAt, schema *parquetSchema) *leafDicts {
+	pf, err := parquetFileOf(f, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return nil
+	}
+	d := &leafDicts{
+		dicts:  make([]*Dictionary, len(schema.Fields)),
+		chunks: make([]int, len(schema.Fields)),
+		groups: pf.RowGroups(),
+	}
+	found := false
+	for i, field := range schema.Fields {
+		ref, ok := findNode(pf.Schema(), field.Name)
+		if ok && isDictColumn(field, pf.Metadata(), ref.column) {
+			d.dicts[i], d.chunks[i], found = newDictionary(), ref.column, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	var end int64
+	for _, rg := range d.groups {
+		end += rg.NumRows()
+		d.ends = append(d.ends, end)
+	}
+	return d
+}
+
+// adds the dictionary pages of the row group holding row to the dictionaries,
+// in page order so the codes of a file with one row group are its indices
+func (d *leafDicts) load(row int64) error {
+	for d.next < len(d.groups) && row >= d.ends[d.next]-d.groups[d.next].NumRows() {
+		rg := d.groups[d.next]
+		d.next++
+		if row >= d.ends[d.next-1] {
+			continue // pruned or seeked over
+		}
+		for i, dict := range d.dicts {
+			if dict == nil {
+				continue
+			}
+			if err := readDictionaryPage(rg.ColumnChunks()[d.chunks[i]], dict); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readDictionaryPage(chunk parquet.ColumnChunk, dict *Dictionary) error {
+	pages := chunk.Pages()
+	defer pages.Close()
+	page, err := pages.ReadPage()
+	if err != nil {
+		return err
+	}
+	defer parquet.Release(page)
+	if pd := page.Dictionary(); pd != nil {
+		for i := 0; i < pd.Len(); i++ {
+			dict.code(pd.Index(int32(i)).ByteArray())
+		}
+	}
+	return nil
+}
+
+// a conjunct of a where clause on a single dictionary column, evaluated
+// at most once per entry
+type dictTest struct {
+	expr    Expr
+	column  *DictColumn
+	results []int8 // by code+1: 0 not evaluated, 1 true, 2 not true
+}
+
+func (t *dictTest) match(row int) bool {
+	code := t.column.Codes[row]
+	r := &t.results[code+1]
+	if *r == 0 {
+		var v any
+		if code >= 0 {
+			v = t.column.Dict.Values[code]
+		}
+		*r = 2
+		if isTrue(t.expr.Eval(func(string) any { return v })) {
+			*r = 1
+		}
+	}
+	return *r == 1
+}
+
+// splits where into the tests of dictionary columns of batch and the
+// conjuncts that are evaluated per row
+func dictTests(where Expr, batch RecordBatch, colIdx map[string]int) ([]*dictTest, []Expr) {
+	var tests []*dictTest
+	var rest []Expr
+	for _, e := range conjuncts(where) {
+		if cols := exprColumns(e); len(cols) == 1 {
+			if i, ok := colIdx[strings.ToLower(cols[0])]; ok {
+				if dc := batch.Dict(i); dc != nil {
+					tests = append(tests, &dictTest{expr: e, column: dc, results: make([]int8, len(dc.Dict.Values)+1)})
+					continue
+				}
+			}
+		}
+		rest = append(rest, e)
+	}
+	return tests, rest
+}
+
+// the operands of a chain of ANDs
+func conjuncts(e Expr) []Expr {
+	if l, ok := e.(*LogicalExpr); ok && strings.EqualFold(l.Op, "AND") {
+		return append(conjuncts(l.Left), conjuncts(l.Right)...)
+	}
+	return []Expr{e}
+}
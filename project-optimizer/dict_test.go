@@ -0,0 +1,136 @@
+package projectoptimizer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestLeafKeepsDictionaries(t *testing.T) {
+	f, err := os.Open("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	leaf := NewProjectExecLeaf(f, []string{"country", "lat"}, nil)
+	batch, err := leaf.Next(5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := batch.Dict(0)
+	if dc == nil {
+		t.Fatalf("expected country to be dictionary encoded")
+	}
+	if batch.Dict(1) != nil {
+		t.Fatalf("expected lat not to be dictionary encoded")
+	}
+	if n := len(dc.Dict.Values); n == 0 || n > 300 {
+		t.Fatalf("expected a dictionary of the countries, got %d entries", n)
+	}
+	for i, code := range dc.Codes {
+		if dc.Dict.Values[code] != batch.Columns[0][i] {
+			t.Fatalf("row %d: code %d is %v, column holds %v", i, code, dc.Dict.Values[code], batch.Columns[0][i])
+		}
+	}
+}
+
+func TestFilterOnDictionaryCodes(t *testing.T) {
+	type row struct {
+		City *string `parquet:"city,optional,dict"`
+		N    int32   `parquet:"n"`
+	}
+	city := func(s string) *string { return &s }
+	rows := []row{
+		{city("berlin"), 1}, {nil, 2}, {city("paris"), 3},
+		{city("lima"), 4}, {city("berlin"), 5}, {nil, 6},
+		{city("berlin"), 7},
+	}
+	path := filepath.Join(t.TempDir(), "cities.parquet")
+	if err := parquet.WriteFile(path, rows, parquet.MaxRowsPerRowGroup(3)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, tc := range []struct {
+		where string
+		want  []any
+	}{
+		{"city = 'berlin' AND n > 1", []any{int32(5), int32(7)}},
+		{"city IS NULL", []any{int32(2), int32(6)}},
+		{"city IN ('lima', 'paris') OR n = 1", []any{int32(1), int32(3), int32(4)}},
+		{"city LIKE '%r%'", []any{int32(1), int32(3), int32(5), int32(7)}},
+	} {
+		where, err := ParseExpr(tc.where)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := NewFilterExec(NewProjectExecLeaf(f, []string{"city", "n"}, nil), where)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readAll(t, filter)
+		if !reflect.DeepEqual(got.Columns[1], tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.where, tc.want, got.Columns[1])
+		}
+		dc := got.Dict(0)
+		if dc == nil {
+			t.Fatalf("%s: expected the filter to keep the dictionary", tc.where)
+		}
+		for i, code := range dc.Codes {
+			if (code < 0) != (got.Columns[0][i] == nil) || code >= 0 && dc.Dict.Values[code] != got.Columns[0][i] {
+				t.Fatalf("%s: row %d has code %d for %v", tc.where, i, code, got.Columns[0][i])
+			}
+		}
+	}
+
+	aggr, err := NewAggregateExec(NewProjectExecLeaf(f, []string{"city"}, nil), []Aggregate{
+		{Func: "count", Column: "city"}, {Func: "min", Column: "city"}, {Func: "max", Column: "city"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, aggr).Columns; !reflect.DeepEqual(got, [][]any{{int64(5)}, {"berlin"}, {"paris"}}) {
+		t.Errorf("expected count 5, min berlin and max paris, got %v", got)
+	}
+	// entries no row uses are not a min or max
+	dict := newDictionary()
+	dict.code([]byte("a"))
+	used := &DictColumn{Dict: dict, Codes: []int32{dict.code([]byte("m")), -1, dict.code([]byte("z"))}}
+	if n, v := aggregateCodes("min", used, nil); n != 2 || v != "m" {
+		t.Errorf("expected 2 rows with a min of m, got %d and %v", n, v)
+	}
+}
+
+func TestDictTestEvaluatesEntriesOnce(t *testing.T) {
+	dict := newDictionary()
+	codes := []int32{dict.code([]byte("a")), dict.code([]byte("b")), -1, dict.code([]byte("a"))}
+	batch := RecordBatch{
+		Columns: [][]any{{"a", "b", nil, "a"}},
+		Dicts:   []*DictColumn{{Dict: dict, Codes: codes}},
+	}
+	where, err := ParseExpr("c = 'a' AND c IS NOT NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests, rest := dictTests(where, batch, map[string]int{"c": 0})
+	if len(tests) != 2 || len(rest) != 0 {
+		t.Fatalf("expected both conjuncts to be evaluated on the dictionary, got %d and %d", len(tests), len(rest))
+	}
+	var matched []bool
+	for row := range codes {
+		matched = append(matched, tests[0].match(row))
+	}
+	if !reflect.DeepEqual(matched, []bool{true, false, false, true}) {
+		t.Fatalf("unexpected matches %v", matched)
+	}
+	if !reflect.DeepEqual(tests[0].results, []int8{2, 1, 2}) {
+		t.Fatalf("expected one result per entry and NULL, got %v", tests[0].results)
+	}
+}
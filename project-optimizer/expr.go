@@ -0,0 +1,776 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// small sql style expression language used for where clauses, e.g.
+//
+//	country = 'Angola' AND (lat > 0 OR temp_mean_c_approx BETWEEN 20 AND 30)
+//
+// boolean expressions evaluate to true, false or nil (unknown) so NULL
+// follows sql three valued logic, a row only passes a filter on true.
+
+type Expr interface {
+	Eval(row func(column string) any) any
+	String() string
+}
+
+type ColumnRef struct {
+	Name string
+}
+
+type Literal struct {
+	Value any // int64, float64, string, bool or nil
+}
+
+type CompareExpr struct {
+	Op          string // = != < <= > >=
+	Left, Right Expr
+}
+
+type InExpr struct {
+	Left Expr
+	List []Expr
+	Not  bool
+}
+
+type IsNullExpr struct {
+	Expr Expr
+	Not  bool
+}
+
+type LikeExpr struct {
+	Left    Expr
+	Pattern string
+	Not     bool
+}
+
+type LogicalExpr struct {
+	Op          string // AND, OR
+	Left, Right Expr
+}
+
+type NotExpr struct {
+	Expr Expr
+}
+
+func (c *ColumnRef) Eval(row func(string) any) any { return row(c.Name) }
+func (c *ColumnRef) String() string                { return quoteIdent(c.Name) }
+
+func (l *Literal) Eval(func(string) any) any { return l.Value }
+func (l *Literal) String() string {
+	switch v := l.Value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (c *CompareExpr) Eval(row func(string) any) any {
+	l, r := c.Left.Eval(row), c.Right.Eval(row)
+	if l == nil || r == nil {
+		return nil
+	}
+	cmp, ok := compareAny(l, r)
+	if !ok {
+		return nil
+	}
+	switch c.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return nil
+}
+func (c *CompareExpr) String() string {
+	return c.Left.String() + " " + c.Op + " " + c.Right.String()
+}
+
+func (in *InExpr) Eval(row func(string) any) any {
+	l := in.Left.Eval(row)
+	if l == nil {
+		return nil
+	}
+	sawNull := false
+	for _, e := range in.List {
+		v := e.Eval(row)
+		if v == nil {
+			sawNull = true
+			continue
+		}
+		if cmp, ok := compareAny(l, v); ok && cmp == 0 {
+			return !in.Not
+		}
+	}
+	if sawNull {
+		return nil
+	}
+	return in.Not
+}
+func (in *InExpr) String() string {
+	items := make([]string, len(in.List))
+	for i, e := range in.List {
+		items[i] = e.String()
+	}
+	op := " IN ("
+	if in.Not {
+		op = " NOT IN ("
+	}
+	return in.Left.String() + op + strings.Join(items, ", ") + ")"
+}
+
+func (n *IsNullExpr) Eval(row func(string) any) any {
+	return (n.Expr.Eval(row) == nil) != n.Not
+}
+func (n *IsNullExpr) String() string {
+	if n.Not {
+		return n.Expr.String() + " IS NOT NULL"
+	}
+	return n.Expr.String() + " IS NULL"
+}
+
+func (l *LikeExpr) Eval(row func(string) any) any {
+	v := l.Left.Eval(row)
+	s, ok := v.(string)
+	if !ok {
+		return nil
+	}
+	return likeMatch(s, l.Pattern) != l.Not
+}
+func (l *LikeExpr) String() string {
+	op := " LIKE "
+	if l.Not {
+		op = " NOT LIKE "
+	}
+	return l.Left.String() + op + (&Literal{Value: l.Pattern}).String()
+}
+
+func (l *LogicalExpr) Eval(row func(string) any) any {
+	left := l.Left.Eval(row)
+	if strings.EqualFold(l.Op, "AND") {
+		if left == false {
+			return false
+		}
+		right := l.Right.Eval(row)
+		if right == false {
+			return false
+		}
+		if left == nil || right == nil {
+			return nil
+		}
+		return true
+	}
+	if left == true {
+		return true
+	}
+	right := l.Right.Eval(row)
+	if right == true {
+		return true
+	}
+	if left == nil || right == nil {
+		return nil
+	}
+	return false
+}
+func (l *LogicalExpr) String() string {
+	return "(" + l.Left.String() + " " + l.Op + " " + l.Right.String() + ")"
+}
+
+func (n *NotExpr) Eval(row func(string) any) any {
+	v, ok := n.Expr.Eval(row).(bool)
+	if !ok {
+		return nil
+	}
+	return !v
+}
+func (n *NotExpr) String() string { return "NOT " + n.Expr.String() }
+
+// a row passes a filter only when the expression is true, never on unknown
+func isTrue(v any) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// names of every column referenced by the expression, lower cased and deduplicated
+func exprColumns(e Expr) []string {
+	seen := make(map[string]bool)
+	var out []string
+	walkExpr(e, func(e Expr) {
+		if c, ok := e.(*ColumnRef); ok {
+			name := strings.ToLower(c.Name)
+			if !seen[name] {
+				seen[name] = true
+				out = append(out, c.Name)
+			}
+		}
+	})
+	return out
+}
+
+func walkExpr(e Expr, fn func(Expr)) {
+	if e == nil {
+		return
+	}
+	fn(e)
+	switch v := e.(type) {
+	case *CompareExpr:
+		walkExpr(v.Left, fn)
+		walkExpr(v.Right, fn)
+	case *InExpr:
+		walkExpr(v.Left, fn)
+		for _, item := range v.List {
+			walkExpr(item, fn)
+		}
+	case *IsNullExpr:
+		walkExpr(v.Expr, fn)
+	case *LikeExpr:
+		walkExpr(v.Left, fn)
+	case *LogicalExpr:
+		walkExpr(v.Left, fn)
+		walkExpr(v.Right, fn)
+	case *NotExpr:
+		walkExpr(v.Expr, fn)
+	}
+}
+
+// copy of e with every column name lower cased, e is left untouched
+func lowerExpr(e Expr) Expr {
+	switch v := e.(type) {
+	case *ColumnRef:
+		return &ColumnRef{Name: strings.ToLower(v.Name)}
+	case *CompareExpr:
+		return &CompareExpr{Op: v.Op, Left: lowerExpr(v.Left), Right: lowerExpr(v.Right)}
+	case *InExpr:
+		list := make([]Expr, len(v.List))
+		for i, item := range v.List {
+			list[i] = lowerExpr(item)
+		}
+		return &InExpr{Left: lowerExpr(v.Left), List: list, Not: v.Not}
+	case *IsNullExpr:
+		return &IsNullExpr{Expr: lowerExpr(v.Expr), Not: v.Not}
+	case *LikeExpr:
+		return &LikeExpr{Left: lowerExpr(v.Left), Pattern: v.Pattern, Not: v.Not}
+	case *LogicalExpr:
+		return &LogicalExpr{Op: v.Op, Left: lowerExpr(v.Left), Right: lowerExpr(v.Right)}
+	case *NotExpr:
+		return &NotExpr{Expr: lowerExpr(v.Expr)}
+	}
+	return e
+}
+
+// orders two values, numbers compare across go types and strings compare
+// against dates so '2025-01-01' can be used as a date literal
+func compareAny(a, b any) (int, bool) {
+	if cmp, ok, handled := compareLogical(a, b); handled {
+		return cmp, ok
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			if ia, ok := a.(int64); ok {
+				if ib, ok := b.(int64); ok {
+					return cmpOrdered(ia, ib), true
+				}
+			}
+			return cmpOrdered(fa, fb), true
+		}
+		return 0, false
+	}
+	switch va := a.(type) {
+	case string:
+		switch vb := b.(type) {
+		case string:
+			return strings.Compare(va, vb), true
+		case time.Time:
+			if ta, err := parseDate(va); err == nil {
+				return ta.Compare(vb), true
+			}
+		}
+	case bool:
+		if vb, ok := b.(bool); ok {
+			if va == vb {
+				return 0, true
+			}
+			if !va {
+				return -1, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		switch vb := b.(type) {
+		case time.Time:
+			return va.Compare(vb), true
+		case string:
+			if tb, err := parseDate(vb); err == nil {
+				return va.Compare(tb), true
+			}
+			if tb, err := time.Parse(time.RFC3339, vb); err == nil {
+				return va.Compare(tb), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, !math.IsNaN(n)
+	case Decimal:
+		return n.Float64(), true
+	}
+	return 0, false
+}
+
+func cmpOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// sql LIKE with % and _ wildcards, _ matches a character rather than a byte.
+// a mismatch after % only retries from the last %, the match is linear in
+// the length of s for every %
+func likeMatch(s, pattern string) bool {
+	str, pat := []rune(s), []rune(pattern)
+	i, j := 0, 0
+	star, mark := -1, 0 // last % in the pattern and where s resumes after it
+	for i < len(str) {
+		switch {
+		case j < len(pat) && pat[j] == '%':
+			star, mark = j, i
+			j++
+		case j < len(pat) && (pat[j] == '_' || pat[j] == str[i]):
+			i++
+			j++
+		case star >= 0:
+			mark++
+			i, j = mark, star+1
+		default:
+			return false
+		}
+	}
+	for j < len(pat) && pat[j] == '%' {
+		j++
+	}
+	return j == len(pat)
+}
+
+func quoteIdent(name string) string {
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || r == '_' || (i > 0 && (unicode.IsDigit(r) || r == '.'))) {
+			return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+		}
+	}
+	return name
+}
+
+// ParseExpr parses a where clause into an Expr
+func ParseExpr(input string) (Expr, error) {
+	p, err := newExprParser(input)
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // keywords are upper cased
+	pos  int
+}
+
+var exprKeywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "IS": true, "NULL": true,
+	"TRUE": true, "FALSE": true, "LIKE": true, "BETWEEN": true,
+}
+
+func tokenize(input string, keywords map[string]bool) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		r, _ := utf8.DecodeRuneInString(input[i:]) // identifiers are not only ascii
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'':
+			var sb strings.Builder
+			start := i
+			i++
+			for {
+				if i >= len(input) {
+					return nil, fmt.Errorf("unterminated string starting at position %d", start)
+				}
+				if input[i] == '\'' {
+					if i+1 < len(input) && input[i+1] == '\'' {
+						sb.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(input[i])
+				i++
+			}
+			toks = append(toks, token{kind: tokString, text: sb.String(), pos: start})
+		case c == '"' || c == '`':
+			// a doubled quote is one quote of the name, see quoteIdent
+			var sb strings.Builder
+			start := i
+			i++
+			for {
+				end := strings.IndexByte(input[i:], c)
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated identifier starting at position %d", start)
+				}
+				sb.WriteString(input[i : i+end])
+				i += end + 1
+				if i < len(input) && input[i] == c {
+					sb.WriteByte(c)
+					i++
+					continue
+				}
+				break
+			}
+			toks = append(toks, token{kind: tokIdent, text: sb.String(), pos: start})
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < len(input) && input[i+1] >= '0' && input[i+1] <= '9'):
+			start := i
+			for i < len(input) && (input[i] >= '0' && input[i] <= '9' || input[i] == '.' || input[i] == 'e' || input[i] == 'E' ||
+				((input[i] == '-' || input[i] == '+') && (input[i-1] == 'e' || input[i-1] == 'E'))) {
+				i++
+			}
+			toks = append(toks, token{kind: tokNumber, text: input[start:i], pos: start})
+		case c == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(input) {
+				r, size := utf8.DecodeRuneInString(input[i:])
+				if !(r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+					break
+				}
+				i += size
+			}
+			word := input[start:i]
+			if keywords[strings.ToUpper(word)] {
+				toks = append(toks, token{kind: tokKeyword, text: strings.ToUpper(word), pos: start})
+			} else {
+				toks = append(toks, token{kind: tokIdent, text: word, pos: start})
+			}
+		default:
+			start := i
+			two := ""
+			if i+1 < len(input) {
+				two = input[i : i+2]
+			}
+			switch two {
+			case "<=", ">=", "!=", "<>", "==":
+				if two == "<>" {
+					two = "!="
+				} else if two == "==" {
+					two = "="
+				}
+				toks = append(toks, token{kind: tokOp, text: two, pos: start})
+				i += 2
+				continue
+			}
+			if !strings.ContainsRune("=<>(),*-+;", rune(c)) {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			toks = append(toks, token{kind: tokOp, text: string(c), pos: start})
+			i++
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(input)}), nil
+}
+
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+func newExprParser(input string) (*exprParser, error) {
+	toks, err := tokenize(input, exprKeywords)
+	if err != nil {
+		return nil, err
+	}
+	return &exprParser{toks: toks}, nil
+}
+
+func (p *exprParser) peek() token { return p.toks[p.pos] }
+func (p *exprParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+func (p *exprParser) done() bool { return p.peek().kind == tokEOF }
+
+// consumes the keyword or operator if it is next
+func (p *exprParser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokKeyword || t.kind == tokOp) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		if t.kind == tokEOF {
+			return fmt.Errorf("expected %s at end of input", text)
+		}
+		return fmt.Errorf("expected %s at position %d, got %q", text, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseExpr() (Expr, error) {
+	return p.parseOr()
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &LogicalExpr{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &LogicalExpr{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (Expr, error) {
+	if p.accept("NOT") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Expr: e}, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *exprParser) parsePredicate() (Expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp && isCompareOp(t.text) {
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &CompareExpr{Op: t.text, Left: left, Right: right}, nil
+	}
+	if p.accept("IS") {
+		not := p.accept("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return &IsNullExpr{Expr: left, Not: not}, nil
+	}
+	not := p.accept("NOT")
+	switch {
+	case p.accept("IN"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		in := &InExpr{Left: left, Not: not}
+		for {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			in.List = append(in.List, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return in, nil
+	case p.accept("LIKE"):
+		t := p.next()
+		if t.kind != tokString {
+			return nil, fmt.Errorf("expected a string pattern after LIKE at position %d", t.pos)
+		}
+		return &LikeExpr{Left: left, Pattern: t.text, Not: not}, nil
+	case p.accept("BETWEEN"):
+		lo, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		var e Expr = &LogicalExpr{
+			Op:    "AND",
+			Left:  &CompareExpr{Op: ">=", Left: left, Right: lo},
+			Right: &CompareExpr{Op: "<=", Left: left, Right: hi},
+		}
+		if not {
+			e = &NotExpr{Expr: e}
+		}
+		return e, nil
+	}
+	if not {
+		return nil, fmt.Errorf("expected IN, LIKE or BETWEEN after NOT at position %d", p.peek().pos)
+	}
+	return left, nil
+}
+
+func isCompareOp(op string) bool {
+	switch op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOperand() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIdent:
+		return &ColumnRef{Name: t.text}, nil
+	case tokString:
+		return &Literal{Value: t.text}, nil
+	case tokNumber:
+		return parseNumber(t.text, false)
+	case tokKeyword:
+		switch t.text {
+		case "NULL":
+			return &Literal{Value: nil}, nil
+		case "TRUE":
+			return &Literal{Value: true}, nil
+		case "FALSE":
+			return &Literal{Value: false}, nil
+		}
+	case tokOp:
+		switch t.text {
+		case "(":
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		case "-":
+			n := p.next()
+			if n.kind == tokNumber {
+				return parseNumber(n.text, true)
+			}
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+func parseNumber(text string, negative bool) (Expr, error) {
+	if negative {
+		text = "-" + text
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return &Literal{Value: i}, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+	return &Literal{Value: f}, nil
+}
@@ -0,0 +1,79 @@
+package projectoptimizer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExprEval(t *testing.T) {
+	row := map[string]any{"country": "Angola", "lat": -8.8, "pop": int64(35), "capital": nil, "island": "Curaçao", `a"b`: int64(1),
+		"país": "Angola", "long": strings.Repeat("a", 5000)}
+	get := func(name string) any { return row[name] }
+
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{"country = 'Angola'", true},
+		{"country <> 'Angola'", false},
+		{"lat < 0 AND pop >= 35", true},
+		{"lat > 0 OR pop = 35", true},
+		{"NOT (lat > 0)", true},
+		{"pop IN (1, 2, 35)", true},
+		{"pop NOT IN (1, 2)", true},
+		{"country LIKE 'An%'", true},
+		{"island LIKE 'Cura_ao'", true},
+		{"island LIKE '%_ao'", true},
+		{"island LIKE 'Cura__ao'", false},
+		{"island LIKE '%%ç%o'", true},
+		{"island LIKE 'C%x%'", false},
+		{"country LIKE '%'", true},
+		// every % would retry the rest of the string with backtracking
+		{"long LIKE '%a%a%a%a%a%a%a%a%a%a%a%a%b'", false},
+		{"país = 'Angola'", true},
+		{`"a""b" = 1`, true},
+		{"lat BETWEEN -10 AND -5", true},
+		{"capital IS NULL", true},
+		{"capital IS NOT NULL", false},
+		// three valued logic
+		{"capital = 'Luanda'", nil},
+		{"capital = 'Luanda' OR lat < 0", true},
+		{"capital = 'Luanda' AND lat < 0", nil},
+		{"capital = 'Luanda' AND lat > 0", false},
+		{"NOT capital = 'Luanda'", nil},
+		{"pop IN (1, NULL)", nil},
+	}
+	for _, tc := range tests {
+		e, err := ParseExpr(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := e.Eval(get); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.expr, tc.want, got)
+		}
+	}
+}
+
+func TestQuotedIdentRoundTrip(t *testing.T) {
+	for _, name := range []string{`a"b`, "with space", `""`, "plain", "país"} {
+		input := quoteIdent(name) + " IS NULL"
+		e, err := ParseExpr(input)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if got := e.(*IsNullExpr).Expr.(*ColumnRef).Name; got != name {
+			t.Errorf("expected %q, got %q", name, got)
+		}
+		if e.String() != input {
+			t.Errorf("expected %s, got %s", input, e)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, input := range []string{"", "lat >", "country = 'x", "a = 1 b", "pop IN (1, 2", "lat NOT 3"} {
+		if _, err := ParseExpr(input); err == nil {
+			t.Errorf("%q: expected a parse error", input)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FilterExec keeps the rows of its child where an Expr evaluates to true
+type FilterExec struct {
+	childInput Operator
+	where      Expr
+	schema     *parquetSchema
+	colIdx     map[string]int
+	done       bool
+}
+
+func NewFilterExec(input Operator, where Expr) (*FilterExec, error) {
+	schema := input.Schema()
+	colIdx := schemaIndex(schema)
+	if err := checkWhere(where, colIdx); err != nil {
+		return nil, err
+	}
+	return &FilterExec{
+		childInput: input,
+		where:      where,
+		schema:     schema,
+		colIdx:     colIdx,
+	}, nil
+}
+
+// makes sure every column of where is in colIdx
+func checkWhere(where Expr, colIdx map[string]int) error {
+	if where == nil {
+		return nil
+	}
+	for _, name := range exprColumns(where) {
+		if _, ok := colIdx[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("%w: filter column %q", ErrColumnNotFound, name)
+		}
+	}
+	return nil
+}
+
+// reports if where is true for one row of values, a nil where keeps every
+// row. a comparison with NULL is unknown so the row is dropped like in sql
+func matchesWhere(where Expr, colIdx map[string]int, values []any) bool {
+	if where == nil {
+		return true
+	}
+	return isTrue(where.Eval(func(column string) any {
+		i, ok := colIdx[strings.ToLower(column)]
+		if !ok {
+			return nil
+		}
+		return values[i]
+	}))
+}
+
+// lower cased column name -> position in the schema
+func schemaIndex(schema *parquetSchema) map[string]int {
+	idx := make(map[string]int, len(schema.Fields))
+	for i, field := range schema.Fields {
+		idx[strings.ToLower(field.Name)] = i
+	}
+	return idx
+}
+
+func (f *FilterExec) Next(n uint) (RecordBatch, error) {
+	out := RecordBatch{
+		Schema:  *f.schema,
+		Columns: make([][]any, len(f.schema.Fields)),
+	}
+	for uint(out.NumRows()) < n && !f.done {
+		want := n - uint(out.NumRows())
+		batch, err := f.childInput.Next(want)
+		if err != nil && err != io.EOF {
+			return out, err
+		}
+		if err == io.EOF {
+			f.done = true
+		}
+		appendMatching(&out, batch, f.where, f.colIdx)
+	}
+	if f.done {
+		return out, io.EOF
+	}
+	return out, nil
+}
+
+// appends the rows of batch where the expression is true to out.
+// colIdx maps lower cased column names to positions in batch
+func appendMatching(out *RecordBatch, batch RecordBatch, where Expr, colIdx map[string]int) {
+	rows := batch.NumRows()
+	row := 0
+	get := func(column string) any {
+		i, ok := colIdx[strings.ToLower(column)]
+		if !ok {
+			return nil
+		}
+		return batch.Columns[i][row]
+	}
+	out.adoptDicts(batch)
+	tests, rest := dictTests(where, batch, colIdx)
+rows:
+	for row = 0; row < rows; row++ {
+		for _, t := range tests {
+			if !t.match(row) {
+				continue rows
+			}
+		}
+		for _, e := range rest {
+			if !isTrue(e.Eval(get)) {
+				continue rows
+			}
+		}
+		for c := range out.Columns {
+			out.Columns[c] = append(out.Columns[c], batch.Columns[c][row])
+		}
+		for c, dc := range out.Dicts {
+			if dc != nil {
+				dc.Codes = append(dc.Codes, batch.Dicts[c].Codes[row])
+			}
+		}
+	}
+}
+
+func (f *FilterExec) Schema() *parquetSchema {
+	return f.schema
+}
@@ -0,0 +1,408 @@
+package projectoptimizer
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// footers of local parquet files shared by every query of the process.
+// an entry is keyed by the device and inode of a file and only used while the
+// file keeps the size and modification time it was read with. it holds the
+// end of the file from the page index on, with the schema and statistics
+// derived from the footer. entries are evicted least recently used first
+// once they take more than the byte limit.
+//
+// queries open the parquet file through their own handle, the footer and the
+// page index are served from the entry and pages are read through the
+// handle. bloom filters of the statistics are read through a handle of the
+// entry that is opened on demand by path, at most maxOpen of them are open at
+// a time: the least recently used ones are closed above the limit and the
+// handle of an evicted entry at once. a path that no longer names the file
+// the footer was read from is not read at all
+
+// Footers is the process-wide footer cache, 64 MiB of footers by default
+var Footers = NewFooterCache(64 << 20)
+
+// open files of a FooterCache by default
+const defaultFooterOpenFiles = 128
+
+var errFileChanged = errors.New("file changed since its footer was read")
+
+// FooterCache keeps parsed parquet footers, safe for concurrent use
+type FooterCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*list.Element // of *footerEntry by fileKey
+	lru      list.List                // most recently used first
+	maxOpen  int
+	open     list.List // of *footerFile with an open handle, most recently used first
+
+	hits, misses, evictions atomic.Int64
+}
+
+// FooterCacheStats counts the lookups of a FooterCache
+type FooterCacheStats struct {
+	Hits, Misses, Evictions int64
+	Entries                 int
+	Bytes                   int64 // footer and page index bytes held
+	OpenFiles               int
+}
+
+type footerEntry struct {
+	key  string
+	path string
+	size int64
+	cost int64 // length of tail
+
+	tail   []byte // the file from the offset on, page index and footer
+	offset int64
+	handle *footerFile
+	file   *parquet.File // reading through handle
+	schema *parquetSchema
+
+	once   sync.Once // statistics are derived on first use
+	stats  map[string]columnStats
+	groups []map[string]columnStats
+}
+
+// NewFooterCache keeps up to maxBytes of footers, <= 0 disables the cache
+func NewFooterCache(maxBytes int64) *FooterCache {
+	return &FooterCache{maxBytes: maxBytes, maxOpen: defaultFooterOpenFiles, entries: make(map[string]*list.Element)}
+}
+
+// SetMaxOpenFiles limits the files the cached footers keep open, at least one
+func (c *FooterCache) SetMaxOpenFiles(n int) {
+	c.mu.Lock()
+	c.maxOpen = max(n, 1)
+	closing := c.closeOpen()
+	c.mu.Unlock()
+	for _, f := range closing {
+		f.close()
+	}
+}
+
+// SetMaxBytes changes the limit and evicts what no longer fits, <= 0 clears
+// and disables the cache
+func (c *FooterCache) SetMaxBytes(maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// Invalidate drops the footers read from the file at path
+func (c *FooterCache) Invalidate(path string) {
+	path = absPath(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if e.Value.(*footerEntry).path == path {
+			c.remove(e)
+		}
+	}
+}
+
+// Clear drops every footer
+func (c *FooterCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		c.remove(e)
+	}
+}
+
+func (c *FooterCache) Stats() FooterCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return FooterCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+		OpenFiles: c.open.Len(),
+	}
+}
+
+// footer of the file described by info, read from path on a miss. nil
+// without an error when the cache is disabled or path no longer names the
+// file of info
+func (c *FooterCache) get(path string, info fs.FileInfo) (*footerEntry, error) {
+	path = absPath(path)
+	key := fileKey(path, info)
+	c.mu.Lock()
+	if c.maxBytes <= 0 {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*footerEntry)
+		if sameFileVersion(info, entry.handle.info) {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return entry, nil
+		}
+		c.remove(e) // the file was rewritten
+	}
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	entry, err := c.openFooterEntry(key, path, info)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e) // read concurrently, the newer one is kept
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.bytes += entry.cost
+	c.evict()
+	return entry, nil
+}
+
+// drops the least recently used entries above the limit, must be called with mu held
+func (c *FooterCache) evict() {
+	for len(c.entries) > 0 && (c.maxBytes <= 0 || c.bytes > c.maxBytes) {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// must be called with mu held. closing the handle of the entry waits for
+// reads in flight, they never wait for mu
+func (c *FooterCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*footerEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.cost
+	c.forget(entry.handle)
+	entry.handle.close()
+}
+
+// drops a handle from the open list, must be called with mu held
+func (c *FooterCache) forget(f *footerFile) {
+	if f.elem != nil {
+		c.open.Remove(f.elem)
+		f.elem = nil
+	}
+}
+
+// drops the least recently used handles above maxOpen from the open list,
+// must be called with mu held. the caller closes them once mu is released
+func (c *FooterCache) closeOpen() []*footerFile {
+	var closing []*footerFile
+	for c.open.Len() > c.maxOpen {
+		f := c.open.Remove(c.open.Back()).(*footerFile)
+		f.elem = nil
+		closing = append(closing, f)
+	}
+	return closing
+}
+
+// a handle was used, it is opened when it was not in the open list
+func (c *FooterCache) used(f *footerFile) {
+	c.mu.Lock()
+	if f.elem != nil {
+		c.open.MoveToFront(f.elem)
+		c.mu.Unlock()
+		return
+	}
+	f.elem = c.open.PushFront(f)
+	closing := c.closeOpen()
+	c.mu.Unlock()
+	for _, old := range closing {
+		old.close()
+	}
+}
+
+// opens the footer of the file at path, nil when it no longer matches info
+func (c *FooterCache) openFooterEntry(key, path string, info fs.FileInfo) (*footerEntry, error) {
+	handle := &footerFile{cache: c, path: path, info: info}
+	fail := func() {
+		c.mu.Lock()
+		c.forget(handle)
+		c.mu.Unlock()
+		handle.close()
+	}
+	var tail [8]byte
+	if _, err := handle.ReadAt(tail[:], info.Size()-8); err != nil {
+		fail()
+		if errors.Is(err, errFileChanged) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading footer of %s: %w", path, err)
+	}
+	pf, err := parquet.OpenFile(handle, info.Size())
+	if err != nil {
+		fail()
+		return nil, fmt.Errorf("reading footer of %s: %w", path, err)
+	}
+	schema, err := parseSchema(pf.Schema())
+	if err != nil {
+		fail()
+		return nil, fmt.Errorf("reading schema of %s: %w", path, err)
+	}
+	// the page index is written between the pages and the footer
+	offset := info.Size() - int64(binary.LittleEndian.Uint32(tail[:4])) - 8
+	for _, rg := range pf.Metadata().RowGroups {
+		for _, chunk := range rg.Columns {
+			for _, off := range []int64{chunk.ColumnIndexOffset, chunk.OffsetIndexOffset} {
+				if off > 0 {
+					offset = min(offset, off)
+				}
+			}
+		}
+	}
+	end := make([]byte, info.Size()-offset)
+	if _, err := handle.ReadAt(end, offset); err != nil {
+		fail()
+		return nil, fmt.Errorf("reading footer of %s: %w", path, err)
+	}
+	return &footerEntry{
+		key:    key,
+		path:   path,
+		size:   info.Size(),
+		cost:   int64(len(end)),
+		tail:   end,
+		offset: offset,
+		handle: handle,
+		file:   pf,
+		schema: schema,
+	}, nil
+}
+
+// footerFile reads a cached file through a handle opened on demand
+type footerFile struct {
+	cache *FooterCache
+	path  string
+	info  fs.FileInfo // of the file the footer was read from
+
+	mu   sync.RWMutex
+	f    *os.File
+	elem *list.Element // in cache.open, guarded by cache.mu
+}
+
+func (h *footerFile) ReadAt(p []byte, off int64) (int, error) {
+	for {
+		h.mu.RLock()
+		if f := h.f; f != nil {
+			n, err := f.ReadAt(p, off)
+			h.mu.RUnlock()
+			h.cache.used(h)
+			return n, err
+		}
+		h.mu.RUnlock()
+		if err := h.reopen(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (h *footerFile) reopen() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.f != nil {
+		return nil
+	}
+	f, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil && !sameFileVersion(info, h.info) {
+		err = fmt.Errorf("%s: %w", h.path, errFileChanged)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	h.f = f
+	return nil
+}
+
+// closes the handle once the reads in flight are done, later reads open it again
+func (h *footerFile) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+}
+
+// statistics of the whole file and of every row group, for every column
+func (e *footerEntry) statistics() (map[string]columnStats, []map[string]columnStats) {
+	e.once.Do(func() {
+		e.stats = fileStats(e.schema, e.file.Metadata())
+		e.groups = rowGroupLookups(e.file, e.schema)
+	})
+	return e.stats, e.groups
+}
+
+// the cached footer of r when it is a local file, nil otherwise
+func cachedFooter(r io.ReaderAt) *footerEntry {
+	if s, ok := r.(sizedReaderAt); ok {
+		r = s.ReaderAt
+	}
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	entry, _ := Footers.get(f.Name(), info)
+	return entry
+}
+
+// opens the parquet file in r, the footers and page indexes of local files
+// come from the cache and their pages are read from r
+func parquetFileOf(r io.ReaderAt, options ...parquet.FileOption) (*parquet.File, error) {
+	if entry := cachedFooter(r); entry != nil {
+		return parquet.OpenFile(&cachedTail{ReaderAt: r, entry: entry}, entry.size, options...)
+	}
+	return openParquetFile(r, options...)
+}
+
+// reads the end of a file from its footer entry, the rest from the file
+type cachedTail struct {
+	io.ReaderAt
+	entry *footerEntry
+}
+
+func (t *cachedTail) ReadAt(p []byte, off int64) (int, error) {
+	if off < t.entry.offset {
+		return t.ReaderAt.ReadAt(p, off)
+	}
+	if off >= t.entry.size {
+		return 0, io.EOF
+	}
+	n := copy(p, t.entry.tail[off-t.entry.offset:])
+	return n, eofIfShort(n, p)
+}
+
+// whether info describes the same file with the same contents as read
+func sameFileVersion(info, read fs.FileInfo) bool {
+	return os.SameFile(info, read) && info.Size() == read.Size() && info.ModTime().Equal(read.ModTime())
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
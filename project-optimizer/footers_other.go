@@ -0,0 +1,10 @@
+//go:build !unix
+
+package projectoptimizer
+
+import "io/fs"
+
+// cache key of a file: its path, reads check it is still the same file
+func fileKey(path string, _ fs.FileInfo) string {
+	return absPath(path)
+}
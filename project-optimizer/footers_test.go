@@ -0,0 +1,231 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestFooterCacheSharesFootersAcrossQueries(t *testing.T) {
+	dir := writeDailyDataset(t)
+	path := filepath.Join(dir, "day-1.parquet")
+	query := func() RecordBatch {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		where, err := ParseExpr("store = 'north'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf := NewProjectExecLeaf(f, []string{"store", "total"}, nil)
+		if _, err := leaf.PruneRowGroups(where); err != nil {
+			t.Fatal(err)
+		}
+		return drain(t, leaf)
+	}
+
+	before := Footers.Stats()
+	first := query()
+	afterFirst := Footers.Stats()
+	if afterFirst.Misses-before.Misses != 1 {
+		t.Fatalf("expected the footer to be read once, got %d misses", afterFirst.Misses-before.Misses)
+	}
+	second := query()
+	if hits := Footers.Stats().Hits - afterFirst.Hits; hits < 2 {
+		t.Fatalf("expected the second query to use the cached footer, got %d hits", hits)
+	}
+	if Footers.Stats().Misses != afterFirst.Misses || second.NumRows() != first.NumRows() {
+		t.Fatalf("expected the second query to read no footer")
+	}
+
+	// a rewritten file is read again
+	rows := []dailyRowWithRegion{{Day: 1, Store: "east", Total: 5, Region: "eu"}}
+	if err := parquet.WriteFile(path, rows); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	schema, err := ReadParquetSchema(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schema.ColumnInfo("region"); err != nil {
+		t.Fatalf("expected the schema of the rewritten file, got %v", err)
+	}
+}
+
+func TestFooterCacheLimits(t *testing.T) {
+	dir := writeDailyDataset(t)
+	c := NewFooterCache(1 << 20)
+	get := func(name string) *footerEntry {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := c.get(path, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+	one := get("day-1.parquet")
+	if one == nil || one.schema == nil || one.file.NumRows() != 2 {
+		t.Fatalf("expected the footer of day-1, got %+v", one)
+	}
+	if stats, _ := one.statistics(); stats["store"].min != "north" {
+		t.Fatalf("expected store statistics, got %+v", stats["store"])
+	}
+	get("day-2.parquet")
+	if get("day-1.parquet") != one {
+		t.Fatalf("expected the cached entry")
+	}
+
+	// room for a single footer, day-2 is the least recently used
+	c.SetMaxBytes(one.cost)
+	if stats := c.Stats(); stats.Entries != 1 || stats.Evictions != 1 || stats.Bytes != one.cost {
+		t.Fatalf("expected day-2 to be evicted, got %+v", stats)
+	}
+	c.Invalidate(filepath.Join(dir, "day-1.parquet"))
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Fatalf("expected an empty cache, got %+v", stats)
+	}
+
+	c.SetMaxBytes(0)
+	if get("day-3.parquet") != nil || c.Stats().Entries != 0 {
+		t.Fatalf("expected a disabled cache to keep nothing")
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Fatalf("unexpected counts %+v", stats)
+	}
+}
+
+func TestFooterCacheLimitsOpenFiles(t *testing.T) {
+	dir := writeDailyDataset(t)
+	c := NewFooterCache(1 << 20)
+	c.SetMaxOpenFiles(2)
+	entries := make(map[string]*footerEntry)
+	for _, name := range []string{"day-1.parquet", "day-2.parquet", "day-3.parquet"} {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries[name], err = c.get(path, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := c.Stats(); stats.Entries != 3 || stats.OpenFiles != 2 {
+		t.Fatalf("expected 3 footers and 2 open files, got %+v", stats)
+	}
+	one := entries["day-1.parquet"]
+	if one.handle.f != nil {
+		t.Fatalf("expected the least recently used file to be closed")
+	}
+
+	// a closed handle is opened again to read, the least recently used one closes
+	magic := make([]byte, 4)
+	if _, err := one.handle.ReadAt(magic, 0); err != nil || string(magic) != "PAR1" {
+		t.Fatalf("expected to read day-1 again, got %q %v", magic, err)
+	}
+	if entries["day-2.parquet"].handle.f != nil || c.Stats().OpenFiles != 2 {
+		t.Fatalf("expected day-2 to be closed, got %+v", c.Stats())
+	}
+
+	// dropped footers close their files
+	c.Invalidate(filepath.Join(dir, "day-1.parquet"))
+	if one.handle.f != nil || c.Stats().OpenFiles != 1 {
+		t.Fatalf("expected the file of an invalidated footer to be closed, got %+v", c.Stats())
+	}
+	c.Clear()
+	if stats := c.Stats(); stats.OpenFiles != 0 || entries["day-3.parquet"].handle.f != nil {
+		t.Fatalf("expected every file to be closed, got %+v", stats)
+	}
+
+	// a file rewritten since its footer was read is not read
+	if err := os.Chtimes(filepath.Join(dir, "day-1.parquet"), time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := one.handle.ReadAt(magic, 0); !errors.Is(err, errFileChanged) {
+		t.Fatalf("expected errFileChanged, got %v", err)
+	}
+}
+
+func TestFooterCacheReadsThroughCallerHandle(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "day.parquet")
+	if err := parquet.WriteFile(path, []dailyRow{{Day: 1, Store: "north", Total: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	stores := func(f *os.File) []any {
+		t.Helper()
+		leaf, err := OpenProjectExecLeaf(f, []string{"store"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return drain(t, leaf).Columns[0]
+	}
+	stores(old)
+
+	// replaced under its path, the open handle still reads the old file
+	replacement := filepath.Join(dir, "new.parquet")
+	if err := parquet.WriteFile(replacement, []dailyRow{{Day: 2, Store: "south", Total: 20}, {Day: 2, Store: "west", Total: 30}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		t.Fatal(err)
+	}
+	hits := Footers.Stats().Hits
+	if got := stores(old); len(got) != 1 || got[0] != "north" {
+		t.Fatalf("expected the rows of the replaced file, got %v", got)
+	}
+	if Footers.Stats().Hits == hits {
+		t.Fatalf("expected the footer of the open handle to be cached")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got := stores(f); len(got) != 2 || got[0] != "south" {
+		t.Fatalf("expected the rows of the new file, got %v", got)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := stores(old); len(got) != 1 || got[0] != "north" {
+		t.Fatalf("expected the rows of the unlinked file, got %v", got)
+	}
+
+	// options apply to cached footers
+	pf, err := parquetFileOf(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pf.ColumnIndexes()) == 0 {
+		t.Fatalf("expected the page index of the file")
+	}
+	if pf, err = parquetFileOf(f, parquet.SkipPageIndex(true)); err != nil || len(pf.ColumnIndexes()) != 0 {
+		t.Fatalf("expected the page index to be skipped, got %d indexes (%v)", len(pf.ColumnIndexes()), err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package projectoptimizer
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// cache key of a file: its device and inode, a file replaced or unlinked
+// under its path keeps its own key
+func fileKey(path string, info fs.FileInfo) string {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+	}
+	return absPath(path)
+}
@@ -0,0 +1,315 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// parquet files read over plain http with range requests. reads are split
+// into aligned blocks: neighbouring blocks missing for a read are fetched with
+// one request, reads of a block already in flight wait for that request, and
+// a few blocks are kept for the many small reads of footers and page headers.
+// the request opening a file fetches its tail, which usually holds the footer
+
+type HTTPOptions struct {
+	Client      *http.Client              // http.DefaultClient when nil
+	Header      http.Header               // sent with every request, e.g. Authorization
+	BlockSize   int64                     // bytes of a block, 256 KiB by default
+	CacheBlocks int                       // blocks kept after they were read, 64 by default
+	MaxParallel int                       // requests in flight at once, 4 by default
+	Retries     int                       // attempts after a failed request, 3 by default, < 0 for none
+	RetryDelay  time.Duration             // before the first retry, doubled after every one, 100ms by default
+	Prepare     func(*http.Request) error // called before every attempt, e.g. to sign it
+}
+
+// HTTPStats counts the traffic of an HTTPSource
+type HTTPStats struct {
+	Requests int64 // requests sent, retries included
+	Retries  int64
+	Bytes    int64 // body bytes received
+}
+
+// HTTPSource is a Source reading a remote file with http range requests,
+// it is safe for concurrent use
+type HTTPSource struct {
+	url  string
+	size int64
+	opts HTTPOptions
+	sem  chan struct{}
+	tail []byte // last bytes of the file, read when it was opened
+
+	mu     sync.Mutex
+	blocks map[int64]*httpBlock // cached and in flight by block index
+	tick   int64
+
+	requests, retries, bytes atomic.Int64
+}
+
+type httpBlock struct {
+	done chan struct{} // closed once data or err is set
+	data []byte
+	err  error
+	used int64
+}
+
+// OpenHTTPSource finds the size of the file at url with a request for its
+// last block, the server has to support range requests
+func OpenHTTPSource(url string, opts HTTPOptions) (*HTTPSource, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 256 << 10
+	}
+	if opts.CacheBlocks <= 0 {
+		opts.CacheBlocks = 64
+	}
+	if opts.MaxParallel <= 0 {
+		opts.MaxParallel = 4
+	}
+	if opts.Retries == 0 {
+		opts.Retries = 3
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 100 * time.Millisecond
+	}
+	s := &HTTPSource{
+		url:    url,
+		opts:   opts,
+		sem:    make(chan struct{}, opts.MaxParallel),
+		blocks: make(map[int64]*httpBlock),
+	}
+	body, resp, err := s.get(fmt.Sprintf("bytes=-%d", opts.BlockSize))
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+		if start+int64(len(body)) != size {
+			return nil, fmt.Errorf("%s: expected the last %d bytes, got %d from %d", url, size-start, len(body), start)
+		}
+		s.size = size
+	case http.StatusOK:
+		// the whole file was sent, it is smaller than a block or ranges are ignored
+		if int64(len(body)) > opts.BlockSize {
+			return nil, fmt.Errorf("%s: server does not support range requests", url)
+		}
+		s.size = int64(len(body))
+	}
+	s.tail = body
+	return s, nil
+}
+
+func (s *HTTPSource) Size() int64 {
+	return s.size
+}
+
+// Close drops the cached blocks
+func (s *HTTPSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks = make(map[int64]*httpBlock)
+	return nil
+}
+
+// Stats returns the requests sent so far
+func (s *HTTPSource) Stats() HTTPStats {
+	return HTTPStats{Requests: s.requests.Load(), Retries: s.retries.Load(), Bytes: s.bytes.Load()}
+}
+
+func (s *HTTPSource) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), s.size)
+	if tailStart := s.size - int64(len(s.tail)); off >= tailStart {
+		n := copy(p, s.tail[off-tailStart:end-tailStart])
+		return n, eofIfShort(n, p)
+	}
+
+	bs := s.opts.BlockSize
+	first, last := off/bs, (end-1)/bs
+	blocks := make([]*httpBlock, 0, last-first+1)
+	s.mu.Lock()
+	var run []*httpBlock // missing blocks fetched by one request
+	runStart := first
+	for i := first; i <= last; i++ {
+		b, ok := s.blocks[i]
+		if !ok {
+			b = &httpBlock{done: make(chan struct{})}
+			s.blocks[i] = b
+			if len(run) == 0 {
+				runStart = i
+			}
+			run = append(run, b)
+		} else if len(run) > 0 {
+			go s.fetch(runStart, run)
+			run = nil
+		}
+		s.tick++
+		b.used = s.tick
+		blocks = append(blocks, b)
+	}
+	if len(run) > 0 {
+		go s.fetch(runStart, run)
+	}
+	s.mu.Unlock()
+
+	n := 0
+	for k, b := range blocks {
+		<-b.done
+		if b.err != nil {
+			return n, b.err
+		}
+		blockStart := (first + int64(k)) * bs
+		from := max(off, blockStart) - blockStart
+		to := min(end, blockStart+int64(len(b.data))) - blockStart
+		n += copy(p[n:], b.data[from:to])
+	}
+	s.evict()
+	return n, eofIfShort(n, p)
+}
+
+// ReaderAt returns io.EOF when it reads less than asked for
+func eofIfShort(n int, p []byte) error {
+	if n < len(p) {
+		return io.EOF
+	}
+	return nil
+}
+
+// fetches the blocks from index first with a single range request
+func (s *HTTPSource) fetch(first int64, run []*httpBlock) {
+	bs := s.opts.BlockSize
+	start := first * bs
+	end := min(start+int64(len(run))*bs, s.size)
+	body, resp, err := s.get(fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if err == nil {
+		switch {
+		case resp.StatusCode == http.StatusOK && int64(len(body)) == s.size:
+			body = body[start:end]
+		case resp.StatusCode != http.StatusPartialContent || int64(len(body)) != end-start:
+			err = fmt.Errorf("%s: expected bytes %d-%d, got %d bytes with status %s", s.url, start, end-1, len(body), resp.Status)
+		}
+	}
+	s.mu.Lock()
+	for k, b := range run {
+		if err != nil {
+			// failed blocks are asked for again by the next read
+			b.err = err
+			delete(s.blocks, first+int64(k))
+		} else {
+			b.data = body[int64(k)*bs : min(int64(k+1)*bs, end-start)]
+		}
+		close(b.done)
+	}
+	s.mu.Unlock()
+}
+
+// drops the least recently used blocks above the cache size
+func (s *HTTPSource) evict() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.blocks) > s.opts.CacheBlocks {
+		var oldest int64 = -1
+		for i, b := range s.blocks {
+			select {
+			case <-b.done:
+			default:
+				continue // in flight
+			}
+			if oldest < 0 || b.used < s.blocks[oldest].used {
+				oldest = i
+			}
+		}
+		if oldest < 0 {
+			return
+		}
+		delete(s.blocks, oldest)
+	}
+}
+
+// sends a GET with a Range header, retrying network errors, 5xx and 429.
+// at most MaxParallel requests are in flight
+func (s *HTTPSource) get(rng string) ([]byte, *http.Response, error) {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+	delay := s.opts.RetryDelay
+	var lastErr error
+	for attempt := 0; attempt <= max(s.opts.Retries, 0); attempt++ {
+		if attempt > 0 {
+			s.retries.Add(1)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		req, err := http.NewRequest(http.MethodGet, s.url, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, v := range s.opts.Header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Range", rng)
+		if s.opts.Prepare != nil {
+			if err := s.opts.Prepare(req); err != nil {
+				return nil, nil, err
+			}
+		}
+		s.requests.Add(1)
+		resp, err := s.opts.Client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		s.bytes.Add(int64(len(body)))
+		switch {
+		case err != nil:
+			lastErr = err
+			continue
+		case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+			lastErr = fmt.Errorf("%s: %s", s.url, resp.Status)
+			continue
+		case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+			return nil, nil, fmt.Errorf("%s: %s", s.url, resp.Status)
+		}
+		return body, resp, nil
+	}
+	return nil, nil, fmt.Errorf("giving up after %d attempts: %w", max(s.opts.Retries, 0)+1, lastErr)
+}
+
+// start offset and size of the file from "bytes 100-199/1234"
+func parseContentRange(header string) (start, size int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	rng, total, ok2 := strings.Cut(spec, "/")
+	from, _, ok3 := strings.Cut(rng, "-")
+	if !ok || !ok2 || !ok3 {
+		return 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	if start, err = strconv.ParseInt(from, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("Content-Range %q has no size", header)
+	}
+	return start, size, nil
+}
+
+// whether path names a file served over http
+func isHTTPURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
@@ -0,0 +1,184 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serves data/ and counts the range requests it sees
+type rangeServer struct {
+	*httptest.Server
+	requests atomic.Int64
+	failures atomic.Int64 // the next requests answered with 503
+	inFlight atomic.Int64
+	peak     atomic.Int64
+	delay    time.Duration
+}
+
+func newRangeServer(t *testing.T) *rangeServer {
+	t.Helper()
+	s := &rangeServer{}
+	files := http.FileServer(http.Dir("../data"))
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		n := s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		for {
+			peak := s.peak.Load()
+			if n <= peak || s.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		time.Sleep(s.delay)
+		if s.failures.Add(-1) >= 0 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestHTTPSourceReadsParquet(t *testing.T) {
+	srv := newRangeServer(t)
+	src, err := OpenHTTPSource(srv.URL+"/history.parquet", HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if info, _ := f.Stat(); src.Size() != info.Size() {
+		t.Fatalf("expected size %d, got %d", info.Size(), src.Size())
+	}
+
+	columns := []string{"country", "capital", "lat"}
+	want, err := NewProjectExecLeaf(f, columns, nil).Next(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewProjectExecLeaf(src, columns, nil).Next(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Columns, want.Columns) {
+		t.Fatalf("rows read over http differ from the file")
+	}
+	if stats := src.Stats(); stats.Requests != srv.requests.Load() || stats.Bytes >= src.Size() {
+		t.Fatalf("expected only the projected chunks to be fetched, got %+v of %d bytes", stats, src.Size())
+	}
+}
+
+func TestHTTPSourceCoalescesReads(t *testing.T) {
+	srv := newRangeServer(t)
+	srv.delay = 20 * time.Millisecond
+	src, err := OpenHTTPSource(srv.URL+"/history.parquet", HTTPOptions{BlockSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := srv.requests.Load()
+
+	// eight readers of the same bytes wait for one request
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := make([]byte, 100)
+			if _, err := src.ReadAt(p, 5000); err != nil || !bytes.Equal(p, data[5000:5100]) {
+				t.Errorf("unexpected read %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := srv.requests.Load() - opened; n != 1 {
+		t.Fatalf("expected concurrent reads of a block to share a request, got %d", n)
+	}
+
+	// block 1 is cached, blocks 2 to 5 are fetched with one request
+	p := make([]byte, 4*4096)
+	if _, err := src.ReadAt(p, 4096+100); err != nil || !bytes.Equal(p, data[4196:4196+len(p)]) {
+		t.Fatalf("unexpected read %v", err)
+	}
+	if n := srv.requests.Load() - opened; n != 2 {
+		t.Fatalf("expected neighbouring blocks to be fetched together, got %d requests", n)
+	}
+}
+
+func TestHTTPSourceLimitsConcurrency(t *testing.T) {
+	srv := newRangeServer(t)
+	srv.delay = 10 * time.Millisecond
+	src, err := OpenHTTPSource(srv.URL+"/history.parquet", HTTPOptions{BlockSize: 1024, MaxParallel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := src.ReadAt(make([]byte, 10), int64(i)*10240); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if peak := srv.peak.Load(); peak > 2 {
+		t.Fatalf("expected at most 2 requests at once, got %d", peak)
+	}
+}
+
+func TestHTTPSourceRetries(t *testing.T) {
+	srv := newRangeServer(t)
+	srv.failures.Store(2)
+	src, err := OpenHTTPSource(srv.URL+"/history.parquet", HTTPOptions{RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := src.Stats(); stats.Retries != 2 || stats.Requests != 3 {
+		t.Fatalf("expected two retries, got %+v", stats)
+	}
+
+	srv.failures.Store(5)
+	if _, err := OpenHTTPSource(srv.URL+"/history.parquet", HTTPOptions{Retries: 2, RetryDelay: time.Millisecond}); err == nil {
+		t.Fatalf("expected to give up after 3 attempts")
+	}
+	srv.failures.Store(0)
+	before := srv.requests.Load()
+	if _, err := OpenHTTPSource(srv.URL+"/missing.parquet", HTTPOptions{RetryDelay: time.Millisecond}); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+	if n := srv.requests.Load() - before; n != 1 {
+		t.Fatalf("expected client errors not to be retried, got %d requests", n)
+	}
+}
+
+func TestConvertFromHTTP(t *testing.T) {
+	srv := newRangeServer(t)
+	out := filepath.Join(t.TempDir(), "angola.csv")
+	rows, err := Convert(srv.URL+"/history.parquet?version=1", out, ConvertOptions{
+		Input: InputOptions{Columns: []string{"country", "capital"}},
+		Where: "country = 'Angola'",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows == 0 {
+		t.Fatalf("expected rows of Angola")
+	}
+}
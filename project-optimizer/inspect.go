@@ -0,0 +1,340 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/encoding/thrift"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// file inspection for debugging slow queries: how a parquet file is laid
+// out in row groups, column chunks and pages, and what the footer knows
+// about them
+
+type InspectOptions struct {
+	Pages bool // read the header of every page, this reads the whole file
+}
+
+// FileInfo is everything the footer of a parquet file says about it
+type FileInfo struct {
+	Path             string             `json:"path"`
+	Size             int64              `json:"size"`
+	Version          int32              `json:"version"`
+	CreatedBy        string             `json:"created_by,omitempty"`
+	NumRows          int64              `json:"num_rows"`
+	KeyValueMetadata []KeyValue         `json:"key_value_metadata,omitempty"`
+	Schema           *SchemaDescription `json:"schema"`
+	RowGroups        []RowGroupInfo     `json:"row_groups"`
+}
+
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type RowGroupInfo struct {
+	Index            int               `json:"index"`
+	NumRows          int64             `json:"num_rows"`
+	CompressedSize   int64             `json:"compressed_size"`
+	UncompressedSize int64             `json:"uncompressed_size"`
+	Columns          []ColumnChunkInfo `json:"columns"`
+}
+
+type ColumnChunkInfo struct {
+	Path                 string             `json:"path"`
+	Type                 string             `json:"type"`
+	Codec                string             `json:"codec"`
+	Encodings            []string           `json:"encodings"`
+	NumValues            int64              `json:"num_values"`
+	CompressedSize       int64              `json:"compressed_size"`
+	UncompressedSize     int64              `json:"uncompressed_size"`
+	DataPageOffset       int64              `json:"data_page_offset"`
+	DictionaryPageOffset int64              `json:"dictionary_page_offset,omitempty"` // 0 without a dictionary page
+	PageEncodings        []PageEncodingInfo `json:"page_encodings,omitempty"`
+	Statistics           *StatisticsInfo    `json:"statistics,omitempty"`
+	ColumnIndex          bool               `json:"column_index"`
+	OffsetIndex          bool               `json:"offset_index"`
+	BloomFilter          *BloomFilterInfo   `json:"bloom_filter,omitempty"`
+	Pages                []PageInfo         `json:"pages,omitempty"` // only with InspectOptions.Pages
+}
+
+// number of pages of a type written with an encoding
+type PageEncodingInfo struct {
+	PageType string `json:"page_type"`
+	Encoding string `json:"encoding"`
+	Count    int32  `json:"count"`
+}
+
+type StatisticsInfo struct {
+	Min           string `json:"min,omitempty"`
+	Max           string `json:"max,omitempty"`
+	NullCount     int64  `json:"null_count"`
+	DistinctCount int64  `json:"distinct_count,omitempty"`
+}
+
+type BloomFilterInfo struct {
+	Offset   int64 `json:"offset"`
+	NumBytes int32 `json:"num_bytes"`
+}
+
+type PageInfo struct {
+	Type             string `json:"type"`
+	Offset           int64  `json:"offset"`
+	CompressedSize   int32  `json:"compressed_size"`
+	UncompressedSize int32  `json:"uncompressed_size"`
+	NumValues        int32  `json:"num_values"`
+	NumNulls         int32  `json:"num_nulls,omitempty"` // v2 data pages only
+	NumRows          int32  `json:"num_rows,omitempty"`  // v2 data pages only
+	Encoding         string `json:"encoding"`
+}
+
+// InspectFile reads the footer of a parquet file, and its page headers when asked to
+func InspectFile(f io.ReaderAt, opts InspectOptions) (*FileInfo, error) {
+	desc, err := DescribeSchema(f)
+	if err != nil {
+		return nil, err
+	}
+	src, err := AsSource(f)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := parquet.OpenFile(src, src.Size(), parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return nil, err
+	}
+	md := pf.Metadata()
+	info := &FileInfo{
+		Path:      sourceName(f),
+		Size:      src.Size(),
+		Version:   md.Version,
+		CreatedBy: md.CreatedBy,
+		NumRows:   md.NumRows,
+		Schema:    desc,
+	}
+	for _, kv := range md.KeyValueMetadata {
+		info.KeyValueMetadata = append(info.KeyValueMetadata, KeyValue{Key: kv.Key, Value: kv.Value})
+	}
+	for i, rg := range md.RowGroups {
+		group := RowGroupInfo{Index: i, NumRows: rg.NumRows, UncompressedSize: rg.TotalByteSize}
+		for _, chunk := range rg.Columns {
+			col, err := inspectChunk(src, pf.Schema(), chunk, opts)
+			if err != nil {
+				return nil, fmt.Errorf("row group %d column %s: %w", i, strings.Join(chunk.MetaData.PathInSchema, "."), err)
+			}
+			group.CompressedSize += col.CompressedSize
+			group.Columns = append(group.Columns, col)
+		}
+		info.RowGroups = append(info.RowGroups, group)
+	}
+	return info, nil
+}
+
+func inspectChunk(f Source, schema *parquet.Schema, chunk format.ColumnChunk, opts InspectOptions) (ColumnChunkInfo, error) {
+	md := chunk.MetaData
+	col := ColumnChunkInfo{
+		Path:                 strings.Join(md.PathInSchema, "."),
+		Type:                 strings.ToLower(md.Type.String()),
+		Codec:                md.Codec.String(),
+		NumValues:            md.NumValues,
+		CompressedSize:       md.TotalCompressedSize,
+		UncompressedSize:     md.TotalUncompressedSize,
+		DataPageOffset:       md.DataPageOffset,
+		DictionaryPageOffset: md.DictionaryPageOffset,
+		ColumnIndex:          chunk.ColumnIndexOffset > 0,
+		OffsetIndex:          chunk.OffsetIndexOffset > 0,
+	}
+	for _, enc := range md.Encoding {
+		col.Encodings = append(col.Encodings, enc.String())
+	}
+	for _, s := range md.EncodingStats {
+		col.PageEncodings = append(col.PageEncodings, PageEncodingInfo{
+			PageType: s.PageType.String(),
+			Encoding: s.Encoding.String(),
+			Count:    s.Count,
+		})
+	}
+	if leaf, ok := schema.Lookup(md.PathInSchema...); ok {
+		col.Type = physicalTypeName(leaf.Node.Type())
+		col.Statistics = chunkStatistics(leaf.Node.Type(), md.Statistics)
+	}
+
+	protocol := &thrift.CompactProtocol{}
+	if md.BloomFilterOffset > 0 {
+		var header format.BloomFilterHeader
+		r := io.NewSectionReader(f, md.BloomFilterOffset, 1<<10)
+		if err := thrift.NewDecoder(protocol.NewReader(r)).Decode(&header); err != nil {
+			return col, fmt.Errorf("reading bloom filter header: %w", err)
+		}
+		col.BloomFilter = &BloomFilterInfo{Offset: md.BloomFilterOffset, NumBytes: header.NumBytes}
+	}
+
+	if opts.Pages {
+		start := md.DataPageOffset
+		if md.DictionaryPageOffset > 0 && md.DictionaryPageOffset < start {
+			start = md.DictionaryPageOffset
+		}
+		// the footer of a broken file can claim any size
+		if start < 0 || md.TotalCompressedSize < 0 || md.TotalCompressedSize > f.Size()-start {
+			return col, fmt.Errorf("column chunk of %d bytes at offset %d does not fit in a file of %d bytes", md.TotalCompressedSize, start, f.Size())
+		}
+		data := make([]byte, md.TotalCompressedSize)
+		if _, err := f.ReadAt(data, start); err != nil {
+			return col, fmt.Errorf("reading pages: %w", err)
+		}
+		r := bytes.NewReader(data)
+		for r.Len() > 0 {
+			offset := start + r.Size() - int64(r.Len())
+			var header format.PageHeader
+			if err := thrift.NewDecoder(protocol.NewReader(r)).Decode(&header); err != nil {
+				return col, fmt.Errorf("reading page header at offset %d: %w", offset, err)
+			}
+			if header.CompressedPageSize < 0 || int64(header.CompressedPageSize) > int64(r.Len()) {
+				return col, fmt.Errorf("page at offset %d of %d bytes overruns its column chunk", offset, header.CompressedPageSize)
+			}
+			col.Pages = append(col.Pages, pageInfo(offset, header))
+			if _, err := r.Seek(int64(header.CompressedPageSize), io.SeekCurrent); err != nil {
+				return col, err
+			}
+		}
+	}
+	return col, nil
+}
+
+func chunkStatistics(t parquet.Type, s format.Statistics) *StatisticsInfo {
+	out := &StatisticsInfo{NullCount: s.NullCount, DistinctCount: s.DistinctCount}
+	minRaw, maxRaw := s.MinValue, s.MaxValue
+	if minRaw == nil && maxRaw == nil && isSignedOrder(t) {
+		minRaw, maxRaw = s.Min, s.Max
+	}
+	field := structField{PqType: t}
+	if v, ok := statValue(field, minRaw); ok && minRaw != nil {
+		out.Min = textValue(v)
+	}
+	if v, ok := statValue(field, maxRaw); ok && maxRaw != nil {
+		out.Max = textValue(v)
+	}
+	return out
+}
+
+func pageInfo(offset int64, h format.PageHeader) PageInfo {
+	page := PageInfo{
+		Type:             h.Type.String(),
+		Offset:           offset,
+		CompressedSize:   h.CompressedPageSize,
+		UncompressedSize: h.UncompressedPageSize,
+	}
+	switch {
+	case h.DataPageHeader != nil:
+		page.NumValues = h.DataPageHeader.NumValues
+		page.Encoding = h.DataPageHeader.Encoding.String()
+	case h.DataPageHeaderV2 != nil:
+		page.NumValues = h.DataPageHeaderV2.NumValues
+		page.NumNulls = h.DataPageHeaderV2.NumNulls
+		page.NumRows = h.DataPageHeaderV2.NumRows
+		page.Encoding = h.DataPageHeaderV2.Encoding.String()
+	case h.DictionaryPageHeader != nil:
+		page.NumValues = h.DictionaryPageHeader.NumValues
+		page.Encoding = h.DictionaryPageHeader.Encoding.String()
+	}
+	return page
+}
+
+// JSON renders the inspection for tools
+func (fi *FileInfo) JSON() ([]byte, error) {
+	return json.MarshalIndent(fi, "", "  ")
+}
+
+// String renders the inspection for people, one table of column chunks per row group
+func (fi *FileInfo) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "file:       %s\n", fi.Path)
+	fmt.Fprintf(&sb, "size:       %s\n", byteSize(fi.Size))
+	fmt.Fprintf(&sb, "rows:       %d\n", fi.NumRows)
+	fmt.Fprintf(&sb, "row groups: %d\n", len(fi.RowGroups))
+	fmt.Fprintf(&sb, "version:    %d\n", fi.Version)
+	if fi.CreatedBy != "" {
+		fmt.Fprintf(&sb, "created by: %s\n", fi.CreatedBy)
+	}
+	if len(fi.KeyValueMetadata) > 0 {
+		sb.WriteString("key value metadata:\n")
+		for _, kv := range fi.KeyValueMetadata {
+			fmt.Fprintf(&sb, "  %s = %s\n", kv.Key, truncate(kv.Value, 60))
+		}
+	}
+	sb.WriteString("\n")
+	sb.WriteString(fi.Schema.String())
+
+	for _, rg := range fi.RowGroups {
+		fmt.Fprintf(&sb, "\nrow group %d: %d rows, %s compressed, %s uncompressed\n",
+			rg.Index, rg.NumRows, byteSize(rg.CompressedSize), byteSize(rg.UncompressedSize))
+		tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  column\ttype\tcodec\tencodings\tvalues\tcompressed\tuncompressed\tdictionary\tmin\tmax\tnulls\tpage index\tbloom filter")
+		for _, col := range rg.Columns {
+			dict := "no"
+			if col.DictionaryPageOffset > 0 {
+				dict = "yes"
+			}
+			var minText, maxText, nulls string
+			if s := col.Statistics; s != nil {
+				minText, maxText, nulls = truncate(s.Min, 24), truncate(s.Max, 24), fmt.Sprint(s.NullCount)
+			}
+			var index []string
+			if col.ColumnIndex {
+				index = append(index, "column")
+			}
+			if col.OffsetIndex {
+				index = append(index, "offset")
+			}
+			bloom := "-"
+			if col.BloomFilter != nil {
+				bloom = byteSize(int64(col.BloomFilter.NumBytes))
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				col.Path, col.Type, col.Codec, strings.Join(col.Encodings, ","), col.NumValues,
+				byteSize(col.CompressedSize), byteSize(col.UncompressedSize), dict,
+				minText, maxText, nulls, orDash(strings.Join(index, ",")), bloom)
+		}
+		tw.Flush()
+		for _, col := range rg.Columns {
+			if len(col.Pages) == 0 {
+				continue
+			}
+			fmt.Fprintf(&sb, "  pages of %s:\n", col.Path)
+			tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "    type\toffset\tvalues\tencoding\tcompressed\tuncompressed")
+			for _, page := range col.Pages {
+				fmt.Fprintf(tw, "    %s\t%d\t%d\t%s\t%s\t%s\n", page.Type, page.Offset, page.NumValues,
+					page.Encoding, byteSize(int64(page.CompressedSize)), byteSize(int64(page.UncompressedSize)))
+			}
+			tw.Flush()
+		}
+	}
+	return sb.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// human readable size, 1.5 KiB
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,130 @@
+package projectoptimizer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/encoding/thrift"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+func TestInspectFile(t *testing.T) {
+	type row struct {
+		ID   int64  `parquet:"id"`
+		City string `parquet:"city,dict"`
+	}
+	path := filepath.Join(t.TempDir(), "inspect.parquet")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := parquet.NewGenericWriter[row](f,
+		parquet.MaxRowsPerRowGroup(3),
+		parquet.KeyValueMetadata("written_by", "inspect test"),
+		parquet.BloomFilters(parquet.SplitBlockFilter(10, "city")),
+	)
+	if _, err := w.Write([]row{{1, "Berlin"}, {2, "Paris"}, {3, "Berlin"}, {4, "Lima"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := InspectFile(f, InspectOptions{Pages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NumRows != 4 || len(info.RowGroups) != 2 {
+		t.Fatalf("expected 4 rows in 2 row groups, got %d rows in %d", info.NumRows, len(info.RowGroups))
+	}
+	if len(info.KeyValueMetadata) != 1 || info.KeyValueMetadata[0].Value != "inspect test" {
+		t.Fatalf("expected key value metadata, got %v", info.KeyValueMetadata)
+	}
+	city := info.RowGroups[0].Columns[1]
+	if city.Path != "city" || city.Type != "binary" || city.Statistics == nil ||
+		city.Statistics.Min != "Berlin" || city.Statistics.Max != "Paris" {
+		t.Fatalf("unexpected city chunk %+v %+v", city, city.Statistics)
+	}
+	if city.DictionaryPageOffset == 0 || city.BloomFilter == nil || city.BloomFilter.NumBytes == 0 {
+		t.Fatalf("expected a dictionary page and a bloom filter, got %+v", city)
+	}
+	if !city.ColumnIndex || !city.OffsetIndex {
+		t.Fatalf("expected a page index, got %+v", city)
+	}
+	if len(city.Pages) != 2 || city.Pages[0].Type != "DICTIONARY_PAGE" || city.Pages[1].NumValues != 3 {
+		t.Fatalf("expected a dictionary and a data page, got %+v", city.Pages)
+	}
+	if info.RowGroups[0].Columns[0].BloomFilter != nil {
+		t.Fatalf("expected no bloom filter on id")
+	}
+
+	text := info.String()
+	for _, want := range []string{"row groups: 2", "written_by = inspect test", "row group 1: 1 rows", "DICTIONARY_PAGE"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in\n%s", want, text)
+		}
+	}
+	b, err := info.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded FileInfo
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.RowGroups[1].Columns[1].Statistics.Min != "Lima" {
+		t.Fatalf("unexpected json output %s", b)
+	}
+}
+
+func TestInspectRejectsOversizedChunks(t *testing.T) {
+	type row struct {
+		ID int64 `parquet:"id"`
+	}
+	src := BytesSource(make([]byte, 64))
+	for _, size := range []int64{-1, 1 << 40} {
+		chunk := format.ColumnChunk{MetaData: format.ColumnMetaData{
+			PathInSchema:        []string{"id"},
+			DataPageOffset:      4,
+			TotalCompressedSize: size,
+		}}
+		if _, err := inspectChunk(src, parquet.SchemaOf(row{}), chunk, InspectOptions{Pages: true}); err == nil {
+			t.Errorf("expected an error for a column chunk of %d bytes", size)
+		}
+	}
+}
+
+func TestInspectRejectsPagesThatLoop(t *testing.T) {
+	type row struct {
+		ID int64 `parquet:"id"`
+	}
+	// a page whose size seeks back to its own header
+	var header []byte
+	for n := 1; header == nil; n++ {
+		b, err := thrift.Marshal(new(thrift.CompactProtocol), &format.PageHeader{Type: format.DataPage, CompressedPageSize: int32(-n)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) == n {
+			header = b
+		}
+	}
+	data := append(make([]byte, 4), header...)
+	chunk := format.ColumnChunk{MetaData: format.ColumnMetaData{
+		PathInSchema:        []string{"id"},
+		DataPageOffset:      4,
+		TotalCompressedSize: int64(len(header)),
+	}}
+	if _, err := inspectChunk(BytesSource(data), parquet.SchemaOf(row{}), chunk, InspectOptions{Pages: true}); err == nil {
+		t.Fatal("expected an error for a page of negative size")
+	}
+	chunk.MetaData.TotalCompressedSize = int64(len(header)) - 1
+	if _, err := inspectChunk(BytesSource(append(data, 0)), parquet.SchemaOf(row{}), chunk, InspectOptions{Pages: true}); err == nil {
+		t.Fatal("expected an error for a header past its column chunk")
+	}
+}
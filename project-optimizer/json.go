@@ -0,0 +1,331 @@
+package projectoptimizer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// read a json array of objects or newline delimited json as a leaf operator.
+// keys missing from an object are read as NULL, nested objects and arrays are
+// kept as their json text in a string column
+
+type JSONOptions struct {
+	SampleSize int                     // objects used for type inference, defaults to 1000
+	Schema     *parquetSchema          // explicit schema, skips inference
+	Columns    []string                // projection, empty keeps every key
+	Types      map[string]parquet.Type // per key overrides of the inferred type
+	Where      Expr                    // rows kept, nil keeps every row
+}
+
+type JSONExec struct {
+	dec     *json.Decoder
+	array   bool // source is a single [...] instead of ndjson
+	sample  []jsonObject
+	schema  *parquetSchema
+	where   Expr
+	whereAt map[string]int // lower cased column name -> position in a row
+	Type    reflect.Type
+	row     int
+	doneErr error
+}
+
+func NewJSONExecLeaf(source io.Reader, opts JSONOptions) (*JSONExec, error) {
+	br := bufio.NewReader(source)
+	first, err := peekNonSpace(br)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading json: %w", err)
+	}
+	j := &JSONExec{dec: json.NewDecoder(br), where: opts.Where}
+	j.dec.UseNumber()
+	if first == '[' {
+		j.array = true
+		if _, err := j.dec.Token(); err != nil {
+			return nil, fmt.Errorf("reading json array: %w", err)
+		}
+	}
+
+	sampleSize := opts.SampleSize
+	if sampleSize <= 0 {
+		sampleSize = defaultSampleSize
+	}
+	for len(j.sample) < sampleSize {
+		obj, err := j.decodeObject()
+		if err == io.EOF {
+			j.doneErr = io.EOF
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		j.sample = append(j.sample, obj)
+	}
+
+	full := opts.Schema
+	if full == nil {
+		full = inferJSONSchema(j.sample)
+	}
+	j.schema = full.Clone()
+	if err := applyTypeOverrides(j.schema, opts.Types); err != nil {
+		return nil, err
+	}
+	if len(opts.Columns) > 0 {
+		for _, name := range opts.Columns {
+			if _, err := full.ColumnInfo(name); err != nil {
+				return nil, fmt.Errorf("%w: key %s in json schema", ErrColumnNotFound, name)
+			}
+		}
+		j.schema.KeepFields(opts.Columns...)
+	}
+	j.whereAt = schemaIndex(j.schema)
+	if err := checkWhere(j.where, j.whereAt); err != nil {
+		return nil, err
+	}
+	_, j.Type = genStructWithFields(j.schema.Fields...)
+	return j, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+// object with its keys in document order
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (j *JSONExec) decodeObject() (jsonObject, error) {
+	if j.array && !j.dec.More() {
+		if _, err := j.dec.Token(); err != nil {
+			return jsonObject{}, fmt.Errorf("reading end of json array: %w", err)
+		}
+		return jsonObject{}, io.EOF
+	}
+	pos := j.row + len(j.sample) + 1
+	tok, err := j.dec.Token()
+	if err == io.EOF {
+		return jsonObject{}, io.EOF
+	}
+	if err != nil {
+		return jsonObject{}, fmt.Errorf("decoding json object %d: %w", pos, err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return jsonObject{}, fmt.Errorf("decoding json object %d: expected an object, got %v", pos, tok)
+	}
+	obj := jsonObject{values: make(map[string]any)}
+	for j.dec.More() {
+		tok, err := j.dec.Token()
+		if err != nil {
+			return jsonObject{}, fmt.Errorf("decoding json object %d: %w", pos, err)
+		}
+		key := tok.(string)
+		var v any
+		if err := j.dec.Decode(&v); err != nil {
+			return jsonObject{}, fmt.Errorf("decoding json object %d key %q: %w", pos, key, err)
+		}
+		if _, dup := obj.values[key]; !dup {
+			obj.keys = append(obj.keys, key)
+		}
+		obj.values[key] = v
+	}
+	if _, err := j.dec.Token(); err != nil {
+		return jsonObject{}, fmt.Errorf("decoding json object %d: %w", pos, err)
+	}
+	return obj, nil
+}
+
+// keys are ordered by first appearance, types are the narrowest that fit every sampled value
+func inferJSONSchema(sample []jsonObject) *parquetSchema {
+	type candidate struct {
+		isBool, isInt, isDouble, isString, seen bool
+	}
+	var order []string
+	candidates := make(map[string]*candidate)
+	for _, obj := range sample {
+		for _, k := range obj.keys {
+			c, ok := candidates[k]
+			if !ok {
+				c = &candidate{isBool: true, isInt: true, isDouble: true, isString: true}
+				candidates[k] = c
+				order = append(order, k)
+			}
+			switch v := obj.values[k].(type) {
+			case nil:
+				continue
+			case bool:
+				c.isInt, c.isDouble, c.isString = false, false, false
+			case json.Number:
+				c.isBool, c.isString = false, false
+				if _, err := v.Int64(); err != nil {
+					c.isInt = false
+				}
+				if _, err := v.Float64(); err != nil {
+					c.isDouble = false
+				}
+			case string:
+				c.isBool, c.isInt, c.isDouble = false, false, false
+			default:
+				c.isBool, c.isInt, c.isDouble, c.isString = false, false, false, false
+			}
+			c.seen = true
+		}
+	}
+	schema := &parquetSchema{}
+	for _, k := range order {
+		c := candidates[k]
+		var t parquet.Type
+		switch {
+		case !c.seen:
+			t = parquet.String().Type()
+		case c.isBool:
+			t = parquet.BooleanType
+		case c.isInt:
+			t = parquet.Int64Type
+		case c.isDouble:
+			t = parquet.DoubleType
+		default:
+			t = parquet.String().Type()
+		}
+		schema.Fields = append(schema.Fields, structField{Name: k, PqType: t, Optional: true})
+	}
+	return schema
+}
+
+func (j *JSONExec) Next(n uint) (RecordBatch, error) {
+	batch := RecordBatch{
+		Schema:  *j.schema,
+		Columns: make([][]any, len(j.schema.Fields)),
+	}
+	var curSize uint
+	for curSize < n {
+		obj, err := j.nextObject()
+		if err != nil {
+			return batch, err
+		}
+		row := make([]any, len(j.schema.Fields))
+		for i, field := range j.schema.Fields {
+			raw, ok := lookupFold(obj.values, field.Name)
+			if !ok {
+				continue
+			}
+			v, err := convertJSONValue(raw, field.PqType)
+			if err != nil {
+				return batch, fmt.Errorf("json object %d key %q: %w", j.row, field.Name, err)
+			}
+			row[i] = v
+		}
+		if !matchesWhere(j.where, j.whereAt, row) {
+			continue
+		}
+		for i, v := range row {
+			batch.Columns[i] = append(batch.Columns[i], v)
+		}
+		curSize++
+	}
+	return batch, nil
+}
+
+func (j *JSONExec) nextObject() (jsonObject, error) {
+	if len(j.sample) > 0 {
+		obj := j.sample[0]
+		j.sample = j.sample[1:]
+		j.row++
+		return obj, nil
+	}
+	if j.doneErr != nil {
+		return jsonObject{}, j.doneErr
+	}
+	obj, err := j.decodeObject()
+	if err != nil {
+		j.doneErr = err
+		return jsonObject{}, err
+	}
+	j.row++
+	return obj, nil
+}
+
+func (j *JSONExec) Schema() *parquetSchema {
+	return j.schema
+}
+
+func lookupFold(obj map[string]any, key string) (any, bool) {
+	if v, ok := obj[key]; ok {
+		return v, true
+	}
+	for k, v := range obj {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// maps a decoded json value onto the go type of a schema column
+func convertJSONValue(raw any, t parquet.Type) (any, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case json.Number:
+		switch t.Kind() {
+		case parquet.Int32:
+			if isDateType(t) {
+				// days since the epoch, as dates are stored
+				days, err := v.Int64()
+				if err == nil {
+					days, err = castInt(days, 0, 32)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("cannot read json number %s as a date: %w", v, err)
+				}
+				return time.Unix(days*secondsPerDay, 0).UTC(), nil
+			}
+			i, err := v.Int64()
+			if err != nil {
+				return nil, err
+			}
+			i, err = castInt(i, 0, 32)
+			return int32(i), err
+		case parquet.Int64:
+			return v.Int64()
+		case parquet.Float:
+			f, err := v.Float64()
+			return float32(f), err
+		case parquet.Double:
+			return v.Float64()
+		case parquet.ByteArray:
+			return v.String(), nil
+		}
+	case bool:
+		if t.Kind() == parquet.Boolean {
+			return v, nil
+		}
+	case string:
+		if isDateType(t) {
+			return parseDate(v)
+		}
+		if t.Kind() == parquet.ByteArray {
+			return v, nil
+		}
+		return parseCell(v, t)
+	}
+	if t.Kind() == parquet.ByteArray {
+		b, err := json.Marshal(raw)
+		return string(b), err
+	}
+	return nil, fmt.Errorf("cannot read json value %v as %v", raw, t)
+}
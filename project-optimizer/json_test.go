@@ -0,0 +1,97 @@
+package projectoptimizer
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestJSONArraySource(t *testing.T) {
+	f, err := os.Open("../data/countries-table.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	leaf, err := NewJSONExecLeaf(f, JSONOptions{Columns: []string{"country", "place", "density"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []parquet.Kind{parquet.ByteArray, parquet.Int64, parquet.Double}
+	for i, field := range leaf.Schema().Fields {
+		if field.PqType.Kind() != kinds[i] {
+			t.Errorf("column %q: expected %v, got %v", field.Name, kinds[i], field.PqType.Kind())
+		}
+	}
+	batch, err := leaf.Next(1000)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if batch.NumRows() != 234 {
+		t.Errorf("expected 234 countries, got %d", batch.NumRows())
+	}
+	if batch.Columns[0][0] != "India" || batch.Columns[1][0] != int64(356) {
+		t.Errorf("unexpected first row: %v %v", batch.Columns[0][0], batch.Columns[1][0])
+	}
+}
+
+func TestNDJSONMissingKeys(t *testing.T) {
+	data := `{"id": 1, "name": "a", "tags": ["x"]}
+{"id": 2, "score": 1.5}
+{"id": 3, "name": null, "score": 2}
+`
+	leaf, err := NewJSONExecLeaf(strings.NewReader(data), JSONOptions{
+		Where: mustParseExpr(t, "id != 2"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := leaf.Schema().toColumns()
+	if !reflect.DeepEqual(names, []string{"id", "name", "tags", "score"}) {
+		t.Fatalf("unexpected key order %v", names)
+	}
+	batch, err := leaf.Next(10)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	want := [][]any{{int64(1), int64(3)}, {"a", nil}, {`["x"]`, nil}, {nil, 2.0}}
+	if !reflect.DeepEqual(batch.Columns, want) {
+		t.Errorf("expected %v, got %v", want, batch.Columns)
+	}
+}
+
+func TestJSONRejectsInt32Overflow(t *testing.T) {
+	leaf, err := NewJSONExecLeaf(strings.NewReader(`{"n": 7}
+{"n": 3000000000}
+`), JSONOptions{Types: map[string]parquet.Type{"n": parquet.Int32Type}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Next(10); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("expected an out of range error, got %v", err)
+	}
+}
+
+func TestJSONNumbersAsDates(t *testing.T) {
+	leaf, err := NewJSONExecLeaf(strings.NewReader(`{"day": 19700}
+{"day": "2023-12-09"}
+`), JSONOptions{Types: map[string]parquet.Type{"day": parquet.Date().Type()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := leaf.Next(10)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	want := time.Date(2023, 12, 9, 0, 0, 0, 0, time.UTC)
+	for i, v := range batch.Columns[0] {
+		if got, ok := v.(time.Time); !ok || !got.Equal(want) {
+			t.Fatalf("row %d: expected %v, got %#v", i, want, v)
+		}
+	}
+}
@@ -1,3 +1,48 @@
 package projectoptimizer
 
+import "io"
+
 // pretty simple, just implement limit
+
+// LimitExec hands on the first rows of its child and stops reading it
+type LimitExec struct {
+	childInput Operator
+	limit      int64
+	seen       int64
+}
+
+func NewLimitExec(input Operator, limit int64) *LimitExec {
+	return &LimitExec{childInput: input, limit: limit}
+}
+
+func (l *LimitExec) Next(n uint) (RecordBatch, error) {
+	left := l.limit - l.seen
+	if left <= 0 {
+		schema := l.Schema()
+		return RecordBatch{Schema: *schema, Columns: make([][]any, len(schema.Fields))}, io.EOF
+	}
+	if int64(n) > left {
+		n = uint(left)
+	}
+	batch, err := l.childInput.Next(n)
+	if rows := int64(batch.NumRows()); rows > left {
+		// children may return more rows than asked for
+		for c := range batch.Columns {
+			batch.Columns[c] = batch.Columns[c][:left]
+		}
+		for _, dc := range batch.Dicts {
+			if dc != nil && int64(len(dc.Codes)) > left {
+				dc.Codes = dc.Codes[:left]
+			}
+		}
+	}
+	l.seen += int64(batch.NumRows())
+	if err == nil && l.seen >= l.limit {
+		err = io.EOF
+	}
+	return batch, err
+}
+
+func (l *LimitExec) Schema() *parquetSchema {
+	return l.childInput.Schema()
+}
@@ -0,0 +1,129 @@
+package projectoptimizer
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// line input of the shell. terminals get an editor with cursor movement
+// and history (lineedit_unix.go), other inputs are read line by line
+
+// ErrInterrupt is returned by ReadLine when the line is abandoned with ctrl-c
+var ErrInterrupt = errors.New("interrupt")
+
+const maxHistory = 1000
+
+// LineReader reads the lines of the shell
+type LineReader interface {
+	ReadLine(prompt string) (string, error) // io.EOF at the end of input
+	AddHistory(line string)
+	Close() error
+}
+
+// NewLineReader edits lines on a terminal, history is loaded from and
+// appended to historyPath unless it is empty. other inputs are read
+// without prompts
+func NewLineReader(in *os.File, out io.Writer, historyPath string) (LineReader, error) {
+	if !isTerminal(in) {
+		return NewPlainLineReader(in, nil), nil
+	}
+	h, err := openHistory(historyPath)
+	if err != nil {
+		return nil, err
+	}
+	return newTermReader(in, out, h), nil
+}
+
+// IsTerminal tells whether f is a terminal
+func IsTerminal(f *os.File) bool {
+	return isTerminal(f)
+}
+
+// NewPlainLineReader reads lines from r, writing prompts to out unless it is nil
+func NewPlainLineReader(r io.Reader, out io.Writer) LineReader {
+	return &plainReader{r: bufio.NewReader(r), out: out}
+}
+
+type plainReader struct {
+	r   *bufio.Reader
+	out io.Writer
+}
+
+func (p *plainReader) ReadLine(prompt string) (string, error) {
+	if p.out != nil {
+		io.WriteString(p.out, prompt)
+	}
+	line, err := p.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (p *plainReader) AddHistory(string) {}
+func (p *plainReader) Close() error      { return nil }
+
+// DefaultHistoryPath is ~/.parqlite_history, empty without a home directory
+func DefaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".parqlite_history")
+}
+
+// previous lines, oldest first, appended to a file as they are added
+type history struct {
+	lines []string
+	f     *os.File
+}
+
+func openHistory(path string) (*history, error) {
+	h := &history{}
+	if path == "" {
+		return h, nil
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				h.lines = append(h.lines, line)
+			}
+		}
+		if len(h.lines) > maxHistory {
+			h.lines = h.lines[len(h.lines)-maxHistory:]
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	h.f = f
+	return h, nil
+}
+
+func (h *history) add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[1:]
+	}
+	if h.f != nil {
+		h.f.WriteString(line + "\n")
+	}
+}
+
+func (h *history) close() error {
+	if h.f == nil {
+		return nil
+	}
+	return h.f.Close()
+}
@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package projectoptimizer
+
+import (
+	"io"
+	"os"
+)
+
+// without termios every input is read line by line
+func isTerminal(*os.File) bool {
+	return false
+}
+
+func newTermReader(in *os.File, _ io.Writer, h *history) LineReader {
+	h.close()
+	return NewPlainLineReader(in, nil)
+}
@@ -0,0 +1,244 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package projectoptimizer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func getTermios(fd uintptr) (syscall.Termios, error) {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(ioctlGetTermios), uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return t, errno
+	}
+	return t, nil
+}
+
+func setTermios(fd uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(ioctlSetTermios), uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	_, err := getTermios(f.Fd())
+	return err == nil
+}
+
+// termReader edits a line in raw mode:
+//
+//	left, right, ctrl-b, ctrl-f   move the cursor
+//	home, end, ctrl-a, ctrl-e     to the start or end of the line
+//	up, down, ctrl-p, ctrl-n      browse the history
+//	backspace, delete, ctrl-d     delete a character, ctrl-d on an empty line ends the input
+//	ctrl-k, ctrl-u, ctrl-w        delete to the end, to the start, the word before the cursor
+//	ctrl-l                        clear the screen
+//	ctrl-c                        abandon the line
+type termReader struct {
+	in      *os.File
+	r       *bufio.Reader
+	out     io.Writer
+	history *history
+}
+
+func newTermReader(in *os.File, out io.Writer, h *history) LineReader {
+	return &termReader{in: in, r: bufio.NewReader(in), out: out, history: h}
+}
+
+func (t *termReader) AddHistory(line string) { t.history.add(line) }
+func (t *termReader) Close() error           { return t.history.close() }
+
+func (t *termReader) ReadLine(prompt string) (string, error) {
+	fd := t.in.Fd()
+	saved, err := getTermios(fd)
+	if err != nil {
+		return "", err
+	}
+	raw := saved
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.INLCR | syscall.IGNCR
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+	if err := setTermios(fd, &raw); err != nil {
+		return "", err
+	}
+	defer setTermios(fd, &saved)
+
+	e := lineEditor{prompt: prompt, history: t.history.lines, hist: len(t.history.lines)}
+	e.refresh(t.out)
+	for {
+		r, _, err := t.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			io.WriteString(t.out, "\r\n")
+			return string(e.buf), nil
+		case 3: // ctrl-c
+			io.WriteString(t.out, "^C\r\n")
+			return "", ErrInterrupt
+		case 4: // ctrl-d
+			if len(e.buf) == 0 {
+				io.WriteString(t.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete()
+		case 127, 8: // backspace, ctrl-h
+			if e.pos > 0 {
+				e.pos--
+				e.delete()
+			}
+		case 1:
+			e.pos = 0
+		case 5:
+			e.pos = len(e.buf)
+		case 2:
+			e.left()
+		case 6:
+			e.right()
+		case 11:
+			e.buf = e.buf[:e.pos]
+		case 21:
+			e.buf, e.pos = e.buf[e.pos:], 0
+		case 23:
+			e.deleteWord()
+		case 12:
+			io.WriteString(t.out, "\x1b[H\x1b[2J")
+		case 16:
+			e.browse(-1)
+		case 14:
+			e.browse(1)
+		case 27:
+			t.escape(&e)
+		default:
+			if r >= ' ' {
+				e.insert(r)
+			}
+		}
+		e.refresh(t.out)
+	}
+}
+
+// handles the escape sequences of cursor and editing keys
+func (t *termReader) escape(e *lineEditor) {
+	b, err := t.r.ReadByte()
+	if err != nil || b != '[' && b != 'O' {
+		return
+	}
+	code, err := t.r.ReadByte()
+	if err != nil {
+		return
+	}
+	if code >= '0' && code <= '9' {
+		// vt sequences end with ~
+		if end, err := t.r.ReadByte(); err != nil || end != '~' {
+			return
+		}
+		switch code {
+		case '1', '7':
+			code = 'H'
+		case '4', '8':
+			code = 'F'
+		case '3':
+			e.delete()
+			return
+		}
+	}
+	switch code {
+	case 'A':
+		e.browse(-1)
+	case 'B':
+		e.browse(1)
+	case 'C':
+		e.right()
+	case 'D':
+		e.left()
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.buf)
+	}
+}
+
+// the line being edited, kept apart from the terminal
+type lineEditor struct {
+	prompt  string
+	buf     []rune
+	pos     int
+	history []string
+	hist    int    // history entry shown, len(history) for the new line
+	pending []rune // the new line while browsing the history
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = r
+	e.pos++
+}
+
+// deletes the rune under the cursor
+func (e *lineEditor) delete() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+}
+
+func (e *lineEditor) deleteWord() {
+	start := e.pos
+	for start > 0 && e.buf[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && e.buf[start-1] != ' ' {
+		start--
+	}
+	e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+	e.pos = start
+}
+
+func (e *lineEditor) left() {
+	if e.pos > 0 {
+		e.pos--
+	}
+}
+
+func (e *lineEditor) right() {
+	if e.pos < len(e.buf) {
+		e.pos++
+	}
+}
+
+// moves by step through the history
+func (e *lineEditor) browse(step int) {
+	next := e.hist + step
+	if next < 0 || next > len(e.history) {
+		return
+	}
+	if e.hist == len(e.history) {
+		e.pending = e.buf
+	}
+	e.hist = next
+	if next == len(e.history) {
+		e.buf = e.pending
+	} else {
+		e.buf = []rune(e.history[next])
+	}
+	e.pos = len(e.buf)
+}
+
+// redraws the line and puts the cursor back
+func (e *lineEditor) refresh(out io.Writer) {
+	s := "\r" + e.prompt + string(e.buf) + "\x1b[K"
+	if back := len(e.buf) - e.pos; back > 0 {
+		s += fmt.Sprintf("\x1b[%dD", back)
+	}
+	io.WriteString(out, s)
+}
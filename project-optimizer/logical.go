@@ -0,0 +1,629 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// parquet logical types and the go values used for them in a RecordBatch:
+//
+//	DATE, TIMESTAMP, INT96  time.Time (UTC)
+//	TIME                    time.Duration since midnight
+//	DECIMAL                 Decimal
+//	UUID                    uuid.UUID
+//	ENUM, JSON, STRING      string
+//	unsigned INT            uint64
+
+// Decimal is a fixed point number: Unscaled * 10^-Scale
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int32
+}
+
+func NewDecimal(unscaled int64, scale int32) Decimal {
+	return Decimal{Unscaled: big.NewInt(unscaled), Scale: scale}
+}
+
+// ParseDecimal parses "-12.340", the scale is the number of fraction digits
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	digits := s
+	var scale int32
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits = s[:i] + s[i+1:]
+		scale = int32(len(s) - i - 1)
+	}
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+	if !ok || strings.ContainsAny(digits, "eE") {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{Unscaled: unscaled, Scale: scale}, nil
+}
+
+func (d Decimal) unscaled() *big.Int {
+	if d.Unscaled == nil {
+		return new(big.Int)
+	}
+	return d.Unscaled
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.unscaled()).String()
+	sign := ""
+	if d.unscaled().Sign() < 0 {
+		sign = "-"
+	}
+	if d.Scale <= 0 {
+		return sign + digits + strings.Repeat("0", int(-d.Scale))
+	}
+	if len(digits) <= int(d.Scale) {
+		digits = strings.Repeat("0", int(d.Scale)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(d.Scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func (d Decimal) Float64() float64 {
+	r := new(big.Rat).SetInt(d.unscaled())
+	if d.Scale > 0 {
+		r.Quo(r, new(big.Rat).SetInt(pow10(d.Scale)))
+	} else if d.Scale < 0 {
+		r.Mul(r, new(big.Rat).SetInt(pow10(-d.Scale)))
+	}
+	f, _ := r.Float64()
+	return f
+}
+
+// Rescale changes the number of fraction digits, exact is false when digits were rounded away
+func (d Decimal) Rescale(scale int32) (out Decimal, exact bool) {
+	if scale >= d.Scale {
+		return Decimal{Unscaled: new(big.Int).Mul(d.unscaled(), pow10(scale-d.Scale)), Scale: scale}, true
+	}
+	div := pow10(d.Scale - scale)
+	q, r := new(big.Int).QuoRem(d.unscaled(), div, new(big.Int))
+	// round half away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(div) >= 0 {
+		if d.unscaled().Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return Decimal{Unscaled: q, Scale: scale}, r.Sign() == 0
+}
+
+// Cmp compares the values exactly, whatever their scales
+func (d Decimal) Cmp(o Decimal) int {
+	scale := max(d.Scale, o.Scale)
+	a, _ := d.Rescale(scale)
+	b, _ := o.Rescale(scale)
+	return a.Unscaled.Cmp(b.Unscaled)
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// value of an exact number as a decimal, ok is false for anything else
+func toDecimal(v any) (Decimal, bool) {
+	switch x := v.(type) {
+	case Decimal:
+		return x, true
+	case int32:
+		return NewDecimal(int64(x), 0), true
+	case int64:
+		return NewDecimal(x, 0), true
+	case uint64:
+		return Decimal{Unscaled: new(big.Int).SetUint64(x)}, true
+	case int:
+		return NewDecimal(int64(x), 0), true
+	}
+	return Decimal{}, false
+}
+
+// converts a parquet value into the value stored in a RecordBatch
+func columnValue(field structField, v parquet.Value) any {
+	if v.IsNull() {
+		return nil
+	}
+	if lt := field.PqType.LogicalType(); lt != nil {
+		switch {
+		case lt.Date != nil:
+			return time.Unix(int64(v.Int32())*secondsPerDay, 0).UTC()
+		case lt.Timestamp != nil:
+			return time.Unix(0, v.Int64()*unitNanos(lt.Timestamp.Unit)).UTC()
+		case lt.Time != nil:
+			if v.Kind() == parquet.Int32 {
+				return time.Duration(v.Int32()) * time.Millisecond
+			}
+			return time.Duration(v.Int64() * unitNanos(lt.Time.Unit))
+		case lt.Decimal != nil:
+			return decimalValue(v, lt.Decimal.Scale)
+		case lt.UUID != nil:
+			id, err := uuid.FromBytes(v.ByteArray())
+			if err != nil {
+				return string(v.ByteArray())
+			}
+			return id
+		case lt.Integer != nil && !lt.Integer.IsSigned:
+			if v.Kind() == parquet.Int32 {
+				return uint64(v.Uint32())
+			}
+			return v.Uint64()
+		}
+	}
+	switch v.Kind() {
+	case parquet.Boolean:
+		return v.Boolean()
+	case parquet.Int32:
+		return v.Int32()
+	case parquet.Int64:
+		return v.Int64()
+	case parquet.Int96:
+		return int96Time(v.Int96())
+	case parquet.Float:
+		return v.Float()
+	case parquet.Double:
+		return v.Double()
+	default:
+		return string(v.ByteArray())
+	}
+}
+
+func unitNanos(unit format.TimeUnit) int64 {
+	switch {
+	case unit.Millis != nil:
+		return int64(time.Millisecond)
+	case unit.Micros != nil:
+		return int64(time.Microsecond)
+	}
+	return 1
+}
+
+func decimalValue(v parquet.Value, scale int32) Decimal {
+	switch v.Kind() {
+	case parquet.Int32:
+		return NewDecimal(int64(v.Int32()), scale)
+	case parquet.Int64:
+		return NewDecimal(v.Int64(), scale)
+	}
+	// big endian two's complement
+	b := v.ByteArray()
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b))*8))
+	}
+	return Decimal{Unscaled: n, Scale: scale}
+}
+
+// legacy impala timestamps: nanoseconds of the day followed by the julian day
+const julianUnixEpoch = 2440588
+
+func int96Time(i deprecated.Int96) time.Time {
+	nanos := int64(i[0]) | int64(i[1])<<32
+	days := int64(i[2]) - julianUnixEpoch
+	return time.Unix(days*secondsPerDay, nanos).UTC()
+}
+
+func timeInt96(t time.Time) deprecated.Int96 {
+	t = t.UTC()
+	days := t.Unix() / secondsPerDay
+	if t.Unix()%secondsPerDay < 0 {
+		days--
+	}
+	nanos := t.Sub(time.Unix(days*secondsPerDay, 0)).Nanoseconds()
+	return deprecated.Int96{uint32(nanos), uint32(nanos >> 32), uint32(days + julianUnixEpoch)}
+}
+
+// converts a value to the go type used for a logical type, ok is false
+// when t has no logical type handled here
+func castLogical(v any, t parquet.Type) (out any, ok bool, err error) {
+	lt := t.LogicalType()
+	switch {
+	case t.Kind() == parquet.Int96 || lt != nil && lt.Timestamp != nil:
+		out, err = toTime(v)
+	case lt != nil && lt.Time != nil:
+		out, err = toTimeOfDay(v)
+	case lt != nil && lt.Decimal != nil:
+		out, err = castDecimal(v, lt.Decimal.Scale, lt.Decimal.Precision)
+	case lt != nil && lt.UUID != nil:
+		out, err = toUUID(v)
+	case lt != nil && lt.Integer != nil && !lt.Integer.IsSigned:
+		out, err = castUint(v, int(lt.Integer.BitWidth))
+	default:
+		return nil, false, nil
+	}
+	return out, true, err
+}
+
+// like castInt for unsigned columns of the given bit width
+func castUint(v any, bits int) (uint64, error) {
+	var u uint64
+	switch x := v.(type) {
+	case uint64:
+		u = x
+	case int64:
+		if x < 0 {
+			return 0, fmt.Errorf("cannot cast %d to uint%d: out of range", x, bits)
+		}
+		u = uint64(x)
+	case string:
+		n, err := strconv.ParseUint(strings.TrimSpace(x), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot cast %q to uint%d", x, bits)
+		}
+		u = n
+	default:
+		f, ok := toFloat(v)
+		if !ok {
+			return 0, fmt.Errorf("cannot cast %T to uint%d", v, bits)
+		}
+		if f != math.Trunc(f) {
+			return 0, fmt.Errorf("cannot cast %v to uint%d: not an integer", v, bits)
+		}
+		if f < 0 || f >= math.Ldexp(1, 64) {
+			return 0, fmt.Errorf("cannot cast %v to uint%d: out of range", v, bits)
+		}
+		u = uint64(f)
+	}
+	if bits < 64 && u >= 1<<bits {
+		return 0, fmt.Errorf("cannot cast %d to uint%d: out of range", u, bits)
+	}
+	return u, nil
+}
+
+// layouts accepted for timestamps, after RFC3339
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.DateOnly,
+}
+
+func toTime(v any) (time.Time, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return x.UTC(), nil
+	case string:
+		s := strings.TrimSpace(x)
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t.UTC(), nil
+		}
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", x)
+	}
+	return time.Time{}, fmt.Errorf("cannot cast %T to timestamp", v)
+}
+
+func toTimeOfDay(v any) (time.Duration, error) {
+	switch x := v.(type) {
+	case time.Duration:
+		return x, nil
+	case time.Time:
+		return x.Sub(x.Truncate(24 * time.Hour)), nil
+	case string:
+		for _, layout := range []string{"15:04:05.999999999", "15:04"} {
+			if t, err := time.Parse(layout, strings.TrimSpace(x)); err == nil {
+				return t.Sub(t.Truncate(24 * time.Hour)), nil
+			}
+		}
+		return 0, fmt.Errorf("invalid time %q", x)
+	}
+	return 0, fmt.Errorf("cannot cast %T to time", v)
+}
+
+// rounds v to scale fraction digits, more than precision digits in all are
+// an error rather than a value the column can not store
+func castDecimal(v any, scale, precision int32) (Decimal, error) {
+	d, ok := toDecimal(v)
+	if !ok {
+		var err error
+		switch x := v.(type) {
+		case string:
+			d, err = ParseDecimal(x)
+		case float32, float64:
+			f, _ := toFloat(x)
+			d, err = ParseDecimal(strconv.FormatFloat(f, 'f', int(scale), 64))
+		default:
+			err = fmt.Errorf("cannot cast %T to decimal", v)
+		}
+		if err != nil {
+			return Decimal{}, err
+		}
+	}
+	out, _ := d.Rescale(scale)
+	if digits := len(new(big.Int).Abs(out.unscaled()).String()); precision > 0 && digits > int(precision) {
+		return Decimal{}, fmt.Errorf("%v does not fit decimal(%d,%d)", v, precision, scale)
+	}
+	return out, nil
+}
+
+func toUUID(v any) (uuid.UUID, error) {
+	switch x := v.(type) {
+	case uuid.UUID:
+		return x, nil
+	case string:
+		return uuid.Parse(x)
+	case []byte:
+		return uuid.FromBytes(x)
+	}
+	return uuid.UUID{}, fmt.Errorf("cannot cast %T to uuid", v)
+}
+
+// encodes a RecordBatch value as a parquet value of type t
+func encodeValue(v any, t parquet.Type) (parquet.Value, error) {
+	v, err := castValue(v, t)
+	if err != nil {
+		return parquet.Value{}, err
+	}
+	lt := t.LogicalType()
+	switch t.Kind() {
+	case parquet.Boolean:
+		return parquet.BooleanValue(v.(bool)), nil
+	case parquet.Int32:
+		switch x := v.(type) {
+		case time.Time:
+			days := x.Unix() / secondsPerDay
+			if x.Unix()%secondsPerDay < 0 {
+				days--
+			}
+			return parquet.Int32Value(int32(days)), nil
+		case time.Duration:
+			return parquet.Int32Value(int32(x / time.Millisecond)), nil
+		case Decimal:
+			n := x.unscaled()
+			if !n.IsInt64() || n.Int64() < math.MinInt32 || n.Int64() > math.MaxInt32 {
+				return parquet.Value{}, fmt.Errorf("decimal %s does not fit int32", x)
+			}
+			return parquet.Int32Value(int32(n.Int64())), nil
+		case uint64:
+			if x > math.MaxUint32 {
+				return parquet.Value{}, fmt.Errorf("%d does not fit uint32", x)
+			}
+			return parquet.Int32Value(int32(uint32(x))), nil
+		}
+		return parquet.Int32Value(v.(int32)), nil
+	case parquet.Int64:
+		switch x := v.(type) {
+		case time.Time:
+			return parquet.Int64Value(timeUnits(x, lt.Timestamp.Unit)), nil
+		case time.Duration:
+			return parquet.Int64Value(int64(x) / unitNanos(lt.Time.Unit)), nil
+		case Decimal:
+			if !x.unscaled().IsInt64() {
+				return parquet.Value{}, fmt.Errorf("decimal %s does not fit int64", x)
+			}
+			return parquet.Int64Value(x.unscaled().Int64()), nil
+		case uint64:
+			return parquet.Int64Value(int64(x)), nil
+		}
+		return parquet.Int64Value(v.(int64)), nil
+	case parquet.Int96:
+		return parquet.Int96Value(timeInt96(v.(time.Time))), nil
+	case parquet.Float:
+		return parquet.FloatValue(v.(float32)), nil
+	case parquet.Double:
+		return parquet.DoubleValue(v.(float64)), nil
+	case parquet.FixedLenByteArray:
+		var b []byte
+		switch x := v.(type) {
+		case uuid.UUID:
+			b = x[:]
+		case Decimal:
+			b = decimalBytes(x, t.Length())
+			if len(b) != t.Length() {
+				return parquet.Value{}, fmt.Errorf("decimal %s does not fit %d bytes", x, t.Length())
+			}
+		default:
+			b = []byte(formatValue(v))
+		}
+		if len(b) != t.Length() {
+			return parquet.Value{}, fmt.Errorf("%d bytes do not fit a fixed length of %d", len(b), t.Length())
+		}
+		return parquet.FixedLenByteArrayValue(b), nil
+	}
+	if d, ok := v.(Decimal); ok {
+		return parquet.ByteArrayValue(decimalBytes(d, 0)), nil
+	}
+	return parquet.ByteArrayValue([]byte(v.(string))), nil
+}
+
+func timeUnits(t time.Time, unit format.TimeUnit) int64 {
+	switch {
+	case unit.Millis != nil:
+		return t.UnixMilli()
+	case unit.Micros != nil:
+		return t.UnixMicro()
+	}
+	return t.UnixNano()
+}
+
+// big endian two's complement, sign extended to size bytes when size > 0
+func decimalBytes(d Decimal, size int) []byte {
+	n := d.unscaled()
+	magnitude := n
+	if n.Sign() < 0 {
+		// -2^k needs no more bits than 2^k - 1
+		magnitude = new(big.Int).Not(n)
+	}
+	width := (magnitude.BitLen() + 8) / 8 // room for the sign bit
+	if size > width {
+		width = size
+	}
+	b := make([]byte, width)
+	if n.Sign() >= 0 {
+		n.FillBytes(b)
+		return b
+	}
+	// two's complement: 2^(8*width) + n
+	new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), uint(width)*8), n).FillBytes(b)
+	return b
+}
+
+// go type and parquet tag options of the struct field used to write a column
+func physicalField(field structField) (reflect.Type, string) {
+	t := field.PqType
+	lt := t.LogicalType()
+	switch t.Kind() {
+	case parquet.Boolean:
+		return reflect.TypeOf(false), ""
+	case parquet.Int32, parquet.Int64:
+		goType := reflect.TypeOf(int32(0))
+		if t.Kind() == parquet.Int64 {
+			goType = reflect.TypeOf(int64(0))
+		}
+		switch {
+		case lt == nil:
+		case lt.Date != nil:
+			return goType, "date"
+		case lt.Timestamp != nil:
+			return goType, "timestamp(" + timeUnitTag(lt.Timestamp.Unit, lt.Timestamp.IsAdjustedToUTC) + ")"
+		case lt.Time != nil:
+			return goType, "time(" + timeUnitTag(lt.Time.Unit, lt.Time.IsAdjustedToUTC) + ")"
+		case lt.Decimal != nil:
+			return goType, fmt.Sprintf("decimal(%d:%d)", lt.Decimal.Scale, lt.Decimal.Precision)
+		case lt.Integer != nil && !lt.Integer.IsSigned:
+			if t.Kind() == parquet.Int64 {
+				return reflect.TypeOf(uint64(0)), ""
+			}
+			return reflect.TypeOf(uint32(0)), ""
+		}
+		return goType, ""
+	case parquet.Int96:
+		return reflect.TypeOf(deprecated.Int96{}), ""
+	case parquet.Float:
+		return reflect.TypeOf(float32(0)), ""
+	case parquet.Double:
+		return reflect.TypeOf(float64(0)), ""
+	case parquet.FixedLenByteArray:
+		switch {
+		case lt != nil && lt.UUID != nil:
+			return reflect.TypeOf(uuid.UUID{}), ""
+		case lt != nil && lt.Decimal != nil:
+			return reflect.TypeOf([]byte(nil)), fmt.Sprintf("decimal(%d:%d)", lt.Decimal.Scale, lt.Decimal.Precision)
+		}
+		return reflect.ArrayOf(t.Length(), reflect.TypeOf(byte(0))), ""
+	}
+	switch {
+	case lt == nil:
+		return reflect.TypeOf(""), "bytes"
+	case lt.Enum != nil:
+		return reflect.TypeOf(""), "enum"
+	case lt.Json != nil:
+		return reflect.TypeOf(""), "json"
+	case lt.Decimal != nil:
+		return reflect.TypeOf([]byte(nil)), fmt.Sprintf("decimal(%d:%d)", lt.Decimal.Scale, lt.Decimal.Precision)
+	}
+	return reflect.TypeOf(""), ""
+}
+
+func timeUnitTag(unit format.TimeUnit, utc bool) string {
+	name := "nanosecond"
+	switch {
+	case unit.Millis != nil:
+		name = "millisecond"
+	case unit.Micros != nil:
+		name = "microsecond"
+	}
+	if !utc {
+		name += ":local"
+	}
+	return name
+}
+
+// node that stores the field with its logical type. the node comes from a
+// one field struct, column names are not valid in struct tags
+func writeNode(field structField) parquet.Node {
+	goType, opts := physicalField(field)
+	tag := "value"
+	if opts != "" {
+		tag += "," + opts
+	}
+	if field.Optional {
+		tag += ",optional"
+	}
+	typ := reflect.StructOf([]reflect.StructField{{Name: "Value", Type: goType, Tag: reflect.StructTag(`parquet:"` + tag + `"`)}})
+	return parquet.SchemaOf(reflect.New(typ).Interface()).Fields()[0]
+}
+
+// statistics are plain encoded values of the physical type. int96 has no
+// sort order, writers' min and max of it can not be compared
+func statValue(field structField, b []byte) (any, bool) {
+	kind := field.PqType.Kind()
+	size := 0
+	switch kind {
+	case parquet.Boolean:
+		size = 1
+	case parquet.Int32, parquet.Float:
+		size = 4
+	case parquet.Int64, parquet.Double:
+		size = 8
+	case parquet.Int96:
+		return nil, false
+	}
+	if len(b) < size {
+		return nil, false
+	}
+	return columnValue(field, kind.Value(b)), true
+}
+
+// compares values of logical types, handled is false when neither is one
+func compareLogical(a, b any) (cmp int, ok, handled bool) {
+	switch va := a.(type) {
+	case Decimal:
+		if vb, isDec := toDecimal(b); isDec {
+			return va.Cmp(vb), true, true
+		}
+		if s, isStr := b.(string); isStr {
+			if vb, err := ParseDecimal(s); err == nil {
+				return va.Cmp(vb), true, true
+			}
+			return 0, false, true
+		}
+		if fb, isNum := toFloat(b); isNum {
+			return cmpOrdered(va.Float64(), fb), true, true
+		}
+		return 0, false, true
+	case time.Duration:
+		switch vb := b.(type) {
+		case time.Duration:
+			return cmpOrdered(int64(va), int64(vb)), true, true
+		case string:
+			if d, err := toTimeOfDay(vb); err == nil {
+				return cmpOrdered(int64(va), int64(d)), true, true
+			}
+		}
+		return 0, false, true
+	case uuid.UUID:
+		switch vb := b.(type) {
+		case uuid.UUID:
+			return strings.Compare(va.String(), vb.String()), true, true
+		case string:
+			return strings.Compare(va.String(), strings.ToLower(vb)), true, true
+		}
+		return 0, false, true
+	}
+	switch b.(type) {
+	case Decimal, time.Duration, uuid.UUID:
+		cmp, ok, _ := compareLogical(b, a)
+		return -cmp, ok, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,243 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+)
+
+func logicalSchema(t *testing.T) *parquetSchema {
+	t.Helper()
+	var fields []structField
+	for _, c := range []struct{ name, typ string }{
+		{"day", "date"},
+		{"ts", "timestamp"},
+		{"legacy_ts", "int96"},
+		{"opens", "time"},
+		{"price", "decimal(10,2)"},
+		{"big", "decimal(30,4)"},
+		{"id", "uuid"},
+		{"status", "enum"},
+	} {
+		typ, err := ParseTypeName(c.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, structField{Name: c.name, PqType: typ, Optional: true})
+	}
+	return &parquetSchema{Fields: fields}
+}
+
+func TestLogicalTypesRoundTrip(t *testing.T) {
+	schema := logicalSchema(t)
+	ts := time.Date(2024, 3, 9, 14, 30, 15, 123456000, time.UTC)
+	id := uuid.MustParse("3f1e8c0e-8d3c-4b8a-9a55-6c2b0a2f7e11")
+	batch := RecordBatch{Schema: *schema, Columns: [][]any{
+		{time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), nil},
+		{ts, "2024-03-10 08:00:00"},
+		{ts, nil},
+		{"09:30", 8 * time.Hour},
+		{"12.5", -0.25},
+		{"-12345678901234567890.1234", int64(7)},
+		{id, id.String()},
+		{"OPEN", "CLOSED"},
+	}}
+
+	f, err := os.CreateTemp(t.TempDir(), "logical-*.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewParquetBatchWriter(f, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadParquetSchema(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	price, _ := read.ColumnInfo("price")
+	if lt := price.PqType.LogicalType(); lt == nil || lt.Decimal == nil || lt.Decimal.Scale != 2 {
+		t.Fatalf("expected price to be written as decimal with scale 2, got %v", price.PqType)
+	}
+
+	leaf := NewProjectExecLeaf(f, read.toColumns(), nil)
+	got, err := leaf.Next(10)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	want := [][]string{
+		{"2024-03-09", "NULL"},
+		{"2024-03-09T14:30:15.123456Z", "2024-03-10T08:00:00Z"},
+		{"2024-03-09T14:30:15.123456Z", "NULL"},
+		{"09:30:00", "08:00:00"},
+		{"12.50", "-0.25"},
+		{"-12345678901234567890.1234", "7.0000"},
+		{id.String(), id.String()},
+		{"OPEN", "CLOSED"},
+	}
+	for c := range want {
+		for row := range want[c] {
+			if s := formatValue(got.Columns[c][row]); s != want[c][row] {
+				t.Errorf("%s row %d: expected %s, got %s (%T)", schema.Fields[c].Name, row, want[c][row], s, got.Columns[c][row])
+			}
+		}
+	}
+	if _, ok := got.Columns[1][0].(time.Time); !ok {
+		t.Fatalf("expected timestamps to be read as time.Time, got %T", got.Columns[1][0])
+	}
+	if _, ok := got.Columns[6][0].(uuid.UUID); !ok {
+		t.Fatalf("expected uuids to be read as uuid.UUID, got %T", got.Columns[6][0])
+	}
+
+	// int96 has no sort order, its statistics never prune
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := fileStats(read, pf.Metadata())
+	if stats["legacy_ts"].hasMinMax || !stats["ts"].hasMinMax {
+		t.Fatalf("expected min and max of ts only, got %+v and %+v", stats["legacy_ts"], stats["ts"])
+	}
+}
+
+func TestLogicalTypesCompare(t *testing.T) {
+	row := map[string]any{
+		"price": NewDecimal(1250, 2),
+		"ts":    time.Date(2024, 3, 9, 14, 30, 0, 0, time.UTC),
+		"opens": 9 * time.Hour,
+	}
+	get := func(column string) any { return row[column] }
+	for _, c := range []struct {
+		expr string
+		want any
+	}{
+		{"price = 12.5", true},
+		{"price > 12.49", true},
+		{"price IN (12.50, 3)", true},
+		{"price < 12", false},
+		{"ts >= '2024-03-09'", true},
+		{"ts < '2024-03-09T14:00:00Z'", false},
+		{"opens = '09:00'", true},
+	} {
+		e, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.Eval(get); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestDecimalParseAndRescale(t *testing.T) {
+	d, err := ParseDecimal("-0.050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.String() != "-0.050" || d.Scale != 3 {
+		t.Fatalf("unexpected decimal %s scale %d", d, d.Scale)
+	}
+	if r, exact := d.Rescale(1); r.String() != "-0.1" || exact {
+		t.Fatalf("expected -0.1 rounded, got %s exact=%v", r, exact)
+	}
+	if d.Cmp(NewDecimal(-5, 2)) != 0 {
+		t.Fatalf("expected -0.050 to equal -0.05")
+	}
+	if _, err := ParseDecimal("1e5"); err == nil {
+		t.Fatalf("expected an error for exponents")
+	}
+	if _, err := ParseTypeName("decimal(3,5)"); err == nil {
+		t.Fatalf("expected an error for a scale larger than the precision")
+	}
+	if typ, err := ParseTypeName("decimal(18, 3)"); err != nil || typ.Kind() != parquet.Int64 {
+		t.Fatalf("expected decimal(18,3) to be stored as int64, got %v %v", typ, err)
+	}
+	// fixed length decimals take the fewest bytes of their precision
+	for precision, length := range map[int]int{19: 9, 21: 9, 22: 10, 30: 13, 38: 16} {
+		typ, err := ParseTypeName(fmt.Sprintf("decimal(%d,2)", precision))
+		if err != nil || typ.Kind() != parquet.FixedLenByteArray || typ.Length() != length {
+			t.Fatalf("expected decimal(%d,2) in %d bytes, got %v %v", precision, length, typ, err)
+		}
+	}
+	if _, err := ParseTypeName("decimal(39,2)"); err == nil {
+		t.Fatalf("expected an error for a precision past 38")
+	}
+}
+
+func TestDecimalOverflow(t *testing.T) {
+	for _, name := range []string{"decimal(9,2)", "decimal(18,2)", "decimal(30,2)"} {
+		typ, err := ParseTypeName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		precision := typ.LogicalType().Decimal.Precision
+		largest := strings.Repeat("9", int(precision)-2) + ".99"
+		v, err := encodeValue("-"+largest, typ)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		field := structField{Name: "d", PqType: typ}
+		if got := columnValue(field, v); formatValue(got) != "-"+largest {
+			t.Fatalf("%s: expected -%s back, got %v", name, largest, got)
+		}
+		if _, err := castValue("1"+largest, typ); err == nil {
+			t.Fatalf("%s: expected %s to be rejected", name, "1"+largest)
+		}
+		if _, err := encodeValue("1"+largest, typ); err == nil {
+			t.Fatalf("%s: expected encoding %s to fail", name, "1"+largest)
+		}
+	}
+	// values out of range of the physical type are never truncated
+	huge := Decimal{Unscaled: new(big.Int).Lsh(big.NewInt(1), 40), Scale: 2}
+	if _, err := encodeValue(huge, parquet.Decimal(2, 0, parquet.Int32Type).Type()); err == nil {
+		t.Fatal("expected a decimal too large for int32 to fail")
+	}
+	if _, err := encodeValue(huge, parquet.Decimal(2, 0, parquet.FixedLenByteArrayType(4)).Type()); err == nil {
+		t.Fatal("expected a decimal too large for 4 bytes to fail")
+	}
+	if v, err := encodeValue(NewDecimal(-128, 0), parquet.Decimal(0, 0, parquet.FixedLenByteArrayType(1)).Type()); err != nil || v.ByteArray()[0] != 0x80 {
+		t.Fatalf("expected -128 in a byte, got %v %v", v, err)
+	}
+}
+
+func TestUnsignedOutOfRange(t *testing.T) {
+	u32 := parquet.Uint(32).Type()
+	for _, v := range []any{3.7, int64(5e9), 5e9, int64(-1), -1.0, "-1"} {
+		if got, err := castValue(v, u32); err == nil {
+			t.Fatalf("expected %v to be rejected as uint32, got %v", v, got)
+		}
+		if _, err := encodeValue(v, u32); err == nil {
+			t.Fatalf("expected encoding %v as uint32 to fail", v)
+		}
+	}
+	if _, err := encodeValue(uint64(5e9), u32); err == nil {
+		t.Fatal("expected encoding a uint64 past uint32 to fail")
+	}
+	v, err := encodeValue(int64(math.MaxUint32), u32)
+	if err != nil || uint32(v.Int32()) != math.MaxUint32 {
+		t.Fatalf("expected the largest uint32 back, got %v %v", v, err)
+	}
+	if _, err := castValue(300, parquet.Uint(8).Type()); err == nil {
+		t.Fatal("expected 300 to be rejected as uint8")
+	}
+}
@@ -0,0 +1,306 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/apache/arrow/go/v15/arrow/memory"
+)
+
+// memory accounting of a query. every query built from a plan gets its own
+// MemoryPool, its operators take a MemoryReservation from it and grow it
+// before they hold on to rows, shrinking it once the rows are handed on.
+// closing the query frees what is still reserved. growing past the limit of the
+// pool fails with ErrMemoryLimitExceeded: operators that can spill, like
+// SortExec, write what they hold to disk and carry on, the others fail the
+// query. sizes are estimates of the go values a RecordBatch holds, arrow
+// buffers are counted exactly through the pool's Allocator
+
+var ErrMemoryLimitExceeded = errors.New("memory limit exceeded")
+
+// MemoryPool bounds the memory of a query, safe for concurrent use
+type MemoryPool struct {
+	mu           sync.Mutex
+	limit        int64
+	used         int64
+	peak         int64
+	reservations []*MemoryReservation
+}
+
+// MemoryReservation is the share of a pool one operator holds
+type MemoryReservation struct {
+	pool *MemoryPool
+	name string
+	used int64
+	peak int64
+}
+
+// MemoryUsage reports the memory of a query
+type MemoryUsage struct {
+	Limit     int64 // <= 0 without a limit
+	Used      int64
+	Peak      int64
+	Operators []OperatorMemory // in the order the operators reserved memory
+}
+
+// OperatorMemory is the memory one operator of a query used
+type OperatorMemory struct {
+	Name       string
+	Used, Peak int64
+}
+
+// NewMemoryPool bounds a query to limit bytes, <= 0 only accounts
+func NewMemoryPool(limit int64) *MemoryPool {
+	return &MemoryPool{limit: limit}
+}
+
+// Reservation starts accounting the memory of an operator, nil pools hand
+// out nil reservations which account nothing
+func (p *MemoryPool) Reservation(name string) *MemoryReservation {
+	if p == nil {
+		return nil
+	}
+	r := &MemoryReservation{pool: p, name: name}
+	p.mu.Lock()
+	p.reservations = append(p.reservations, r)
+	p.mu.Unlock()
+	return r
+}
+
+// Usage reports the pool, nil pools report nothing
+func (p *MemoryPool) Usage() MemoryUsage {
+	if p == nil {
+		return MemoryUsage{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u := MemoryUsage{Limit: p.limit, Used: p.used, Peak: p.peak}
+	for _, r := range p.reservations {
+		u.Operators = append(u.Operators, OperatorMemory{Name: r.name, Used: r.used, Peak: r.peak})
+	}
+	return u
+}
+
+// String lists the peak memory of every operator
+func (u MemoryUsage) String() string {
+	s := fmt.Sprintf("peak %s", byteSize(u.Peak))
+	if u.Limit > 0 {
+		s += " of " + byteSize(u.Limit)
+	}
+	for _, op := range u.Operators {
+		s += fmt.Sprintf(", %s %s", op.Name, byteSize(op.Peak))
+	}
+	return s
+}
+
+// Grow reserves n more bytes, ErrMemoryLimitExceeded when the pool has no
+// room left for them
+func (r *MemoryReservation) Grow(n int64) error {
+	if r == nil || n <= 0 {
+		r.Shrink(-n)
+		return nil
+	}
+	p := r.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.limit > 0 && p.used+n > p.limit {
+		return fmt.Errorf("%s: %w: %d bytes more with %d of %d in use", r.name, ErrMemoryLimitExceeded, n, p.used, p.limit)
+	}
+	r.add(n)
+	return nil
+}
+
+// Shrink returns n bytes to the pool
+func (r *MemoryReservation) Shrink(n int64) {
+	if r == nil || n <= 0 {
+		return
+	}
+	r.pool.mu.Lock()
+	defer r.pool.mu.Unlock()
+	r.add(-min(n, r.used))
+}
+
+// Resize grows or shrinks the reservation to n bytes
+func (r *MemoryReservation) Resize(n int64) error {
+	if r == nil {
+		return nil
+	}
+	r.pool.mu.Lock()
+	used := r.used
+	r.pool.mu.Unlock()
+	return r.Grow(n - used)
+}
+
+// Free returns every byte of the reservation
+func (r *MemoryReservation) Free() {
+	if r == nil {
+		return
+	}
+	r.pool.mu.Lock()
+	defer r.pool.mu.Unlock()
+	r.add(-r.used)
+}
+
+// frees every reservation once the query is done, peaks are kept
+func (p *MemoryPool) freeAll() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.reservations {
+		r.add(-r.used)
+	}
+}
+
+// Used is the number of bytes reserved
+func (r *MemoryReservation) Used() int64 {
+	if r == nil {
+		return 0
+	}
+	r.pool.mu.Lock()
+	defer r.pool.mu.Unlock()
+	return r.used
+}
+
+// must be called with the pool's mu held
+func (r *MemoryReservation) add(n int64) {
+	p := r.pool
+	r.used += n
+	r.peak = max(r.peak, r.used)
+	p.used += n
+	p.peak = max(p.peak, p.used)
+}
+
+// Allocator is an arrow allocator counting its buffers in the pool under
+// name. arrow allocations cannot fail, they are counted even above the
+// limit so the reservations that follow fail instead
+func (p *MemoryPool) Allocator(name string) memory.Allocator {
+	if p == nil {
+		return memory.NewGoAllocator()
+	}
+	return &poolAllocator{res: p.Reservation(name), mem: memory.NewGoAllocator()}
+}
+
+type poolAllocator struct {
+	res *MemoryReservation
+	mem memory.Allocator
+}
+
+func (a *poolAllocator) Allocate(size int) []byte {
+	a.force(int64(size))
+	return a.mem.Allocate(size)
+}
+
+func (a *poolAllocator) Reallocate(size int, b []byte) []byte {
+	a.force(int64(size - len(b)))
+	return a.mem.Reallocate(size, b)
+}
+
+func (a *poolAllocator) Free(b []byte) {
+	a.res.Shrink(int64(len(b)))
+	a.mem.Free(b)
+}
+
+// reserves n bytes regardless of the limit
+func (a *poolAllocator) force(n int64) {
+	if n < 0 {
+		a.res.Shrink(-n)
+		return
+	}
+	a.res.pool.mu.Lock()
+	a.res.add(n)
+	a.res.pool.mu.Unlock()
+}
+
+// estimated bytes of the values of a batch: an interface per value and the
+// bytes strings, byte slices and nested values point to
+func batchBytes(batch RecordBatch) int64 {
+	var n int64
+	for _, col := range batch.Columns {
+		for _, v := range col {
+			n += valueBytes(v)
+		}
+	}
+	return n
+}
+
+func valueBytes(v any) int64 {
+	const word = 16 // an interface value
+	switch x := v.(type) {
+	case string:
+		return word + int64(len(x))
+	case []byte:
+		return word + 24 + int64(len(x))
+	case []any:
+		n := int64(word + 24)
+		for _, item := range x {
+			n += valueBytes(item)
+		}
+		return n
+	case map[string]any:
+		n := int64(word + 48)
+		for k, item := range x {
+			n += int64(len(k)) + 16 + valueBytes(item)
+		}
+		return n
+	case Decimal:
+		return word + 48
+	}
+	return word
+}
+
+// meteredExec accounts the batches an operator hands out, a batch is held
+// until the next one is asked for, nothing once the input ends
+type meteredExec struct {
+	Operator
+	res *MemoryReservation
+}
+
+// meters op in pool, op itself without a pool
+func meter(op Operator, pool *MemoryPool, name string) Operator {
+	if pool == nil {
+		return op
+	}
+	return &meteredExec{Operator: op, res: pool.Reservation(name)}
+}
+
+func (m *meteredExec) Next(n uint) (RecordBatch, error) {
+	batch, err := m.Operator.Next(n)
+	if err != nil && err != io.EOF {
+		m.res.Free()
+		return batch, err
+	}
+	if rerr := m.res.Resize(batchBytes(batch)); rerr != nil {
+		return RecordBatch{}, rerr
+	}
+	if err == io.EOF {
+		m.res.Free() // the peak keeps the last batch
+	}
+	return batch, err
+}
+
+// closes every closer, the first error is returned
+type closeAll []io.Closer
+
+func (c closeAll) Close() error {
+	var first error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Operator finds the memory of an operator by name
+func (u MemoryUsage) Operator(name string) (OperatorMemory, bool) {
+	for _, op := range u.Operators {
+		if op.Name == name {
+			return op, true
+		}
+	}
+	return OperatorMemory{}, false
+}
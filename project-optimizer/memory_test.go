@@ -0,0 +1,110 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMemoryPool(t *testing.T) {
+	pool := NewMemoryPool(100)
+	a, b := pool.Reservation("a"), pool.Reservation("b")
+	if err := a.Grow(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Grow(50); !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("expected ErrMemoryLimitExceeded, got %v", err)
+	}
+	if err := b.Resize(40); err != nil {
+		t.Fatal(err)
+	}
+	a.Shrink(30)
+	b.Free()
+	usage := pool.Usage()
+	if usage.Used != 30 || usage.Peak != 100 {
+		t.Fatalf("expected 30 bytes in use and a peak of 100, got %+v", usage)
+	}
+	if op, _ := usage.Operator("a"); op.Used != 30 || op.Peak != 60 {
+		t.Fatalf("unexpected usage of a %+v", op)
+	}
+	if op, _ := usage.Operator("b"); op.Used != 0 || op.Peak != 40 {
+		t.Fatalf("unexpected usage of b %+v", op)
+	}
+
+	// nil pools account nothing
+	var none *MemoryPool
+	if err := none.Reservation("x").Grow(1 << 40); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryPoolAccountsArrowAndOperators(t *testing.T) {
+	src, err := OpenMmap(writeMmapFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	pool := NewMemoryPool(0)
+	table, err := ReadArrowColumns(src, []string{"name", "bonus"}, pool.Allocator("arrow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op, _ := pool.Usage().Operator("arrow"); op.Used == 0 {
+		t.Fatalf("expected arrow buffers to be counted")
+	}
+	table.Release()
+	if op, _ := pool.Usage().Operator("arrow"); op.Used != 0 || op.Peak == 0 {
+		t.Fatalf("expected released buffers to be returned, got %+v", op)
+	}
+
+	// operators that cannot spill fail the query
+	plan := &FilterPlan{Input: &ScanPlan{Path: "../data/history.parquet"}, Where: mustParseExpr(t, "lat > 0")}
+	in, err := BuildPlan(plan, PlanOptions{MemoryLimit: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if _, err := in.Next(1000); !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("expected ErrMemoryLimitExceeded, got %v", err)
+	}
+
+	in, err = BuildPlan(plan, PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	drain(t, in)
+	usage := in.Memory()
+	if op, ok := usage.Operator("scan"); !ok || op.Peak == 0 {
+		t.Fatalf("expected the peak of the scan, got %+v", usage)
+	}
+	if s := usage.String(); !strings.Contains(s, ", scan ") {
+		t.Fatalf("expected the scan in the report, got %q", s)
+	}
+}
+
+func TestQueriesFreeTheirMemory(t *testing.T) {
+	session := NewSession(PlanOptions{MemoryLimit: 1 << 20})
+	if err := session.Register("h", "../data/history.parquet"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		in, err := session.Query("SELECT * FROM h LIMIT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := in.Next(10); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if op, _ := in.Memory().Operator("scan"); op.Used == 0 {
+			t.Fatalf("expected the scan to hold its batch before Close, got %+v", in.Memory())
+		}
+		if err := in.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if usage := in.Memory(); usage.Used != 0 || len(usage.Operators) != 1 {
+			t.Fatalf("query %d: expected a pool of its own freed on Close, got %+v", i, usage)
+		}
+	}
+}
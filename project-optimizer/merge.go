@@ -0,0 +1,257 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// reconcile the schemas of files written at different times
+
+type SchemaMergeMode int
+
+const (
+	MergeUnion        SchemaMergeMode = iota // every column of every schema, absent ones read as NULL
+	MergeIntersection                        // only the columns every schema has
+)
+
+var ErrIncompatibleSchema = errors.New("incompatible schema change")
+
+// MergeSchemas reconciles schemas column by column, matching names case
+// insensitively. Compatible types are widened (int32 -> int64, float -> double,
+// decimals of the same scale to the larger precision), any other type change,
+// logical types included, fails with ErrIncompatibleSchema
+func MergeSchemas(mode SchemaMergeMode, schemas ...*parquetSchema) (*parquetSchema, error) {
+	return mergeSchemas(mode, schemas, nil)
+}
+
+// names label the schemas in error messages, usually file paths
+func mergeSchemas(mode SchemaMergeMode, schemas []*parquetSchema, names []string) (*parquetSchema, error) {
+	label := func(i int) string {
+		if i < len(names) {
+			return names[i]
+		}
+		return fmt.Sprintf("schema %d", i+1)
+	}
+	merged := &parquetSchema{}
+	origin := make(map[string]int) // lower cased column -> schema it was last typed by
+	counts := make(map[string]int)
+	for s, schema := range schemas {
+		for _, field := range schema.Fields {
+			key := strings.ToLower(field.Name)
+			counts[key]++
+			idx := merged.fieldIndex(field.Name)
+			if idx < 0 {
+				merged.Fields = append(merged.Fields, field)
+				origin[key] = s
+				continue
+			}
+			existing := merged.Fields[idx]
+			widened, ok := existing.PqType, sameNested(existing, field)
+			if existing.Node == nil && field.Node == nil {
+				widened, ok = widenType(existing.PqType, field.PqType)
+			}
+			if !ok {
+				return nil, fmt.Errorf("%w: column %q is %s in %s but %s in %s", ErrIncompatibleSchema,
+					field.Name, fieldTypeName(existing), label(origin[key]),
+					fieldTypeName(field), label(s))
+			}
+			if field.Optional {
+				merged.Fields[idx] = optionalField(existing)
+			}
+			if existing.Node == nil && !sameType(widened, existing.PqType) {
+				merged.Fields[idx].PqType = widened
+				origin[key] = s
+			}
+		}
+	}
+	if mode == MergeIntersection {
+		var common []structField
+		for _, field := range merged.Fields {
+			if counts[strings.ToLower(field.Name)] == len(schemas) {
+				common = append(common, field)
+			}
+		}
+		if len(common) == 0 && len(schemas) > 0 {
+			return nil, fmt.Errorf("%w: the schemas have no column in common", ErrIncompatibleSchema)
+		}
+		merged.Fields = common
+	}
+	// a column some schemas lack is read as NULL from them
+	for i, field := range merged.Fields {
+		if counts[strings.ToLower(field.Name)] != len(schemas) {
+			merged.Fields[i] = optionalField(field)
+		}
+	}
+	return merged, nil
+}
+
+// type able to hold the values of both a and b. the logical types must
+// agree: decimals widen only to a decimal of the same scale, timestamps and
+// times to the finer unit, plain integers and floats as before
+func widenType(a, b parquet.Type) (parquet.Type, bool) {
+	if isDateType(a) || isDateType(b) {
+		return a, isDateType(a) && isDateType(b)
+	}
+	class := logicalClass(a)
+	if class != logicalClass(b) {
+		return nil, false
+	}
+	ka, kb := a.Kind(), b.Kind()
+	switch class {
+	case "decimal":
+		da, db := a.LogicalType().Decimal, b.LogicalType().Decimal
+		if da.Scale != db.Scale {
+			return nil, false
+		}
+		if db.Precision > da.Precision {
+			return b, true
+		}
+		return a, true
+	case "timestamp", "time":
+		if timeUnitRank(b) > timeUnitRank(a) {
+			return b, true
+		}
+		return a, true
+	case "unsigned":
+		if intBits(b) > intBits(a) {
+			return b, true
+		}
+		return a, true
+	}
+	isInt := func(k parquet.Kind) bool { return k == parquet.Int32 || k == parquet.Int64 }
+	if class == "" && isInt(ka) && isInt(kb) {
+		if intBits(b) > intBits(a) {
+			return b, true
+		}
+		return a, true
+	}
+	if ka == kb {
+		return a, true
+	}
+	if class != "" {
+		return nil, false
+	}
+	isFloat := func(k parquet.Kind) bool { return k == parquet.Float || k == parquet.Double }
+	switch {
+	case isFloat(ka) && isFloat(kb):
+		return parquet.DoubleType, true
+	}
+	return nil, false
+}
+
+// kind of values a logical type decodes to, empty for plain physical types.
+// strings, enums and json all read as strings
+func logicalClass(t parquet.Type) string {
+	if t.Kind() == parquet.Int96 {
+		return "timestamp"
+	}
+	lt := t.LogicalType()
+	switch {
+	case lt == nil:
+	case lt.Decimal != nil:
+		return "decimal"
+	case lt.Timestamp != nil:
+		return "timestamp"
+	case lt.Time != nil:
+		return "time"
+	case lt.UUID != nil:
+		return "uuid"
+	case lt.Integer != nil && !lt.Integer.IsSigned:
+		return "unsigned"
+	case lt.Json != nil || lt.Enum != nil || lt.UTF8 != nil:
+		return "string"
+	}
+	if t.Kind() == parquet.ByteArray {
+		return "string"
+	}
+	return ""
+}
+
+// bit width of an integer type, INT(8) and INT(16) are stored as int32
+func intBits(t parquet.Type) int {
+	if lt := t.LogicalType(); lt != nil && lt.Integer != nil {
+		return int(lt.Integer.BitWidth)
+	}
+	if t.Kind() == parquet.Int64 {
+		return 64
+	}
+	return 32
+}
+
+// precision of a timestamp or time type, int96 holds nanoseconds
+func timeUnitRank(t parquet.Type) int {
+	if t.Kind() == parquet.Int96 {
+		return 3
+	}
+	var unit format.TimeUnit
+	if lt := t.LogicalType(); lt.Timestamp != nil {
+		unit = lt.Timestamp.Unit
+	} else {
+		unit = lt.Time.Unit
+	}
+	switch {
+	case unit.Millis != nil:
+		return 1
+	case unit.Micros != nil:
+		return 2
+	}
+	return 3
+}
+
+// types decode values the same way, no widening is needed between them
+func sameType(a, b parquet.Type) bool {
+	return a.Kind() == b.Kind() && a.Length() == b.Length() && reflect.DeepEqual(a.LogicalType(), b.LogicalType())
+}
+
+// nested columns merge when they have the same structure, a struct may be
+// optional in one schema and required in another
+func sameNested(a, b structField) bool {
+	if a.Node == nil || b.Node == nil {
+		return false
+	}
+	na, nb := a.Node, b.Node
+	if !na.Repeated() && !nb.Repeated() {
+		na, nb = parquet.Required(na), parquet.Required(nb)
+	}
+	return parquet.EqualNodes(na, nb)
+}
+
+// user facing name of a type, the names ParseTypeName accepts
+func typeName(t parquet.Type) string {
+	if isDateType(t) {
+		return "date"
+	}
+	if isGroupType(t) {
+		return t.String()
+	}
+	switch t.Kind() {
+	case parquet.Boolean:
+		return "bool"
+	case parquet.Int32:
+		return "int32"
+	case parquet.Int64:
+		return "int64"
+	case parquet.Float:
+		return "float"
+	case parquet.Double:
+		return "double"
+	case parquet.ByteArray:
+		return "string"
+	}
+	return t.String()
+}
+
+// position of a column, -1 when it does not exist
+func (p *parquetSchema) fieldIndex(name string) int {
+	for i, field := range p.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,178 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestMergeSchemasWidens(t *testing.T) {
+	old := &parquetSchema{Fields: []structField{
+		{Name: "id", PqType: parquet.Int32Type},
+		{Name: "price", PqType: parquet.FloatType},
+		{Name: "legacy", PqType: parquet.String().Type()},
+	}}
+	cur := &parquetSchema{Fields: []structField{
+		{Name: "ID", PqType: parquet.Int64Type},
+		{Name: "price", PqType: parquet.DoubleType},
+		{Name: "added", PqType: parquet.BooleanType},
+	}}
+
+	union, err := MergeSchemas(MergeUnion, old, cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]parquet.Kind{"id": parquet.Int64, "price": parquet.Double, "legacy": parquet.ByteArray, "added": parquet.Boolean}
+	if len(union.Fields) != len(want) {
+		t.Fatalf("expected %d columns, got %v", len(want), union.toColumns())
+	}
+	for _, field := range union.Fields {
+		if field.PqType.Kind() != want[strings.ToLower(field.Name)] {
+			t.Fatalf("column %s: expected %v, got %v", field.Name, want[strings.ToLower(field.Name)], field.PqType.Kind())
+		}
+	}
+
+	common, err := MergeSchemas(MergeIntersection, old, cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := common.toColumns(); len(got) != 2 || got[0] != "id" || got[1] != "price" {
+		t.Fatalf("expected [id price], got %v", got)
+	}
+}
+
+func TestMergeSchemasIncompatible(t *testing.T) {
+	a := &parquetSchema{Fields: []structField{{Name: "zip", PqType: parquet.Int64Type}}}
+	b := &parquetSchema{Fields: []structField{{Name: "zip", PqType: parquet.String().Type()}}}
+	_, err := mergeSchemas(MergeUnion, []*parquetSchema{a, b}, []string{"2023.parquet", "2024.parquet"})
+	if !errors.Is(err, ErrIncompatibleSchema) {
+		t.Fatalf("expected ErrIncompatibleSchema, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"zip" is int64 in 2023.parquet but string in 2024.parquet`) {
+		t.Fatalf("unclear error message: %v", err)
+	}
+}
+
+func TestDatasetWidensColumns(t *testing.T) {
+	type v1 struct {
+		ID    int32   `parquet:"id"`
+		Price float32 `parquet:"price"`
+	}
+	type v2 struct {
+		ID    int64   `parquet:"id"`
+		Price float64 `parquet:"price"`
+		Note  string  `parquet:"note"`
+	}
+	dir := t.TempDir()
+	if err := parquet.WriteFile(filepath.Join(dir, "a.parquet"), []v1{{ID: 1, Price: 1.5}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := parquet.WriteFile(filepath.Join(dir, "b.parquet"), []v2{{ID: 1 << 40, Price: 2.25, Note: "new"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := NewDatasetExec(dir, DatasetOptions{SchemaMerge: MergeIntersection})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	out := drain(t, ds)
+	if len(out.Columns) != 2 || out.NumRows() != 2 {
+		t.Fatalf("expected 2 columns and 2 rows, got %v", out.Columns)
+	}
+	if out.Columns[0][0] != int64(1) || out.Columns[0][1] != int64(1<<40) {
+		t.Fatalf("expected ids widened to int64, got %#v", out.Columns[0])
+	}
+	if out.Columns[1][0] != float64(1.5) || out.Columns[1][1] != float64(2.25) {
+		t.Fatalf("expected prices widened to double, got %#v", out.Columns[1])
+	}
+}
+
+func TestMergeSchemasLogicalTypes(t *testing.T) {
+	decimal := func(scale, precision int, typ parquet.Type) parquet.Type {
+		return parquet.Decimal(scale, precision, typ).Type()
+	}
+	merge := func(a, b parquet.Type) (parquet.Type, error) {
+		merged, err := MergeSchemas(MergeUnion,
+			&parquetSchema{Fields: []structField{{Name: "x", PqType: a}}},
+			&parquetSchema{Fields: []structField{{Name: "x", PqType: b}}})
+		if err != nil {
+			return nil, err
+		}
+		return merged.Fields[0].PqType, nil
+	}
+	for _, tc := range []struct{ a, b parquet.Type }{
+		{parquet.Timestamp(parquet.Millisecond).Type(), parquet.Int64Type},
+		{parquet.Uint(64).Type(), parquet.Int64Type},
+		{decimal(2, 9, parquet.Int32Type), parquet.Int64Type},
+		{decimal(2, 9, parquet.Int32Type), decimal(3, 18, parquet.Int64Type)},
+	} {
+		if _, err := merge(tc.a, tc.b); !errors.Is(err, ErrIncompatibleSchema) {
+			t.Errorf("%v and %v: expected ErrIncompatibleSchema, got %v", tc.a, tc.b, err)
+		}
+	}
+
+	got, err := merge(decimal(2, 9, parquet.Int32Type), decimal(2, 18, parquet.Int64Type))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lt := got.LogicalType(); got.Kind() != parquet.Int64 || lt.Decimal == nil || lt.Decimal.Scale != 2 || lt.Decimal.Precision != 18 {
+		t.Fatalf("expected decimal(18,2) as int64, got %v", got)
+	}
+	for _, tc := range []struct{ a, b, want parquet.Type }{
+		{parquet.Int(8).Type(), parquet.Int(32).Type(), parquet.Int(32).Type()},
+		{parquet.Int(16).Type(), parquet.Int32Type, parquet.Int32Type},
+		{parquet.Int(8).Type(), parquet.Int64Type, parquet.Int64Type},
+		{parquet.Uint(8).Type(), parquet.Uint(32).Type(), parquet.Uint(32).Type()},
+		{parquet.Uint(16).Type(), parquet.Uint(64).Type(), parquet.Uint(64).Type()},
+	} {
+		for _, pair := range [][2]parquet.Type{{tc.a, tc.b}, {tc.b, tc.a}} {
+			got, err := merge(pair[0], pair[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameType(got, tc.want) {
+				t.Errorf("%v and %v: expected %v, got %v", pair[0], pair[1], tc.want, got)
+			}
+		}
+	}
+	got, err = merge(parquet.Timestamp(parquet.Millisecond).Type(), parquet.Timestamp(parquet.Microsecond).Type())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lt := got.LogicalType(); lt.Timestamp == nil || lt.Timestamp.Unit.Micros == nil {
+		t.Fatalf("expected microsecond timestamps, got %v", got)
+	}
+}
+
+func TestDatasetWidensDecimals(t *testing.T) {
+	type v1 struct {
+		Price int32 `parquet:"price,decimal(2:9)"`
+	}
+	type v2 struct {
+		Price int64 `parquet:"price,decimal(2:18)"`
+	}
+	dir := t.TempDir()
+	if err := parquet.WriteFile(filepath.Join(dir, "a.parquet"), []v1{{Price: 150}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := parquet.WriteFile(filepath.Join(dir, "b.parquet"), []v2{{Price: 1 << 40}}); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewDatasetExec(dir, DatasetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	out := drain(t, ds)
+	var got []string
+	for _, v := range out.Columns[0] {
+		got = append(got, formatValue(v))
+	}
+	if strings.Join(got, " ") != "1.50 10995116277.76" {
+		t.Fatalf("expected the decimals with their scale, got %v", got)
+	}
+}
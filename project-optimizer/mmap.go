@@ -0,0 +1,461 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/encoding/rle"
+	"github.com/parquet-go/parquet-go/encoding/thrift"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+/*
+memory mapped parquet files.
+
+an MmapSource maps a local file once, reads of the leaf copy out of the
+mapping instead of calling pread for every page. that is all the Mmap
+options change: the values of a RecordBatch are go values decoded from the
+pages, none of them points into the mapping. ReadArrowColumns goes
+further: plain encoded, uncompressed pages of fixed width columns without
+NULLs are handed to arrow as they are, the values buffer of the array is a
+slice of the mapping. other pages are decoded into arrow builders.
+
+the mapping outlives Close while arrow buffers point into it: every such
+buffer holds a reference that is dropped when arrow releases it, the file is
+unmapped when the source is closed and the last buffer is released.
+*/
+
+// MmapSource is a Source over a memory mapped file, safe for concurrent use
+type MmapSource struct {
+	name string
+	mu   sync.RWMutex // held for reading while the mapping is copied from
+	data []byte
+	refs int // arrow buffers and page walks pointing into data
+	open bool
+}
+
+// OpenMmap maps the file at path
+func OpenMmap(path string) (*MmapSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // the mapping stays valid
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := mmapFile(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("mapping %s: %w", path, err)
+	}
+	return &MmapSource{name: path, data: data, open: true}, nil
+}
+
+func (m *MmapSource) Name() string {
+	return m.name
+}
+
+func (m *MmapSource) Size() int64 {
+	return int64(len(m.data))
+}
+
+func (m *MmapSource) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.open {
+		return 0, fmt.Errorf("read of closed %s", m.name)
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	return n, eofIfShort(n, p)
+}
+
+// Close unmaps the file once no arrow buffer points into it anymore
+func (m *MmapSource) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.open {
+		return nil
+	}
+	m.open = false
+	return m.unmapIfUnused()
+}
+
+// whether the file is still mapped
+func (m *MmapSource) mapped() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.data != nil
+}
+
+// must be called with mu held
+func (m *MmapSource) unmapIfUnused() error {
+	if m.open || m.refs > 0 || m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return munmap(data)
+}
+
+// slice of the mapping for an arrow buffer, released by arrow through
+// the allocator of the buffer
+func (m *MmapSource) buffer(off, n int64) (*memory.Buffer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.open {
+		return nil, fmt.Errorf("read of closed %s", m.name)
+	}
+	if off < 0 || off+n > int64(len(m.data)) {
+		return nil, fmt.Errorf("%s: bytes %d-%d out of range", m.name, off, off+n)
+	}
+	m.refs++
+	return memory.NewBufferWithAllocator(m.data[off:off+n:off+n], mappedAllocator{m}), nil
+}
+
+// frees buffers by dropping their reference to the mapping. the buffers are
+// only read and released, it never allocates or resizes and returns nil
+type mappedAllocator struct {
+	m *MmapSource
+}
+
+func (a mappedAllocator) Allocate(int) []byte {
+	return nil
+}
+
+func (a mappedAllocator) Reallocate(int, []byte) []byte {
+	return nil
+}
+
+func (a mappedAllocator) Free([]byte) {
+	a.m.unref()
+}
+
+// the mapping, kept mapped by a reference until unref is called so a
+// concurrent Close does not unmap it during a walk over its pages
+func (m *MmapSource) ref() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.open {
+		return nil, fmt.Errorf("read of closed %s", m.name)
+	}
+	m.refs++
+	return m.data, nil
+}
+
+func (m *MmapSource) unref() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refs--
+	m.unmapIfUnused()
+}
+
+// ReadArrowColumns reads top level columns of a mapped file into an arrow
+// table with a chunk per page, repeated columns are not supported. the table may point into the mapping, it
+// stays mapped until the table is released
+func ReadArrowColumns(src *MmapSource, columns []string, mem memory.Allocator) (arrow.Table, error) {
+	pf, err := openParquetFile(src, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]arrow.Field, len(columns))
+	chunked := make([]*arrow.Chunked, 0, len(columns))
+	release := func() {
+		for _, c := range chunked {
+			c.Release()
+		}
+	}
+	for i, name := range columns {
+		ref, ok := findNode(pf.Schema(), name)
+		if !ok || !ref.node.Leaf() || len(ref.path) != 1 {
+			release()
+			return nil, fmt.Errorf("%s: %q is not a top level column", src.name, name)
+		}
+		if ref.node.Repeated() {
+			release()
+			return nil, fmt.Errorf("%s: repeated column %q can not be read into arrow", src.name, name)
+		}
+		typ := arrowType(ref.node.Type())
+		if typ == nil {
+			release()
+			return nil, fmt.Errorf("%s: column %q of type %v can not be read into arrow", src.name, name, ref.node.Type())
+		}
+		fields[i] = arrow.Field{Name: ref.path[0], Type: typ, Nullable: ref.node.Optional()}
+		var arrays []arrow.Array
+		for g, rg := range pf.RowGroups() {
+			cm := &pf.Metadata().RowGroups[g].Columns[ref.column].MetaData
+			arrays, err = appendChunkArrays(arrays, src, cm, rg.ColumnChunks()[ref.column], typ, ref.node.Optional(), mem)
+			if err != nil {
+				for _, a := range arrays {
+					a.Release()
+				}
+				release()
+				return nil, fmt.Errorf("%s: column %q: %w", src.name, name, err)
+			}
+		}
+		chunked = append(chunked, arrow.NewChunked(typ, arrays))
+		for _, a := range arrays {
+			a.Release() // the chunked column holds them now
+		}
+	}
+	cols := make([]arrow.Column, len(chunked))
+	for i, c := range chunked {
+		cols[i] = *arrow.NewColumn(fields[i], c)
+	}
+	table := array.NewTable(arrow.NewSchema(fields, nil), cols, -1)
+	for i := range cols {
+		cols[i].Release()
+	}
+	release()
+	return table, nil
+}
+
+// arrow type of a flat parquet column, nil when it has none here
+func arrowType(t parquet.Type) arrow.DataType {
+	lt := t.LogicalType()
+	switch t.Kind() {
+	case parquet.Boolean:
+		return arrow.FixedWidthTypes.Boolean
+	case parquet.Int32:
+		if lt != nil && lt.Date != nil {
+			return arrow.FixedWidthTypes.Date32
+		}
+		if lt != nil && (lt.Decimal != nil || lt.Time != nil) {
+			return nil
+		}
+		return arrow.PrimitiveTypes.Int32
+	case parquet.Int64:
+		if lt != nil && lt.Timestamp != nil {
+			unit := arrow.Nanosecond
+			switch {
+			case lt.Timestamp.Unit.Millis != nil:
+				unit = arrow.Millisecond
+			case lt.Timestamp.Unit.Micros != nil:
+				unit = arrow.Microsecond
+			}
+			return &arrow.TimestampType{Unit: unit, TimeZone: "UTC"}
+		}
+		if lt != nil && (lt.Decimal != nil || lt.Time != nil) {
+			return nil
+		}
+		return arrow.PrimitiveTypes.Int64
+	case parquet.Float:
+		return arrow.PrimitiveTypes.Float32
+	case parquet.Double:
+		return arrow.PrimitiveTypes.Float64
+	case parquet.ByteArray:
+		if lt != nil && (lt.UTF8 != nil || lt.Enum != nil || lt.Json != nil) {
+			return arrow.BinaryTypes.String
+		}
+		if lt != nil && lt.Decimal != nil {
+			return nil
+		}
+		return arrow.BinaryTypes.Binary
+	}
+	return nil
+}
+
+// appends an array per data page of chunk, the pages are walked in the
+// mapping so their values can be shared
+func appendChunkArrays(arrays []arrow.Array, src *MmapSource, cm *format.ColumnMetaData, chunk parquet.ColumnChunk, typ arrow.DataType, optional bool, mem memory.Allocator) ([]arrow.Array, error) {
+	data, err := src.ref()
+	if err != nil {
+		return arrays, err
+	}
+	defer src.unref()
+	first := len(arrays)
+	width := 0 // bits of a value, 0 for the types that are always decoded
+	if fw, ok := typ.(arrow.FixedWidthDataType); ok && typ.ID() != arrow.BOOL {
+		width = fw.BitWidth()
+	}
+	zeroCopy := cm.Codec == format.Uncompressed && width > 0
+
+	off := cm.DataPageOffset
+	if cm.DictionaryPageOffset > 0 && cm.DictionaryPageOffset < off {
+		off = cm.DictionaryPageOffset
+	}
+	end := off + cm.TotalCompressedSize
+	if off < 0 || cm.TotalCompressedSize < 0 || end > int64(len(data)) {
+		return arrays, fmt.Errorf("column chunk bytes %d-%d out of range", off, end)
+	}
+	var header format.PageHeader
+	for off < end {
+		header = format.PageHeader{}
+		r := bytes.NewReader(data[off:end])
+		if err := thrift.NewDecoder(new(thrift.CompactProtocol).NewReader(r)).Decode(&header); err != nil {
+			return arrays, fmt.Errorf("page header at %d: %w", off, err)
+		}
+		body := off + int64(r.Size()) - int64(r.Len())
+		next := body + int64(header.CompressedPageSize)
+		if header.CompressedPageSize < 0 || next > end {
+			return arrays, fmt.Errorf("page at %d of %d bytes overruns its column chunk", off, header.CompressedPageSize)
+		}
+		var page *mappedPage
+		switch {
+		case !zeroCopy:
+		case header.Type == format.DataPage && header.DataPageHeader.Encoding == format.Plain:
+			page = &mappedPage{values: int(header.DataPageHeader.NumValues), data: body, end: next}
+			if optional {
+				// definition levels prefixed by their length, in the same buffer as the values
+				n := int64(binaryLength(data[body:next]))
+				if body+4+n > next {
+					return arrays, fmt.Errorf("page at %d has %d bytes of definition levels past its end", off, n)
+				}
+				page.levels = data[body+4 : body+4+n]
+				page.data = body + 4 + n
+			}
+		case header.Type == format.DataPageV2 && header.DataPageHeaderV2.Encoding == format.Plain &&
+			header.DataPageHeaderV2.RepetitionLevelsByteLength == 0:
+			h := header.DataPageHeaderV2
+			page = &mappedPage{values: int(h.NumValues), data: body + int64(h.DefinitionLevelsByteLength), end: next}
+			if h.DefinitionLevelsByteLength < 0 || page.data > next {
+				return arrays, fmt.Errorf("page at %d has %d bytes of definition levels past its end", off, h.DefinitionLevelsByteLength)
+			}
+			if optional {
+				page.levels = data[body:page.data]
+				page.noNulls = h.NumNulls == 0
+			}
+		}
+		if page != nil {
+			a, err := page.array(src, typ, width, optional, mem)
+			if err != nil {
+				return arrays, err
+			}
+			if a != nil {
+				arrays = append(arrays, a)
+				off = next
+				continue
+			}
+		}
+		if header.Type == format.DataPage || header.Type == format.DataPageV2 {
+			// the whole chunk is decoded, pages shared so far are dropped
+			for _, a := range arrays[first:] {
+				a.Release()
+			}
+			return decodeChunkArrays(arrays[:first], chunk, typ, mem)
+		}
+		off = next
+	}
+	return arrays, nil
+}
+
+// a plain encoded, uncompressed data page in the mapping
+type mappedPage struct {
+	values    int
+	levels    []byte // definition levels of optional columns, rle encoded
+	noNulls   bool   // known from the header
+	data, end int64  // values
+}
+
+// shares the values of the page with arrow when it has no NULLs, nil when
+// the page has to be decoded
+func (p *mappedPage) array(src *MmapSource, typ arrow.DataType, width int, optional bool, mem memory.Allocator) (arrow.Array, error) {
+	if optional && !p.noNulls {
+		levels, err := (&rle.Encoding{BitWidth: 1}).DecodeLevels(nil, p.levels)
+		if err != nil {
+			return nil, err
+		}
+		if len(levels) < p.values {
+			return nil, fmt.Errorf("page of %d values has %d definition levels", p.values, len(levels))
+		}
+		for _, l := range levels[:p.values] {
+			if l == 0 {
+				return nil, nil // values of pages with NULLs are packed, they are decoded
+			}
+		}
+	}
+	n := int64(p.values * width / 8)
+	if p.values < 0 || p.end-p.data < n {
+		return nil, fmt.Errorf("page of %d values has %d bytes", p.values, p.end-p.data)
+	}
+	values, err := src.buffer(p.data, n)
+	if err != nil {
+		return nil, err
+	}
+	defer values.Release()
+	data := array.NewData(typ, p.values, []*memory.Buffer{nil, values}, nil, 0, 0)
+	defer data.Release()
+	return array.MakeFromData(data), nil
+}
+
+// little endian length prefix of the levels of a v1 page
+func binaryLength(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// decodes every page of chunk into arrow builders
+func decodeChunkArrays(arrays []arrow.Array, chunk parquet.ColumnChunk, typ arrow.DataType, mem memory.Allocator) ([]arrow.Array, error) {
+	pages := chunk.Pages()
+	defer pages.Close()
+	b := array.NewBuilder(mem, typ)
+	defer b.Release()
+	values := make([]parquet.Value, 1024)
+	for {
+		page, err := pages.ReadPage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return arrays, err
+		}
+		r := page.Values()
+		for {
+			n, err := r.ReadValues(values)
+			for _, v := range values[:n] {
+				appendArrowValue(b, v)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				parquet.Release(page)
+				return arrays, err
+			}
+		}
+		parquet.Release(page)
+	}
+	return append(arrays, b.NewArray()), nil
+}
+
+func appendArrowValue(b array.Builder, v parquet.Value) {
+	if v.IsNull() {
+		b.AppendNull()
+		return
+	}
+	switch b := b.(type) {
+	case *array.BooleanBuilder:
+		b.Append(v.Boolean())
+	case *array.Int32Builder:
+		b.Append(v.Int32())
+	case *array.Date32Builder:
+		b.Append(arrow.Date32(v.Int32()))
+	case *array.Int64Builder:
+		b.Append(v.Int64())
+	case *array.TimestampBuilder:
+		b.Append(arrow.Timestamp(v.Int64()))
+	case *array.Float32Builder:
+		b.Append(v.Float())
+	case *array.Float64Builder:
+		b.Append(v.Double())
+	case *array.StringBuilder:
+		b.BinaryBuilder.Append(v.ByteArray())
+	case *array.BinaryBuilder:
+		b.Append(v.ByteArray())
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package projectoptimizer
+
+import (
+	"io"
+	"os"
+)
+
+// without mmap the file is read into memory, slices of it are still shared
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(f, 0, size), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmap([]byte) error {
+	return nil
+}
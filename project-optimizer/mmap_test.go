@@ -0,0 +1,268 @@
+package projectoptimizer
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/encoding/thrift"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+type mmapRow struct {
+	ID    int64   `parquet:"id"`
+	Score float64 `parquet:"score"`
+	Bonus *int32  `parquet:"bonus,optional"`
+	Name  string  `parquet:"name"`
+}
+
+func writeMmapFile(t *testing.T, options ...parquet.WriterOption) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mmap.parquet")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	options = append([]parquet.WriterOption{parquet.MaxRowsPerRowGroup(400), parquet.PageBufferSize(1024)}, options...)
+	w := parquet.NewGenericWriter[mmapRow](f, options...)
+	rows := make([]mmapRow, 1000)
+	for i := range rows {
+		rows[i] = mmapRow{ID: int64(i), Score: float64(i) / 4, Name: string(rune('a' + i%26))}
+		// NULL in the first row group, some NULLs in the last one
+		if bonus := int32(i); i >= 400 && (i < 800 || i >= 900 || i%2 == 0) {
+			rows[i].Bonus = &bonus
+		}
+	}
+	if _, err := w.Write(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// whether b lies in the mapping of src
+func inMapping(src *MmapSource, b []byte) bool {
+	if len(b) == 0 || len(src.data) == 0 {
+		return false
+	}
+	start := uintptr(unsafe.Pointer(&src.data[0]))
+	p := uintptr(unsafe.Pointer(&b[0]))
+	return p >= start && p < start+uintptr(len(src.data))
+}
+
+func TestMmapArrowZeroCopy(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		path := writeMmapFile(t, parquet.Compression(&parquet.Uncompressed), parquet.DataPageVersion(version))
+		src, err := OpenMmap(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns := []string{"id", "score", "name", "bonus"}
+		if version == 1 {
+			// parquet-go writes v1 pages of optional columns with an extra
+			// repetition level section, other readers do not expect it
+			columns = columns[:3]
+		}
+		mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+		table, err := ReadArrowColumns(src, columns, mem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.NumRows() != 1000 {
+			t.Fatalf("v%d: expected 1000 rows, got %d", version, table.NumRows())
+		}
+
+		ids := table.Column(0).Data()
+		if len(ids.Chunks()) < 3 {
+			t.Fatalf("v%d: expected an array per page, got %d", version, len(ids.Chunks()))
+		}
+		var next int64
+		for _, chunk := range ids.Chunks() {
+			if !inMapping(src, chunk.Data().Buffers()[1].Bytes()) {
+				t.Fatalf("v%d: expected ids to point into the mapping", version)
+			}
+			for _, id := range chunk.(*array.Int64).Int64Values() {
+				if id != next {
+					t.Fatalf("v%d: expected id %d, got %d", version, next, id)
+				}
+				next++
+			}
+		}
+		if version == 2 {
+			// pages of the row groups with NULLs are decoded
+			shared, nulls := 0, 0
+			for _, chunk := range table.Column(3).Data().Chunks() {
+				if inMapping(src, chunk.Data().Buffers()[1].Bytes()) {
+					shared++
+				}
+				nulls += chunk.NullN()
+			}
+			if shared == 0 || nulls != 400+50 {
+				t.Fatalf("expected shared pages and 450 NULLs, got %d shared and %d NULLs", shared, nulls)
+			}
+		}
+		names := table.Column(2).Data().Chunk(0).(*array.String)
+		if names.Value(27) != "b" {
+			t.Fatalf("v%d: expected name b, got %q", version, names.Value(27))
+		}
+
+		// the mapping outlives Close until arrow is done with it
+		if err := src.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := src.ReadAt(make([]byte, 1), 0); err == nil {
+			t.Fatalf("v%d: expected reads of a closed source to fail", version)
+		}
+		if !src.mapped() || ids.Chunk(0).(*array.Int64).Value(1) != 1 {
+			t.Fatalf("v%d: expected the mapping to be kept while arrays use it", version)
+		}
+		table.Release()
+		if src.mapped() {
+			t.Fatalf("v%d: expected the file to be unmapped once the table is released", version)
+		}
+		mem.AssertSize(t, 0)
+	}
+}
+
+func TestMmapArrowDecodesCompressedPages(t *testing.T) {
+	src, err := OpenMmap(writeMmapFile(t, parquet.Compression(&parquet.Snappy)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	table, err := ReadArrowColumns(src, []string{"score", "bonus"}, memory.NewGoAllocator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Release()
+	var scores []float64
+	for _, chunk := range table.Column(0).Data().Chunks() {
+		if inMapping(src, chunk.Data().Buffers()[1].Bytes()) {
+			t.Fatalf("expected compressed pages to be decoded")
+		}
+		scores = append(scores, chunk.(*array.Float64).Float64Values()...)
+	}
+	if len(scores) != 1000 || scores[10] != 2.5 {
+		t.Fatalf("unexpected scores %v", scores[:11])
+	}
+	if table.Column(1).DataType().ID() != arrow.INT32 || table.Column(1).NullN() != 450 {
+		t.Fatalf("expected an int32 bonus with 450 NULLs, got %v with %d", table.Column(1).DataType(), table.Column(1).NullN())
+	}
+}
+
+func TestMmapArrowRejectsRepeatedAndCorruptPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repeated.parquet")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type repeatedRow struct {
+		Xs []int32 `parquet:"xs"`
+	}
+	w := parquet.NewGenericWriter[repeatedRow](f, parquet.Compression(&parquet.Uncompressed), parquet.DataPageVersion(1))
+	if _, err := w.Write([]repeatedRow{{Xs: []int32{1, 2}}, {Xs: []int32{3}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	src, err := OpenMmap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if _, err := ReadArrowColumns(src, []string{"xs"}, memory.NewGoAllocator()); err == nil {
+		t.Errorf("expected an error reading a repeated column")
+	}
+
+	// a v1 page of an optional column whose definition levels claim more
+	// bytes than the page has
+	header, err := thrift.Marshal(new(thrift.CompactProtocol), &format.PageHeader{
+		Type:                 format.DataPage,
+		UncompressedPageSize: 8,
+		CompressedPageSize:   8,
+		DataPageHeader:       &format.DataPageHeader{NumValues: 1, Encoding: format.Plain},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(header, 0xe8, 0x03, 0, 0, 1, 0, 0, 0)
+	corrupt := &MmapSource{name: "corrupt", data: data, open: true}
+	cm := &format.ColumnMetaData{Codec: format.Uncompressed, TotalCompressedSize: int64(len(data))}
+	if _, err := appendChunkArrays(nil, corrupt, cm, nil, arrow.PrimitiveTypes.Int32, true, memory.NewGoAllocator()); err == nil {
+		t.Errorf("expected an error for definition levels past the end of the page")
+	}
+}
+
+func TestMmapLeaf(t *testing.T) {
+	f, err := os.Open("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	columns := []string{"country", "lat", "date"}
+	want, err := NewProjectExecLeaf(f, columns, nil).Next(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := OpenInput("../data/history.parquet", InputOptions{Columns: columns, Mmap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := in.Next(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := in.Operator.(*ProjectExec).leaf.src.(*MmapSource)
+	if err := in.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Columns, want.Columns) {
+		t.Fatalf("rows read from the mapping differ from the file")
+	}
+	if src.mapped() {
+		t.Fatalf("expected Close to unmap the file")
+	}
+}
+
+// the leaf copies out of the mapping, zero copy columns come from
+// ReadArrowColumns over the same source
+func TestMmapLeafCopies(t *testing.T) {
+	path := writeMmapFile(t, parquet.Compression(&parquet.Uncompressed))
+	in, err := OpenInput(path, InputOptions{Columns: []string{"id", "name"}, Mmap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	batch, err := in.Next(1000)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	src := in.Operator.(*ProjectExec).leaf.src.(*MmapSource)
+	for _, v := range batch.Columns[1] {
+		if s := v.(string); inMapping(src, unsafe.Slice(unsafe.StringData(s), len(s))) {
+			t.Fatalf("expected %q to be copied out of the mapping", s)
+		}
+	}
+	table, err := ReadArrowColumns(src, []string{"id"}, memory.NewGoAllocator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Release()
+	for _, chunk := range table.Column(0).Data().Chunks() {
+		if !inMapping(src, chunk.Data().Buffers()[1].Bytes()) {
+			t.Fatalf("expected ids to point into the mapping")
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+/*
+nested columns: structs, lists and maps.
+
+a nested column keeps the parquet node it was read from in structField.Node,
+its values in a RecordBatch are
+  - struct: map[string]any keyed by field name
+  - list and repeated fields: []any
+  - map: map[string]any, keys are formatted as text
+
+rows are flat in parquet, every leaf column holds its values with repetition
+and definition levels. the assembler rebuilds the nested values of a row from
+those levels and stripe does the opposite when writing.
+
+struct fields can be projected with dotted paths like address.city, only the
+leaf columns under the path are read.
+*/
+
+// nodeField builds the structField of a node, optional is set when one of
+// the groups enclosing the node is optional
+func nodeField(name string, node parquet.Node, optional bool) structField {
+	field := structField{Name: name, PqType: node.Type(), Optional: optional || node.Optional()}
+	if !node.Leaf() || node.Repeated() {
+		if field.Optional && !node.Optional() && !node.Repeated() {
+			node = parquet.Optional(node)
+		}
+		field.Node = node
+	}
+	return field
+}
+
+// same field but allowed to hold NULL
+func optionalField(field structField) structField {
+	field.Optional = true
+	if field.Node != nil && !field.Node.Optional() && !field.Node.Repeated() {
+		field.Node = parquet.Optional(field.Node)
+	}
+	return field
+}
+
+// group types (struct, list, map) have no physical type
+func isGroupType(t parquet.Type) bool {
+	return t != nil && t.PhysicalType() == nil
+}
+
+// plain groups can be walked into with dotted paths, lists and maps can not
+func isStruct(node parquet.Node) bool {
+	if node.Leaf() || node.Repeated() {
+		return false
+	}
+	lt := node.Type().LogicalType()
+	return lt == nil || lt.List == nil && lt.Map == nil
+}
+
+// number of leaf columns under a node
+func numLeaves(node parquet.Node) int {
+	if node.Leaf() {
+		return 1
+	}
+	n := 0
+	for _, field := range node.Fields() {
+		n += numLeaves(field)
+	}
+	return n
+}
+
+// repeated child of a LIST group and the name of the element field inside of
+// it. element is empty for two level lists where the repeated child is the
+// element itself
+func listLayout(node parquet.Node) (repeated parquet.Field, element string, ok bool) {
+	if node.Leaf() {
+		return nil, "", false
+	}
+	lt := node.Type().LogicalType()
+	fields := node.Fields()
+	if lt == nil || lt.List == nil || len(fields) != 1 || !fields[0].Repeated() {
+		return nil, "", false
+	}
+	repeated = fields[0]
+	// a repeated group named array holds a struct element (backward compatibility rules)
+	if !repeated.Leaf() && len(repeated.Fields()) == 1 && repeated.Name() != "array" {
+		element = repeated.Fields()[0].Name()
+	}
+	return repeated, element, true
+}
+
+// repeated key_value child of a MAP group
+func mapLayout(node parquet.Node) (parquet.Field, bool) {
+	if node.Leaf() {
+		return nil, false
+	}
+	lt := node.Type().LogicalType()
+	fields := node.Fields()
+	if lt == nil || lt.Map == nil || len(fields) != 1 || !fields[0].Repeated() ||
+		fields[0].Leaf() || len(fields[0].Fields()) != 2 {
+		return nil, false
+	}
+	return fields[0], true
+}
+
+// node an element of a list column is stored as, ok is false for anything
+// but lists and repeated fields
+func listElement(node parquet.Node) (parquet.Node, bool) {
+	if node.Repeated() {
+		return parquet.Required(node), true
+	}
+	repeated, element, ok := listLayout(node)
+	if !ok {
+		return nil, false
+	}
+	if element != "" {
+		return repeated.Fields()[0], true
+	}
+	return parquet.Required(repeated), true
+}
+
+// user facing name of a nested type, e.g. list<string> or struct<city: string>
+func fieldTypeName(field structField) string {
+	if field.Node == nil {
+		return typeName(field.PqType)
+	}
+	return nodeTypeName(field.Node)
+}
+
+func nodeTypeName(node parquet.Node) string {
+	if elem, ok := listElement(node); ok {
+		return "list<" + nodeTypeName(elem) + ">"
+	}
+	if kv, ok := mapLayout(node); ok {
+		entry := kv.Fields()
+		return "map<" + nodeTypeName(entry[0]) + ", " + nodeTypeName(entry[1]) + ">"
+	}
+	if node.Leaf() {
+		return typeName(node.Type())
+	}
+	var parts []string
+	for _, field := range node.Fields() {
+		parts = append(parts, field.Name()+": "+nodeTypeName(field))
+	}
+	return "struct<" + strings.Join(parts, ", ") + ">"
+}
+
+// position of a node inside of a schema
+type nodeRef struct {
+	path     []string
+	node     parquet.Node
+	column   int  // first leaf column under the node
+	def, rep int  // levels of the groups enclosing the node
+	optional bool // the node or one of the groups enclosing it may be NULL
+}
+
+// resolves a top level field or a dotted path through struct fields, names
+// are matched case insensitively
+func findNode(root parquet.Node, name string) (nodeRef, bool) {
+	top := nodeRef{node: root}
+	if ref, ok := top.child(name); ok {
+		return ref, true
+	}
+	ref := top
+	for _, part := range strings.Split(name, ".") {
+		if len(ref.path) > 0 && !isStruct(ref.node) {
+			return nodeRef{}, false
+		}
+		next, ok := ref.child(part)
+		if !ok {
+			return nodeRef{}, false
+		}
+		ref = next
+	}
+	return ref, len(ref.path) > 1
+}
+
+func (r nodeRef) child(name string) (nodeRef, bool) {
+	if r.node.Leaf() {
+		return nodeRef{}, false
+	}
+	def, rep := r.def, r.rep
+	if len(r.path) > 0 {
+		if r.node.Optional() || r.node.Repeated() {
+			def++
+		}
+		if r.node.Repeated() {
+			rep++
+		}
+	}
+	column := r.column
+	for _, field := range r.node.Fields() {
+		if strings.EqualFold(field.Name(), name) {
+			return nodeRef{
+				path:     append(r.path[:len(r.path):len(r.path)], field.Name()),
+				node:     field,
+				column:   column,
+				def:      def,
+				rep:      rep,
+				optional: r.optional || field.Optional(),
+			}, true
+		}
+		column += numLeaves(field)
+	}
+	return nodeRef{}, false
+}
+
+// resolves a dotted path into the struct columns of the schema
+func (p *parquetSchema) fieldByPath(name string) (structField, bool) {
+	head, _, ok := strings.Cut(name, ".")
+	if !ok {
+		return structField{}, false
+	}
+	for _, top := range p.Fields {
+		if !strings.EqualFold(top.Name, head) || top.Node == nil {
+			continue
+		}
+		ref, ok := findNode(parquet.Group{top.Name: top.Node}, name)
+		if !ok {
+			return structField{}, false
+		}
+		return nodeField(strings.Join(ref.path, "."), ref.node, ref.optional), true
+	}
+	return structField{}, false
+}
+
+// copy of a group with only the given paths, a path ending at a node keeps
+// everything under it
+func prunedGroup(node parquet.Node, paths [][]string) parquet.Group {
+	group := parquet.Group{}
+	for _, field := range node.Fields() {
+		var sub [][]string
+		whole, found := false, false
+		for _, path := range paths {
+			if path[0] != field.Name() {
+				continue
+			}
+			found = true
+			if len(path) == 1 {
+				whole = true
+			} else {
+				sub = append(sub, path[1:])
+			}
+		}
+		switch {
+		case !found:
+		case whole:
+			group[field.Name()] = field
+		case field.Optional():
+			group[field.Name()] = parquet.Optional(prunedGroup(field, sub))
+		default:
+			group[field.Name()] = prunedGroup(field, sub)
+		}
+	}
+	return group
+}
+
+// rebuilds nested values from the leaf columns of a row
+type assembler struct {
+	values [][]parquet.Value // values of the row by leaf column index
+	next   []int             // position of the next unread value of every column
+}
+
+func newAssembler(columns int) *assembler {
+	return &assembler{values: make([][]parquet.Value, columns), next: make([]int, columns)}
+}
+
+func (a *assembler) reset(row parquet.Row) {
+	clear(a.values)
+	row.Range(func(column int, values []parquet.Value) bool {
+		if column < len(a.values) {
+			a.values[column] = values
+		}
+		return true
+	})
+}
+
+// value of the node at ref for the current row
+func (a *assembler) field(ref *nodeRef) any {
+	// fields may share columns, e.g. address and address.city
+	for c := ref.column; c < ref.column+numLeaves(ref.node); c++ {
+		a.next[c] = 0
+	}
+	return a.read(ref.node, ref.column, ref.def, ref.rep)
+}
+
+func (a *assembler) peek(column int) (parquet.Value, bool) {
+	if a.next[column] < len(a.values[column]) {
+		return a.values[column][a.next[column]], true
+	}
+	return parquet.Value{}, false
+}
+
+// steps over the single value every column under a NULL or empty node has
+func (a *assembler) skip(node parquet.Node, column int) {
+	for c := column; c < column+numLeaves(node); c++ {
+		a.next[c]++
+	}
+}
+
+// def and rep are the levels of the groups enclosing node
+func (a *assembler) read(node parquet.Node, column, def, rep int) any {
+	v, _ := a.peek(column)
+	if v.DefinitionLevel() < def {
+		// one of the enclosing groups is NULL
+		a.skip(node, column)
+		return nil
+	}
+	switch {
+	case node.Repeated():
+		def, rep = def+1, rep+1
+		if v.DefinitionLevel() < def {
+			a.skip(node, column)
+			return []any{}
+		}
+		var items []any
+		for {
+			items = append(items, a.value(node, column, def, rep))
+			next, ok := a.peek(column)
+			if !ok || next.RepetitionLevel() != rep {
+				return items
+			}
+		}
+	case node.Optional():
+		def++
+		if v.DefinitionLevel() < def {
+			a.skip(node, column)
+			return nil
+		}
+	}
+	return a.value(node, column, def, rep)
+}
+
+// value of a node that is known to be present
+func (a *assembler) value(node parquet.Node, column, def, rep int) any {
+	if node.Leaf() {
+		v, _ := a.peek(column)
+		a.next[column]++
+		return columnValue(structField{PqType: node.Type()}, v)
+	}
+	if repeated, element, ok := listLayout(node); ok {
+		items, _ := a.read(repeated, column, def, rep).([]any)
+		if element != "" {
+			for i, item := range items {
+				items[i] = item.(map[string]any)[element]
+			}
+		}
+		return items
+	}
+	if kv, ok := mapLayout(node); ok {
+		entries, _ := a.read(kv, column, def, rep).([]any)
+		names := kv.Fields()
+		m := make(map[string]any, len(entries))
+		for _, entry := range entries {
+			e := entry.(map[string]any)
+			m[textValue(e[names[0].Name()])] = e[names[1].Name()]
+		}
+		return m
+	}
+	out := make(map[string]any, len(node.Fields()))
+	for _, field := range node.Fields() {
+		out[field.Name()] = a.read(field, column, def, rep)
+		column += numLeaves(field)
+	}
+	return out
+}
+
+// appends the leaf values of v to columns, the inverse of assembler.read.
+// def and rep are the levels of the groups enclosing node, first is the
+// repetition level the first value of every column is written with
+func stripe(columns [][]parquet.Value, node parquet.Node, column int, v any, def, first, rep int) error {
+	switch {
+	case node.Repeated():
+		items, ok := v.([]any)
+		if v != nil && !ok {
+			return fmt.Errorf("cannot store %T in a repeated column", v)
+		}
+		if len(items) == 0 {
+			appendNulls(columns, node, column, def, first)
+			return nil
+		}
+		for i, item := range items {
+			r := first
+			if i > 0 {
+				r = rep + 1
+			}
+			if err := stripeValue(columns, node, column, item, def+1, r, rep+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case node.Optional():
+		if v == nil {
+			appendNulls(columns, node, column, def, first)
+			return nil
+		}
+		def++
+	case v == nil:
+		return fmt.Errorf("NULL in a required column")
+	}
+	return stripeValue(columns, node, column, v, def, first, rep)
+}
+
+func stripeValue(columns [][]parquet.Value, node parquet.Node, column int, v any, def, first, rep int) error {
+	if node.Leaf() {
+		if v == nil {
+			return fmt.Errorf("NULL in a required column")
+		}
+		val, err := encodeValue(v, node.Type())
+		if err != nil {
+			return err
+		}
+		columns[column] = append(columns[column], val.Level(first, def, column))
+		return nil
+	}
+	if repeated, element, ok := listLayout(node); ok {
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("cannot store %T in a list column", v)
+		}
+		if element != "" {
+			wrapped := make([]any, len(items))
+			for i, item := range items {
+				wrapped[i] = map[string]any{element: item}
+			}
+			items = wrapped
+		}
+		return stripe(columns, repeated, column, items, def, first, rep)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("cannot store %T in a group column", v)
+	}
+	if kv, ok := mapLayout(node); ok {
+		names := kv.Fields()
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		entries := make([]any, len(keys))
+		for i, k := range keys {
+			entries[i] = map[string]any{names[0].Name(): k, names[1].Name(): m[k]}
+		}
+		return stripe(columns, kv, column, entries, def, first, rep)
+	}
+	for _, field := range node.Fields() {
+		if err := stripe(columns, field, column, m[field.Name()], def, first, rep); err != nil {
+			return fmt.Errorf("%s: %w", field.Name(), err)
+		}
+		column += numLeaves(field)
+	}
+	return nil
+}
+
+func appendNulls(columns [][]parquet.Value, node parquet.Node, column, def, rep int) {
+	for c := column; c < column+numLeaves(node); c++ {
+		columns[c] = append(columns[c], parquet.NullValue().Level(rep, def, c))
+	}
+}
+
+// values of a struct field for every struct in col, NULL where a struct on the path is NULL
+func structValues(col []any, path []string) []any {
+	out := make([]any, len(col))
+	for i, v := range col {
+		for _, name := range path {
+			m, ok := v.(map[string]any)
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[name]
+			if v == nil {
+				for k, item := range m {
+					if strings.EqualFold(k, name) {
+						v = item
+						break
+					}
+				}
+			}
+		}
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,242 @@
+package projectoptimizer
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+type nestedAddress struct {
+	City string `parquet:"city"`
+	Zip  *int32 `parquet:"zip,optional"`
+}
+
+type nestedItem struct {
+	Sku string `parquet:"sku"`
+	Qty int32  `parquet:"qty"`
+}
+
+type nestedRow struct {
+	ID      int64             `parquet:"id"`
+	Address *nestedAddress    `parquet:"address,optional"`
+	Tags    []string          `parquet:"tags,list"`
+	Attrs   map[string]string `parquet:"attrs"`
+	Scores  []int32           `parquet:"scores"`
+	Items   []nestedItem      `parquet:"items,list"`
+}
+
+func writeNestedFile(t *testing.T) *os.File {
+	t.Helper()
+	zip := int32(10115)
+	rows := []nestedRow{
+		{ID: 1, Address: &nestedAddress{City: "Berlin", Zip: &zip}, Tags: []string{"a", "b"},
+			Attrs: map[string]string{"color": "red"}, Scores: []int32{3, 4},
+			Items: []nestedItem{{Sku: "x", Qty: 1}, {Sku: "y", Qty: 2}}},
+		{ID: 2, Tags: []string{}, Attrs: map[string]string{}},
+		{ID: 3, Address: &nestedAddress{City: "Paris"}, Tags: []string{"c"}, Scores: []int32{5}},
+	}
+	path := filepath.Join(t.TempDir(), "nested.parquet")
+	if err := parquet.WriteFile(path, rows); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func readAll(t *testing.T, op Operator) RecordBatch {
+	t.Helper()
+	batch, err := op.Next(100)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	return batch
+}
+
+func TestNestedSchema(t *testing.T) {
+	f := writeNestedFile(t)
+	schema, err := ReadParquetSchema(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"id":      "int64",
+		"address": "struct<city: string, zip: int32>",
+		"tags":    "list<string>",
+		"attrs":   "map<string, string>",
+		"scores":  "list<int32>",
+		"items":   "list<struct<sku: string, qty: int32>>",
+	}
+	if len(schema.Fields) != len(want) {
+		t.Fatalf("expected %d top level columns, got %v", len(want), schema.toColumns())
+	}
+	for _, field := range schema.Fields {
+		if got := fieldTypeName(field); got != want[field.Name] {
+			t.Errorf("column %s: expected %s, got %s", field.Name, want[field.Name], got)
+		}
+	}
+	city, err := schema.ColumnInfo("Address.City")
+	if err != nil || city.Name != "address.city" || !city.Optional || city.Node != nil {
+		t.Fatalf("expected address.city to resolve to an optional leaf, got %+v %v", city, err)
+	}
+	if _, err := schema.ColumnInfo("tags.element"); err == nil {
+		t.Fatalf("expected dotted paths into lists to be rejected")
+	}
+}
+
+func TestNestedRead(t *testing.T) {
+	f := writeNestedFile(t)
+	leaf := NewProjectExecLeaf(f, []string{"id", "address", "tags", "attrs", "scores", "items"}, nil)
+	got := readAll(t, leaf)
+	want := [][]any{
+		{int64(1), int64(2), int64(3)},
+		{map[string]any{"city": "Berlin", "zip": int32(10115)}, nil, map[string]any{"city": "Paris", "zip": nil}},
+		{[]any{"a", "b"}, []any{}, []any{"c"}},
+		{map[string]any{"color": "red"}, map[string]any{}, map[string]any{}},
+		{[]any{int32(3), int32(4)}, []any{}, []any{int32(5)}},
+		{[]any{map[string]any{"sku": "x", "qty": int32(1)}, map[string]any{"sku": "y", "qty": int32(2)}}, []any{}, []any{}},
+	}
+	for c := range want {
+		if !reflect.DeepEqual(got.Columns[c], want[c]) {
+			t.Errorf("column %s: expected %#v, got %#v", got.Schema.Fields[c].Name, want[c], got.Columns[c])
+		}
+	}
+	if s := formatValue(got.Columns[5][0]); s != `[{"qty":1,"sku":"x"},{"qty":2,"sku":"y"}]` {
+		t.Fatalf("unexpected text form %s", s)
+	}
+}
+
+func TestNestedDottedProjection(t *testing.T) {
+	f := writeNestedFile(t)
+	leaf := NewProjectExecLeaf(f, []string{"address.city", "id"}, nil)
+	if n := len(leaf.leaf.r.Schema().Columns()); n != 2 {
+		t.Fatalf("expected only 2 leaf columns to be read, got %d", n)
+	}
+	got := readAll(t, leaf)
+	if want := []any{"Berlin", nil, "Paris"}; !reflect.DeepEqual(got.Columns[0], want) {
+		t.Fatalf("expected %v, got %v", want, got.Columns[0])
+	}
+
+	where, err := ParseExpr("address.city = 'Paris'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilterExec(NewProjectExecLeaf(f, []string{"id", "address.city"}, nil), where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, filter); got.NumRows() != 1 || got.Columns[0][0] != int64(3) {
+		t.Fatalf("expected only row 3, got %v", got.Columns)
+	}
+}
+
+func TestUnnest(t *testing.T) {
+	f := writeNestedFile(t)
+	leaf := NewProjectExecLeaf(f, []string{"id", "tags"}, nil)
+	unnest, err := NewUnnestExec(leaf, "tags", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field := unnest.Schema().Fields[1]; fieldTypeName(field) != "string" || !field.Optional {
+		t.Fatalf("expected tags to become an optional string column, got %+v", field)
+	}
+	var ids, tags []any
+	for {
+		batch, err := unnest.Next(2)
+		ids = append(ids, batch.Columns[0]...)
+		tags = append(tags, batch.Columns[1]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []any{int64(1), int64(1), int64(3)}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("expected ids %v, got %v", want, ids)
+	}
+	if want := []any{"a", "b", "c"}; !reflect.DeepEqual(tags, want) {
+		t.Fatalf("expected tags %v, got %v", want, tags)
+	}
+
+	keep, err := NewUnnestExec(NewProjectExecLeaf(f, []string{"id", "items"}, nil), "items", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readAll(t, keep)
+	if got.NumRows() != 4 || got.Columns[1][2] != nil || got.Columns[1][3] != nil {
+		t.Fatalf("expected empty lists to keep a NULL row, got %v", got.Columns)
+	}
+	if _, err := NewUnnestExec(NewProjectExecLeaf(f, []string{"id"}, nil), "id", false); err == nil {
+		t.Fatalf("expected an error when unnesting a flat column")
+	}
+}
+
+func TestNestedRoundTrip(t *testing.T) {
+	f := writeNestedFile(t)
+	leaf := NewProjectExecLeaf(f, []string{"id", "address", "tags", "attrs", "scores", "items"}, nil)
+	in := readAll(t, leaf)
+
+	out, err := os.CreateTemp(t.TempDir(), "copy-*.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w, err := NewParquetBatchWriter(out, leaf.Schema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteBatch(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	again := readAll(t, NewProjectExecLeaf(out, leaf.Schema().toColumns(), nil))
+	if !reflect.DeepEqual(again.Columns, in.Columns) {
+		t.Fatalf("nested values changed on the way through a file:\n%#v\n%#v", in.Columns, again.Columns)
+	}
+	// the columns are written in the order they were read in
+	info, err := out.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := parquet.OpenFile(out, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, field := range written.Schema().Fields() {
+		names = append(names, field.Name())
+	}
+	if want := leaf.Schema().toColumns(); !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected the columns %v, got %v", want, names)
+	}
+}
+
+func TestMapFloatKeys(t *testing.T) {
+	type row struct {
+		Weights map[float64]string `parquet:"weights"`
+	}
+	path := filepath.Join(t.TempDir(), "weights.parquet")
+	if err := parquet.WriteFile(path, []row{{Weights: map[float64]string{1.231: "a", 1.234: "b", 0.001: "c"}}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := readAll(t, NewProjectExecLeaf(f, []string{"weights"}, nil)).Columns[0][0]
+	want := map[string]any{"1.231": "a", "1.234": "b", "0.001": "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,64 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrPagerClosed is returned by writes to a pager the user quit before the
+// end of the result, it is not a failure of the query
+var ErrPagerClosed = errors.New("pager closed")
+
+// Pager pipes what is written to it through a pager program
+type Pager struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+}
+
+// DefaultPager is $PAGER, less -S when it is unset and less is installed,
+// empty otherwise
+func DefaultPager() string {
+	if pager, ok := os.LookupEnv("PAGER"); ok {
+		return pager
+	}
+	if _, err := exec.LookPath("less"); err == nil {
+		return "less -S"
+	}
+	return ""
+}
+
+// StartPager runs command, e.g. "less -S", on the screen out
+func StartPager(command string, out io.Writer) (*Pager, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty pager command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = out, os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &Pager{cmd: cmd, in: in}, nil
+}
+
+func (p *Pager) Write(b []byte) (int, error) {
+	n, err := p.in.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("%w: %v", ErrPagerClosed, err)
+	}
+	return n, nil
+}
+
+// Close ends the input of the pager and waits until it is quit
+func (p *Pager) Close() error {
+	p.in.Close()
+	return p.cmd.Wait()
+}
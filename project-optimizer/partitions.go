@@ -0,0 +1,321 @@
+package projectoptimizer
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// hive style partitioning: country=XX/year=YYYY/part-0.parquet
+
+// directory value used for NULL partition values
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+type partitionValue struct {
+	key, raw string
+}
+
+// key=value directories between root and the file, in order
+func parsePartitionPath(root, path string) ([]partitionValue, error) {
+	if isS3URL(root) {
+		// relative like local paths, without the scheme Clean would collapse
+		root, path = strings.TrimPrefix(root, "s3://"), strings.TrimPrefix(path, "s3://")
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	var values []partitionValue
+	for _, dir := range strings.Split(filepath.ToSlash(rel), "/") {
+		key, raw, ok := strings.Cut(dir, "=")
+		if !ok || key == "" {
+			continue
+		}
+		value, err := url.PathUnescape(raw)
+		if err != nil {
+			return nil, fmt.Errorf("partition directory %q: %w", dir, err)
+		}
+		values = append(values, partitionValue{key: key, raw: value})
+	}
+	return values, nil
+}
+
+// partition columns of a dataset and the value of each of them for every path.
+// every file must be partitioned by the same keys in the same order
+func inferPartitions(root string, paths []string) ([]structField, [][]any, error) {
+	parsed := make([][]partitionValue, len(paths))
+	for i, path := range paths {
+		values, err := parsePartitionPath(root, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed[i] = values
+	}
+	if len(paths) == 0 || len(parsed[0]) == 0 {
+		for i := range parsed {
+			if len(parsed[i]) > 0 {
+				return nil, nil, fmt.Errorf("%s is partitioned but %s is not", paths[i], paths[0])
+			}
+		}
+		return nil, nil, nil
+	}
+
+	keys := parsed[0]
+	fields := make([]structField, len(keys))
+	for k, key := range keys {
+		var cells []string
+		for i, values := range parsed {
+			if len(values) != len(keys) || !strings.EqualFold(values[k].key, key.key) {
+				return nil, nil, fmt.Errorf("%s is not partitioned like %s", paths[i], paths[0])
+			}
+			if values[k].raw != hiveDefaultPartition {
+				cells = append(cells, values[k].raw)
+			}
+		}
+		fields[k] = structField{Name: key.key, PqType: inferCellType(cells), Optional: true}
+	}
+
+	rows := make([][]any, len(paths))
+	for i, values := range parsed {
+		rows[i] = make([]any, len(fields))
+		for k, field := range fields {
+			if values[k].raw == hiveDefaultPartition {
+				continue
+			}
+			v, err := parseCell(values[k].raw, field.PqType)
+			if err != nil {
+				return nil, nil, fmt.Errorf("partition %s of %s: %w", field.Name, paths[i], err)
+			}
+			rows[i][k] = v
+		}
+	}
+	return fields, rows, nil
+}
+
+// directory the partition directories of a glob start in: its longest prefix without wildcards
+func globRoot(pattern string) string {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	var root []string
+	for _, part := range parts[:len(parts)-1] {
+		if strings.ContainsAny(part, `*?[\`) {
+			break
+		}
+		root = append(root, part)
+	}
+	if len(root) == 0 {
+		return "."
+	}
+	if len(root) == 1 && root[0] == "" {
+		return "/"
+	}
+	return filepath.FromSlash(strings.Join(root, "/"))
+}
+
+// key=value directory name for a partition value
+func partitionDir(key string, v any) string {
+	if v == nil {
+		return key + "=" + hiveDefaultPartition
+	}
+	return key + "=" + escapePartitionValue(textValue(v))
+}
+
+// percent encodes the characters that can not appear in a directory name
+func escapePartitionValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte(`"#%'*/:=?\{}[]^`, c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// partition files open at a time by default
+const defaultPartitionOpenFiles = 64
+
+// PartitionedWriter writes batches into a key=value directory layout under
+// root, a local directory or an s3:// prefix. The partition columns are encoded in
+// the directory names and not stored in the files.
+// at most maxOpen partition files are open at a time, the least recently
+// written one is closed above the limit and the next rows of its partition go
+// to a new part file
+type PartitionedWriter struct {
+	root       string
+	keys       []int // positions of the partition columns in incoming batches
+	data       []int // positions of the stored columns
+	fileSchema *parquetSchema
+	options    []parquet.WriterOption
+	parts      map[string]*partitionFile
+	order      []string
+	maxOpen    int
+	open       list.List // of *partitionFile with an open file, most recently written first
+}
+
+type partitionFile struct {
+	dir  string
+	f    io.WriteCloser
+	w    *ParquetBatchWriter
+	next int           // number of the next part file
+	elem *list.Element // in the open list, nil when the file is closed
+}
+
+func NewPartitionedWriter(root string, schema *parquetSchema, partitionBy []string, options ...parquet.WriterOption) (*PartitionedWriter, error) {
+	if len(partitionBy) == 0 {
+		return nil, fmt.Errorf("no partition columns given")
+	}
+	colIdx := schemaIndex(schema)
+	p := &PartitionedWriter{
+		root:       root,
+		fileSchema: &parquetSchema{},
+		options:    options,
+		parts:      make(map[string]*partitionFile),
+		maxOpen:    defaultPartitionOpenFiles,
+	}
+	isKey := make(map[int]bool)
+	for _, name := range partitionBy {
+		i, ok := colIdx[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("partition column %q not found in input schema", name)
+		}
+		p.keys = append(p.keys, i)
+		isKey[i] = true
+	}
+	for i, field := range schema.Fields {
+		if !isKey[i] {
+			p.data = append(p.data, i)
+			p.fileSchema.Fields = append(p.fileSchema.Fields, field)
+		}
+	}
+	if len(p.data) == 0 {
+		return nil, fmt.Errorf("every column is a partition column, nothing left to store")
+	}
+	return p, nil
+}
+
+func (p *PartitionedWriter) WriteBatch(batch RecordBatch) error {
+	groups := make(map[string]*RecordBatch)
+	var order []string
+	for row := 0; row < batch.NumRows(); row++ {
+		dirs := make([]string, len(p.keys))
+		for k, i := range p.keys {
+			dirs[k] = partitionDir(batch.Schema.Fields[i].Name, batch.Columns[i][row])
+		}
+		dir := filepath.Join(dirs...)
+		group, ok := groups[dir]
+		if !ok {
+			group = &RecordBatch{Schema: *p.fileSchema, Columns: make([][]any, len(p.data))}
+			groups[dir] = group
+			order = append(order, dir)
+		}
+		for c, i := range p.data {
+			group.Columns[c] = append(group.Columns[c], batch.Columns[i][row])
+		}
+	}
+	for _, dir := range order {
+		part, err := p.partition(dir)
+		if err != nil {
+			return err
+		}
+		if err := part.w.WriteBatch(*groups[dir]); err != nil {
+			return fmt.Errorf("writing partition %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// SetMaxOpenFiles limits the partition files open at a time, at least one
+func (p *PartitionedWriter) SetMaxOpenFiles(n int) error {
+	p.maxOpen = max(n, 1)
+	return p.closeOpen()
+}
+
+// opens a new part file of a partition on first use and after it was closed
+func (p *PartitionedWriter) partition(dir string) (*partitionFile, error) {
+	part, ok := p.parts[dir]
+	if !ok {
+		part = &partitionFile{dir: dir}
+		p.parts[dir] = part
+		p.order = append(p.order, dir)
+	}
+	if part.elem != nil {
+		p.open.MoveToFront(part.elem)
+		return part, nil
+	}
+	name := fmt.Sprintf("part-%05d.parquet", part.next)
+	f, err := CreateOutput(joinPath(p.root, filepath.ToSlash(dir), name))
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewParquetBatchWriter(f, p.fileSchema, p.options...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	part.f, part.w = f, w
+	part.next++
+	part.elem = p.open.PushFront(part)
+	return part, p.closeOpen()
+}
+
+// closes the least recently written files above maxOpen
+func (p *PartitionedWriter) closeOpen() error {
+	for p.open.Len() > p.maxOpen {
+		if err := p.closePart(p.open.Back().Value.(*partitionFile)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushes the open file of a partition, the first error is returned
+func (p *PartitionedWriter) closePart(part *partitionFile) error {
+	p.open.Remove(part.elem)
+	part.elem = nil
+	err := part.w.Close()
+	if err != nil {
+		err = fmt.Errorf("closing partition %s: %w", part.dir, err)
+	}
+	if cerr := part.f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	part.f, part.w = nil, nil
+	return err
+}
+
+// Partitions lists the partition directories written so far, relative to root
+func (p *PartitionedWriter) Partitions() []string {
+	return append([]string(nil), p.order...)
+}
+
+// Close flushes every open partition file, the first error is returned
+func (p *PartitionedWriter) Close() error {
+	var first error
+	for p.open.Len() > 0 {
+		if err := p.closePart(p.open.Back().Value.(*partitionFile)); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// WritePartitioned drains input into a partitioned layout under root,
+// returns the number of rows written
+func WritePartitioned(input Operator, root string, partitionBy []string, batchSize uint, options ...parquet.WriterOption) (int64, error) {
+	w, err := NewPartitionedWriter(root, input.Schema(), partitionBy, options...)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := CopyBatches(w, input, batchSize)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return rows, err
+}
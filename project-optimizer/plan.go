@@ -0,0 +1,495 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// logical plans: what a query reads and computes, before any operator is
+// built. NormalizePlan rewrites a plan into a canonical form so queries
+// asking for the same result in different ways render to the same text:
+// paths are absolute, column names lower cased, stacked filters merged into
+// one sorted conjunction, filters moved below projections and projections
+// folded into scans. BuildPlan turns a plan into operators, pushing filters
+// into the scans that can use them to prune.
+//
+//	filter((country = 'Angola' AND lat > 0))
+//	  scan(/data/history.parquet, columns=lat, lon)
+
+// Plan is a node of a logical plan
+type Plan interface {
+	Children() []Plan
+	String() string // the node alone, FormatPlan renders the whole tree
+}
+
+// ScanPlan reads a file, a dataset directory or glob, an s3:// or http url.
+// a filter right above a scan may use columns the scan does not return
+type ScanPlan struct {
+	Path    string
+	Format  string   // guessed from the extension when empty, directories are parquet datasets
+	Columns []string // empty reads every column
+}
+
+// FilterPlan keeps the rows where Where is true
+type FilterPlan struct {
+	Input Plan
+	Where Expr
+}
+
+// ProjectPlan keeps Columns, in their order
+type ProjectPlan struct {
+	Input   Plan
+	Columns []string
+}
+
+// SortPlan orders the rows by Keys
+type SortPlan struct {
+	Input Plan
+	Keys  []SortKey
+}
+
+// LimitPlan keeps the first N rows
+type LimitPlan struct {
+	Input Plan
+	N     int64
+}
+
+// AggregatePlan reduces the rows to one row of Aggregates
+type AggregatePlan struct {
+	Input      Plan
+	Aggregates []Aggregate
+}
+
+func (s *ScanPlan) Children() []Plan { return nil }
+func (s *ScanPlan) String() string {
+	out := "scan(" + s.Path
+	if s.Format != "" && s.Format != FormatParquet {
+		out += ", format=" + s.Format
+	}
+	if len(s.Columns) > 0 {
+		out += ", columns=" + columnList(s.Columns)
+	}
+	return out + ")"
+}
+
+func (f *FilterPlan) Children() []Plan { return []Plan{f.Input} }
+func (f *FilterPlan) String() string   { return "filter(" + f.Where.String() + ")" }
+
+func (p *ProjectPlan) Children() []Plan { return []Plan{p.Input} }
+func (p *ProjectPlan) String() string   { return "project(" + columnList(p.Columns) + ")" }
+
+func (s *SortPlan) Children() []Plan { return []Plan{s.Input} }
+func (s *SortPlan) String() string {
+	keys := make([]string, len(s.Keys))
+	for i, key := range s.Keys {
+		keys[i] = key.String()
+	}
+	return "sort(" + strings.Join(keys, ", ") + ")"
+}
+
+func (l *LimitPlan) Children() []Plan { return []Plan{l.Input} }
+func (l *LimitPlan) String() string   { return fmt.Sprintf("limit(%d)", l.N) }
+
+func (a *AggregatePlan) Children() []Plan { return []Plan{a.Input} }
+func (a *AggregatePlan) String() string {
+	aggrs := make([]string, len(a.Aggregates))
+	for i, aggr := range a.Aggregates {
+		aggrs[i] = aggr.String()
+	}
+	return "aggregate(" + strings.Join(aggrs, ", ") + ")"
+}
+
+func columnList(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, name := range columns {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// FormatPlan renders a plan one node per line, children indented below
+// their parent
+func FormatPlan(p Plan) string {
+	var sb strings.Builder
+	var write func(p Plan, depth int)
+	write = func(p Plan, depth int) {
+		sb.WriteString(strings.Repeat("  ", depth))
+		sb.WriteString(p.String())
+		sb.WriteByte('\n')
+		for _, child := range p.Children() {
+			write(child, depth+1)
+		}
+	}
+	write(p, 0)
+	return sb.String()
+}
+
+// NormalizePlan returns the canonical form of a plan, p is left untouched
+func NormalizePlan(p Plan) (Plan, error) {
+	switch p := p.(type) {
+	case *ScanPlan:
+		format, err := scanFormat(p.Path, p.Format)
+		if err != nil {
+			return nil, err
+		}
+		path := p.Path
+		if !isS3URL(path) && !isHTTPURL(path) {
+			if path, err = filepath.Abs(path); err != nil {
+				return nil, err
+			}
+		}
+		return &ScanPlan{Path: path, Format: format, Columns: lowerColumns(p.Columns)}, nil
+	case *FilterPlan:
+		if p.Where == nil {
+			return NormalizePlan(p.Input)
+		}
+		input, err := NormalizePlan(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		if project, ok := input.(*ProjectPlan); ok {
+			// the filter only sees projected columns, it can run before
+			return NormalizePlan(&ProjectPlan{Input: &FilterPlan{Input: project.Input, Where: p.Where}, Columns: project.Columns})
+		}
+		if sorted, ok := input.(*SortPlan); ok {
+			// fewer rows to sort
+			return NormalizePlan(&SortPlan{Input: &FilterPlan{Input: sorted.Input, Where: p.Where}, Keys: sorted.Keys})
+		}
+		ands := conjuncts(lowerExpr(p.Where))
+		if inner, ok := input.(*FilterPlan); ok {
+			ands = append(conjuncts(inner.Where), ands...)
+			input = inner.Input
+		}
+		return &FilterPlan{Input: input, Where: joinAnd(ands)}, nil
+	case *ProjectPlan:
+		input, err := NormalizePlan(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		columns := lowerColumns(p.Columns)
+		if len(columns) == 0 {
+			return input, nil
+		}
+		if inner, ok := input.(*ProjectPlan); ok {
+			input = inner.Input
+		}
+		if limit, ok := input.(*LimitPlan); ok {
+			// dropping columns keeps the rows
+			return NormalizePlan(&LimitPlan{Input: &ProjectPlan{Input: limit.Input, Columns: columns}, N: limit.N})
+		}
+		if sorted, ok := input.(*SortPlan); ok {
+			// the scan below a sort only reads the columns kept and sorted by
+			read := columns
+			for _, key := range sorted.Keys {
+				read = appendMissing(read, []string{key.Column})
+			}
+			if scan := planScan(sorted.Input); scan != nil && coversColumns(scan.Columns, read) {
+				narrowed, err := NormalizePlan(&ProjectPlan{Input: sorted.Input, Columns: read})
+				if err != nil {
+					return nil, err
+				}
+				input = &SortPlan{Input: narrowed, Keys: sorted.Keys}
+				if len(read) == len(columns) {
+					return input, nil
+				}
+			}
+		}
+		if scan, ok := input.(*ScanPlan); ok && coversColumns(scan.Columns, columns) {
+			folded := *scan
+			folded.Columns = columns
+			return &folded, nil
+		}
+		if filter, ok := input.(*FilterPlan); ok {
+			scan, ok := filter.Input.(*ScanPlan)
+			if ok && coversColumns(scan.Columns, appendMissing(columns, exprColumns(filter.Where))) {
+				folded := *scan
+				folded.Columns = columns
+				return &FilterPlan{Input: &folded, Where: filter.Where}, nil
+			}
+		}
+		return &ProjectPlan{Input: input, Columns: columns}, nil
+	case *SortPlan:
+		input, err := NormalizePlan(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]SortKey, len(p.Keys))
+		for i, key := range p.Keys {
+			keys[i] = SortKey{Column: strings.ToLower(key.Column), Desc: key.Desc}
+		}
+		return &SortPlan{Input: input, Keys: keys}, nil
+	case *LimitPlan:
+		if p.N < 0 {
+			return nil, fmt.Errorf("%w: negative limit %d", ErrInvalidQuery, p.N)
+		}
+		input, err := NormalizePlan(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		if inner, ok := input.(*LimitPlan); ok {
+			return &LimitPlan{Input: inner.Input, N: min(p.N, inner.N)}, nil
+		}
+		return &LimitPlan{Input: input, N: p.N}, nil
+	case *AggregatePlan:
+		input, err := NormalizePlan(p.Input)
+		if err != nil {
+			return nil, err
+		}
+		aggrs := make([]Aggregate, len(p.Aggregates))
+		var columns []string
+		for i, aggr := range p.Aggregates {
+			aggrs[i] = Aggregate{Func: strings.ToLower(aggr.Func), Column: strings.ToLower(aggr.Column)}
+			if aggr.Column != "*" {
+				columns = appendMissing(columns, []string{aggrs[i].Column})
+			}
+		}
+		if len(columns) > 0 {
+			// only read the aggregated columns
+			switch in := input.(type) {
+			case *ScanPlan, *FilterPlan:
+				if input, err = NormalizePlan(&ProjectPlan{Input: in, Columns: columns}); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return &AggregatePlan{Input: input, Aggregates: aggrs}, nil
+	}
+	return nil, fmt.Errorf("unsupported plan node %T", p)
+}
+
+// the scan of a scan or of a filter right above one, nil otherwise
+func planScan(p Plan) *ScanPlan {
+	if filter, ok := p.(*FilterPlan); ok {
+		p = filter.Input
+	}
+	scan, _ := p.(*ScanPlan)
+	return scan
+}
+
+// format of the files a scan reads
+func scanFormat(path, format string) (string, error) {
+	if format != "" {
+		return strings.ToLower(format), nil
+	}
+	guessed, err := FormatFromPath(path)
+	if err == nil {
+		return guessed, nil
+	}
+	if isS3URL(path) {
+		return FormatParquet, nil // a prefix of parquet objects
+	}
+	if info, statErr := os.Stat(path); statErr == nil && info.IsDir() {
+		return FormatParquet, nil
+	}
+	return "", err
+}
+
+func lowerColumns(columns []string) []string {
+	if len(columns) == 0 {
+		return nil
+	}
+	out := make([]string, len(columns))
+	for i, name := range columns {
+		out[i] = strings.ToLower(name)
+	}
+	return out
+}
+
+// whether reading have, every column when empty, provides want
+func coversColumns(have, want []string) bool {
+	if len(have) == 0 {
+		return true
+	}
+	for _, name := range want {
+		if indexFold(have, name) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ANDs conjuncts together in the order of their text, without duplicates
+func joinAnd(ands []Expr) Expr {
+	byText := make(map[string]Expr, len(ands))
+	var texts []string
+	for _, e := range ands {
+		text := e.String()
+		if _, ok := byText[text]; !ok {
+			byText[text] = e
+			texts = append(texts, text)
+		}
+	}
+	sort.Strings(texts)
+	out := byText[texts[0]]
+	for _, text := range texts[1:] {
+		out = &LogicalExpr{Op: "AND", Left: out, Right: byText[text]}
+	}
+	return out
+}
+
+// PlanOptions tune how a plan runs, they never change its result
+type PlanOptions struct {
+	Parallelism int         // files of a dataset scanned at once
+	Mmap        bool        // memory map local files, pages are read from the mapping
+	ChunkCache  *ChunkCache // decoded column chunks shared with other queries
+	HTTP        HTTPOptions // for http:// and https:// scans and s3:// objects
+	MemoryLimit int64       // bytes the operators of a query may reserve, <= 0 does not limit
+	SpillDir    string      // parent of the files sorts spill to, the system temp directory when empty
+	NoSpill     bool        // sorts fail with ErrMemoryLimitExceeded instead of spilling
+}
+
+// BuildPlan normalizes p and builds its operators, Close releases the
+// files they read
+func BuildPlan(p Plan, opts PlanOptions) (*Input, error) {
+	p, err := NormalizePlan(p)
+	if err != nil {
+		return nil, err
+	}
+	return buildPlan(p, opts)
+}
+
+// builds the operators of a normalized plan with a memory pool of their own
+func buildPlan(p Plan, opts PlanOptions) (*Input, error) {
+	mem := NewMemoryPool(opts.MemoryLimit)
+	in, err := buildNode(p, opts, mem)
+	if err != nil {
+		return nil, err
+	}
+	in.mem = mem
+	return in, nil
+}
+
+func buildNode(p Plan, opts PlanOptions, mem *MemoryPool) (*Input, error) {
+	switch p := p.(type) {
+	case *ScanPlan:
+		return buildScan(p, nil, p.Columns, opts, mem)
+	case *FilterPlan:
+		if scan, ok := p.Input.(*ScanPlan); ok {
+			return buildScan(scan, p.Where, scan.Columns, opts, mem)
+		}
+		in, err := buildNode(p.Input, opts, mem)
+		if err != nil {
+			return nil, err
+		}
+		op, err := NewFilterExec(in.Operator, p.Where)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in.Operator = meter(op, mem, "filter")
+		return in, nil
+	case *ProjectPlan:
+		in, err := buildNode(p.Input, opts, mem)
+		if err != nil {
+			return nil, err
+		}
+		op, err := projectColumns(in.Operator, p.Columns)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		// projections hand on the columns of their input, they hold nothing
+		in.Operator = op
+		return in, nil
+	case *SortPlan:
+		in, err := buildNode(p.Input, opts, mem)
+		if err != nil {
+			return nil, err
+		}
+		sorted, err := NewSortExec(in.Operator, p.Keys, SortOptions{Memory: mem, SpillDir: opts.SpillDir, NoSpill: opts.NoSpill})
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in.Operator, in.f = sorted, closeAll{sorted, in.f}
+		return in, nil
+	case *LimitPlan:
+		in, err := buildNode(p.Input, opts, mem)
+		if err != nil {
+			return nil, err
+		}
+		in.Operator = NewLimitExec(in.Operator, p.N)
+		return in, nil
+	case *AggregatePlan:
+		in, err := buildNode(p.Input, opts, mem)
+		if err != nil {
+			return nil, err
+		}
+		op, err := NewAggregateExec(in.Operator, p.Aggregates)
+		if err != nil {
+			in.Close()
+			return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+		}
+		in.Operator = meter(op, mem, "aggregate")
+		return in, nil
+	}
+	return nil, fmt.Errorf("unsupported plan node %T", p)
+}
+
+// reads columns of the rows of a scan where where is true. parquet datasets
+// prune files and row groups with where, other inputs are filtered row by row
+func buildScan(scan *ScanPlan, where Expr, columns []string, opts PlanOptions, mem *MemoryPool) (*Input, error) {
+	format, err := scanFormat(scan.Path, scan.Format)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatParquet && !isHTTPURL(scan.Path) {
+		d, err := NewDatasetExec(scan.Path, DatasetOptions{
+			Columns:     columns,
+			Where:       where,
+			Parallelism: opts.Parallelism,
+			Mmap:        opts.Mmap,
+			ChunkCache:  opts.ChunkCache,
+			HTTP:        opts.HTTP,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Input{Operator: meter(d, mem, "scan"), f: d}, nil
+	}
+	read := columns
+	if where != nil && len(columns) > 0 {
+		read = appendMissing(columns, exprColumns(where))
+	}
+	in, err := OpenInput(scan.Path, InputOptions{
+		Format:     format,
+		Columns:    read,
+		Where:      where,
+		HTTP:       opts.HTTP,
+		Mmap:       opts.Mmap,
+		ChunkCache: opts.ChunkCache,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if where == nil {
+		in.Operator = meter(in.Operator, mem, "scan")
+		return in, nil
+	}
+	var op Operator
+	op, err = NewFilterExec(in.Operator, where)
+	if err == nil && len(read) > len(columns) {
+		op, err = projectColumns(op, columns)
+	}
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	in.Operator = meter(op, mem, "scan")
+	return in, nil
+}
+
+// keeps columns of the output of op
+func projectColumns(op Operator, columns []string) (Operator, error) {
+	schema := op.Schema().Clone()
+	for _, name := range columns {
+		if _, err := schema.ColumnInfo(name); err != nil {
+			return nil, err
+		}
+	}
+	schema.KeepFields(columns...)
+	return NewProjectExec(schema, op, nil), nil
+}
@@ -0,0 +1,124 @@
+package projectoptimizer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func mustParseExpr(t *testing.T, input string) Expr {
+	t.Helper()
+	e, err := ParseExpr(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestNormalizePlan(t *testing.T) {
+	abs, err := filepath.Abs("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan := &ScanPlan{Path: "../data/history.parquet"}
+	want := "filter((country = 'Angola' AND lat > 0))\n" +
+		"  scan(" + abs + ", columns=lat, country)\n"
+
+	// the same query written four ways
+	plans := []Plan{
+		&ProjectPlan{
+			Input:   &FilterPlan{Input: &FilterPlan{Input: scan, Where: mustParseExpr(t, "lat > 0")}, Where: mustParseExpr(t, "country = 'Angola'")},
+			Columns: []string{"LAT", "country"},
+		},
+		&FilterPlan{
+			Input: &ProjectPlan{Input: scan, Columns: []string{"lat", "country"}},
+			Where: mustParseExpr(t, "country = 'Angola' AND lat > 0 AND lat > 0"),
+		},
+		&FilterPlan{
+			Input: &ProjectPlan{Input: &ProjectPlan{Input: scan, Columns: []string{"country", "lat", "lon"}}, Columns: []string{"lat", "country"}},
+			Where: mustParseExpr(t, "lat > 0 AND country = 'Angola'"),
+		},
+		&FilterPlan{
+			Input: &ProjectPlan{Input: scan, Columns: []string{"Lat", "Country"}},
+			Where: &LogicalExpr{Op: "and", Left: mustParseExpr(t, "LAT > 0"), Right: mustParseExpr(t, "Country = 'Angola'")},
+		},
+	}
+	for i, p := range plans {
+		normal, err := NormalizePlan(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatPlan(normal); got != want {
+			t.Fatalf("plan %d: expected\n%s\ngot\n%s", i, want, got)
+		}
+	}
+
+	// projections of a scan are read by the scan
+	normal, err := NormalizePlan(&ProjectPlan{Input: scan, Columns: []string{"Lat"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FormatPlan(normal); got != "scan("+abs+", columns=lat)\n" {
+		t.Fatalf("expected the projection folded into the scan, got %s", got)
+	}
+	if _, err := NormalizePlan(&ScanPlan{Path: "table.unknown"}); err == nil {
+		t.Fatalf("expected scans of an unknown format to fail")
+	}
+}
+
+func TestBuildPlan(t *testing.T) {
+	f := generateDataFilter()
+	defer f.Close()
+	where := mustParseExpr(t, "country = 'Angola' AND lat < 0")
+	filter, err := NewFilterExec(NewProjectExecLeaf(f, []string{"country", "lat", "lon"}, nil), where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := drain(t, filter)
+
+	plans := map[string]Plan{
+		"parquet": &ProjectPlan{
+			Input:   &FilterPlan{Input: &ScanPlan{Path: "../data/history.parquet"}, Where: where},
+			Columns: []string{"country", "lat", "lon"},
+		},
+		// stacked filters are merged and pushed into the scan
+		"nested": &FilterPlan{
+			Input: &FilterPlan{
+				Input: &ScanPlan{Path: "../data/history.parquet", Columns: []string{"country", "lat", "lon"}},
+				Where: mustParseExpr(t, "country = 'Angola'"),
+			},
+			Where: mustParseExpr(t, "lat < 0"),
+		},
+	}
+	for name, p := range plans {
+		in, err := BuildPlan(p, PlanOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := drain(t, in)
+		in.Close()
+		if got.NumRows() == 0 || !reflect.DeepEqual(got.Columns, want.Columns) {
+			t.Fatalf("%s: expected %d rows, got %d", name, want.NumRows(), got.NumRows())
+		}
+	}
+
+	// other formats are filtered row by row, columns only used by the
+	// filter are dropped again
+	csv := filepath.Join(t.TempDir(), "stores.csv")
+	if err := os.WriteFile(csv, []byte("store,total\nnorth,10\nsouth,20\nwest,30\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	in, err := BuildPlan(&ProjectPlan{
+		Input:   &FilterPlan{Input: &ScanPlan{Path: csv}, Where: mustParseExpr(t, "total >= 20")},
+		Columns: []string{"store"},
+	}, PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	got := drain(t, in)
+	if len(got.Schema.Fields) != 1 || !reflect.DeepEqual(got.Columns[0], []any{"south", "west"}) {
+		t.Fatalf("expected the stores south and west, got %v", got.Columns)
+	}
+}
@@ -1,19 +1,30 @@
 package projectoptimizer
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/google/uuid"
 	"github.com/parquet-go/parquet-go"
 )
 
+// FilterPredicate tests a row filled into the struct generated for its
+// columns, NULL fields hold the zero value. projections filter with an Expr
+// which follows sql NULL semantics
 type FilterPredicate func(reflect.Value) bool
 type structField struct {
-	Name   string
-	PqType parquet.Type
+	Name     string
+	PqType   parquet.Type
+	Optional bool         // the column may hold NULL values
+	Node     parquet.Node // set for nested columns: structs, lists, maps and repeated fields
 }
 type parquetSchema struct {
 	Fields []structField
@@ -22,6 +33,14 @@ type parquetSchema struct {
 type RecordBatch struct {
 	Schema  parquetSchema
 	Columns [][]any
+	Dicts   []*DictColumn // per column, nil when no column is dictionary encoded, see Dict
+}
+
+func (r RecordBatch) NumRows() int {
+	if len(r.Columns) == 0 {
+		return 0
+	}
+	return len(r.Columns[0])
 }
 
 type Display interface {
@@ -35,13 +54,24 @@ type Operator interface {
 
 // read from a file for source data
 type Leaf struct {
-	r    *parquet.Reader
-	Type reflect.Type
+	r       *parquet.Reader
+	Type    reflect.Type
+	columns []int      // leaf column index of every schema field, the first one of nested fields
+	nested  []*nodeRef // nested fields in the reader schema, nil for flat columns
+	asm     *assembler
+	rows    []parquet.Row
+	src     io.ReaderAt
+	row     int64         // index of the next row of the file
+	skip    []rowRange    // row groups pruned by their statistics, in file order
+	dicts   *leafDicts    // nil without dictionary encoded string columns
+	codes   []int32       // dictionary codes of the last decoded row
+	cached  *cachedChunks // column chunks read through a ChunkCache instead of r
 }
 type ProjectExec struct {
 	childInput Operator // child operator
 	schema     *parquetSchema
-	filter     []FilterPredicate
+	where      Expr           // rows kept by a leaf, nil keeps every row
+	whereAt    map[string]int // lower cased column name -> position in a row
 	Type       reflect.Type
 	columns    []string
 	leaf       *Leaf
@@ -60,28 +90,94 @@ func (s *parquetSchema) ColumnInfo(column string) (structField, error) {
 			return field, nil
 		}
 	}
-	return structField{}, fmt.Errorf("column %s not found in schema", column)
+	if field, ok := s.fieldByPath(column); ok {
+		return field, nil
+	}
+	return structField{}, fmt.Errorf("%w: %s", ErrColumnNotFound, column)
 }
 
-func NewProjectExec(schema *parquetSchema, input Operator, filter []FilterPredicate) *ProjectExec {
+// ErrColumnNotFound is returned when a query names a column its input does not have
+var ErrColumnNotFound = errors.New("column not found")
+
+// NewProjectExec picks the columns of schema out of the rows of input.
+// where, when not nil, keeps the rows of input it is true for and can name
+// any column of input. it panics when where names a column input lacks
+func NewProjectExec(schema *parquetSchema, input Operator, where Expr) *ProjectExec {
+	whereAt := schemaIndex(input.Schema())
+	if err := checkWhere(where, whereAt); err != nil {
+		panic(err)
+	}
 	return &ProjectExec{
 		columns:    schema.toColumns(),
 		schema:     schema,
-		filter:     filter,
+		where:      where,
+		whereAt:    whereAt,
 		childInput: input,
 		leaf:       nil,
 	}
 }
-func NewProjectExecLeaf(source *os.File, columns []string, filter []FilterPredicate) *ProjectExec {
-	schema, Typestruct, reader := initPrunedReader(source, columns...)
+
+// NewProjectExecLeaf reads columns of a parquet file, source is an *os.File,
+// a Source or any io.ReaderAt with a Size method. where, when not nil, keeps
+// the rows it is true for and can only name the read columns.
+// it panics when the footer of the file can not be read, OpenProjectExecLeaf
+// returns the error
+func NewProjectExecLeaf(source io.ReaderAt, columns []string, where Expr) *ProjectExec {
+	p, err := OpenProjectExecLeaf(source, columns, where)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// OpenProjectExecLeaf is NewProjectExecLeaf returning the error of reading
+// the footer, sources that can fail like http or s3 objects are opened with it
+func OpenProjectExecLeaf(source io.ReaderAt, columns []string, where Expr) (*ProjectExec, error) {
+	schema, Typestruct, reader, err := initPrunedReader(source, columns...)
+	if err != nil {
+		return nil, err
+	}
+	whereAt := schemaIndex(schema)
+	if err := checkWhere(where, whereAt); err != nil {
+		return nil, err
+	}
 	return &ProjectExec{
 		childInput: nil,
 		columns:    columns,
 		schema:     schema,
-		filter:     filter,
+		where:      where,
+		whereAt:    whereAt,
 		Type:       Typestruct,
-		leaf:       &Leaf{r: reader},
+		leaf:       newLeaf(reader, schema, source),
+	}, nil
+}
+
+func newLeaf(reader *parquet.Reader, schema *parquetSchema, src io.ReaderAt) *Leaf {
+	l := &Leaf{
+		r:       reader,
+		src:     src,
+		columns: make([]int, len(schema.Fields)),
+		nested:  make([]*nodeRef, len(schema.Fields)),
+		rows:    make([]parquet.Row, 1),
+	}
+	for i, field := range schema.Fields {
+		ref, ok := findNode(reader.Schema(), field.Name)
+		if !ok {
+			l.columns[i] = -1
+			continue
+		}
+		l.columns[i] = ref.column
+		if field.Node != nil {
+			l.nested[i] = &ref
+			if l.asm == nil {
+				l.asm = newAssembler(len(reader.Schema().Columns()))
+			}
+		}
+	}
+	if l.dicts = newLeafDicts(src, schema); l.dicts != nil {
+		l.codes = make([]int32, len(schema.Fields))
 	}
+	return l
 }
 
 func (p *ProjectExec) Next(n uint) (RecordBatch, error) {
@@ -96,47 +192,186 @@ func (p *ProjectExec) nextLeaf(n uint) (RecordBatch, error) {
 		Schema:  *p.schema,
 		Columns: make([][]any, len(p.schema.Fields)),
 	}
-	var curSize, retries uint
+	// rows are read as parquet values so NULLs survive for the filter
+	if d := p.leaf.dicts; d != nil {
+		batches.Dicts = make([]*DictColumn, len(p.schema.Fields))
+		for i, dict := range d.dicts {
+			if dict != nil {
+				batches.Dicts[i] = &DictColumn{Dict: dict}
+			}
+		}
+	}
+	values := make([]any, len(p.schema.Fields))
+	var curSize uint
 	for curSize < n {
-		entry := reflect.New(p.Type).Interface()
-		if err := p.leaf.r.Read(entry); err != nil {
-			if err == io.EOF {
+		if err := p.leaf.seekPruned(); err != nil {
+			return batches, err
+		}
+		if p.leaf.dicts != nil {
+			if err := p.leaf.dicts.load(p.leaf.row); err != nil {
 				return batches, err
-			} else {
-				retries++
-				if retries > 3 {
-					break
+			}
+		}
+		var read int
+		var err error
+		if c := p.leaf.cached; c != nil {
+			if err = c.read(p.leaf.row, values); err == nil {
+				read = 1
+			}
+		} else if read, err = p.leaf.r.ReadRows(p.leaf.rows); read == 1 {
+			p.leaf.decode(p.schema, p.leaf.rows[0], values)
+		}
+		if err != nil && err != io.EOF {
+			return batches, err
+		}
+		if read == 1 {
+			p.leaf.row++
+			if matchesWhere(p.where, p.whereAt, values) {
+				for i, value := range values {
+					batches.Columns[i] = append(batches.Columns[i], value)
+				}
+				for i, dc := range batches.Dicts {
+					if dc != nil {
+						dc.Codes = append(dc.Codes, p.leaf.codes[i])
+					}
 				}
-				continue // for now continue but dont get stuck in infident loop
+				curSize++
 			}
-
 		}
-		v := reflect.ValueOf(entry).Elem()
-		include := true
-		for _, pred := range p.filter {
-			if !pred(v) {
-				include = false
-				break
+		if err == io.EOF {
+			return batches, err
+		}
+	}
+	return batches, nil
+
+}
+
+// PruneRowGroups makes a leaf skip the row groups whose statistics or bloom
+// filters prove that no row satisfies where, rows of the other row groups are
+// not filtered. returns the number of skipped row groups
+func (p *ProjectExec) PruneRowGroups(where Expr) (int, error) {
+	if !p.isLeaf() {
+		return 0, fmt.Errorf("row groups can only be pruned on a leaf")
+	}
+	schema, err := ReadParquetSchema(p.leaf.src)
+	if err != nil {
+		return 0, err
+	}
+	skip, err := prunedRowGroups(p.leaf.src, schema, where, nil)
+	if err != nil {
+		return 0, err
+	}
+	p.leaf.skip = skip
+	return len(skip), nil
+}
+
+// UseChunkCache makes a leaf read whole column chunks through cache, shared
+// with every other leaf using it. only local files whose projected columns
+// are all flat are read this way, returns whether the leaf uses the cache.
+// dictionary codes are not kept for rows read from the cache
+func (p *ProjectExec) UseChunkCache(cache *ChunkCache) bool {
+	if !p.isLeaf() || cache == nil {
+		return false
+	}
+	if p.leaf.cached = newCachedChunks(cache, p.leaf.src, p.schema); p.leaf.cached == nil {
+		return false
+	}
+	p.leaf.dicts, p.leaf.codes = nil, nil
+	return true
+}
+
+// jumps over the pruned row group the next row belongs to
+func (l *Leaf) seekPruned() error {
+	for len(l.skip) > 0 && l.row >= l.skip[0].start {
+		if l.row < l.skip[0].end {
+			if l.cached == nil {
+				if err := l.r.SeekToRow(l.skip[0].end); err != nil {
+					return err
+				}
 			}
+			l.row = l.skip[0].end
+		}
+		l.skip = l.skip[1:]
+	}
+	return nil
+}
+
+// converts a parquet row into RecordBatch values, NULL becomes nil
+func (l *Leaf) decode(schema *parquetSchema, row parquet.Row, values []any) {
+	if l.asm != nil {
+		l.asm.reset(row)
+	}
+	for i, field := range schema.Fields {
+		values[i] = nil
+		if l.codes != nil {
+			l.codes[i] = -1
 		}
-		if !include {
+		if ref := l.nested[i]; ref != nil {
+			values[i] = l.asm.field(ref)
 			continue
 		}
-		for i := 0; i < v.NumField(); i++ {
-			_ = v.Type().Field(i)
-			value := v.Field(i).Interface()
-			batches.Columns[i] = append(batches.Columns[i], value)
+		col := l.columns[i]
+		if col < 0 {
+			continue
+		}
+		v, ok := columnOf(row, col)
+		if !ok {
+			continue
 		}
-		curSize++
+		if l.dicts != nil && l.dicts.dicts[i] != nil {
+			if !v.IsNull() {
+				dict := l.dicts.dicts[i]
+				l.codes[i] = dict.code(v.ByteArray())
+				values[i] = dict.Values[l.codes[i]]
+			}
+			continue
+		}
+		values[i] = columnValue(field, v)
 	}
-	return batches, nil
+}
+
+// value of a leaf column in a flat row
+func columnOf(row parquet.Row, col int) (parquet.Value, bool) {
+	if col < len(row) && row[col].Column() == col {
+		return row[col], true
+	}
+	for _, v := range row {
+		if v.Column() == col {
+			return v, true
+		}
+	}
+	return parquet.Value{}, false
+}
 
+// reads the next row into values and fills entry with them for predicates
+func (l *Leaf) next(schema *parquetSchema, values []any, entry reflect.Value) error {
+	read, err := l.r.ReadRows(l.rows)
+	if read == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return err
+	}
+	l.decode(schema, l.rows[0], values)
+	entry.SetZero()
+	for i, v := range values {
+		if err := setFieldValue(entry.Field(i), v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
+
 func (p *ProjectExec) nextProject(n uint) (RecordBatch, error) {
 	childrenBatch, err := p.childInput.Next(n)
-	if err != nil {
+	if err != nil && err != io.EOF {
 		return RecordBatch{}, err
 	}
+	if p.where != nil {
+		kept := RecordBatch{Schema: childrenBatch.Schema, Columns: make([][]any, len(childrenBatch.Columns))}
+		appendMatching(&kept, childrenBatch, p.where, p.whereAt)
+		childrenBatch = kept
+	}
 	wantedSchema := p.schema
 	batches := RecordBatch{
 		Schema:  *wantedSchema,
@@ -144,20 +379,25 @@ func (p *ProjectExec) nextProject(n uint) (RecordBatch, error) {
 	}
 	// only grab the selected columns from child batch
 	// map wanted columns to child schema columns
-	colIndices := make([]int, 0, len(p.columns))
-	for _, colName := range p.columns {
-		for ci, field := range childrenBatch.Schema.Fields {
-			if strings.EqualFold(colName, field.Name) {
-				colIndices = append(colIndices, ci)
-				break
+	for i, colName := range p.columns {
+		if ci := childrenBatch.Schema.fieldIndex(colName); ci >= 0 {
+			batches.Columns[i] = childrenBatch.Columns[ci]
+			if dc := childrenBatch.Dict(ci); dc != nil {
+				if batches.Dicts == nil {
+					batches.Dicts = make([]*DictColumn, len(batches.Columns))
+				}
+				batches.Dicts[i] = dc
 			}
+			continue
+		}
+		// dotted paths pick fields out of struct columns
+		head, rest, ok := strings.Cut(colName, ".")
+		if ci := childrenBatch.Schema.fieldIndex(head); ok && ci >= 0 {
+			batches.Columns[i] = structValues(childrenBatch.Columns[ci], strings.Split(rest, "."))
 		}
 	}
-	// now extract the columns
-	for i, ci := range colIndices {
-		batches.Columns[i] = childrenBatch.Columns[ci]
-	}
-	return batches, nil
+	// the last rows come back together with io.EOF
+	return batches, err
 
 }
 func (p *ProjectExec) Schema() *parquetSchema {
@@ -167,30 +407,50 @@ func (p *ProjectExec) isLeaf() bool {
 	return p.leaf != nil
 }
 
-func initPrunedReader(f *os.File, columns ...string) (*parquetSchema, reflect.Type, *parquet.Reader) {
-	// Parse original schema
-	freshReader := parquet.NewReader(f)
-	schema := freshReader.Schema()
-	parsedSchema, _ := parseSchema(schema)
-	freshReader.Close()
+func initPrunedReader(f io.ReaderAt, columns ...string) (*parquetSchema, reflect.Type, *parquet.Reader, error) {
+	// parquet-go finds the size of files and readers with a Size method itself
+	if src, err := AsSource(f); err == nil {
+		f = src
+	}
+	// the footer is read once, local files share it through the footer cache
+	pf, err := parquetFileOf(f)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	schema := pf.Schema()
+	parsedSchema, err := parseSchema(schema)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	// Prune to requested columns
 	parsedSchema.KeepFields(columns...)
 
-	// Generate struct for reading pruned columns
-	prunedStruct, structType := genStructWithFields(parsedSchema.Fields...)
+	// Generate struct for the predicates of the pruned columns
+	_, structType := genStructWithFields(parsedSchema.Fields...)
 
-	// Create new reader with pruned schema
-	reader := parquet.NewReader(f, parquet.SchemaOf(prunedStruct))
+	// Create new reader with pruned schema, the nodes of the file keep their logical types.
+	// dotted paths only keep the leaves under them
+	var paths [][]string
+	for _, field := range parsedSchema.Fields {
+		if ref, ok := findNode(schema, field.Name); ok {
+			paths = append(paths, ref.path)
+		}
+	}
+	reader := parquet.NewReader(pf, parquet.NewSchema(schema.Name(), prunedGroup(schema, paths)))
 
-	return parsedSchema, structType, reader
+	return parsedSchema, structType, reader, nil
 }
 
 // iterate through row groups
 // TODO:  should return a Record interface instead of parquet.Row, for now this is fine
-func IterRowGroupsWithPrune(f *os.File, columns ...string) *RecordBatch {
+func IterRowGroupsWithPrune(f io.ReaderAt, columns ...string) *RecordBatch {
 
-	v, structType, reader := initPrunedReader(f, columns...)
+	v, structType, reader, err := initPrunedReader(f, columns...)
+	if err != nil {
+		fmt.Printf("error opening file: %v\n", err)
+		return nil
+	}
 
 	size := reader.NumRows()
 	fmt.Printf("Number of Rows: %d\n", size)
@@ -198,19 +458,18 @@ func IterRowGroupsWithPrune(f *os.File, columns ...string) *RecordBatch {
 		Schema:  *v,
 		Columns: make([][]any, len(v.Fields)),
 	}
+	leaf := newLeaf(reader, v, f)
+	values := make([]any, len(v.Fields))
 	i := 0
 	rows := 0
 	for i < 50 {
-		entry := reflect.New(structType).Interface()
-		if err := reader.Read(entry); err != nil {
+		v := reflect.New(structType).Elem()
+		if err := leaf.next(&rb.Schema, values, v); err != nil {
 			fmt.Printf("error reading rows: %v\n", err)
 			break
 		}
-		v := reflect.ValueOf(entry).Elem()
 		for i := 0; i < v.NumField(); i++ {
-			_ = v.Type().Field(i)
-			value := v.Field(i).Interface()
-			rb.Columns[i] = append(rb.Columns[i], value)
+			rb.Columns[i] = append(rb.Columns[i], values[i])
 		}
 		rows++
 		i++
@@ -221,8 +480,12 @@ func IterRowGroupsWithPrune(f *os.File, columns ...string) *RecordBatch {
 	fmt.Printf("========================================\n")
 	return &rb
 }
-func IterRowGroupsWithPruneFilter(f *os.File, columns []string, pred FilterPredicate) *RecordBatch {
-	v, structType, reader := initPrunedReader(f, columns...)
+func IterRowGroupsWithPruneFilter(f io.ReaderAt, columns []string, pred FilterPredicate) *RecordBatch {
+	v, structType, reader, err := initPrunedReader(f, columns...)
+	if err != nil {
+		fmt.Printf("error opening file: %v\n", err)
+		return nil
+	}
 
 	size := reader.NumRows()
 	fmt.Printf("Number of Rows: %d\n", size)
@@ -230,20 +493,19 @@ func IterRowGroupsWithPruneFilter(f *os.File, columns []string, pred FilterPredi
 		Schema:  *v,
 		Columns: make([][]any, len(v.Fields)),
 	}
+	leaf := newLeaf(reader, v, f)
+	values := make([]any, len(v.Fields))
 	i := 0
 	rows := 0
 	for i < 50 {
-		entry := reflect.New(structType).Interface()
-		if err := reader.Read(entry); err != nil {
+		v := reflect.New(structType).Elem()
+		if err := leaf.next(&rb.Schema, values, v); err != nil {
 			fmt.Printf("error reading rows: %v\n", err)
 			break
 		}
-		v := reflect.ValueOf(entry).Elem()
 		if pred(v) {
 			for i := 0; i < v.NumField(); i++ {
-				_ = v.Type().Field(i)
-				value := v.Field(i).Interface()
-				rb.Columns[i] = append(rb.Columns[i], value)
+				rb.Columns[i] = append(rb.Columns[i], values[i])
 			}
 		}
 		rows++
@@ -257,19 +519,12 @@ func IterRowGroupsWithPruneFilter(f *os.File, columns []string, pred FilterPredi
 	return &rb
 }
 
+// top level fields of a parquet schema, groups and repeated fields become
+// nested columns
 func parseSchema(schema *parquet.Schema) (*parquetSchema, error) {
 	var fields []structField
-	size := len(schema.Columns())
-	size2 := len(schema.Fields())
-	if size != size2 {
-		return nil, fmt.Errorf("mismatched schema columns and fields size: %d vs %d", size, size2)
-	}
-	for i := 0; i < size; i++ {
-		field := schema.Fields()[i]
-		fields = append(fields, structField{
-			Name:   field.Name(),
-			PqType: field.Type(),
-		})
+	for _, field := range schema.Fields() {
+		fields = append(fields, nodeField(field.Name(), field, false))
 	}
 	return &parquetSchema{Fields: fields}, nil
 }
@@ -283,15 +538,20 @@ func (p *parquetSchema) existIn(fieldName string) bool {
 }
 
 // pass in fields u want to prune
-// case insensitive
+// case insensitive, dotted paths select fields of structs
 func (p *parquetSchema) KeepFields(fieldNames ...string) {
 	var wantedFields []structField
 	for _, name := range fieldNames {
+		found := false
 		for _, field := range p.Fields {
 			if strings.EqualFold(name, field.Name) {
 				wantedFields = append(wantedFields, field)
+				found = true
 			}
 		}
+		if field, ok := p.fieldByPath(name); ok && !found {
+			wantedFields = append(wantedFields, field)
+		}
 	}
 	p.Fields = wantedFields
 }
@@ -320,15 +580,27 @@ func (p *parquetSchema) Clone() *parquetSchema {
 /*
 Create custom structs based on field names @ run time.
 This is for generating structs dynamically.
-used to prune parquet files for projection push down
+used to prune parquet files for projection push down.
+the struct is never a parquet schema, the tags are quoted so any column name
+keeps a valid tag
 */
 func genStructWithFields(fields ...structField) (any, reflect.Type) {
 	var res []reflect.StructField
+	seen := make(map[string]bool)
 	for _, field := range fields {
+		name := goFieldName(field.Name)
+		for seen[name] {
+			name += "_"
+		}
+		seen[name] = true
+		zero := ZeroValueForParquetType(field.PqType)
+		if field.Node != nil && field.Node.Repeated() {
+			zero = []any(nil)
+		}
 		res = append(res, reflect.StructField{
-			Name: capitalize(field.Name),
-			Type: reflect.TypeOf(ZeroValueForParquetType(field.PqType)),
-			Tag:  reflect.StructTag(`parquet:"` + field.Name + `"`),
+			Name: name,
+			Type: reflect.TypeOf(zero),
+			Tag:  reflect.StructTag(`parquet:` + strconv.Quote(parquetTag(field))),
 		})
 	}
 	typ := reflect.StructOf(res)
@@ -342,26 +614,84 @@ func capitalize(s string) string {
 	return strings.ToUpper(s[:1]) + s[1:]
 }
 
+// struct field names have to be exported go identifiers, csv headers like
+// "Price per Unit" become Price_per_Unit
+func goFieldName(name string) string {
+	var sb strings.Builder
+	for _, r := range capitalize(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	out := sb.String()
+	if out == "" || !unicode.IsUpper([]rune(out)[0]) {
+		out = "X" + out
+	}
+	return out
+}
+
+func parquetTag(field structField) string {
+	tag := field.Name
+	if field.Optional {
+		tag += ",optional"
+	}
+	return tag
+}
+
+func isDateType(t parquet.Type) bool {
+	return t != nil && t.LogicalType() != nil && t.LogicalType().Date != nil
+}
+
 // ZeroValueForParquetType returns the zero/default Go value for a Parquet type.
+// logical types map to the go type used for them in a RecordBatch
 func ZeroValueForParquetType(pqType parquet.Type) any {
-	switch pqType {
-	case parquet.BooleanType:
+	if pqType == nil {
+		return ""
+	}
+	if isGroupType(pqType) {
+		if lt := pqType.LogicalType(); lt != nil && lt.List != nil {
+			return []any(nil)
+		}
+		return map[string]any(nil)
+	}
+	if lt := pqType.LogicalType(); lt != nil {
+		switch {
+		case lt.Date != nil, lt.Timestamp != nil:
+			return time.Time{}
+		case lt.Time != nil:
+			return time.Duration(0)
+		case lt.Decimal != nil:
+			return Decimal{}
+		case lt.UUID != nil:
+			return uuid.UUID{}
+		case lt.Integer != nil && !lt.Integer.IsSigned:
+			return uint64(0)
+		}
+	}
+	switch pqType.Kind() {
+	case parquet.Boolean:
 		return false
-	case parquet.Int32Type:
+	case parquet.Int32:
 		return int32(0)
-	case parquet.Int64Type:
+	case parquet.Int64:
 		return int64(0)
-	case parquet.FloatType:
+	case parquet.Int96:
+		return time.Time{}
+	case parquet.Float:
 		return float32(0)
-	case parquet.DoubleType:
+	case parquet.Double:
 		return float64(0)
-	case parquet.ByteArrayType:
+	case parquet.ByteArray:
 		return "" // or []byte{} if you prefer raw bytes
 	default:
 		return ""
 	}
 }
 
+const secondsPerDay = 24 * 60 * 60
+
 func compareValues(a, b any) bool {
 	// Type switch based on actual data type
 	switch v := a.(type) {
@@ -381,6 +711,12 @@ func compareValues(a, b any) bool {
 }
 
 func (r RecordBatch) Show() string {
+	return showTable(r, r.NumRows(), 20, 50)
+}
+
+// renders the first maxRows rows of r as a table of columns at most maxWidth
+// wide, r is the start of a result of total rows
+func showTable(r RecordBatch, total, maxRows, maxWidth int) string {
 	if len(r.Columns) == 0 {
 		return "Empty RecordBatch"
 	}
@@ -390,7 +726,7 @@ func (r RecordBatch) Show() string {
 		numRows = len(r.Columns[0])
 	}
 
-	if numRows == 0 {
+	if total == 0 {
 		return "RecordBatch with 0 rows"
 	}
 
@@ -398,20 +734,19 @@ func (r RecordBatch) Show() string {
 	colWidths := make([]int, len(r.Schema.Fields))
 	for i, field := range r.Schema.Fields {
 		// Start with header width
-		colWidths[i] = len(field.Name)
+		colWidths[i] = cellWidth(field.Name)
 
 		// Check data widths (sample first 100 rows for performance)
 		sampleSize := min(numRows, 100)
 		for rowIdx := 0; rowIdx < sampleSize; rowIdx++ {
-			val := formatValue(r.Columns[i][rowIdx])
-			if len(val) > colWidths[i] {
-				colWidths[i] = len(val)
+			if width := cellWidth(formatValue(r.Columns[i][rowIdx])); width > colWidths[i] {
+				colWidths[i] = width
 			}
 		}
 
-		// Cap maximum width at 50 characters
-		if colWidths[i] > 50 {
-			colWidths[i] = 50
+		// Cap maximum width at maxWidth characters
+		if colWidths[i] > maxWidth {
+			colWidths[i] = maxWidth
 		}
 
 		// Minimum width of 3
@@ -423,7 +758,7 @@ func (r RecordBatch) Show() string {
 	var sb strings.Builder
 
 	// Write summary
-	sb.WriteString(fmt.Sprintf("RecordBatch: %d rows × %d columns\n", numRows, len(r.Schema.Fields)))
+	sb.WriteString(fmt.Sprintf("RecordBatch: %d rows × %d columns\n", total, len(r.Schema.Fields)))
 	sb.WriteString("\n")
 
 	// Write top border
@@ -440,7 +775,7 @@ func (r RecordBatch) Show() string {
 	sb.WriteString("│")
 	for i, field := range r.Schema.Fields {
 		sb.WriteString(" ")
-		sb.WriteString(padRight(field.Name, colWidths[i]))
+		sb.WriteString(fitCell(field.Name, colWidths[i]))
 		sb.WriteString(" │")
 	}
 	sb.WriteString("\n")
@@ -455,29 +790,28 @@ func (r RecordBatch) Show() string {
 	}
 	sb.WriteString("┤\n")
 
-	// Write data rows (limit display to first 20 rows)
-	displayRows := min(numRows, 20)
+	// Write data rows (limit display to first maxRows rows)
+	displayRows := min(numRows, maxRows)
 	for rowIdx := 0; rowIdx < displayRows; rowIdx++ {
 		sb.WriteString("│")
 		for colIdx := 0; colIdx < len(r.Columns); colIdx++ {
 			sb.WriteString(" ")
-			val := formatValue(r.Columns[colIdx][rowIdx])
-			sb.WriteString(padRight(truncate(val, colWidths[colIdx]), colWidths[colIdx]))
+			sb.WriteString(fitCell(formatValue(r.Columns[colIdx][rowIdx]), colWidths[colIdx]))
 			sb.WriteString(" │")
 		}
 		sb.WriteString("\n")
 	}
 
 	// If there are more rows, show ellipsis
-	if numRows > displayRows {
+	if total > displayRows {
 		sb.WriteString("│")
 		for _, width := range colWidths {
 			sb.WriteString(" ")
-			sb.WriteString(padRight("...", width))
+			sb.WriteString(fitCell("...", width))
 			sb.WriteString(" │")
 		}
 		sb.WriteString("\n")
-		sb.WriteString(fmt.Sprintf("│ ... %d more rows\n", numRows-displayRows))
+		sb.WriteString(fmt.Sprintf("│ ... %d more rows\n", total-displayRows))
 	}
 
 	// Write bottom border
@@ -515,39 +849,38 @@ func formatValue(val any) string {
 		return fmt.Sprintf("%.2f", v)
 	case bool:
 		return fmt.Sprintf("%t", v)
+	case time.Time:
+		if v.Equal(v.Truncate(24 * time.Hour)) {
+			return v.Format(time.DateOnly)
+		}
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		// time of day
+		return time.Unix(0, int64(v)).UTC().Format("15:04:05.999999999")
+	case map[string]any, []any:
+		b, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(b)
 	default:
 		return fmt.Sprintf("%v", v)
 	}
 }
 
-func padRight(s string, width int) string {
-	if len(s) >= width {
-		return s
-	}
-	return s + strings.Repeat(" ", width-len(s))
-}
-
+// cuts s to maxLen characters, ending in ... when it is cut
 func truncate(s string, maxLen int) string {
-	if len(s) <= maxLen {
+	if utf8.RuneCountInString(s) <= maxLen {
 		return s
 	}
+	r := []rune(s)
 	if maxLen <= 3 {
-		return s[:maxLen]
+		return string(r[:maxLen])
 	}
-	return s[:maxLen-3] + "..."
+	return string(r[:maxLen-3]) + "..."
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
+// renders the fields with their repetition and types, e.g. optional binary country (STRING)
 func (p parquetSchema) ShowSchema() string {
-	var sb strings.Builder
-	sb.WriteString("Schema:\n")
-	for i, field := range p.Fields {
-		sb.WriteString(fmt.Sprintf("  %d. %s: %T\n", i, field.Name, field.PqType))
-	}
-	return sb.String()
+	return p.Describe().String()
 }
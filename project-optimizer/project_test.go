@@ -302,3 +302,123 @@ func TestProjectExecLeafProjection(t *testing.T) {
 		t.Errorf("columns length (%d) does not match schema fields (%d)", len(batch.Columns), len(batch.Schema.Fields))
 	}
 }
+
+func TestParquetNullRoundTrip(t *testing.T) {
+	schema := &parquetSchema{Fields: []structField{
+		{Name: "id", PqType: parquet.Int64Type},
+		{Name: "score", PqType: parquet.DoubleType, Optional: true},
+		{Name: "note", PqType: parquet.String().Type(), Optional: true},
+	}}
+	batch := RecordBatch{Schema: *schema, Columns: [][]any{
+		{int64(1), int64(2), int64(3)},
+		{1.5, nil, 0.0},
+		{nil, "", "x"},
+	}}
+	f, err := os.CreateTemp(t.TempDir(), "nulls-*.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewParquetBatchWriter(f, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	leaf := NewProjectExecLeaf(f, []string{"id", "score", "note"}, nil)
+	if !leaf.Schema().Fields[1].Optional {
+		t.Fatalf("expected score to be read back as optional")
+	}
+	got, err := leaf.Next(10)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	for c := range batch.Columns {
+		for i := range batch.Columns[c] {
+			if got.Columns[c][i] != batch.Columns[c][i] {
+				t.Fatalf("column %d row %d: expected %#v, got %#v", c, i, batch.Columns[c][i], got.Columns[c][i])
+			}
+		}
+	}
+
+	required := &parquetSchema{Fields: []structField{{Name: "id", PqType: parquet.Int64Type}}}
+	w, err = NewParquetBatchWriter(io.Discard, required)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteBatch(RecordBatch{Schema: *required, Columns: [][]any{{nil}}}); err == nil {
+		t.Fatalf("expected an error writing NULL to a required column")
+	}
+}
+
+func TestLeafFilterSkipsNulls(t *testing.T) {
+	schema := &parquetSchema{Fields: []structField{
+		{Name: "id", PqType: parquet.Int64Type},
+		{Name: "score", PqType: parquet.DoubleType, Optional: true},
+		{Name: "note", PqType: parquet.String().Type(), Optional: true},
+	}}
+	f, err := os.CreateTemp(t.TempDir(), "nulls-*.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewParquetBatchWriter(f, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteBatch(RecordBatch{Schema: *schema, Columns: [][]any{
+		{int64(1), int64(2), int64(3)},
+		{1.5, nil, 0.0},
+		{nil, "", "x"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		where string
+		want  []any
+	}{
+		{"score < 1", []any{int64(3)}},
+		{"note = ''", []any{int64(2)}},
+		{"score != 5", []any{int64(1), int64(3)}},
+		// a NULL in a column the filter does not read keeps the row
+		{"id > 0", []any{int64(1), int64(2), int64(3)}},
+	} {
+		leaf := NewProjectExecLeaf(f, []string{"id", "score", "note"}, mustParseExpr(t, tc.where))
+		if got := drain(t, leaf).Columns[0]; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected ids %v, got %v", tc.where, tc.want, got)
+		}
+	}
+}
+
+func TestProjectExecWhere(t *testing.T) {
+	src := `{"name": "a", "score": 10}
+{"name": "b", "score": null}
+{"name": "c", "score": 30}
+`
+	input, err := NewJSONExecLeaf(strings.NewReader(src), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, err := ParseExpr("score > 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := input.Schema().Clone()
+	schema.KeepFields("name")
+	batch, err := NewProjectExec(schema, input, where).Next(10)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(batch.Columns[0]); got != "[a c]" {
+		t.Fatalf("expected [a c] keeping no NULL score, got %s", got)
+	}
+}
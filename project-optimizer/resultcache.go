@@ -0,0 +1,461 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// results of queries kept for the queries asking for them again. a result
+// is keyed by the normalized plan of its query, as FormatPlan renders it,
+// and the fingerprints of every file the plan reads: the size and
+// modification time of local files, the etag of s3 objects. once a file
+// changes the plan gets a new key and the result of the old one is
+// invalidated. a result is stored as the parquet file its batches serialize
+// to, in memory up to MaxBytes, then in Dir up to MaxDiskBytes, the least
+// recently used first out of both. results on disk survive the process, a
+// cache opened on the same Dir finds them again. a query streams its result
+// while it runs and only stores it once it was read to the end, plans
+// reading http urls are never cached as their changes cannot be seen
+
+// ResultCacheOptions bound a ResultCache
+type ResultCacheOptions struct {
+	TTL          time.Duration // results older than this are computed again, 0 keeps them until evicted
+	MaxBytes     int64         // serialized results kept in memory
+	Dir          string        // results evicted from memory are moved here, empty drops them
+	MaxDiskBytes int64         // serialized results kept in Dir
+	Plan         PlanOptions   // how the queries of a miss run
+}
+
+// ResultCache keeps the results of queries, safe for concurrent use
+type ResultCache struct {
+	mu        sync.Mutex
+	opts      ResultCacheOptions
+	entries   map[string]*resultEntry // by key
+	byPlan    map[string]string       // key last used by every normalized plan
+	mem, disk list.List               // of *resultEntry, most recently used first
+	memBytes  int64
+	diskBytes int64
+	now       func() time.Time
+
+	hits, misses, invalidations, evictions atomic.Int64
+}
+
+// ResultCacheStats counts the lookups of a ResultCache
+type ResultCacheStats struct {
+	Hits, Misses  int64
+	Invalidations int64 // results dropped because a file they were computed from changed
+	Evictions     int64 // results dropped for room or age
+	Entries       int
+	Bytes         int64 // held in memory
+	DiskBytes     int64 // held in Dir
+}
+
+type resultEntry struct {
+	key     string
+	plan    string
+	created time.Time
+	size    int64
+	data    []byte        // the serialized result while it is in memory
+	path    string        // the file holding it once it is on disk
+	elem    *list.Element // in mem or disk
+}
+
+// metadata keys of the files results are stored in
+const (
+	resultPlanKey    = "parqlite.plan"
+	resultColumnsKey = "parqlite.columns"
+)
+
+// NewResultCache opens a cache, the results already in opts.Dir are kept
+func NewResultCache(opts ResultCacheOptions) (*ResultCache, error) {
+	c := &ResultCache{
+		opts:    opts,
+		entries: make(map[string]*resultEntry),
+		byPlan:  make(map[string]string),
+		now:     time.Now,
+	}
+	if opts.Dir == "" {
+		return c, nil
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating result cache directory: %w", err)
+	}
+	dirEntries, err := os.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading result cache directory: %w", err)
+	}
+	var found []*resultEntry
+	for _, de := range dirEntries {
+		key, ok := strings.CutSuffix(de.Name(), ".parquet")
+		info, err := de.Info()
+		if !ok || len(key) != sha256.Size*2 || err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(opts.Dir, de.Name())
+		found = append(found, &resultEntry{key: key, plan: storedPlan(path), created: info.ModTime(), size: info.Size(), path: path})
+	}
+	// oldest first so the newest ends up in front
+	sort.Slice(found, func(i, j int) bool { return found[i].created.Before(found[j].created) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range found {
+		c.entries[e.key] = e
+		if e.plan != "" {
+			c.byPlan[e.plan] = e.key
+		}
+		e.elem = c.disk.PushFront(e)
+		c.diskBytes += e.size
+	}
+	c.evict()
+	return c, nil
+}
+
+// the plan a stored result was computed for, empty when it cannot be read
+func storedPlan(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	pf, err := openParquetFile(f)
+	if err != nil {
+		return ""
+	}
+	plan, _ := pf.Lookup(resultPlanKey)
+	return plan
+}
+
+// Clear drops every result, the ones being read stay readable
+func (c *ResultCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		c.remove(e)
+	}
+	clear(c.byPlan)
+}
+
+func (c *ResultCache) Stats() ResultCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ResultCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+		Evictions:     c.evictions.Load(),
+		Entries:       len(c.entries),
+		Bytes:         c.memBytes,
+		DiskBytes:     c.diskBytes,
+	}
+}
+
+// Run returns the result of p: the stored one when the files it reads did
+// not change since it was computed, otherwise p runs and its result is
+// stored once it was read to the end. Close must be called in both cases
+func (c *ResultCache) Run(p Plan) (*Input, error) {
+	p, err := NormalizePlan(p)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, ok, err := planFingerprint(p)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return buildPlan(p, c.opts.Plan)
+	}
+	plan := FormatPlan(p)
+	sum := sha256.Sum256([]byte(plan + "\n" + fingerprint))
+	key := hex.EncodeToString(sum[:])
+	if in := c.lookup(plan, key); in != nil {
+		return in, nil
+	}
+	in, err := buildPlan(p, c.opts.Plan)
+	if err != nil {
+		return nil, err
+	}
+	r := &resultRecorder{in: in, c: c, plan: plan, key: key}
+	r.w, err = newResultWriter(&r.buf, in.Schema(), plan)
+	if err != nil {
+		r.w = nil // results the parquet writer cannot hold are not stored
+	}
+	return &Input{Operator: r, f: r, mem: in.mem}, nil
+}
+
+// the stored result of key, nil on a miss
+func (c *ResultCache) lookup(plan, key string) *Input {
+	c.mu.Lock()
+	if old, ok := c.byPlan[plan]; ok && old != key {
+		if e, ok := c.entries[old]; ok {
+			c.remove(e)
+			c.invalidations.Add(1)
+		}
+		delete(c.byPlan, plan)
+	}
+	e, ok := c.entries[key]
+	if ok && c.opts.TTL > 0 && c.now().Sub(e.created) > c.opts.TTL {
+		c.remove(e)
+		c.evictions.Add(1)
+		ok = false
+	}
+	if !ok {
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil
+	}
+	if e.data != nil {
+		c.mem.MoveToFront(e.elem)
+	} else {
+		c.disk.MoveToFront(e.elem)
+	}
+	c.byPlan[plan] = key
+	data, path := e.data, e.path
+	c.mu.Unlock()
+
+	var src Source = BytesSource(data)
+	var closer io.Closer = nopCloser{}
+	if data == nil {
+		f, err := os.Open(path)
+		if err != nil {
+			c.mu.Lock()
+			if c.entries[key] == e {
+				c.remove(e) // removed behind the cache's back
+			}
+			c.mu.Unlock()
+			c.misses.Add(1)
+			return nil
+		}
+		src, closer = NewSource(f, e.size), f
+	}
+	op, err := openResult(src)
+	if err != nil {
+		closer.Close()
+		c.misses.Add(1)
+		return nil
+	}
+	c.hits.Add(1)
+	return &Input{Operator: op, f: closer}
+}
+
+// stores the serialized result of a plan
+func (c *ResultCache) store(plan, key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.remove(old) // computed by two queries at once
+	}
+	e := &resultEntry{key: key, plan: plan, created: c.now(), size: int64(len(data)), data: data}
+	c.entries[key] = e
+	c.byPlan[plan] = key
+	if e.size <= c.opts.MaxBytes {
+		e.elem = c.mem.PushFront(e)
+		c.memBytes += e.size
+	} else if !c.toDisk(e) {
+		delete(c.entries, key)
+		return
+	}
+	c.evict()
+}
+
+// the largest result worth recording
+func (c *ResultCache) maxResultBytes() int64 {
+	if c.opts.Dir != "" {
+		return max(c.opts.MaxBytes, c.opts.MaxDiskBytes)
+	}
+	return c.opts.MaxBytes
+}
+
+// moves a result out of memory into Dir, false when it does not fit there.
+// must be called with mu held
+func (c *ResultCache) toDisk(e *resultEntry) bool {
+	if c.opts.Dir == "" || e.size > c.opts.MaxDiskBytes {
+		return false
+	}
+	path := filepath.Join(c.opts.Dir, e.key+".parquet")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, e.data, 0o644); err != nil {
+		os.Remove(tmp)
+		return false
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false
+	}
+	e.data, e.path = nil, path
+	e.elem = c.disk.PushFront(e)
+	c.diskBytes += e.size
+	return true
+}
+
+// moves the least recently used results above MaxBytes to disk and drops
+// the ones above MaxDiskBytes, must be called with mu held
+func (c *ResultCache) evict() {
+	for c.mem.Len() > 0 && c.memBytes > c.opts.MaxBytes {
+		e := c.mem.Remove(c.mem.Back()).(*resultEntry)
+		c.memBytes -= e.size
+		if !c.toDisk(e) {
+			delete(c.entries, e.key)
+			c.evictions.Add(1)
+		}
+	}
+	for c.disk.Len() > 0 && c.diskBytes > c.opts.MaxDiskBytes {
+		c.remove(c.disk.Back().Value.(*resultEntry))
+		c.evictions.Add(1)
+	}
+}
+
+func (c *ResultCache) remove(e *resultEntry) {
+	delete(c.entries, e.key)
+	if e.data != nil {
+		c.mem.Remove(e.elem)
+		c.memBytes -= e.size
+		return
+	}
+	c.disk.Remove(e.elem)
+	c.diskBytes -= e.size
+	os.Remove(e.path)
+}
+
+// the state of every file a plan reads, false for plans reading http urls
+func planFingerprint(p Plan) (string, bool, error) {
+	var sb strings.Builder
+	var walk func(p Plan) (bool, error)
+	walk = func(p Plan) (bool, error) {
+		if scan, ok := p.(*ScanPlan); ok {
+			return scanFingerprint(&sb, scan)
+		}
+		for _, child := range p.Children() {
+			if ok, err := walk(child); !ok || err != nil {
+				return ok, err
+			}
+		}
+		return true, nil
+	}
+	ok, err := walk(p)
+	return sb.String(), ok, err
+}
+
+func scanFingerprint(sb *strings.Builder, scan *ScanPlan) (bool, error) {
+	if isHTTPURL(scan.Path) {
+		return false, nil
+	}
+	if isS3URL(scan.Path) {
+		if scan.Format != FormatParquet {
+			return false, nil
+		}
+		objects, _, err := s3DatasetObjects(scan.Path)
+		if err != nil {
+			return false, err
+		}
+		for _, obj := range objects {
+			fmt.Fprintf(sb, "%s %d %s %d\n", obj.Key, obj.Size, obj.ETag, obj.LastModified.UnixNano())
+		}
+		return true, nil
+	}
+	paths := []string{scan.Path}
+	if scan.Format == FormatParquet {
+		var err error
+		if paths, _, err = datasetPaths(scan.Path); err != nil {
+			return false, err
+		}
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(sb, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return true, nil
+}
+
+// serializes a result, the plan and column order are kept in the metadata
+func newResultWriter(out io.Writer, schema *parquetSchema, plan string) (*ParquetBatchWriter, error) {
+	columns, err := json.Marshal(schema.toColumns())
+	if err != nil {
+		return nil, err
+	}
+	return NewParquetBatchWriter(out, schema,
+		parquet.KeyValueMetadata(resultPlanKey, plan),
+		parquet.KeyValueMetadata(resultColumnsKey, string(columns)))
+}
+
+// reads a serialized result back in the column order it was written in
+func openResult(src Source) (Operator, error) {
+	pf, err := openParquetFile(src)
+	if err != nil {
+		return nil, err
+	}
+	text, ok := pf.Lookup(resultColumnsKey)
+	if !ok {
+		return nil, fmt.Errorf("stored result without %s", resultColumnsKey)
+	}
+	var columns []string
+	if err := json.Unmarshal([]byte(text), &columns); err != nil {
+		return nil, fmt.Errorf("reading stored result: %w", err)
+	}
+	leaf, err := OpenProjectExecLeaf(src, columns, nil)
+	if err != nil {
+		return nil, err
+	}
+	return leaf, nil
+}
+
+// passes the result of a query through while serializing it, the result is
+// stored once it was read to the end
+type resultRecorder struct {
+	in   *Input
+	c    *ResultCache
+	plan string
+	key  string
+	buf  bytes.Buffer
+	w    *ParquetBatchWriter // nil once the result will not be stored
+}
+
+func (r *resultRecorder) Next(n uint) (RecordBatch, error) {
+	batch, err := r.in.Next(n)
+	if err != nil && err != io.EOF {
+		r.w = nil
+		return batch, err
+	}
+	if r.w != nil && batch.NumRows() > 0 {
+		if werr := r.w.WriteBatch(batch); werr != nil || int64(r.buf.Len()) > r.c.maxResultBytes() {
+			r.w = nil
+		}
+	}
+	if err == io.EOF && r.w != nil {
+		if r.w.Close() == nil && int64(r.buf.Len()) <= r.c.maxResultBytes() {
+			r.c.store(r.plan, r.key, r.buf.Bytes())
+		}
+		r.w = nil
+	}
+	return batch, err
+}
+
+func (r *resultRecorder) Schema() *parquetSchema {
+	return r.in.Schema()
+}
+
+// releases the inputs, a result not read to the end is dropped
+func (r *resultRecorder) Close() error {
+	r.w = nil
+	return r.in.Close()
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
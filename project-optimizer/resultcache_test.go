@@ -0,0 +1,148 @@
+package projectoptimizer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func runCached(t *testing.T, c *ResultCache, p Plan) RecordBatch {
+	t.Helper()
+	in, err := c.Run(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	return drain(t, in)
+}
+
+func TestResultCacheReusesResults(t *testing.T) {
+	dir := writeDailyDataset(t)
+	c, err := NewResultCache(ResultCacheOptions{MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &ProjectPlan{
+		Input:   &FilterPlan{Input: &ScanPlan{Path: dir}, Where: mustParseExpr(t, "total >= 20")},
+		Columns: []string{"total", "store"},
+	}
+	first := runCached(t, c, plan)
+	if first.NumRows() != 5 {
+		t.Fatalf("expected 5 rows, got %d", first.NumRows())
+	}
+	if stats := c.Stats(); stats.Misses != 1 || stats.Entries != 1 || stats.Bytes == 0 {
+		t.Fatalf("expected the result to be stored, got %+v", stats)
+	}
+
+	// the same query written differently
+	again := runCached(t, c, &FilterPlan{
+		Input: &ScanPlan{Path: dir, Columns: []string{"TOTAL", "store"}},
+		Where: mustParseExpr(t, "total >= 20"),
+	})
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("expected a hit, got %+v", stats)
+	}
+	if !reflect.DeepEqual(again.Columns, first.Columns) || again.Schema.Fields[0].Name != "total" {
+		t.Fatalf("expected the stored result in its column order, got %v", again.Columns)
+	}
+
+	// a changed file invalidates the result
+	rows := []dailyRow{{Day: 1, Store: "east", Total: 50}}
+	path := filepath.Join(dir, "day-1.parquet")
+	if err := parquet.WriteFile(path, rows); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	changed := runCached(t, c, plan)
+	if changed.NumRows() != 5 || changed.Columns[1][0] != "east" {
+		t.Fatalf("expected the rows of the rewritten file, got %v", changed.Columns)
+	}
+	if stats := c.Stats(); stats.Invalidations != 1 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Fatalf("expected the old result to be invalidated, got %+v", stats)
+	}
+
+	// results not read to the end are not stored
+	in, err := c.Run(&ScanPlan{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.Next(1); err != nil {
+		t.Fatal(err)
+	}
+	in.Close()
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Fatalf("expected a partial result to be dropped, got %+v", stats)
+	}
+
+	// expired results are computed again
+	c.now = func() time.Time { return time.Now().Add(time.Hour) }
+	c.opts.TTL = time.Minute
+	runCached(t, c, plan)
+	if stats := c.Stats(); stats.Misses != 4 || stats.Evictions != 1 || stats.Entries != 1 {
+		t.Fatalf("expected the expired result to be computed again, got %+v", stats)
+	}
+}
+
+func TestResultCacheSpillsToDisk(t *testing.T) {
+	dir := writeDailyDataset(t)
+	cacheDir := filepath.Join(t.TempDir(), "results")
+	opts := ResultCacheOptions{MaxBytes: 0, Dir: cacheDir, MaxDiskBytes: 1 << 20}
+	c, err := NewResultCache(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans := []Plan{
+		&ScanPlan{Path: filepath.Join(dir, "day-1.parquet")},
+		&ScanPlan{Path: filepath.Join(dir, "day-2.parquet")},
+		&ScanPlan{Path: filepath.Join(dir, "day-3.parquet"), Columns: []string{"region"}},
+	}
+	want := runCached(t, c, plans[0])
+	runCached(t, c, plans[1])
+	stats := c.Stats()
+	if stats.Entries != 2 || stats.Bytes != 0 || stats.DiskBytes == 0 {
+		t.Fatalf("expected the results on disk, got %+v", stats)
+	}
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "*.parquet"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 stored results, got %v", files)
+	}
+
+	// a cache opened on the same directory finds them
+	c, err = NewResultCache(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runCached(t, c, plans[0]); !reflect.DeepEqual(got.Columns, want.Columns) {
+		t.Fatalf("expected the stored rows, got %v", got.Columns)
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Fatalf("expected a hit, got %+v", stats)
+	}
+
+	// room for two results: day-2 is the least recently used
+	c.mu.Lock()
+	c.opts.MaxDiskBytes = stats.DiskBytes
+	c.mu.Unlock()
+	runCached(t, c, plans[2])
+	if stats := c.Stats(); stats.Entries != 2 || stats.Evictions != 1 || stats.DiskBytes > opts.MaxDiskBytes {
+		t.Fatalf("expected day-2 to be evicted, got %+v", stats)
+	}
+	runCached(t, c, plans[0])
+	runCached(t, c, plans[1])
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Fatalf("expected day-1 to be kept and day-2 computed again, got %+v", stats)
+	}
+
+	c.Clear()
+	files, _ = filepath.Glob(filepath.Join(cacheDir, "*"))
+	if stats := c.Stats(); stats.Entries != 0 || stats.DiskBytes != 0 || len(files) != 0 {
+		t.Fatalf("expected an empty cache, got %+v and %v", stats, files)
+	}
+}
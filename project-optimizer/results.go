@@ -0,0 +1,320 @@
+package projectoptimizer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// result writers render the rows of a query for people or tools. every
+// format is a BatchWriter so results are written batch by batch as they
+// arrive, only the table preview holds on to its first rows. formats are
+// looked up by name, RegisterResultFormat adds more:
+//
+//	table     the first rows as RecordBatch.Show renders them
+//	full      every row in a box drawn table, the header repeated every page
+//	csv, tsv  with a header row
+//	json      an array of objects
+//	ndjson    an object per line
+//	markdown  a pipe table
+
+const (
+	FormatTable    = "table"
+	FormatFull     = "full"
+	FormatMarkdown = "markdown"
+)
+
+// ResultOptions tune the formats for people, tools' formats ignore them
+type ResultOptions struct {
+	MaxRows  int // rows of the table preview, 20 when 0
+	MaxWidth int // characters a table column is cut to, 50 when 0
+	PageRows int // rows of a page of the full table, the header is repeated for every page. 0 writes one page
+}
+
+func (o ResultOptions) withDefaults() ResultOptions {
+	if o.MaxRows <= 0 {
+		o.MaxRows = 20
+	}
+	if o.MaxWidth <= 0 {
+		o.MaxWidth = 50
+	}
+	return o
+}
+
+// ResultWriterFunc creates the writer of a result format
+type ResultWriterFunc func(out io.Writer, schema *parquetSchema, opts ResultOptions) (BatchWriter, error)
+
+var resultFormats = []string{FormatTable, FormatFull, FormatCSV, FormatTSV, FormatJSON, FormatNDJSON, FormatMarkdown}
+
+var resultWriters = map[string]ResultWriterFunc{
+	FormatTable: func(out io.Writer, schema *parquetSchema, opts ResultOptions) (BatchWriter, error) {
+		return NewTableWriter(out, schema, opts), nil
+	},
+	FormatFull: func(out io.Writer, schema *parquetSchema, opts ResultOptions) (BatchWriter, error) {
+		return NewFullTableWriter(out, schema, opts), nil
+	},
+	FormatMarkdown: func(out io.Writer, schema *parquetSchema, _ ResultOptions) (BatchWriter, error) {
+		return NewMarkdownWriter(out, schema), nil
+	},
+}
+
+func init() {
+	for _, format := range []string{FormatCSV, FormatTSV, FormatJSON, FormatNDJSON} {
+		format := format
+		resultWriters[format] = func(out io.Writer, schema *parquetSchema, _ ResultOptions) (BatchWriter, error) {
+			return NewBatchWriter(out, schema, OutputOptions{Format: format})
+		}
+	}
+}
+
+// RegisterResultFormat adds a result format or replaces one, it is not safe
+// to call while results are written
+func RegisterResultFormat(name string, fn ResultWriterFunc) {
+	name = strings.ToLower(name)
+	if _, ok := resultWriters[name]; !ok {
+		resultFormats = append(resultFormats, name)
+	}
+	resultWriters[name] = fn
+}
+
+// ResultFormats lists the result formats in the order they were registered
+func ResultFormats() []string {
+	return append([]string(nil), resultFormats...)
+}
+
+// NewResultWriter creates the writer of a result format
+func NewResultWriter(out io.Writer, schema *parquetSchema, format string, opts ResultOptions) (BatchWriter, error) {
+	fn, ok := resultWriters[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown result format %q, expected one of %s", ErrUnsupportedFormat, format, strings.Join(resultFormats, ", "))
+	}
+	return fn(out, schema, opts.withDefaults())
+}
+
+// WriteResult streams the rows of op to out in a result format, returns
+// the number of rows
+func WriteResult(out io.Writer, op Operator, format string, opts ResultOptions) (int64, error) {
+	w, err := NewResultWriter(out, op.Schema(), format, opts)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := CopyBatches(w, op, defaultBatchSize)
+	if err != nil {
+		return rows, err
+	}
+	return rows, w.Close()
+}
+
+// TableWriter previews a result: its first rows are rendered by Close like
+// RecordBatch.Show does, the others only counted
+type TableWriter struct {
+	out   io.Writer
+	opts  ResultOptions
+	first RecordBatch
+	total int
+}
+
+func NewTableWriter(out io.Writer, schema *parquetSchema, opts ResultOptions) *TableWriter {
+	return &TableWriter{
+		out:   out,
+		opts:  opts.withDefaults(),
+		first: RecordBatch{Schema: *schema, Columns: make([][]any, len(schema.Fields))},
+	}
+}
+
+func (t *TableWriter) WriteBatch(batch RecordBatch) error {
+	keep := min(t.opts.MaxRows-t.first.NumRows(), batch.NumRows())
+	if keep > 0 {
+		for c := range t.first.Columns {
+			t.first.Columns[c] = append(t.first.Columns[c], batch.Columns[c][:keep]...)
+		}
+	}
+	t.total += batch.NumRows()
+	return nil
+}
+
+func (t *TableWriter) Close() error {
+	table := showTable(t.first, t.total, t.opts.MaxRows, t.opts.MaxWidth)
+	if !strings.HasSuffix(table, "\n") {
+		// the table of an empty result is a single line
+		table += "\n"
+	}
+	_, err := io.WriteString(t.out, table)
+	return err
+}
+
+// FullTableWriter renders every row in a box drawn table, values keep every
+// digit. the widths of the columns are fixed by the first page, or the first
+// batch without pages, longer values of later rows are cut
+type FullTableWriter struct {
+	w       *bufio.Writer
+	schema  *parquetSchema
+	opts    ResultOptions
+	widths  []int
+	pending RecordBatch // rows of the first page until the widths are known
+	rows    int
+}
+
+func NewFullTableWriter(out io.Writer, schema *parquetSchema, opts ResultOptions) *FullTableWriter {
+	return &FullTableWriter{
+		w:       bufio.NewWriter(out),
+		schema:  schema,
+		opts:    opts.withDefaults(),
+		pending: RecordBatch{Schema: *schema, Columns: make([][]any, len(schema.Fields))},
+	}
+}
+
+func (f *FullTableWriter) WriteBatch(batch RecordBatch) error {
+	if f.widths == nil {
+		for c := range f.pending.Columns {
+			f.pending.Columns[c] = append(f.pending.Columns[c], batch.Columns[c]...)
+		}
+		if f.opts.PageRows > 0 && f.pending.NumRows() < f.opts.PageRows {
+			return nil
+		}
+		batch = f.pending
+		f.start()
+	}
+	for row := 0; row < batch.NumRows(); row++ {
+		if f.opts.PageRows > 0 && f.rows > 0 && f.rows%f.opts.PageRows == 0 {
+			f.border("├", "┼", "┤")
+			f.header()
+			f.border("├", "┼", "┤")
+		}
+		f.w.WriteString("│")
+		for c, width := range f.widths {
+			f.w.WriteString(" ")
+			f.w.WriteString(fitCell(textValue(batch.Columns[c][row]), width))
+			f.w.WriteString(" │")
+		}
+		f.w.WriteString("\n")
+		f.rows++
+	}
+	return f.w.Flush()
+}
+
+// sizes the columns to the pending rows and writes the header
+func (f *FullTableWriter) start() {
+	f.widths = make([]int, len(f.schema.Fields))
+	for c, field := range f.schema.Fields {
+		width := cellWidth(field.Name)
+		for _, v := range f.pending.Columns[c] {
+			width = max(width, cellWidth(textValue(v)))
+		}
+		f.widths[c] = max(min(width, f.opts.MaxWidth), 3)
+	}
+	f.pending = RecordBatch{}
+	f.border("┌", "┬", "┐")
+	f.header()
+	f.border("├", "┼", "┤")
+}
+
+func (f *FullTableWriter) header() {
+	f.w.WriteString("│")
+	for c, field := range f.schema.Fields {
+		f.w.WriteString(" ")
+		f.w.WriteString(fitCell(field.Name, f.widths[c]))
+		f.w.WriteString(" │")
+	}
+	f.w.WriteString("\n")
+}
+
+// cuts s to width characters, ending in ... when it is cut, and pads it to
+// width. line breaks become spaces so a cell stays on its row
+func fitCell(s string, width int) string {
+	s = truncate(lineBreaks.Replace(s), width)
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// characters s takes in a cell, see fitCell
+func cellWidth(s string) int {
+	return utf8.RuneCountInString(lineBreaks.Replace(s))
+}
+
+func (f *FullTableWriter) border(left, middle, right string) {
+	f.w.WriteString(left)
+	for c, width := range f.widths {
+		if c > 0 {
+			f.w.WriteString(middle)
+		}
+		f.w.WriteString(strings.Repeat("─", width+2))
+	}
+	f.w.WriteString(right + "\n")
+}
+
+// writes the rows of an unfinished first page and the bottom of the table
+func (f *FullTableWriter) Close() error {
+	if f.widths == nil {
+		batch := f.pending
+		f.start()
+		if err := f.WriteBatch(batch); err != nil {
+			return err
+		}
+	}
+	f.border("└", "┴", "┘")
+	fmt.Fprintf(f.w, "%d rows\n", f.rows)
+	return f.w.Flush()
+}
+
+// MarkdownWriter writes a pipe table, numeric columns are right aligned.
+// values keep every digit, pipes are escaped and line breaks become spaces
+type MarkdownWriter struct {
+	w       *bufio.Writer
+	schema  *parquetSchema
+	started bool
+}
+
+func NewMarkdownWriter(out io.Writer, schema *parquetSchema) *MarkdownWriter {
+	return &MarkdownWriter{w: bufio.NewWriter(out), schema: schema}
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+
+func (m *MarkdownWriter) writeHeader() {
+	if m.started {
+		return
+	}
+	m.started = true
+	cells := make([]string, len(m.schema.Fields))
+	aligns := make([]string, len(m.schema.Fields))
+	for i, field := range m.schema.Fields {
+		cells[i] = markdownEscaper.Replace(field.Name)
+		aligns[i] = "---"
+		if field.Node == nil && isNumericType(field.PqType) {
+			aligns[i] = "---:"
+		}
+	}
+	m.row(cells)
+	m.row(aligns)
+}
+
+func (m *MarkdownWriter) row(cells []string) {
+	m.w.WriteString("| ")
+	m.w.WriteString(strings.Join(cells, " | "))
+	m.w.WriteString(" |\n")
+}
+
+func (m *MarkdownWriter) WriteBatch(batch RecordBatch) error {
+	m.writeHeader()
+	cells := make([]string, len(batch.Columns))
+	for row := 0; row < batch.NumRows(); row++ {
+		for c := range batch.Columns {
+			if v := batch.Columns[c][row]; v == nil {
+				cells[c] = "NULL"
+			} else {
+				cells[c] = markdownEscaper.Replace(textValue(v))
+			}
+		}
+		m.row(cells)
+	}
+	return m.w.Flush()
+}
+
+func (m *MarkdownWriter) Close() error {
+	m.writeHeader()
+	return m.w.Flush()
+}
@@ -0,0 +1,208 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func numberedInput(t *testing.T, rows int) Operator {
+	t.Helper()
+	var src strings.Builder
+	for i := 1; i <= rows; i++ {
+		fmt.Fprintf(&src, "{\"id\": %d, \"name\": \"row %d\"}\n", i, i)
+	}
+	op, err := NewJSONExecLeaf(strings.NewReader(src.String()), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+// counts the batches written to a result writer
+type batchCounter struct {
+	BatchWriter
+	batches int
+}
+
+func (c *batchCounter) WriteBatch(batch RecordBatch) error {
+	c.batches++
+	return c.BatchWriter.WriteBatch(batch)
+}
+
+func TestMarkdownResult(t *testing.T) {
+	src := `{"name": "a|b", "score": 10}
+{"name": "line\nbreak", "score": null}
+`
+	op, err := NewJSONExecLeaf(strings.NewReader(src), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	rows, err := WriteResult(&out, op, FormatMarkdown, ResultOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "| name | score |\n| --- | ---: |\n| a\\|b | 10 |\n| line break | NULL |\n"
+	if rows != 2 || out.String() != want {
+		t.Fatalf("expected\n%s\ngot %d rows\n%s", want, rows, out.String())
+	}
+}
+
+func TestFullTableResult(t *testing.T) {
+	var out bytes.Buffer
+	rows, err := WriteResult(&out, numberedInput(t, 25), FormatFull, ResultOptions{PageRows: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	if rows != 25 || !strings.Contains(text, "│ row 25 ") || !strings.HasSuffix(text, "┘\n25 rows\n") {
+		t.Fatalf("expected every row, got %d\n%s", rows, text)
+	}
+	// the header opens each of the 3 pages
+	if n := strings.Count(text, "│ id "); n != 3 {
+		t.Fatalf("expected 3 headers, got %d\n%s", n, text)
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for _, line := range lines[:len(lines)-1] {
+		if len([]rune(line)) != len([]rune(lines[0])) {
+			t.Fatalf("expected aligned lines\n%s", text)
+		}
+	}
+
+	// floats are not rounded like the table preview does
+	lat, err := NewJSONExecLeaf(strings.NewReader(`{"lat": -8.8368}`), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if _, err := WriteResult(&out, lat, FormatFull, ResultOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "│ -8.8368 │") {
+		t.Fatalf("expected every digit of lat, got\n%s", out.String())
+	}
+
+	// widths and cuts count characters, not bytes, line breaks stay in the row
+	names, err := NewJSONExecLeaf(strings.NewReader(`{"name": "Curaçao"}
+{"name": "Ελληνική Δημοκρατία"}
+{"name": "x\ny"}
+`), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if _, err := WriteResult(&out, names, FormatFull, ResultOptions{MaxWidth: 10}); err != nil {
+		t.Fatal(err)
+	}
+	text = out.String()
+	if !utf8.ValidString(text) || !strings.Contains(text, "│ Curaçao    │") || !strings.Contains(text, "│ Ελληνικ... │") || !strings.Contains(text, "│ x y        │") {
+		t.Fatalf("expected cells cut and padded by character, got\n%s", text)
+	}
+	lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for _, line := range lines[:len(lines)-1] {
+		if utf8.RuneCountInString(line) != utf8.RuneCountInString(lines[0]) {
+			t.Fatalf("expected aligned lines\n%s", text)
+		}
+	}
+
+	// an empty result still has its header
+	out.Reset()
+	if _, err := WriteResult(&out, NewLimitExec(numberedInput(t, 1), 0), FormatFull, ResultOptions{PageRows: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if text := out.String(); !strings.Contains(text, "│ id ") || !strings.HasSuffix(text, "0 rows\n") {
+		t.Fatalf("expected an empty table, got\n%s", text)
+	}
+}
+
+func TestTableResultCountsCharacters(t *testing.T) {
+	op, err := NewJSONExecLeaf(strings.NewReader(`{"name": "aaaaaaaaaaaaaaaaaaaaéééééé"}
+{"name": "b\nc"}
+`), JSONOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := WriteResult(&out, op, FormatTable, ResultOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	if !strings.Contains(text, "│ aaaaaaaaaaaaaaaaaaaaéééééé │") || !strings.Contains(text, "│ b c                        │") {
+		t.Fatalf("expected cells padded by character, got\n%s", text)
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for _, line := range lines[2:] {
+		if utf8.RuneCountInString(line) != utf8.RuneCountInString(lines[2]) {
+			t.Fatalf("expected aligned lines\n%s", text)
+		}
+	}
+
+	// an empty result ends its line too
+	out.Reset()
+	if _, err := WriteResult(&out, NewLimitExec(numberedInput(t, 1), 0), FormatTable, ResultOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if text := out.String(); text != "RecordBatch with 0 rows\n" {
+		t.Fatalf("expected the empty table on its own line, got %q", text)
+	}
+}
+
+func TestTableResultStreams(t *testing.T) {
+	op := numberedInput(t, 5000)
+	var out bytes.Buffer
+	w := &batchCounter{BatchWriter: NewTableWriter(&out, op.Schema(), ResultOptions{MaxRows: 3})}
+	if _, err := CopyBatches(w, op, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.batches < 5 {
+		t.Fatalf("expected the rows batch by batch, got %d batches", w.batches)
+	}
+	if kept := w.BatchWriter.(*TableWriter).first.NumRows(); kept != 3 {
+		t.Fatalf("expected 3 rows kept, got %d", kept)
+	}
+	if text := out.String(); !strings.Contains(text, "row 3") || strings.Contains(text, "row 4 ") || !strings.Contains(text, "4997 more rows") {
+		t.Fatalf("unexpected preview\n%s", text)
+	}
+}
+
+func TestRegisterResultFormat(t *testing.T) {
+	if _, err := WriteResult(io.Discard, numberedInput(t, 1), "xml", ResultOptions{}); err == nil {
+		t.Fatal("expected an unknown format to fail")
+	}
+	RegisterResultFormat("count", func(out io.Writer, schema *parquetSchema, _ ResultOptions) (BatchWriter, error) {
+		return &rowCounter{out: out}, nil
+	})
+	defer func() {
+		delete(resultWriters, "count")
+		resultFormats = resultFormats[:len(resultFormats)-1]
+	}()
+	if formats := ResultFormats(); formats[len(formats)-1] != "count" {
+		t.Fatalf("expected count to be listed, got %v", formats)
+	}
+	var out bytes.Buffer
+	if _, err := WriteResult(&out, numberedInput(t, 7), "COUNT", ResultOptions{}); err != nil || out.String() != "7\n" {
+		t.Fatalf("expected 7, got %q (%v)", out.String(), err)
+	}
+}
+
+type rowCounter struct {
+	out  io.Writer
+	rows int
+}
+
+func (r *rowCounter) WriteBatch(batch RecordBatch) error {
+	r.rows += batch.NumRows()
+	return nil
+}
+
+func (r *rowCounter) Close() error {
+	_, err := fmt.Fprintln(r.out, r.rows)
+	return err
+}
@@ -0,0 +1,534 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3 compatible object storage: s3://bucket/key paths are listed, read with
+// range requests through an HTTPSource and written with multipart uploads.
+// requests are signed with signature version 4 using credentials from the
+// environment, a custom endpoint (minio, ceph, ...) is addressed path style
+
+// S3Config is the account and endpoint objects are read from and written to
+type S3Config struct {
+	Region          string
+	Endpoint        string // e.g. http://localhost:9000, aws when empty
+	AccessKeyID     string // requests are not signed when empty
+	SecretAccessKey string
+	SessionToken    string
+	PathStyle       bool  // bucket in the path instead of the host name, always set with Endpoint
+	PartSize        int64 // bytes per part of multipart uploads, 8 MiB by default. s3 needs at least 5 MiB
+	Client          *http.Client
+}
+
+// S3ConfigFromEnv reads the standard AWS_* variables: AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION or AWS_DEFAULT_REGION
+// and AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL
+func S3ConfigFromEnv() S3Config {
+	cfg := S3Config{
+		Region:          firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Endpoint:        firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	cfg.PathStyle = cfg.Endpoint != ""
+	return cfg
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// S3Client sends the few requests datasets need
+type S3Client struct {
+	cfg S3Config
+}
+
+func NewS3Client(cfg S3Config) *S3Client {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint != "" {
+		cfg.PathStyle = true
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = 8 << 20
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &S3Client{cfg: cfg}
+}
+
+// S3Object is an entry of a listing
+type S3Object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// splits s3://bucket/key
+func parseS3URL(u string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(u, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("%q is not an s3:// url", u)
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%q has no bucket", u)
+	}
+	return bucket, key, nil
+}
+
+func isS3URL(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+func (c *S3Client) objectURL(bucket, key string, query url.Values) string {
+	var u string
+	switch {
+	case c.cfg.PathStyle:
+		endpoint := c.cfg.Endpoint
+		if endpoint == "" {
+			endpoint = "https://s3." + c.cfg.Region + ".amazonaws.com"
+		}
+		u = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + s3Escape(key, false)
+	default:
+		u = "https://" + bucket + ".s3." + c.cfg.Region + ".amazonaws.com/" + s3Escape(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	return u
+}
+
+// sends a signed request and returns the body, s3 errors become go errors
+func (c *S3Client) do(method, bucket, key string, query url.Values, body []byte) ([]byte, http.Header, error) {
+	req, err := http.NewRequest(method, c.objectURL(bucket, key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	c.sign(req, sha256Hex(body), time.Now())
+	resp, err := c.cfg.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var s3err struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(data, &s3err) != nil || s3err.Code == "" {
+			s3err.Code = resp.Status
+		}
+		return nil, nil, fmt.Errorf("s3 %s s3://%s/%s: %s %s", strings.ToLower(method), bucket, key, s3err.Code, s3err.Message)
+	}
+	return data, resp.Header, nil
+}
+
+// List returns every object of bucket whose key starts with prefix
+func (c *S3Client) List(bucket, prefix string) ([]S3Object, error) {
+	var objects []S3Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		data, _, err := c.do(http.MethodGet, bucket, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key          string
+				Size         int64
+				ETag         string
+				LastModified time.Time
+			}
+		}
+		if err := xml.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, S3Object{Key: obj.Key, Size: obj.Size, ETag: obj.ETag, LastModified: obj.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Open reads an object with signed range requests
+func (c *S3Client) Open(bucket, key string, opts HTTPOptions) (*HTTPSource, error) {
+	opts.Client = c.cfg.Client
+	opts.Prepare = func(req *http.Request) error {
+		c.sign(req, emptySHA256, time.Now())
+		return nil
+	}
+	return OpenHTTPSource(c.objectURL(bucket, key, nil), opts)
+}
+
+// Create writes an object, it is uploaded in parts once it outgrows PartSize
+func (c *S3Client) Create(bucket, key string) *S3Writer {
+	return &S3Writer{c: c, bucket: bucket, key: key}
+}
+
+// S3Writer uploads what is written to it. Close completes the upload,
+// Abort drops it and the parts sent so far
+type S3Writer struct {
+	c           *S3Client
+	bucket, key string
+	buf         bytes.Buffer
+	uploadID    string
+	etags       []string // of the uploaded parts in order
+	err         error
+	done        bool
+}
+
+func (w *S3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.done {
+		return 0, fmt.Errorf("write to closed s3://%s/%s", w.bucket, w.key)
+	}
+	w.buf.Write(p)
+	for int64(w.buf.Len()) >= w.c.cfg.PartSize {
+		if w.err = w.uploadPart(w.buf.Next(int(w.c.cfg.PartSize))); w.err != nil {
+			w.Abort()
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *S3Writer) uploadPart(part []byte) error {
+	if w.uploadID == "" {
+		data, _, err := w.c.do(http.MethodPost, w.bucket, w.key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		var created struct{ UploadId string }
+		if err := xml.Unmarshal(data, &created); err != nil || created.UploadId == "" {
+			return fmt.Errorf("starting upload of s3://%s/%s: no upload id", w.bucket, w.key)
+		}
+		w.uploadID = created.UploadId
+	}
+	query := url.Values{"partNumber": {strconv.Itoa(len(w.etags) + 1)}, "uploadId": {w.uploadID}}
+	_, header, err := w.c.do(http.MethodPut, w.bucket, w.key, query, part)
+	if err != nil {
+		return err
+	}
+	w.etags = append(w.etags, header.Get("ETag"))
+	return nil
+}
+
+// Close uploads the rest and completes the upload, small objects are sent
+// with a single request
+func (w *S3Writer) Close() error {
+	if w.done || w.err != nil {
+		return w.err
+	}
+	w.done = true
+	if w.uploadID == "" {
+		_, _, w.err = w.c.do(http.MethodPut, w.bucket, w.key, nil, w.buf.Bytes())
+		return w.err
+	}
+	if w.buf.Len() > 0 {
+		if w.err = w.uploadPart(w.buf.Bytes()); w.err != nil {
+			w.Abort()
+			return w.err
+		}
+	}
+	var complete bytes.Buffer
+	complete.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range w.etags {
+		fmt.Fprintf(&complete, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, xmlEscape(etag))
+	}
+	complete.WriteString("</CompleteMultipartUpload>")
+	if _, _, w.err = w.c.do(http.MethodPost, w.bucket, w.key, url.Values{"uploadId": {w.uploadID}}, complete.Bytes()); w.err != nil {
+		w.Abort()
+	}
+	return w.err
+}
+
+// Abort drops an unfinished upload
+func (w *S3Writer) Abort() error {
+	w.done = true
+	if w.err == nil {
+		w.err = fmt.Errorf("upload of s3://%s/%s aborted", w.bucket, w.key)
+	}
+	if w.uploadID == "" {
+		return nil
+	}
+	_, _, err := w.c.do(http.MethodDelete, w.bucket, w.key, url.Values{"uploadId": {w.uploadID}}, nil)
+	return err
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds a signature version 4 Authorization header. the host, range and
+// x-amz-* headers are signed
+func (c *S3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	if c.cfg.AccessKeyID == "" {
+		return
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		headers["host"] = req.Host
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(req.URL.EscapedPath() + "\n")
+	canonical.WriteString(canonicalQuery(req.URL.Query()) + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n" + payloadHash)
+
+	scope := day + "/" + c.cfg.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical.String()))
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretAccessKey), day)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// query string with sorted keys and values escaped the way signatures expect
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// percent encodes every byte but the unreserved characters, and / in paths
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !slash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// paths of datasets and outputs: local files or s3:// objects
+
+// opens a local file or an s3 object for reading, local files are memory
+// mapped with mmap
+func openPath(p string, mmap bool, httpOpts HTTPOptions) (Source, io.Closer, error) {
+	if isS3URL(p) {
+		bucket, key, err := parseS3URL(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		src, err := NewS3Client(S3ConfigFromEnv()).Open(bucket, key, httpOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return src, src, nil
+	}
+	if mmap {
+		m, err := OpenMmap(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		return m, m, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	src, err := AsSource(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return src, f, nil
+}
+
+// CreateOutput creates a local file, with its directories, or an s3 object
+// written with a multipart upload that is completed by Close
+func CreateOutput(p string) (io.WriteCloser, error) {
+	if isS3URL(p) {
+		bucket, key, err := parseS3URL(p)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Client(S3ConfigFromEnv()).Create(bucket, key), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
+
+// drops an output after a failure: uploads are aborted, files removed
+func discardOutput(out io.WriteCloser, p string) {
+	if w, ok := out.(*S3Writer); ok {
+		w.Abort()
+		return
+	}
+	out.Close()
+	os.Remove(p)
+}
+
+// joins path elements, s3 urls keep their double slash
+func joinPath(root string, elem ...string) string {
+	if isS3URL(root) {
+		return "s3://" + path.Join(append([]string{strings.TrimPrefix(root, "s3://")}, elem...)...)
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// every parquet object under an s3:// prefix, or matching an s3:// glob, and
+// the prefix partition directories are relative to. see datasetPaths
+func s3DatasetPaths(pattern string) ([]string, string, error) {
+	objects, root, err := s3DatasetObjects(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	paths := make([]string, len(objects))
+	for i, obj := range objects {
+		paths[i] = obj.Key
+	}
+	return paths, root, nil
+}
+
+// the objects of s3DatasetPaths, their keys are s3:// urls
+func s3DatasetObjects(pattern string) ([]S3Object, string, error) {
+	bucket, key, err := parseS3URL(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	client := NewS3Client(S3ConfigFromEnv())
+	glob := strings.ContainsAny(key, `*?[\`)
+	prefix := strings.TrimSuffix(key, "/")
+	if glob {
+		prefix = strings.TrimPrefix(globRoot("/"+key), "/")
+	}
+	listPrefix := prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+	listed, err := client.List(bucket, listPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+	var objects []S3Object
+	for _, obj := range listed {
+		if glob {
+			if ok, _ := path.Match(key, obj.Key); !ok {
+				continue
+			}
+		} else if !strings.EqualFold(path.Ext(obj.Key), ".parquet") {
+			continue
+		}
+		obj.Key = "s3://" + bucket + "/" + obj.Key
+		objects = append(objects, obj)
+	}
+	if len(objects) == 0 && !glob && strings.EqualFold(path.Ext(key), ".parquet") {
+		// a single object, like a path naming a file
+		listed, err := client.List(bucket, key)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, obj := range listed {
+			if obj.Key == key {
+				obj.Key = pattern
+				objects = append(objects, obj)
+			}
+		}
+		prefix = path.Dir(key)
+	}
+	if len(objects) == 0 {
+		return nil, "", fmt.Errorf("no parquet files match %s", pattern)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, joinPath("s3://"+bucket, prefix), nil
+}
@@ -1,9 +1,208 @@
 package projectoptimizer
 
-
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
 
 /*
 deal with serializing Record batches to and from disk.
 
+intermediate results are stored as plain parquet files so that anything that
+can read a parquet leaf can read a spilled result back. a SpillWriter streams
+RecordBatches into a file inside a SpillDir and hands back a SpillHandle, the
+handle can be opened any number of times as an Operator by upper operators.
+every handle carries a crc32 of the bytes written so a truncated or modified
+file is caught before it is read, and closing the SpillDir removes every file
+created in it.
+*/
+
+var ErrSpillChecksum = errors.New("spill file checksum mismatch")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SpillDir owns the temp files of a single query
+type SpillDir struct {
+	mu     sync.Mutex
+	path   string
+	closed bool
+}
+
+func NewSpillDir(parent string) (*SpillDir, error) {
+	path, err := os.MkdirTemp(parent, "parqlite-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("creating spill directory: %w", err)
+	}
+	return &SpillDir{path: path}, nil
+}
+
+func (d *SpillDir) Path() string {
+	return d.path
+}
+
+// removes the directory and every spill file written to it
+func (d *SpillDir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	return os.RemoveAll(d.path)
+}
+
+func (d *SpillDir) NewWriter(schema *parquetSchema) (*SpillWriter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, fmt.Errorf("spill directory %s already closed", d.path)
+	}
+	f, err := os.CreateTemp(d.path, "batch-*.parquet")
+	if err != nil {
+		return nil, fmt.Errorf("creating spill file: %w", err)
+	}
+	hasher := crc32.New(crcTable)
+	counter := &countingWriter{w: io.MultiWriter(f, hasher)}
+	bw, err := NewParquetBatchWriter(counter, schema)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &SpillWriter{
+		f:      f,
+		hasher: hasher,
+		count:  counter,
+		writer: bw,
+		schema: schema.Clone(),
+	}, nil
+}
+
+// SpillWriter streams RecordBatches into a single spill file
+type SpillWriter struct {
+	f      *os.File
+	hasher hash.Hash32
+	count  *countingWriter
+	writer *ParquetBatchWriter
+	schema *parquetSchema
+	rows   int64
+}
+
+func (w *SpillWriter) Write(batch RecordBatch) error {
+	if err := w.writer.WriteBatch(batch); err != nil {
+		return err
+	}
+	w.rows += int64(batch.NumRows())
+	return nil
+}
+
+// flushes the parquet footer and returns a handle to the finished file
+func (w *SpillWriter) Finish() (*SpillHandle, error) {
+	if err := w.writer.Close(); err != nil {
+		w.abort()
+		return nil, fmt.Errorf("writing spill file footer: %w", err)
+	}
+	if err := w.f.Sync(); err != nil {
+		w.abort()
+		return nil, fmt.Errorf("syncing spill file: %w", err)
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return nil, fmt.Errorf("closing spill file: %w", err)
+	}
+	return &SpillHandle{
+		Path:     w.f.Name(),
+		Schema:   w.schema,
+		Rows:     w.rows,
+		Size:     w.count.n,
+		Checksum: w.hasher.Sum32(),
+	}, nil
+}
+
+// drops a partially written file
+func (w *SpillWriter) abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// SpillHandle points at a finished spill file
+type SpillHandle struct {
+	Path     string
+	Schema   *parquetSchema
+	Rows     int64
+	Size     int64
+	Checksum uint32
+}
+
+// verifies the checksum and returns an Operator over the stored batches
+func (h *SpillHandle) Open() (*SpillReader, error) {
+	f, err := os.Open(h.Path)
+	if err != nil {
+		return nil, fmt.Errorf("opening spill file: %w", err)
+	}
+	if err := h.verify(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	leaf, err := OpenProjectExecLeaf(f, h.Schema.toColumns(), nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &SpillReader{f: f, leaf: leaf}, nil
+}
+
+func (h *SpillHandle) verify(f *os.File) error {
+	hasher := crc32.New(crcTable)
+	n, err := io.Copy(hasher, f)
+	if err != nil {
+		return fmt.Errorf("reading spill file %s: %w", filepath.Base(h.Path), err)
+	}
+	if n != h.Size || hasher.Sum32() != h.Checksum {
+		return fmt.Errorf("%w: %s (size %d/%d, crc %08x/%08x)", ErrSpillChecksum, filepath.Base(h.Path), n, h.Size, hasher.Sum32(), h.Checksum)
+	}
+	return nil
+}
+
+// deletes the file early, closing the SpillDir does the same for every handle
+func (h *SpillHandle) Remove() error {
+	if err := os.Remove(h.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// SpillReader reads a spill file back as a leaf operator
+type SpillReader struct {
+	f    *os.File
+	leaf *ProjectExec
+}
+
+func (r *SpillReader) Next(n uint) (RecordBatch, error) {
+	return r.leaf.Next(n)
+}
+
+func (r *SpillReader) Schema() *parquetSchema {
+	return r.leaf.Schema()
+}
+
+func (r *SpillReader) Close() error {
+	return r.f.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
 
-*/
\ No newline at end of file
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
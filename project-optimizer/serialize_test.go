@@ -0,0 +1,101 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestSpillRoundTrip(t *testing.T) {
+	f := generateDataFilter()
+	defer f.Close()
+
+	leaf := NewProjectExecLeaf(f, []string{"country", "lat", "temp_mean_c_approx"}, nil)
+	batch, err := leaf.Next(100)
+	if err != nil && err != io.EOF {
+		t.Fatalf("reading fixture: %v", err)
+	}
+
+	dir, err := NewSpillDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+
+	w, err := dir.NewWriter(leaf.Schema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(batch); err != nil {
+		t.Fatalf("writing batch: %v", err)
+	}
+	handle, err := w.Finish()
+	if err != nil {
+		t.Fatalf("finishing spill file: %v", err)
+	}
+	if handle.Rows != 100 {
+		t.Fatalf("expected 100 rows in handle, got %d", handle.Rows)
+	}
+
+	r, err := handle.Open()
+	if err != nil {
+		t.Fatalf("opening spill file: %v", err)
+	}
+	defer r.Close()
+	got, err := r.Next(200)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF after reading every row, got %v", err)
+	}
+	if got.NumRows() != batch.NumRows() {
+		t.Fatalf("expected %d rows back, got %d", batch.NumRows(), got.NumRows())
+	}
+	for c := range batch.Columns {
+		for i := range batch.Columns[c] {
+			if got.Columns[c][i] != batch.Columns[c][i] {
+				t.Fatalf("column %d row %d: expected %v, got %v", c, i, batch.Columns[c][i], got.Columns[c][i])
+			}
+		}
+	}
+
+	if err := dir.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(handle.Path); !os.IsNotExist(err) {
+		t.Errorf("expected spill file to be removed with its directory, stat err: %v", err)
+	}
+}
+
+func TestSpillChecksumMismatch(t *testing.T) {
+	dir, err := NewSpillDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+
+	schema := &parquetSchema{Fields: []structField{{Name: "lat", PqType: parquet.DoubleType}}}
+	w, err := dir.NewWriter(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(RecordBatch{Schema: *schema, Columns: [][]any{{1.5, 2.5}}}); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := w.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(handle.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("garbage"))
+	f.Close()
+
+	if _, err := handle.Open(); !errors.Is(err, ErrSpillChecksum) {
+		t.Fatalf("expected ErrSpillChecksum, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Session runs SQL over files registered as tables, the queries share
+// Options and so its chunk cache, each query has a memory pool of its own
+// bounded by Options.MemoryLimit
+type Session struct {
+	Options PlanOptions
+	tables  map[string]string
+}
+
+func NewSession(opts PlanOptions) *Session {
+	return &Session{Options: opts, tables: make(map[string]string)}
+}
+
+// Register makes a file, dataset or url queryable as name. the file is
+// opened once to check it can be read
+func (s *Session) Register(name, source string) error {
+	if !isTableName(name) {
+		return fmt.Errorf("invalid table name %q", name)
+	}
+	in, err := BuildPlan(&ScanPlan{Path: source}, s.Options)
+	if err != nil {
+		return err
+	}
+	in.Close()
+	for table := range s.tables {
+		if strings.EqualFold(table, name) {
+			delete(s.tables, table)
+		}
+	}
+	s.tables[name] = source
+	return nil
+}
+
+// TableName derives a table name from a path: its base name without
+// extensions, characters a name cannot hold replaced by _
+func TableName(source string) string {
+	name := path.Base(strings.ReplaceAll(source, "\\", "/"))
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" || name[0] >= '0' && name[0] <= '9' || sqlKeywords[strings.ToUpper(name)] {
+		name = "t_" + name
+	}
+	return name
+}
+
+func isTableName(name string) bool {
+	return name != "" && TableName(name) == name
+}
+
+// Tables lists the registered tables by name
+func (s *Session) Tables() []string {
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Source is the path a table reads
+func (s *Session) Source(table string) (string, bool) {
+	return lookupTable(s.tables, table)
+}
+
+// Schema is the schema of the rows a table holds
+func (s *Session) Schema(table string) (*parquetSchema, error) {
+	source, ok := s.Source(table)
+	if !ok {
+		return nil, fmt.Errorf("no such table %q", table)
+	}
+	in, err := BuildPlan(&ScanPlan{Path: source}, s.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return in.Schema().Clone(), nil
+}
+
+// Plan parses a query over the registered tables into its normalized plan
+func (s *Session) Plan(query string) (Plan, error) {
+	p, err := ParseSQL(query, s.tables)
+	if err != nil {
+		return nil, err
+	}
+	return NormalizePlan(p)
+}
+
+// Query runs a query, Close releases the files it reads
+func (s *Session) Query(query string) (*Input, error) {
+	p, err := s.Plan(query)
+	if err != nil {
+		return nil, err
+	}
+	return buildPlan(p, s.Options)
+}
+
+// Explain renders the plan of a query
+func (s *Session) Explain(query string) (string, error) {
+	p, err := s.Plan(query)
+	if err != nil {
+		return "", err
+	}
+	return FormatPlan(p), nil
+}
@@ -0,0 +1,204 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Shell is the interactive front end of a Session. lines ending with ; run
+// the SQL typed so far, lines starting with . are commands:
+//
+//	.tables                  list the tables
+//	.schema [table]          the schema of a table or of every table
+//	.explain <query>         the plan of a query
+//	.register <name> <path>  make a file queryable as name
+//	.mode [mode]             a result format, see ResultFormats
+//	.timer on|off            report how long queries run
+//	.memory on|off           report the peak memory of queries
+//	.help                    list the commands
+//	.quit, .exit             leave the shell
+type Shell struct {
+	Session *Session
+	Out     io.Writer // results
+	Err     io.Writer // errors
+	Mode    string    // result format, see ResultFormats
+	Result  ResultOptions
+	Pager   string // command the full table is paged with, e.g. less -S. empty writes it to Out
+	Timer   bool
+	Memory  bool // report the peak memory of every query and its operators
+
+	pending strings.Builder // lines of an unfinished statement
+}
+
+func NewShell(session *Session, out, errOut io.Writer) *Shell {
+	return &Shell{Session: session, Out: out, Err: errOut, Mode: FormatTable}
+}
+
+// Prompt asks for a statement or for the rest of one
+func (sh *Shell) Prompt() string {
+	if sh.pending.Len() > 0 {
+		return "   ...> "
+	}
+	return "parqlite> "
+}
+
+// Run reads and executes lines until the input ends or .quit
+func (sh *Shell) Run(lr LineReader) error {
+	for {
+		line, err := lr.ReadLine(sh.Prompt())
+		if errors.Is(err, ErrInterrupt) {
+			sh.pending.Reset()
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		lr.AddHistory(line)
+		if sh.Exec(line) {
+			return nil
+		}
+	}
+}
+
+// Exec executes a line, errors are written to Err. true once the shell
+// should exit
+func (sh *Shell) Exec(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if sh.pending.Len() == 0 {
+		if trimmed == "" {
+			return false
+		}
+		if strings.HasPrefix(trimmed, ".") {
+			quit, err := sh.Command(trimmed)
+			if err != nil {
+				fmt.Fprintf(sh.Err, "error: %v\n", err)
+			}
+			return quit
+		}
+	}
+	sh.pending.WriteString(line)
+	sh.pending.WriteByte('\n')
+	if !strings.HasSuffix(trimmed, ";") {
+		return false
+	}
+	query := sh.pending.String()
+	sh.pending.Reset()
+	if err := sh.query(query); err != nil {
+		fmt.Fprintf(sh.Err, "error: %v\n", err)
+	}
+	return false
+}
+
+func (sh *Shell) query(query string) error {
+	start := time.Now()
+	in, err := sh.Session.Query(query)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out := sh.Out
+	if sh.Mode == FormatFull && sh.Pager != "" {
+		pager, err := StartPager(sh.Pager, sh.Out)
+		if err != nil {
+			return err
+		}
+		defer pager.Close()
+		out = pager
+	}
+	rows, err := WriteResult(out, in, sh.Mode, sh.Result)
+	if err != nil && !errors.Is(err, ErrPagerClosed) {
+		return err
+	}
+	if sh.Timer {
+		fmt.Fprintf(sh.Out, "Run Time: %v, %d rows\n", time.Since(start).Round(time.Microsecond), rows)
+	}
+	if sh.Memory {
+		fmt.Fprintf(sh.Out, "Memory: %s\n", in.Memory())
+	}
+	return nil
+}
+
+// Command runs a dot command, true once the shell should exit
+func (sh *Shell) Command(line string) (quit bool, err error) {
+	name, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+	args := strings.Fields(rest)
+	switch name {
+	case ".quit", ".exit":
+		return true, nil
+	case ".help":
+		fmt.Fprint(sh.Out, shellHelp)
+	case ".tables":
+		for _, table := range sh.Session.Tables() {
+			source, _ := sh.Session.Source(table)
+			fmt.Fprintf(sh.Out, "%s\t%s\n", table, source)
+		}
+	case ".schema":
+		tables := args
+		if len(tables) == 0 {
+			tables = sh.Session.Tables()
+		}
+		for _, table := range tables {
+			schema, err := sh.Session.Schema(table)
+			if err != nil {
+				return false, err
+			}
+			fmt.Fprintf(sh.Out, "%s:\n%s\n", table, schema.ShowSchema())
+		}
+	case ".explain":
+		if rest == "" {
+			return false, fmt.Errorf("usage: .explain <query>")
+		}
+		plan, err := sh.Session.Explain(rest)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprint(sh.Out, plan)
+	case ".register":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: .register <name> <path>")
+		}
+		return false, sh.Session.Register(args[0], args[1])
+	case ".mode":
+		if len(args) == 0 {
+			fmt.Fprintln(sh.Out, sh.Mode)
+			return false, nil
+		}
+		mode := strings.ToLower(args[0])
+		if indexFold(ResultFormats(), mode) < 0 {
+			return false, fmt.Errorf("unknown mode %q, expected one of %s", args[0], strings.Join(ResultFormats(), ", "))
+		}
+		sh.Mode = mode
+	case ".timer":
+		if len(args) != 1 || args[0] != "on" && args[0] != "off" {
+			return false, fmt.Errorf("usage: .timer on|off")
+		}
+		sh.Timer = args[0] == "on"
+	case ".memory":
+		if len(args) != 1 || args[0] != "on" && args[0] != "off" {
+			return false, fmt.Errorf("usage: .memory on|off")
+		}
+		sh.Memory = args[0] == "on"
+	default:
+		return false, fmt.Errorf("unknown command %s, try .help", name)
+	}
+	return false, nil
+}
+
+const shellHelp = `.tables                  list the tables
+.schema [table]          the schema of a table or of every table
+.explain <query>         the plan of a query
+.register <name> <path>  make a file queryable as name
+.mode [mode]             table, full, csv, tsv, json, ndjson or markdown
+.timer on|off            report how long queries run
+.memory on|off           report the peak memory of queries
+.help                    list the commands
+.quit, .exit             leave the shell
+statements end with ;, e.g. SELECT country, lat FROM history WHERE lat > 0 LIMIT 5;
+`
@@ -0,0 +1,113 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	var out, errOut bytes.Buffer
+	sh := NewShell(NewSession(PlanOptions{}), &out, &errOut)
+	script := strings.Join([]string{
+		".register history ../data/history.parquet",
+		".tables",
+		".schema history",
+		".mode csv",
+		"SELECT country, lat",
+		"  FROM history WHERE country = 'Angola'",
+		"  LIMIT 2;",
+		".explain SELECT count(*) FROM history WHERE lat > 0",
+		".mode ndjson",
+		".timer on",
+		".memory on",
+		"SELECT count(*) FROM history WHERE country = 'Angola';",
+		"SELECT nope FROM history;",
+		".mode xml",
+		".quit",
+		"SELECT * FROM history;",
+	}, "\n")
+	if err := sh.Run(NewPlainLineReader(strings.NewReader(script), nil)); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"history\t../data/history.parquet\n",
+		"history:\n",
+		"country,lat\nAngola,-8.838333333\nAngola,-8.838333333\n",
+		"aggregate(COUNT(*))\n  filter(lat > 0)\n",
+		`{"count":307}` + "\n",
+		"Run Time: ",
+		"Memory: peak ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in the output\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "Run Time: ") != 1 {
+		t.Fatalf("expected the shell to stop at .quit\n%s", got)
+	}
+	errs := errOut.String()
+	if !strings.Contains(errs, "nope") || !strings.Contains(errs, `unknown mode "xml"`) {
+		t.Fatalf("expected the errors to be reported, got %q", errs)
+	}
+	if sh.Prompt() != "parqlite> " {
+		t.Fatalf("expected no pending statement")
+	}
+	sh.Exec("SELECT lat")
+	if sh.Prompt() != "   ...> " {
+		t.Fatalf("expected the continuation prompt, got %q", sh.Prompt())
+	}
+}
+
+func TestShellTableMode(t *testing.T) {
+	var out bytes.Buffer
+	s := NewSession(PlanOptions{})
+	if err := s.Register(TableName("../data/history.parquet"), "../data/history.parquet"); err != nil {
+		t.Fatal(err)
+	}
+	sh := NewShell(s, &out, &out)
+	sh.Exec("SELECT country FROM history WHERE country = 'Angola';")
+	if !strings.Contains(out.String(), "RecordBatch: 307 rows × 1 columns") || !strings.Contains(out.String(), "... 287 more rows") {
+		t.Fatalf("unexpected table\n%s", out.String())
+	}
+}
+
+func TestTableName(t *testing.T) {
+	for path, want := range map[string]string{
+		"data/history.parquet": "history",
+		"s3://bucket/daily/":   "daily",
+		"2024-sales.csv.gz":    "t_2024_sales",
+		"order.json":           "t_order",
+	} {
+		if got := TableName(path); got != want {
+			t.Fatalf("%s: expected %s, got %s", path, want, got)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h, err := openHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"SELECT 1;", " ", "SELECT 1;", ".tables"} {
+		h.add(line)
+	}
+	h.close()
+	data, _ := os.ReadFile(path)
+	if string(data) != "SELECT 1;\n.tables\n" {
+		t.Fatalf("unexpected history file %q", data)
+	}
+	h, err = openHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+	if len(h.lines) != 2 || h.lines[1] != ".tables" {
+		t.Fatalf("expected the history to be loaded, got %q", h.lines)
+	}
+}
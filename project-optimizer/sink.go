@@ -0,0 +1,448 @@
+package projectoptimizer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/encoding"
+)
+
+// sinks that write the output of an operator tree in one of the supported
+// file formats, batches are written as they arrive so results never have to
+// fit in memory
+
+type BatchWriter interface {
+	WriteBatch(batch RecordBatch) error
+	Close() error // flushes footers/closing brackets, does not close the underlying writer
+}
+
+// drains an operator into a writer, returns the number of rows written
+func CopyBatches(dst BatchWriter, src Operator, batchSize uint) (int64, error) {
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+	var rows int64
+	for {
+		batch, err := src.Next(batchSize)
+		if err != nil && err != io.EOF {
+			return rows, err
+		}
+		if batch.NumRows() > 0 {
+			if werr := dst.WriteBatch(batch); werr != nil {
+				return rows, werr
+			}
+			rows += int64(batch.NumRows())
+		}
+		if err == io.EOF {
+			return rows, nil
+		}
+	}
+}
+
+const defaultBatchSize = 4096
+
+// ParquetCodec maps a compression name to a parquet-go codec
+func ParquetCodec(name string) (compress.Codec, error) {
+	switch strings.ToLower(name) {
+	case "", "snappy":
+		return &parquet.Snappy, nil
+	case "none", "uncompressed":
+		return &parquet.Uncompressed, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "zstd":
+		return &parquet.Zstd, nil
+	case "lz4", "lz4_raw":
+		return &parquet.Lz4Raw, nil
+	case "brotli":
+		return &parquet.Brotli, nil
+	}
+	return nil, fmt.Errorf("%w: unknown parquet compression %q", ErrUnsupportedFormat, name)
+}
+
+// bits of a split block bloom filter per value, about 1% false positives
+const bloomFilterBitsPerValue = 10
+
+// BloomFilterColumns makes a parquet writer emit split block bloom filters for
+// columns of schema, scans use them to skip row groups on = and IN predicates
+func BloomFilterColumns(schema *parquetSchema, columns ...string) (parquet.WriterOption, error) {
+	filters := make([]parquet.BloomFilterColumn, len(columns))
+	for i, name := range columns {
+		field, err := schema.ColumnInfo(name)
+		if err != nil {
+			return nil, err
+		}
+		if field.Node != nil {
+			return nil, fmt.Errorf("cannot write a bloom filter for nested column %q", field.Name)
+		}
+		filters[i] = parquet.SplitBlockFilter(bloomFilterBitsPerValue, strings.Split(field.Name, ".")...)
+	}
+	return parquet.BloomFilters(filters...), nil
+}
+
+// ParquetSinkExec drains its child into a parquet file
+type ParquetSinkExec struct {
+	childInput Operator
+	writer     *ParquetBatchWriter
+}
+
+func NewParquetSinkExec(input Operator, out io.Writer, options ...parquet.WriterOption) (*ParquetSinkExec, error) {
+	w, err := NewParquetBatchWriter(out, input.Schema(), options...)
+	if err != nil {
+		return nil, err
+	}
+	return &ParquetSinkExec{childInput: input, writer: w}, nil
+}
+
+// writes every row of the child and the parquet footer
+func (s *ParquetSinkExec) Execute(batchSize uint) (int64, error) {
+	rows, err := CopyBatches(s.writer, s.childInput, batchSize)
+	if err != nil {
+		return rows, err
+	}
+	return rows, s.writer.Close()
+}
+
+// writes RecordBatches as parquet rows, the schema keeps the logical types
+// of the fields.
+// NULLs are written as null values of optional columns
+type ParquetBatchWriter struct {
+	w       *parquet.Writer
+	fields  []structField
+	columns []int          // leaf column index of every field, the first one of nested fields
+	types   []parquet.Type // type every field is written as
+	leaves  int            // number of leaf columns, more than fields with nested columns
+}
+
+func NewParquetBatchWriter(out io.Writer, schema *parquetSchema, options ...parquet.WriterOption) (*ParquetBatchWriter, error) {
+	if len(schema.Fields) == 0 {
+		return nil, fmt.Errorf("cannot write batches with an empty schema")
+	}
+	// a parquet.Group would sort the columns by name, nested columns keep
+	// the nodes they were read with
+	root := columnGroup{}
+	columns := make([]int, len(schema.Fields))
+	types := make([]parquet.Type, len(schema.Fields))
+	leaves := 0
+	for i, field := range schema.Fields {
+		node := field.Node
+		if node == nil {
+			node = writeNode(field)
+		}
+		root = append(root, columnField{Node: node, name: field.Name, index: i})
+		columns[i] = leaves
+		types[i] = node.Type()
+		leaves += numLeaves(node)
+	}
+	pqSchema := parquet.NewSchema("", root)
+	options = append([]parquet.WriterOption{pqSchema}, options...)
+	return &ParquetBatchWriter{
+		w:       parquet.NewWriter(out, options...),
+		fields:  append([]structField(nil), schema.Fields...),
+		columns: columns,
+		types:   types,
+		leaves:  leaves,
+	}, nil
+}
+
+func (b *ParquetBatchWriter) WriteBatch(batch RecordBatch) error {
+	if len(batch.Columns) != len(b.fields) {
+		return fmt.Errorf("batch has %d columns, writer expects %d", len(batch.Columns), len(b.fields))
+	}
+	rows := make([]parquet.Row, batch.NumRows())
+	// values of every leaf column, a nested column may have any number of them in a row
+	columns := make([][]parquet.Value, b.leaves)
+	for i := range rows {
+		for c := range columns {
+			columns[c] = columns[c][:0]
+		}
+		for c, field := range b.fields {
+			col := b.columns[c]
+			val := batch.Columns[c][i]
+			if field.Node != nil {
+				if err := stripe(columns, field.Node, col, val, 0, 0, 0); err != nil {
+					return fmt.Errorf("column %q row %d: %w", field.Name, i, err)
+				}
+				continue
+			}
+			if val == nil {
+				if !field.Optional {
+					return fmt.Errorf("column %q row %d: NULL in a required column", field.Name, i)
+				}
+				columns[col] = append(columns[col], parquet.NullValue().Level(0, 0, col))
+				continue
+			}
+			v, err := encodeValue(val, b.types[c])
+			if err != nil {
+				return fmt.Errorf("column %q row %d: %w", field.Name, i, err)
+			}
+			def := 0
+			if field.Optional {
+				def = 1
+			}
+			columns[col] = append(columns[col], v.Level(0, def, col))
+		}
+		var row parquet.Row
+		for _, values := range columns {
+			row = append(row, values...)
+		}
+		rows[i] = row
+	}
+	_, err := b.w.WriteRows(rows)
+	return err
+}
+
+func (b *ParquetBatchWriter) Close() error {
+	return b.w.Close()
+}
+
+// assigns a RecordBatch value to a generated struct field, nil leaves the zero value
+func setFieldValue(field reflect.Value, val any) error {
+	if val == nil {
+		return nil
+	}
+	if t, ok := val.(time.Time); ok && field.Kind() == reflect.Int32 {
+		// date columns are stored as days since the unix epoch
+		days := t.Unix() / secondsPerDay
+		if t.Unix()%secondsPerDay < 0 {
+			days--
+		}
+		field.SetInt(days)
+		return nil
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Type() == field.Type() {
+		field.Set(rv)
+		return nil
+	}
+	// guard against int -> string conversions producing runes
+	if rv.Type().ConvertibleTo(field.Type()) && (field.Kind() == reflect.String) == (rv.Kind() == reflect.String) {
+		field.Set(rv.Convert(field.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot store %T in %s column", val, field.Type())
+}
+
+type CSVWriteOptions struct {
+	Delimiter rune   // defaults to ','
+	NoHeader  bool   // skip the header row
+	NullValue string // written for NULL cells, defaults to ""
+}
+
+// CSVBatchWriter writes batches as csv rows, the header is written with the first batch
+type CSVBatchWriter struct {
+	w       *csv.Writer
+	schema  *parquetSchema
+	opts    CSVWriteOptions
+	started bool
+}
+
+func NewCSVBatchWriter(out io.Writer, schema *parquetSchema, opts CSVWriteOptions) *CSVBatchWriter {
+	w := csv.NewWriter(out)
+	if opts.Delimiter != 0 {
+		w.Comma = opts.Delimiter
+	}
+	return &CSVBatchWriter{w: w, schema: schema, opts: opts}
+}
+
+func (c *CSVBatchWriter) writeHeader() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	if c.opts.NoHeader {
+		return nil
+	}
+	return c.w.Write(c.schema.toColumns())
+}
+
+func (c *CSVBatchWriter) WriteBatch(batch RecordBatch) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	record := make([]string, len(batch.Columns))
+	for row := 0; row < batch.NumRows(); row++ {
+		for i := range batch.Columns {
+			v := batch.Columns[i][row]
+			if v == nil {
+				record[i] = c.opts.NullValue
+			} else {
+				record[i] = textValue(v)
+			}
+		}
+		if err := c.w.Write(record); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *CSVBatchWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// JSONBatchWriter writes batches as a json array of objects or as ndjson
+type JSONBatchWriter struct {
+	w       *bufio.Writer
+	schema  *parquetSchema
+	ndjson  bool
+	keys    [][]byte
+	written int64
+}
+
+func NewJSONBatchWriter(out io.Writer, schema *parquetSchema, ndjson bool) *JSONBatchWriter {
+	j := &JSONBatchWriter{w: bufio.NewWriter(out), schema: schema, ndjson: ndjson}
+	for _, field := range schema.Fields {
+		key, _ := json.Marshal(field.Name)
+		j.keys = append(j.keys, key)
+	}
+	return j
+}
+
+func (j *JSONBatchWriter) WriteBatch(batch RecordBatch) error {
+	for row := 0; row < batch.NumRows(); row++ {
+		switch {
+		case j.ndjson:
+		case j.written == 0:
+			j.w.WriteString("[\n")
+		default:
+			j.w.WriteString(",\n")
+		}
+		j.w.WriteByte('{')
+		for i := range batch.Columns {
+			if i > 0 {
+				j.w.WriteByte(',')
+			}
+			j.w.Write(j.keys[i])
+			j.w.WriteByte(':')
+			b, err := json.Marshal(jsonValue(batch.Columns[i][row]))
+			if err != nil {
+				return fmt.Errorf("encoding column %q: %w", j.schema.Fields[i].Name, err)
+			}
+			j.w.Write(b)
+		}
+		j.w.WriteByte('}')
+		if j.ndjson {
+			j.w.WriteByte('\n')
+		}
+		j.written++
+	}
+	return j.w.Flush()
+}
+
+func (j *JSONBatchWriter) Close() error {
+	if !j.ndjson {
+		if j.written == 0 {
+			j.w.WriteString("[]\n")
+		} else {
+			j.w.WriteString("\n]\n")
+		}
+	}
+	return j.w.Flush()
+}
+
+// lossless text form of a value, unlike formatValue floats keep every digit
+func textValue(v any) string {
+	switch x := v.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return formatValue(v)
+}
+
+func jsonValue(v any) any {
+	switch x := v.(type) {
+	case time.Time, time.Duration:
+		return formatValue(x)
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, item := range x {
+			out[k] = jsonValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(x))
+		for i, item := range x {
+			out[i] = jsonValue(item)
+		}
+		return out
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil
+		}
+	case float32:
+		if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+			return nil
+		}
+	}
+	return v
+}
+
+// group node whose fields keep the order of the columns
+type columnGroup []columnField
+
+type columnField struct {
+	parquet.Node
+	name  string
+	index int // of the field in the go type of the group
+}
+
+func (f columnField) Name() string { return f.name }
+
+func (f columnField) Value(base reflect.Value) reflect.Value {
+	return base.Field(f.index)
+}
+
+func (g columnGroup) ID() int                     { return 0 }
+func (g columnGroup) Type() parquet.Type          { return parquet.Group{}.Type() }
+func (g columnGroup) Optional() bool              { return false }
+func (g columnGroup) Repeated() bool              { return false }
+func (g columnGroup) Required() bool              { return true }
+func (g columnGroup) Leaf() bool                  { return false }
+func (g columnGroup) Encoding() encoding.Encoding { return nil }
+func (g columnGroup) Compression() compress.Codec { return nil }
+
+func (g columnGroup) String() string {
+	var sb strings.Builder
+	parquet.PrintSchema(&sb, "", g)
+	return sb.String()
+}
+
+func (g columnGroup) Fields() []parquet.Field {
+	fields := make([]parquet.Field, len(g))
+	for i, field := range g {
+		fields[i] = field
+	}
+	return fields
+}
+
+func (g columnGroup) GoType() reflect.Type {
+	fields := make([]reflect.StructField, len(g))
+	seen := make(map[string]bool)
+	for i, field := range g {
+		name := goFieldName(field.name)
+		for seen[name] {
+			name += "_"
+		}
+		seen[name] = true
+		fields[i] = reflect.StructField{Name: name, Type: field.GoType()}
+	}
+	return reflect.StructOf(fields)
+}
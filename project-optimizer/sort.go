@@ -1,19 +1,398 @@
 package projectoptimizer
 
-import "reflect"
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
 
 // deal with sorting large data sets that wont fit in memory
+//
+// SortExec reads its whole input before it returns the first row. rows are
+// buffered while the memory pool of the query has room for them, once it
+// has not the buffered rows are sorted and spilled to a file of a SpillDir
+// and the next run starts. the runs are merged back in order, ties keep the
+// order of the input. NULLs sort after every value, before them when
+// descending
+
+// SortKey orders rows by a column
+type SortKey struct {
+	Column string
+	Desc   bool
+}
+
+func (k SortKey) String() string {
+	if k.Desc {
+		return quoteIdent(k.Column) + " DESC"
+	}
+	return quoteIdent(k.Column)
+}
+
+type SortOptions struct {
+	Memory   *MemoryPool // buffered rows are reserved from it, nil buffers every row in memory
+	SpillDir string      // parent of the spill files, the system temp directory when empty
+	NoSpill  bool        // fail with ErrMemoryLimitExceeded instead of spilling
+}
 
 type SortExec struct {
-	columnName string
-	columnType reflect.Type
-	columnIdx  int
+	childInput Operator
+	keys       []SortKey
+	keyIdx     []int
+	schema     *parquetSchema
+	opts       SortOptions
+	res        *MemoryReservation
+
+	sorted bool
+	rows   [][]any // buffered columns
+	order  []int   // rows in sorted order
+	pos    int
+	dir    *SpillDir
+	runs   []*SpillHandle
+	merge  *runMerger
+	spills int
 }
 
-func NewSortExec(columnName string, columnType reflect.Type, columnIdx int) *SortExec {
+func NewSortExec(input Operator, keys []SortKey, opts SortOptions) (*SortExec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("sort needs at least one key")
+	}
+	schema := input.Schema()
+	colIdx := schemaIndex(schema)
+	keyIdx := make([]int, len(keys))
+	for i, key := range keys {
+		idx, ok := colIdx[strings.ToLower(key.Column)]
+		if !ok {
+			return nil, fmt.Errorf("%w: sort column %q", ErrColumnNotFound, key.Column)
+		}
+		keyIdx[i] = idx
+	}
 	return &SortExec{
-		columnName: columnName,
-		columnType: columnType,
-		columnIdx:  columnIdx,
+		childInput: input,
+		keys:       keys,
+		keyIdx:     keyIdx,
+		schema:     schema,
+		opts:       opts,
+		res:        opts.Memory.Reservation("sort"),
+	}, nil
+}
+
+func (s *SortExec) Schema() *parquetSchema {
+	return s.schema
+}
+
+// Spills is the number of runs written to disk
+func (s *SortExec) Spills() int {
+	return s.spills
+}
+
+func (s *SortExec) Next(n uint) (RecordBatch, error) {
+	if !s.sorted {
+		if err := s.consume(n); err != nil {
+			return RecordBatch{}, err
+		}
+		s.sorted = true
+	}
+	if s.merge != nil {
+		return s.merge.next(n)
+	}
+	out := RecordBatch{Schema: *s.schema, Columns: make([][]any, len(s.schema.Fields))}
+	end := min(s.pos+int(n), len(s.order))
+	for c := range out.Columns {
+		col := make([]any, 0, end-s.pos)
+		for _, row := range s.order[s.pos:end] {
+			col = append(col, s.rows[c][row])
+		}
+		out.Columns[c] = col
+	}
+	s.pos = end
+	if s.pos == len(s.order) {
+		s.rows, s.order = nil, nil
+		s.res.Free()
+		return out, io.EOF
+	}
+	return out, nil
+}
+
+// reads the input into sorted runs, n rows at a time
+func (s *SortExec) consume(n uint) error {
+	s.rows = make([][]any, len(s.schema.Fields))
+	for {
+		batch, err := s.childInput.Next(n)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if batch.NumRows() > 0 {
+			if gerr := s.res.Grow(batchBytes(batch)); gerr != nil {
+				if s.opts.NoSpill {
+					return gerr
+				}
+				if serr := s.spill(); serr != nil {
+					return serr
+				}
+				if gerr := s.res.Grow(batchBytes(batch)); gerr != nil {
+					return gerr // a single batch above the limit
+				}
+			}
+			for c := range s.rows {
+				s.rows[c] = append(s.rows[c], batch.Columns[c]...)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(s.runs) == 0 {
+		s.sortRows()
+		return nil
+	}
+	if err := s.spill(); err != nil {
+		return err
+	}
+	merge, err := newRunMerger(s.runs, s.schema, s.compare)
+	if err != nil {
+		return err
+	}
+	s.merge = merge
+	return nil
+}
+
+func (s *SortExec) sortRows() {
+	count := 0
+	if len(s.rows) > 0 {
+		count = len(s.rows[0])
+	}
+	s.order = make([]int, count)
+	for i := range s.order {
+		s.order[i] = i
+	}
+	sort.SliceStable(s.order, func(i, j int) bool {
+		a, b := s.order[i], s.order[j]
+		for k, idx := range s.keyIdx {
+			if cmp := s.compareKey(k, s.rows[idx][a], s.rows[idx][b]); cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+}
+
+// writes the buffered rows as a sorted run
+func (s *SortExec) spill() error {
+	if len(s.rows) == 0 || len(s.rows[0]) == 0 {
+		return nil
+	}
+	if s.dir == nil {
+		dir, err := NewSpillDir(s.opts.SpillDir)
+		if err != nil {
+			return err
+		}
+		s.dir = dir
+	}
+	s.sortRows()
+	w, err := s.dir.NewWriter(s.schema)
+	if err != nil {
+		return err
+	}
+	s.pos = 0
+	for s.pos < len(s.order) {
+		end := min(s.pos+defaultBatchSize, len(s.order))
+		batch := RecordBatch{Schema: *s.schema, Columns: make([][]any, len(s.rows))}
+		for c := range batch.Columns {
+			for _, row := range s.order[s.pos:end] {
+				batch.Columns[c] = append(batch.Columns[c], s.rows[c][row])
+			}
+		}
+		if err := w.Write(batch); err != nil {
+			w.abort()
+			return err
+		}
+		s.pos = end
+	}
+	handle, err := w.Finish()
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, handle)
+	s.spills++
+	s.rows = make([][]any, len(s.schema.Fields))
+	s.order, s.pos = nil, 0
+	s.res.Free()
+	return nil
+}
+
+// orders two rows of the output schema
+func (s *SortExec) compare(a, b []any) int {
+	for k, idx := range s.keyIdx {
+		if cmp := s.compareKey(k, a[idx], b[idx]); cmp != 0 {
+			return cmp
+		}
 	}
+	return 0
+}
+
+func (s *SortExec) compareKey(k int, a, b any) int {
+	cmp := sortCompare(a, b)
+	if s.keys[k].Desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// total order of values for sorting: NULLs last, values compareAny cannot
+// order by their text
+func sortCompare(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	if cmp, ok := compareAny(a, b); ok {
+		return cmp
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// removes the spill files
+func (s *SortExec) Close() error {
+	s.res.Free()
+	var err error
+	if s.merge != nil {
+		err = s.merge.close()
+	}
+	if s.dir != nil {
+		if derr := s.dir.Close(); err == nil {
+			err = derr
+		}
+	}
+	return err
+}
+
+// merges sorted runs read back from their spill files
+type runMerger struct {
+	schema  *parquetSchema
+	readers []*SpillReader
+	heads   []runHead
+	heap    runHeap
+}
+
+type runHead struct {
+	batch RecordBatch
+	row   int
+	done  bool // the reader returned io.EOF
+}
+
+func newRunMerger(runs []*SpillHandle, schema *parquetSchema, compare func(a, b []any) int) (*runMerger, error) {
+	m := &runMerger{schema: schema, heads: make([]runHead, len(runs))}
+	m.heap.compare = compare
+	for i, run := range runs {
+		r, err := run.Open()
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		m.readers = append(m.readers, r)
+		ok, err := m.fill(i)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		if ok {
+			m.heap.items = append(m.heap.items, runItem{run: i, row: m.row(i)})
+		}
+	}
+	heap.Init(&m.heap)
+	return m, nil
+}
+
+// reads the next batch of run i when its current one is used up, false
+// once the run is exhausted
+func (m *runMerger) fill(i int) (bool, error) {
+	h := &m.heads[i]
+	for h.row >= h.batch.NumRows() {
+		if h.done {
+			return false, nil
+		}
+		batch, err := m.readers[i].Next(defaultBatchSize)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		h.batch, h.row, h.done = batch, 0, err == io.EOF
+	}
+	return true, nil
+}
+
+func (m *runMerger) row(i int) []any {
+	h := &m.heads[i]
+	row := make([]any, len(h.batch.Columns))
+	for c := range row {
+		row[c] = h.batch.Columns[c][h.row]
+	}
+	return row
+}
+
+func (m *runMerger) next(n uint) (RecordBatch, error) {
+	out := RecordBatch{Schema: *m.schema, Columns: make([][]any, len(m.schema.Fields))}
+	for uint(out.NumRows()) < n && m.heap.Len() > 0 {
+		item := m.heap.items[0]
+		for c, v := range item.row {
+			out.Columns[c] = append(out.Columns[c], v)
+		}
+		m.heads[item.run].row++
+		ok, err := m.fill(item.run)
+		if err != nil {
+			return RecordBatch{}, err
+		}
+		if ok {
+			m.heap.items[0] = runItem{run: item.run, row: m.row(item.run)}
+			heap.Fix(&m.heap, 0)
+		} else {
+			heap.Pop(&m.heap)
+		}
+	}
+	if m.heap.Len() == 0 {
+		return out, io.EOF
+	}
+	return out, nil
+}
+
+func (m *runMerger) close() error {
+	var err error
+	for _, r := range m.readers {
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+	}
+	m.readers = nil
+	return err
+}
+
+type runItem struct {
+	run int
+	row []any
+}
+
+// the current row of every run, ties go to the earlier run
+type runHeap struct {
+	items   []runItem
+	compare func(a, b []any) int
+}
+
+func (h *runHeap) Len() int { return len(h.items) }
+func (h *runHeap) Less(i, j int) bool {
+	if cmp := h.compare(h.items[i].row, h.items[j].row); cmp != 0 {
+		return cmp < 0
+	}
+	return h.items[i].run < h.items[j].run
+}
+func (h *runHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *runHeap) Push(x any)    { h.items = append(h.items, x.(runItem)) }
+func (h *runHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
 }
@@ -0,0 +1,113 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func sortedMmapRows(t *testing.T) [][]any {
+	t.Helper()
+	rows := make([][]any, 1000)
+	for i := range rows {
+		rows[i] = []any{string(rune('a' + i%26)), int64(i)}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i][0] != rows[j][0] {
+			return rows[i][0].(string) < rows[j][0].(string)
+		}
+		return rows[i][1].(int64) > rows[j][1].(int64)
+	})
+	return rows
+}
+
+func TestSortExec(t *testing.T) {
+	f, err := os.Open(writeMmapFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	keys := []SortKey{{Column: "Name"}, {Column: "id", Desc: true}}
+	sorted, err := NewSortExec(NewProjectExecLeaf(f, []string{"name", "id", "bonus"}, nil), keys, SortOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sorted.Close()
+	got := drain(t, sorted)
+	want := sortedMmapRows(t)
+	for i, row := range want {
+		if got.Columns[0][i] != row[0] || got.Columns[1][i] != row[1] {
+			t.Fatalf("row %d: expected %v, got %v %v", i, row, got.Columns[0][i], got.Columns[1][i])
+		}
+	}
+	if sorted.Spills() != 0 {
+		t.Fatalf("expected an unbounded sort to stay in memory")
+	}
+
+	// NULLs last, first when descending
+	for _, desc := range []bool{false, true} {
+		f.Seek(0, 0)
+		sorted, err := NewSortExec(NewProjectExecLeaf(f, []string{"bonus"}, nil), []SortKey{{Column: "bonus", Desc: desc}}, SortOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bonus := drain(t, sorted).Columns[0]
+		first, last := bonus[0], bonus[len(bonus)-1]
+		if desc && (first != nil || last != int32(400)) || !desc && (first != int32(400) || last != nil) {
+			t.Fatalf("desc %v: unexpected order %v ... %v", desc, first, last)
+		}
+	}
+
+	if _, err := NewSortExec(NewProjectExecLeaf(f, []string{"id"}, nil), []SortKey{{Column: "name"}}, SortOptions{}); err == nil {
+		t.Fatalf("expected a missing sort column to fail")
+	}
+}
+
+func TestSortExecSpills(t *testing.T) {
+	path := writeMmapFile(t)
+	spillDir := t.TempDir()
+	plan := &SortPlan{
+		Input: &ScanPlan{Path: path, Columns: []string{"name", "id"}},
+		Keys:  []SortKey{{Column: "name"}, {Column: "id", Desc: true}},
+	}
+	in, err := BuildPlan(plan, PlanOptions{MemoryLimit: 16 << 10, SpillDir: spillDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := drain(t, in)
+	sorted := in.Operator.(*SortExec)
+	if sorted.Spills() < 2 {
+		t.Fatalf("expected the sort to spill, got %d runs", sorted.Spills())
+	}
+	want := sortedMmapRows(t)
+	for i, row := range want {
+		if !reflect.DeepEqual([]any{got.Columns[0][i], got.Columns[1][i]}, row) {
+			t.Fatalf("row %d: expected %v, got %v %v", i, row, got.Columns[0][i], got.Columns[1][i])
+		}
+	}
+	if err := in.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(spillDir); len(entries) != 0 {
+		t.Fatalf("expected the spill files to be removed, got %d", len(entries))
+	}
+	usage := in.Memory()
+	if usage.Peak > 16<<10 {
+		t.Fatalf("expected the query to stay below its limit, peaked at %d", usage.Peak)
+	}
+	if op, ok := usage.Operator("sort"); !ok || op.Peak == 0 || op.Used != 0 {
+		t.Fatalf("expected the sort to have reserved and freed memory, got %+v", usage)
+	}
+
+	// or fail
+	in, err = BuildPlan(plan, PlanOptions{MemoryLimit: 16 << 10, NoSpill: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if _, err := in.Next(10); !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("expected ErrMemoryLimitExceeded, got %v", err)
+	}
+}
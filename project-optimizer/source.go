@@ -0,0 +1,72 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// sources parquet files are read from. readers take any io.ReaderAt whose
+// size can be told: a Source, an *os.File or a reader with a Size method
+// like bytes.Reader. other storage is wrapped with NewSource
+
+// Source is random access storage holding a parquet file: a file, a buffer,
+// an mmap'ed region or an object of a custom storage layer
+type Source interface {
+	io.ReaderAt
+	Size() int64
+}
+
+type sizedReaderAt struct {
+	io.ReaderAt
+	size int64
+}
+
+func (s sizedReaderAt) Size() int64 {
+	return s.size
+}
+
+// NewSource pairs a reader with the size of the file it reads
+func NewSource(r io.ReaderAt, size int64) Source {
+	return sizedReaderAt{ReaderAt: r, size: size}
+}
+
+// BytesSource reads a parquet file held in memory
+func BytesSource(b []byte) Source {
+	return bytes.NewReader(b)
+}
+
+// AsSource finds the size of r, files are asked with Stat
+func AsSource(r io.ReaderAt) (Source, error) {
+	switch x := r.(type) {
+	case Source:
+		return x, nil
+	case interface{ Stat() (fs.FileInfo, error) }:
+		info, err := x.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return NewSource(r, info.Size()), nil
+	}
+	return nil, fmt.Errorf("cannot tell the size of a %T, wrap it with NewSource", r)
+}
+
+// reads the footer of the parquet file in r
+func openParquetFile(r io.ReaderAt, options ...parquet.FileOption) (*parquet.File, error) {
+	src, err := AsSource(r)
+	if err != nil {
+		return nil, err
+	}
+	return parquet.OpenFile(src, src.Size(), options...)
+}
+
+// name of the file behind r, empty for other sources
+func sourceName(r io.ReaderAt) string {
+	if named, ok := r.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package projectoptimizer
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// storage that only knows how to read at offsets
+type countingReaderAt struct {
+	data  []byte
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return bytes.NewReader(c.data).ReadAt(p, off)
+}
+
+func TestReadFromMemorySources(t *testing.T) {
+	data, err := os.ReadFile("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	columns := []string{"country", "lat", "date"}
+	want, err := NewProjectExecLeaf(f, columns, nil).Next(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &countingReaderAt{data: data}
+	for name, src := range map[string]Source{
+		"bytes":  BytesSource(data),
+		"custom": NewSource(storage, int64(len(data))),
+	} {
+		got, err := NewProjectExecLeaf(src, columns, nil).Next(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Columns, want.Columns) {
+			t.Fatalf("%s source: rows differ from the file", name)
+		}
+		info, err := InspectFile(src, InspectOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Path != "" || info.Size != int64(len(data)) || info.NumRows != 62321 {
+			t.Fatalf("%s source: unexpected footer %+v", name, info)
+		}
+	}
+	if storage.reads == 0 {
+		t.Fatalf("expected the custom storage to be read")
+	}
+}
+
+func TestAsSource(t *testing.T) {
+	f, err := os.Open("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, err := AsSource(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := f.Stat(); src.Size() != info.Size() {
+		t.Fatalf("expected the size of the file, got %d", src.Size())
+	}
+	if src, err := AsSource(strings.NewReader("abc")); err != nil || src.Size() != 3 {
+		t.Fatalf("expected readers with a Size method to be sources, got %v", err)
+	}
+	_, err = ReadParquetSchema(&countingReaderAt{})
+	if err == nil || !strings.Contains(err.Error(), "NewSource") {
+		t.Fatalf("expected an error pointing at NewSource, got %v", err)
+	}
+}
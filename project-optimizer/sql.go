@@ -0,0 +1,174 @@
+package projectoptimizer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// a small SQL dialect over the plans of plan.go, one table per query:
+//
+//	SELECT * | column, ... | aggregate, ...
+//	FROM table | 'path'
+//	[WHERE expr]
+//	[ORDER BY column [ASC | DESC], ...]
+//	[LIMIT n]
+//
+// aggregates are COUNT(*), COUNT, SUM, AVG, MIN and MAX of a column, they
+// cannot be mixed with columns or ORDER BY as there is no GROUP BY. WHERE
+// takes the expressions of ParseExpr
+
+var sqlKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "ORDER": true, "BY": true,
+	"ASC": true, "DESC": true, "LIMIT": true,
+}
+
+func init() {
+	for k := range exprKeywords {
+		sqlKeywords[k] = true
+	}
+}
+
+// ErrInvalidQuery is returned for queries that cannot be parsed or planned
+var ErrInvalidQuery = errors.New("invalid query")
+
+var sqlAggregates = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+// ParseSQL parses a query into a plan, tables maps the names FROM may use
+// to paths. a quoted string after FROM is read as a path. errors wrap
+// ErrInvalidQuery
+func ParseSQL(query string, tables map[string]string) (Plan, error) {
+	p, err := parseSQL(query, tables)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	return p, nil
+}
+
+func parseSQL(query string, tables map[string]string) (Plan, error) {
+	toks, err := tokenize(query, sqlKeywords)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	var columns []string
+	var aggrs []Aggregate
+	if !p.accept("*") {
+		for {
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected a column at position %d, got %q", t.pos, t.text)
+			}
+			if fn := strings.ToLower(t.text); sqlAggregates[fn] && p.accept("(") {
+				aggr := Aggregate{Func: fn}
+				if p.accept("*") {
+					aggr.Column = "*"
+				} else if c := p.next(); c.kind == tokIdent {
+					aggr.Column = c.text
+				} else {
+					return nil, fmt.Errorf("expected a column or * at position %d, got %q", c.pos, c.text)
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				aggrs = append(aggrs, aggr)
+			} else {
+				columns = append(columns, t.text)
+			}
+			if !p.accept(",") {
+				break
+			}
+		}
+		if len(columns) > 0 && len(aggrs) > 0 {
+			return nil, fmt.Errorf("columns cannot be selected with aggregates, there is no GROUP BY")
+		}
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	var path string
+	switch t := p.next(); t.kind {
+	case tokString:
+		path = t.text
+	case tokIdent:
+		var ok bool
+		if path, ok = lookupTable(tables, t.text); !ok {
+			return nil, fmt.Errorf("no such table %q", t.text)
+		}
+	default:
+		return nil, fmt.Errorf("expected a table or a quoted path at position %d, got %q", t.pos, t.text)
+	}
+	var plan Plan = &ScanPlan{Path: path}
+
+	if p.accept("WHERE") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		plan = &FilterPlan{Input: plan, Where: where}
+	}
+	if t := p.peek(); p.accept("ORDER") {
+		if len(aggrs) > 0 {
+			// the single row of an aggregate has none of the columns to sort by
+			return nil, fmt.Errorf("ORDER BY at position %d is not supported with aggregates", t.pos)
+		}
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		var keys []SortKey
+		for {
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected a sort column at position %d, got %q", t.pos, t.text)
+			}
+			key := SortKey{Column: t.text}
+			if p.accept("DESC") {
+				key.Desc = true
+			} else {
+				p.accept("ASC")
+			}
+			keys = append(keys, key)
+			if !p.accept(",") {
+				break
+			}
+		}
+		plan = &SortPlan{Input: plan, Keys: keys}
+	}
+	switch {
+	case len(aggrs) > 0:
+		plan = &AggregatePlan{Input: plan, Aggregates: aggrs}
+	case len(columns) > 0:
+		plan = &ProjectPlan{Input: plan, Columns: columns}
+	}
+	if p.accept("LIMIT") {
+		t := p.next()
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if t.kind != tokNumber || err != nil || n < 0 {
+			return nil, fmt.Errorf("expected a row count after LIMIT at position %d, got %q", t.pos, t.text)
+		}
+		plan = &LimitPlan{Input: plan, N: n}
+	}
+	p.accept(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	return plan, nil
+}
+
+// table names are case insensitive
+func lookupTable(tables map[string]string, name string) (string, bool) {
+	if path, ok := tables[name]; ok {
+		return path, true
+	}
+	for table, path := range tables {
+		if strings.EqualFold(table, name) {
+			return path, true
+		}
+	}
+	return "", false
+}
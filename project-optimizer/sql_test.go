@@ -0,0 +1,114 @@
+package projectoptimizer
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSQL(t *testing.T) {
+	abs, err := filepath.Abs("../data/history.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := map[string]string{"history": "../data/history.parquet"}
+	cases := map[string]string{
+		"SELECT * FROM History": "scan(" + abs + ")\n",
+		"select lat, country from history where country = 'Angola' and lat < 0 order by lat desc, country limit 5;": "limit(5)\n" +
+			"  sort(lat DESC, country)\n" +
+			"    filter((country = 'Angola' AND lat < 0))\n" +
+			"      scan(" + abs + ", columns=lat, country)\n",
+		"SELECT COUNT(*), avg(lat) FROM '../data/history.parquet' WHERE lat > 0": "aggregate(COUNT(*), AVG(lat))\n" +
+			"  filter(lat > 0)\n" +
+			"    scan(" + abs + ", columns=lat)\n",
+	}
+	for query, want := range cases {
+		p, err := ParseSQL(query, tables)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		normal, err := NormalizePlan(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatPlan(normal); got != want {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", query, want, got)
+		}
+	}
+
+	for _, query := range []string{
+		"SELECT lat FROM nowhere",
+		"SELECT lat, count(*) FROM history",
+		"SELECT lat FROM history LIMIT -1",
+		"SELECT lat FROM history ORDER lat",
+		"SELECT lat FROM history WHERE",
+		"SELECT lat FROM history; SELECT lon FROM history",
+	} {
+		if _, err := ParseSQL(query, tables); err == nil {
+			t.Fatalf("%s: expected an error", query)
+		}
+	}
+}
+
+func TestSessionQuery(t *testing.T) {
+	s := NewSession(PlanOptions{})
+	if err := s.Register("history", "../data/history.parquet"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("select", "../data/history.parquet"); err == nil {
+		t.Fatalf("expected keywords to be rejected as table names")
+	}
+	if err := s.Register("missing", "../data/missing.parquet"); err == nil {
+		t.Fatalf("expected a missing file to fail")
+	}
+
+	in, err := s.Query("SELECT lat, country FROM history WHERE country = 'Angola' ORDER BY lat DESC LIMIT 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := drain(t, in)
+	in.Close()
+	if got.NumRows() != 4 || got.Schema.Fields[0].Name != "lat" || got.Columns[1][3] != "Angola" {
+		t.Fatalf("unexpected result\n%s", got.Show())
+	}
+
+	in, err = s.Query("SELECT count(*), count(lat), min(lat), max(country), sum(lat) FROM history WHERE country = 'Angola'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = drain(t, in)
+	in.Close()
+	if got.NumRows() != 1 || got.Columns[0][0] != int64(307) || got.Columns[1][0] != int64(307) {
+		t.Fatalf("unexpected counts\n%s", got.Show())
+	}
+	if got.Columns[2][0] != -8.838333333 || got.Columns[3][0] != "Angola" {
+		t.Fatalf("unexpected min and max\n%s", got.Show())
+	}
+
+	// aggregates over no rows are NULL, counts 0
+	in, err = s.Query("SELECT count(*), avg(lat) FROM history WHERE country = 'Atlantis'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = drain(t, in)
+	in.Close()
+	if got.Columns[0][0] != int64(0) || got.Columns[1][0] != nil {
+		t.Fatalf("unexpected aggregates of no rows\n%s", got.Show())
+	}
+
+	if _, err := s.Query("SELECT sum(country) FROM history"); err == nil {
+		t.Fatalf("expected sum of a string column to fail")
+	}
+}
+
+func TestLimitExec(t *testing.T) {
+	f := generateDataFilter()
+	defer f.Close()
+	got := drain(t, NewLimitExec(NewProjectExecLeaf(f, []string{"country", "lat"}, nil), 7))
+	if got.NumRows() != 7 {
+		t.Fatalf("expected 7 rows, got %d", got.NumRows())
+	}
+	f.Seek(0, 0)
+	if got := drain(t, NewLimitExec(NewProjectExecLeaf(f, []string{"lat"}, nil), 0)); got.NumRows() != 0 {
+		t.Fatalf("expected no rows, got %d", got.NumRows())
+	}
+}
@@ -0,0 +1,323 @@
+package projectoptimizer
+
+import (
+	"io"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// min/max statistics from parquet footers, used to skip files and row groups
+// that cannot contain a row matching a where clause. split block bloom filters
+// of row groups rule out the values of = and IN predicates min/max cannot
+
+type columnStats struct {
+	min, max  any
+	hasMinMax bool
+	nullCount int64
+	numValues int64
+	missing   bool // column is not part of the file, every value is NULL
+	bloom     parquet.BloomFilter
+	pqType    parquet.Type // type of the values hashed into bloom
+}
+
+// returns the statistics of a column, ok is false when nothing is known
+type statsLookup func(column string) (columnStats, bool)
+
+// statistics of a single row group keyed by lower cased column name
+func rowGroupStats(schema *parquetSchema, rg format.RowGroup) map[string]columnStats {
+	out := make(map[string]columnStats, len(rg.Columns))
+	for _, chunk := range rg.Columns {
+		md := chunk.MetaData
+		// leaves of structs are found by their dotted path, the ones inside
+		// of lists and maps are not used for pruning
+		field, err := schema.ColumnInfo(strings.Join(md.PathInSchema, "."))
+		if err != nil || field.Node != nil {
+			continue
+		}
+		cs := columnStats{nullCount: md.Statistics.NullCount, numValues: md.NumValues}
+		minRaw, maxRaw := md.Statistics.MinValue, md.Statistics.MaxValue
+		if minRaw == nil && maxRaw == nil && isSignedOrder(field.PqType) {
+			minRaw, maxRaw = md.Statistics.Min, md.Statistics.Max
+		}
+		if minRaw != nil && maxRaw != nil {
+			lo, ok1 := statValue(field, minRaw)
+			hi, ok2 := statValue(field, maxRaw)
+			cs.min, cs.max, cs.hasMinMax = lo, hi, ok1 && ok2
+		}
+		out[strings.ToLower(field.Name)] = cs
+	}
+	return out
+}
+
+// statistics of a whole file, the union of the statistics of its row groups.
+// a column without a chunk in some row group is left out, nothing is known about it
+func fileStats(schema *parquetSchema, md *format.FileMetaData) map[string]columnStats {
+	out := make(map[string]columnStats)
+	seen := make(map[string]int)
+	for i, rg := range md.RowGroups {
+		for name, cs := range rowGroupStats(schema, rg) {
+			seen[name]++
+			if i == 0 {
+				out[name] = cs
+				continue
+			}
+			acc, ok := out[name]
+			if !ok {
+				continue
+			}
+			acc.nullCount += cs.nullCount
+			acc.numValues += cs.numValues
+			if acc.hasMinMax && cs.hasMinMax {
+				if c, ok := compareAny(cs.min, acc.min); ok && c < 0 {
+					acc.min = cs.min
+				}
+				if c, ok := compareAny(cs.max, acc.max); ok && c > 0 {
+					acc.max = cs.max
+				}
+			} else {
+				acc.hasMinMax = false
+			}
+			out[name] = acc
+		}
+	}
+	for name := range out {
+		if seen[name] != len(md.RowGroups) {
+			delete(out, name)
+		}
+	}
+	return out
+}
+
+// the deprecated min/max fields are only trustworthy for signed numeric types
+func isSignedOrder(t parquet.Type) bool {
+	switch t.Kind() {
+	case parquet.Int32, parquet.Int64, parquet.Float, parquet.Double:
+		return t.LogicalType() == nil || t.LogicalType().Integer == nil || t.LogicalType().Integer.IsSigned
+	}
+	return false
+}
+
+// canSkip reports whether statistics prove that no row satisfies e.
+// it is conservative: anything it does not understand may match
+func canSkip(e Expr, stats statsLookup) bool {
+	switch v := e.(type) {
+	case *LogicalExpr:
+		if v.Op == "AND" {
+			return canSkip(v.Left, stats) || canSkip(v.Right, stats)
+		}
+		return canSkip(v.Left, stats) && canSkip(v.Right, stats)
+	case *CompareExpr:
+		col, lit, op, ok := columnLiteral(v)
+		if !ok {
+			return false
+		}
+		cs, ok := stats(col)
+		if !ok {
+			return false
+		}
+		if cs.missing {
+			return true // NULL compared to anything is never true
+		}
+		if lit == nil {
+			return true
+		}
+		if cs.hasMinMax && rangeExcludes(op, lit, cs.min, cs.max) {
+			return true
+		}
+		return op == "=" && bloomExcludes(cs, lit)
+	case *InExpr:
+		ref, ok := v.Left.(*ColumnRef)
+		if !ok || v.Not {
+			return false
+		}
+		cs, ok := stats(ref.Name)
+		if !ok {
+			return false
+		}
+		if cs.missing {
+			return true
+		}
+		for _, item := range v.List {
+			lit, ok := item.(*Literal)
+			if !ok {
+				return false
+			}
+			if lit.Value == nil {
+				continue
+			}
+			if cs.hasMinMax && rangeExcludes("=", lit.Value, cs.min, cs.max) {
+				continue
+			}
+			if !bloomExcludes(cs, lit.Value) {
+				return false
+			}
+		}
+		return true
+	case *IsNullExpr:
+		ref, ok := v.Expr.(*ColumnRef)
+		if !ok {
+			return false
+		}
+		cs, ok := stats(ref.Name)
+		if !ok {
+			return false
+		}
+		if cs.missing {
+			return v.Not
+		}
+		// null counts are only trusted when the writer also wrote min/max
+		if !cs.hasMinMax {
+			return false
+		}
+		if v.Not {
+			return cs.nullCount >= cs.numValues
+		}
+		return cs.nullCount == 0
+	}
+	return false
+}
+
+// normalizes `col op literal` and `literal op col`
+func columnLiteral(c *CompareExpr) (string, any, string, bool) {
+	if ref, ok := c.Left.(*ColumnRef); ok {
+		if lit, ok := c.Right.(*Literal); ok {
+			return ref.Name, lit.Value, c.Op, true
+		}
+	}
+	if ref, ok := c.Right.(*ColumnRef); ok {
+		if lit, ok := c.Left.(*Literal); ok {
+			return ref.Name, lit.Value, flipOp(c.Op), true
+		}
+	}
+	return "", nil, "", false
+}
+
+func flipOp(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return op
+}
+
+// whether `x op lit` is false for every x in [lo, hi]
+func rangeExcludes(op string, lit, lo, hi any) bool {
+	cmpLo, ok1 := compareAny(lo, lit)
+	cmpHi, ok2 := compareAny(hi, lit)
+	if !ok1 || !ok2 {
+		return false
+	}
+	switch op {
+	case "=":
+		return cmpLo > 0 || cmpHi < 0
+	case "!=":
+		return cmpLo == 0 && cmpHi == 0
+	case "<":
+		return cmpLo >= 0
+	case "<=":
+		return cmpLo > 0
+	case ">":
+		return cmpHi <= 0
+	case ">=":
+		return cmpHi < 0
+	}
+	return false
+}
+
+// whether the bloom filter of a column proves that lit is not one of its values.
+// the literal is hashed as a value of the column, casts that change it
+// (3.5 to an int column) cannot be trusted
+func bloomExcludes(cs columnStats, lit any) bool {
+	if cs.bloom == nil {
+		return false
+	}
+	cv, err := castValue(lit, cs.pqType)
+	if err != nil {
+		return false
+	}
+	if cmp, ok := compareAny(cv, lit); !ok || cmp != 0 {
+		return false
+	}
+	v, err := encodeValue(cv, cs.pqType)
+	if err != nil {
+		return false
+	}
+	found, err := cs.bloom.Check(v)
+	return err == nil && !found
+}
+
+// statistics of every row group of an open file together with the bloom
+// filters of its column chunks, these are read from f when they are checked
+func rowGroupLookups(pf *parquet.File, schema *parquetSchema) []map[string]columnStats {
+	md := pf.Metadata()
+	out := make([]map[string]columnStats, len(md.RowGroups))
+	for i, rg := range pf.RowGroups() {
+		stats := rowGroupStats(schema, md.RowGroups[i])
+		for c, chunk := range rg.ColumnChunks() {
+			bloom := chunk.BloomFilter()
+			if bloom == nil {
+				continue
+			}
+			name := strings.ToLower(strings.Join(md.RowGroups[i].Columns[c].MetaData.PathInSchema, "."))
+			if cs, ok := stats[name]; ok {
+				field, _ := schema.ColumnInfo(name)
+				cs.bloom, cs.pqType = bloom, field.PqType
+				stats[name] = cs
+			}
+		}
+		out[i] = stats
+	}
+	return out
+}
+
+// rows [start, end) of a file
+type rowRange struct {
+	start, end int64
+}
+
+// row ranges of the row groups of f that cannot hold a row satisfying where.
+// extra is asked first, e.g. for the partition columns of a dataset file
+func prunedRowGroups(f io.ReaderAt, schema *parquetSchema, where Expr, extra statsLookup) ([]rowRange, error) {
+	var pf *parquet.File
+	var groups []map[string]columnStats
+	if entry := cachedFooter(f); entry != nil {
+		pf = entry.file
+		_, groups = entry.statistics()
+	} else {
+		var err error
+		if pf, err = openParquetFile(f, parquet.SkipPageIndex(true)); err != nil {
+			return nil, err
+		}
+		groups = rowGroupLookups(pf, schema)
+	}
+	var skip []rowRange
+	var start int64
+	for i, stats := range groups {
+		end := start + pf.Metadata().RowGroups[i].NumRows
+		lookup := func(column string) (columnStats, bool) {
+			if extra != nil {
+				if cs, ok := extra(column); ok {
+					return cs, true
+				}
+			}
+			if _, err := schema.ColumnInfo(column); err != nil {
+				return columnStats{missing: true}, true
+			}
+			cs, ok := stats[strings.ToLower(column)]
+			return cs, ok
+		}
+		if canSkip(where, lookup) {
+			skip = append(skip, rowRange{start, end})
+		}
+		start = end
+	}
+	return skip, nil
+}
@@ -0,0 +1,88 @@
+package projectoptimizer
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// UnnestExec (explode) turns every element of a list column into its own
+// row, the other columns are repeated for each element. Rows with a NULL or
+// empty list are dropped unless keepEmpty is set, then they keep a single
+// NULL element
+type UnnestExec struct {
+	childInput Operator
+	schema     *parquetSchema
+	column     int
+	keepEmpty  bool
+	batch      RecordBatch // child batch being unnested
+	row, item  int         // next element to emit
+	done       bool
+}
+
+func NewUnnestExec(input Operator, column string, keepEmpty bool) (*UnnestExec, error) {
+	schema := input.Schema().Clone()
+	idx := schema.fieldIndex(column)
+	if idx < 0 {
+		return nil, fmt.Errorf("unnest column %q not found in input schema", column)
+	}
+	field := schema.Fields[idx]
+	var element parquet.Node
+	ok := false
+	if field.Node != nil {
+		element, ok = listElement(field.Node)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unnest column %q is %s, not a list", column, fieldTypeName(field))
+	}
+	schema.Fields[idx] = nodeField(field.Name, element, true)
+	return &UnnestExec{childInput: input, schema: schema, column: idx, keepEmpty: keepEmpty}, nil
+}
+
+func (u *UnnestExec) Next(n uint) (RecordBatch, error) {
+	out := RecordBatch{Schema: *u.schema, Columns: make([][]any, len(u.schema.Fields))}
+	for uint(out.NumRows()) < n {
+		if u.row >= u.batch.NumRows() {
+			if u.done {
+				return out, io.EOF
+			}
+			batch, err := u.childInput.Next(n)
+			if err != nil && err != io.EOF {
+				return out, err
+			}
+			u.batch, u.row, u.item = batch, 0, 0
+			u.done = err == io.EOF
+			continue
+		}
+		items, _ := u.batch.Columns[u.column][u.row].([]any)
+		switch {
+		case u.item < len(items):
+			u.emit(&out, items[u.item])
+			u.item++
+		case len(items) == 0 && u.keepEmpty:
+			u.emit(&out, nil)
+			fallthrough
+		default:
+			u.row, u.item = u.row+1, 0
+		}
+	}
+	if u.done && u.row >= u.batch.NumRows() {
+		return out, io.EOF
+	}
+	return out, nil
+}
+
+func (u *UnnestExec) emit(out *RecordBatch, item any) {
+	for c := range out.Columns {
+		v := u.batch.Columns[c][u.row]
+		if c == u.column {
+			v = item
+		}
+		out.Columns[c] = append(out.Columns[c], v)
+	}
+}
+
+func (u *UnnestExec) Schema() *parquetSchema {
+	return u.schema
+}